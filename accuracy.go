@@ -0,0 +1,154 @@
+package lbs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AccuracyID задает идентификатор документа с радиусами действия вышек по умолчанию в коллекции
+// метаданных MetaCollectionName.
+const AccuracyID = "accuracy"
+
+// DefaultRanges задает радиус действия вышки по типу радио, который используется, если радиус
+// для страны еще не вычислен LearnAccuracy. Для неизвестных типов радио используется значение
+// для gsm.
+var DefaultRanges = map[string]float64{
+	"gsm":   3000,
+	"cdma":  3000,
+	"umts":  1500,
+	"wcdma": 1500,
+	"lte":   1000,
+	"nr":    500,
+}
+
+// maxCellRange задает максимальный правдоподобный радиус действия вышки, м: дальность связи GSM
+// ограничена 35 км временем задержки сигнала (timing advance). Записи с большим или нулевым
+// радиусом считаются ошибочными.
+const maxCellRange = 35000
+
+// minAccuracySamples задает минимальное количество записей страны с известным радиусом, по
+// которым вычисляется радиус по умолчанию: по меньшему количеству значение получается случайным.
+const minAccuracySamples = 10
+
+// AccuracyDefaults описывает радиусы действия вышек по умолчанию, вычисленные по данным хранилища
+// для каждого типа радио и страны.
+type AccuracyDefaults struct {
+	ID      string             `bson:"_id" json:"-"`
+	Ranges  map[string]float64 `bson:"ranges" json:"ranges"`   // средний радиус по ключу "radio:mcc", м
+	Updated time.Time          `bson:"updated" json:"updated"` // время вычисления
+}
+
+// accuracyKey возвращает ключ радиуса по умолчанию для типа радио и страны.
+func accuracyKey(radio string, mcc uint16) string {
+	return fmt.Sprintf("%s:%d", radio, mcc)
+}
+
+// accuracyTable содержит загруженные радиусы действия вышек по умолчанию.
+type accuracyTable struct {
+	mu     sync.RWMutex
+	ranges map[string]float64 // радиус по ключу accuracyKey
+}
+
+// cellRange возвращает радиус действия вышки. Если радиус в записи не задан или неправдоподобен,
+// то возвращается средний радиус вышек того же типа в стране, а если он неизвестен — значение
+// из DefaultRanges.
+func (t *accuracyTable) cellRange(cell Cell) float64 {
+	if cell.Accuracy > 0 && cell.Accuracy <= maxCellRange {
+		return cell.Accuracy
+	}
+	t.mu.RLock()
+	value, ok := t.ranges[accuracyKey(cell.RadioType, cell.MobileCountryCode)]
+	t.mu.RUnlock()
+	if ok {
+		return value
+	}
+	if value, ok := DefaultRanges[cell.RadioType]; ok {
+		return value
+	}
+	return DefaultRanges["gsm"]
+}
+
+// set заменяет загруженные радиусы по умолчанию.
+func (t *accuracyTable) set(ranges map[string]float64) {
+	t.mu.Lock()
+	t.ranges = ranges
+	t.mu.Unlock()
+}
+
+// LoadAccuracy загружает из коллекции метаданных радиусы действия вышек по умолчанию, вычисленные
+// LearnAccuracy. Вызывается при инициализации DB; повторный вызов позволяет применить новые
+// значения без перезапуска.
+func (db *DB) LoadAccuracy(ctx context.Context) error {
+	var doc AccuracyDefaults
+	if err := db.findMeta(ctx, AccuracyID, &doc); err != nil {
+		return err
+	}
+	db.accuracy.set(doc.Ranges)
+	return nil
+}
+
+// LearnAccuracy вычисляет средний радиус действия вышек каждого типа радио в каждой стране по
+// записям с правдоподобным радиусом, сохраняет результат в коллекции метаданных и сразу начинает
+// его использовать. В странах с редкой сетью вышки расположены дальше друг от друга и радиус их
+// действия больше, поэтому такое значение заметно точнее одной общей константы. Страны, для
+// которых известен радиус меньше чем у 10 вышек, не учитываются.
+func (db *DB) LearnAccuracy(ctx context.Context) (*AccuracyDefaults, error) {
+	var result []struct {
+		ID struct {
+			Radio string `bson:"radio"`
+			MCC   uint16 `bson:"mcc"`
+		} `bson:"_id"`
+		Range float64 `bson:"range"`
+		Count int     `bson:"count"`
+	}
+	pipeline := []bson.M{
+		{"$match": bson.M{
+			"range":   bson.M{"$gt": 0, "$lte": maxCellRange},
+			"blocked": notBlocked,
+		}},
+		{"$group": bson.M{
+			"_id":   bson.M{"radio": "$radio", "mcc": "$mcc"},
+			"range": bson.M{"$avg": "$range"},
+			"count": bson.M{"$sum": 1},
+		}},
+	}
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	mdb, err := db.database()
+	if err != nil {
+		return nil, err
+	}
+	// при хранении данных в отдельных коллекциях объединяем результаты по всем коллекциям
+	for _, name := range db.collections() {
+		if err := aggregateAll(ctx, mdb.Collection(name), pipeline, &result); err != nil {
+			return nil, err
+		}
+		for _, item := range result {
+			key := accuracyKey(item.ID.Radio, item.ID.MCC)
+			sums[key] += item.Range * float64(item.Count)
+			counts[key] += item.Count
+		}
+	}
+	doc := &AccuracyDefaults{
+		ID:      AccuracyID,
+		Ranges:  make(map[string]float64, len(counts)),
+		Updated: db.clock.Now(),
+	}
+	for key, count := range counts {
+		if count >= minAccuracySamples {
+			doc.Ranges[key] = sums[key] / float64(count)
+		}
+	}
+	_, err = mdb.Collection(MetaCollectionName).ReplaceOne(ctx, bson.M{"_id": AccuracyID}, doc,
+		options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, err
+	}
+	db.accuracy.set(doc.Ranges)
+	return doc, nil
+}
@@ -0,0 +1,26 @@
+package lbs
+
+import "testing"
+
+func TestCellRange(t *testing.T) {
+	var table accuracyTable
+	table.set(map[string]float64{"lte:250": 2500})
+	cell := func(radio string, mcc uint16, accuracy float64) Cell {
+		return Cell{Key: Key{RadioType: radio, MobileCountryCode: mcc}, Data: Data{Accuracy: accuracy}}
+	}
+	for _, test := range []struct {
+		cell Cell
+		want float64
+	}{
+		{cell("lte", 250, 800), 800},     // правдоподобный радиус не меняется
+		{cell("lte", 250, 0), 2500},      // радиус для страны
+		{cell("lte", 250, 100000), 2500}, // неправдоподобный радиус
+		{cell("lte", 255, 0), DefaultRanges["lte"]},
+		{cell("nr", 255, 0), DefaultRanges["nr"]},
+		{cell("iden", 255, 0), DefaultRanges["gsm"]},
+	} {
+		if got := table.cellRange(test.cell); got != test.want {
+			t.Errorf("cellRange(%+v) = %v; want %v", test.cell, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package lbs
+
+import (
+	"math"
+	"time"
+
+	"github.com/geotrace/geo"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// metersPerDegree задает длину дуги в один градус на поверхности Земли в метрах.
+const metersPerDegree = math.Pi * earthRadius / 180
+
+// sampleStats описывает накопленную статистику подтверждений записи о вышке или точке доступа:
+// среднее положение точек измерений, их количество и дисперсию расстояний до среднего положения.
+type sampleStats struct {
+	Location geo.Point // среднее положение точек измерений
+	Samples  int       // количество подтверждений
+	Variance float64   // дисперсия координат, м²
+	Range    float64   // радиус действия, м
+}
+
+// add возвращает статистику после добавления подтверждения в точке p. Среднее положение и
+// дисперсия обновляются потоковым методом Уэлфорда, поэтому для уточнения записи не нужны
+// предыдущие измерения, а радиус действия увеличивается до расстояния от нового среднего
+// положения до точки измерения. Расстояния вычисляются в локальной плоской проекции около точки
+// измерения, как и в mergePipeline.
+func (s sampleStats) add(p geo.Point) sampleStats {
+	if s.Samples <= 0 {
+		return sampleStats{Location: p, Samples: 1, Range: s.Range}
+	}
+	n := float64(s.Samples)
+	k := 1 / (n + 1)
+	dx := (p.Longitude() - s.Location.Longitude()) * metersPerDegree * math.Cos(p.Latitude()*math.Pi/180)
+	dy := (p.Latitude() - s.Location.Latitude()) * metersPerDegree
+	d2 := dx*dx + dy*dy
+	return sampleStats{
+		Location: geo.NewPoint(s.Location.Longitude()+(p.Longitude()-s.Location.Longitude())*k,
+			s.Location.Latitude()+(p.Latitude()-s.Location.Latitude())*k),
+		Samples:  s.Samples + 1,
+		Variance: (s.Variance*n + d2*n*k) / (n + 1),
+		Range:    math.Max(s.Range, math.Sqrt(d2)*(1-k)),
+	}
+}
+
+// Add возвращает запись о точке доступа после добавления к ней подтверждения в точке location,
+// измеренного в момент measured, так же, как DB.Submit, но без обращения к хранилищу. Так исходные
+// измерения одной точки доступа (например, из выгрузки WiGLE) можно объединить до сохранения.
+// Нулевое время measured не меняет время первого и последнего подтверждения.
+func (p AccessPoint) Add(location geo.Point, measured time.Time) AccessPoint {
+	stats := sampleStats{Location: p.Location, Samples: p.Samples, Variance: p.Variance, Range: p.Accuracy}
+	stats = stats.add(location)
+	p.Location, p.Samples, p.Variance, p.Accuracy = stats.Location, stats.Samples, stats.Variance, stats.Range
+	if !measured.IsZero() {
+		if p.Created.IsZero() || measured.Before(p.Created) {
+			p.Created = measured
+		}
+		if measured.After(p.Updated) {
+			p.Updated = measured
+		}
+	}
+	return p
+}
+
+// mergePipeline возвращает конвейер обновления записи (MongoDB 4.2 или новее), который атомарно
+// добавляет к ней подтверждение в точке p, измеренное в момент measured, так же, как
+// sampleStats.add. Запись без координат получает координаты точки измерения, а запись с
+// координатами, но без количества подтверждений (например, из выгрузки без этого поля), считается
+// подтвержденной один раз. Время последнего подтверждения (updated) не уменьшается. Координаты
+// записи читаются и в формате пары [долгота, широта] из предыдущих версий, а сохраняются как точка
+// GeoJSON.
+//
+// Конвейер не заменяет запись целиком, поэтому данные из выгрузок и измерения устройств
+// объединяются: импортированная запись с большим количеством подтверждений смещается отдельным
+// измерением незначительно.
+func mergePipeline(p geo.Point, measured time.Time) bson.A {
+	lon, lat := p.Longitude(), p.Latitude()
+	coordinates := bson.M{"$cond": bson.A{
+		bson.M{"$eq": bson.A{bson.M{"$type": "$location"}, "array"}},
+		"$location",
+		"$location.coordinates",
+	}}
+	d2 := bson.M{"$add": bson.A{
+		bson.M{"$multiply": bson.A{"$_dx", "$_dx"}},
+		bson.M{"$multiply": bson.A{"$_dy", "$_dy"}},
+	}}
+	return bson.A{
+		bson.M{"$set": bson.M{
+			"_n": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$type": "$location"}, "missing"}},
+				0,
+				bson.M{"$max": bson.A{bson.M{"$ifNull": bson.A{"$samples", 0}}, 1}},
+			}},
+			"_lon": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{coordinates, 0}}, lon}},
+			"_lat": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{coordinates, 1}}, lat}},
+		}},
+		bson.M{"$set": bson.M{
+			"_dx": bson.M{"$multiply": bson.A{bson.M{"$subtract": bson.A{lon, "$_lon"}},
+				metersPerDegree * math.Cos(lat*math.Pi/180)}},
+			"_dy": bson.M{"$multiply": bson.A{bson.M{"$subtract": bson.A{lat, "$_lat"}}, metersPerDegree}},
+			"_k":  bson.M{"$divide": bson.A{1, bson.M{"$add": bson.A{"$_n", 1}}}},
+		}},
+		bson.M{"$set": bson.M{
+			"location": bson.D{{Key: "type", Value: "Point"}, {Key: "coordinates", Value: bson.A{
+				bson.M{"$add": bson.A{"$_lon", bson.M{"$multiply": bson.A{bson.M{"$subtract": bson.A{lon, "$_lon"}}, "$_k"}}}},
+				bson.M{"$add": bson.A{"$_lat", bson.M{"$multiply": bson.A{bson.M{"$subtract": bson.A{lat, "$_lat"}}, "$_k"}}}},
+			}}},
+			"samples": bson.M{"$add": bson.A{"$_n", 1}},
+			"variance": bson.M{"$multiply": bson.A{"$_k", bson.M{"$add": bson.A{
+				bson.M{"$multiply": bson.A{bson.M{"$ifNull": bson.A{"$variance", 0}}, "$_n"}},
+				bson.M{"$multiply": bson.A{d2, "$_n", "$_k"}},
+			}}}},
+			"range": bson.M{"$max": bson.A{
+				bson.M{"$ifNull": bson.A{"$range", 0}},
+				bson.M{"$multiply": bson.A{bson.M{"$sqrt": d2}, bson.M{"$subtract": bson.A{1, "$_k"}}}},
+			}},
+			"created": bson.M{"$ifNull": bson.A{"$created", measured}},
+			"updated": bson.M{"$max": bson.A{"$updated", measured}},
+		}},
+		bson.M{"$unset": bson.A{"_n", "_lon", "_lat", "_dx", "_dy", "_k"}},
+	}
+}
@@ -0,0 +1,115 @@
+package lbs
+
+import (
+	"context"
+	"log"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/geotrace/geo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestSampleStats(t *testing.T) {
+	location := geo.NewPoint(37.62, 55.75)
+	// новая запись получает координаты измерения
+	stats := sampleStats{}.add(location)
+	if stats.Location != location || stats.Samples != 1 || stats.Variance != 0 || stats.Range != 0 {
+		t.Errorf("bad new record: %+v", stats)
+	}
+	// координаты сдвигаются на четверть расстояния для записи с тремя подтверждениями
+	old := sampleStats{Location: geo.NewPoint(37.60, 55.75), Samples: 3, Range: 100}
+	stats = old.add(location)
+	if math.Abs(stats.Location.Longitude()-37.605) > 1e-9 || stats.Location.Latitude() != 55.75 {
+		t.Errorf("bad refined location: %v", stats.Location)
+	}
+	if dist := Distance(stats.Location, location); math.Abs(stats.Range-dist) > 1 || dist < 100 {
+		t.Errorf("range not expanded: %v (distance %v)", stats.Range, dist)
+	}
+	// близкое измерение не уменьшает радиус действия
+	if stats = old.add(geo.NewPoint(37.6001, 55.75)); stats.Range != 100 {
+		t.Errorf("range changed: %v", stats.Range)
+	}
+	// потоковая дисперсия совпадает с дисперсией всех точек
+	points := []geo.Point{
+		geo.NewPoint(37.60, 55.75), geo.NewPoint(37.61, 55.75),
+		geo.NewPoint(37.60, 55.76), geo.NewPoint(37.62, 55.74),
+	}
+	stats = sampleStats{}
+	var lon, lat float64
+	for _, p := range points {
+		stats = stats.add(p)
+		lon += p.Longitude() / float64(len(points))
+		lat += p.Latitude() / float64(len(points))
+	}
+	var variance float64
+	for _, p := range points {
+		dx := (p.Longitude() - lon) * metersPerDegree * math.Cos(lat*math.Pi/180)
+		dy := (p.Latitude() - lat) * metersPerDegree
+		variance += (dx*dx + dy*dy) / float64(len(points))
+	}
+	if math.Abs(stats.Location.Longitude()-lon) > 1e-9 || math.Abs(stats.Location.Latitude()-lat) > 1e-9 {
+		t.Errorf("bad mean: %v", stats.Location)
+	}
+	if math.Abs(stats.Variance-variance) > variance*0.01 {
+		t.Errorf("bad variance: %v, expected %v", stats.Variance, variance)
+	}
+}
+
+func TestAccessPointAdd(t *testing.T) {
+	first := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	point := AccessPoint{MAC: "a4:b1:c2:d3:e4:f5"}.Add(geo.NewPoint(37.60, 55.75), first)
+	point = point.Add(geo.NewPoint(37.61, 55.75), first.Add(time.Hour))
+	point = point.Add(geo.NewPoint(37.62, 55.75), time.Time{})
+	if point.Samples != 3 || math.Abs(point.Location.Longitude()-37.61) > 1e-9 || point.Accuracy < 600 {
+		t.Errorf("bad aggregated point: %+v", point)
+	}
+	if !point.Created.Equal(first) || !point.Updated.Equal(first.Add(time.Hour)) {
+		t.Errorf("bad times: %v, %v", point.Created, point.Updated)
+	}
+}
+
+func TestMergePipeline(t *testing.T) {
+	ctx := context.Background()
+	db, err := Dial(ctx, "mongodb://localhost/geotrace", SyncTimeout(time.Second))
+	if err != nil {
+		log.Println("Error connecting to MongoDB:", err)
+		return
+	}
+	defer db.Close()
+	mdb, err := db.database()
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll := mdb.Collection("lbs_test_merge")
+	defer coll.Drop(ctx)
+	points := []geo.Point{geo.NewPoint(37.60, 55.75), geo.NewPoint(37.61, 55.75), geo.NewPoint(37.60, 55.76)}
+	measured := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var stats sampleStats
+	for _, p := range points {
+		stats = stats.add(p)
+		if _, err := coll.UpdateOne(ctx, bson.M{"_id": 1}, mergePipeline(p, measured),
+			options.Update().SetUpsert(true)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var doc struct {
+		Location geo.Point `bson:"location"`
+		Samples  int       `bson:"samples"`
+		Variance float64   `bson:"variance"`
+		Range    float64   `bson:"range"`
+		Updated  time.Time `bson:"updated"`
+	}
+	if err := coll.FindOne(ctx, bson.M{"_id": 1}).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Samples != stats.Samples || Distance(doc.Location, stats.Location) > 0.01 ||
+		math.Abs(doc.Variance-stats.Variance) > 0.01 || math.Abs(doc.Range-stats.Range) > 0.01 {
+		t.Errorf("pipeline result %+v, expected %+v", doc, stats)
+	}
+	if !doc.Updated.Equal(measured) {
+		t.Errorf("bad updated: %v", doc.Updated)
+	}
+}
@@ -0,0 +1,117 @@
+package lbs
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/geotrace/geo"
+)
+
+// Названия коллекций с производными данными, которые вычисляются по записям о вышках командой
+// lbs-admin rebuild и нигде не редактируются вручную.
+var (
+	AreasCollectionName     = "lbs_areas"     // центры и радиусы зон (см. Area)
+	NetworksCollectionName  = "lbs_networks"  // статистика по сетям (см. NetworkStats)
+	AdjacencyCollectionName = "lbs_adjacency" // граф соседства зон (см. AreaNeighbors)
+)
+
+// Area описывает зону (LAC) оператора, вычисленную по записям о ее вышках.
+type Area struct {
+	Key   `bson:",inline"` // ключ зоны; CellId всегда равен 0
+	Data  `bson:",inline"` // центр зоны и радиус, покрывающий зоны действия всех ее вышек
+	Cells int              `bson:"cells" json:"cells"` // количество вышек
+}
+
+// NetworkStats описывает количество записей о вышках одного типа радио одного оператора.
+type NetworkStats struct {
+	RadioType         string    `bson:"radio" json:"radio"`                         // тип радио
+	MobileCountryCode uint16    `bson:"mcc" json:"mcc"`                             // код страны
+	MobileNetworkCode uint16    `bson:"mnc" json:"mnc"`                             // код оператора
+	Cells             int       `bson:"cells" json:"cells"`                         // количество вышек, кроме заблокированных
+	Blocked           int       `bson:"blocked" json:"blocked"`                     // количество заблокированных вышек
+	Areas             int       `bson:"areas" json:"areas"`                         // количество зон
+	Updated           time.Time `bson:"updated,omitempty" json:"updated,omitempty"` // время последнего измерения
+}
+
+// AreaNeighbors описывает зоны того же типа радио и оператора, соседние с зоной Key.
+type AreaNeighbors struct {
+	Key       `bson:",inline"`
+	Neighbors []uint32 `bson:"neighbors" json:"neighbors"` // LAC соседних зон по возрастанию
+}
+
+// AreaOf вычисляет зону по записям о ее вышках: центром служит среднее положение вышек, а радиус
+// покрывает зоны их действия. Все записи должны относиться к одной зоне; ключ берется из первой.
+// Для пустого списка возвращается false.
+func AreaOf(cells []Cell) (Area, bool) {
+	if len(cells) == 0 {
+		return Area{}, false
+	}
+	var lat, lon float64
+	for _, cell := range cells {
+		lat += cell.Location.Latitude()
+		lon += cell.Location.Longitude()
+	}
+	lat, lon = lat/float64(len(cells)), lon/float64(len(cells))
+	var accuracy float64
+	for _, cell := range cells {
+		dist := distance(lat, lon, cell.Location.Latitude(), cell.Location.Longitude()) + cell.Accuracy
+		accuracy = math.Max(accuracy, dist)
+	}
+	return Area{
+		Key:   cells[0].Key.Area(),
+		Data:  Data{Location: geo.NewPoint(lon, lat), Accuracy: accuracy},
+		Cells: len(cells),
+	}, true
+}
+
+// AdjacentAreas строит граф соседства зон: зоны одного типа радио и оператора считаются
+// соседними, если их круги пересекаются. Соседи есть не у всех зон; зоны без соседей в результат
+// не попадают. Результат упорядочен по ключу зоны.
+func AdjacentAreas(areas []Area) []AreaNeighbors {
+	networks := make(map[Key][]Area)
+	for _, area := range areas {
+		network := area.Key
+		network.LocationAreaCode, network.CellId = 0, 0
+		networks[network] = append(networks[network], area)
+	}
+	var result []AreaNeighbors
+	for _, list := range networks {
+		neighbors := make([][]uint32, len(list))
+		for i := range list {
+			for j := i + 1; j < len(list); j++ {
+				a, b := list[i], list[j]
+				if Distance(a.Location, b.Location) > a.Accuracy+b.Accuracy {
+					continue
+				}
+				neighbors[i] = append(neighbors[i], b.LocationAreaCode)
+				neighbors[j] = append(neighbors[j], a.LocationAreaCode)
+			}
+		}
+		for i, area := range list {
+			if len(neighbors[i]) == 0 {
+				continue
+			}
+			sort.Slice(neighbors[i], func(a, b int) bool { return neighbors[i][a] < neighbors[i][b] })
+			result = append(result, AreaNeighbors{Key: area.Key.Area(), Neighbors: neighbors[i]})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return lessKey(result[i].Key, result[j].Key) })
+	return result
+}
+
+// lessKey сравнивает ключи в порядке полей: тип радио, код страны, код оператора, LAC и
+// идентификатор вышки.
+func lessKey(a, b Key) bool {
+	switch {
+	case a.RadioType != b.RadioType:
+		return a.RadioType < b.RadioType
+	case a.MobileCountryCode != b.MobileCountryCode:
+		return a.MobileCountryCode < b.MobileCountryCode
+	case a.MobileNetworkCode != b.MobileNetworkCode:
+		return a.MobileNetworkCode < b.MobileNetworkCode
+	case a.LocationAreaCode != b.LocationAreaCode:
+		return a.LocationAreaCode < b.LocationAreaCode
+	}
+	return a.CellId < b.CellId
+}
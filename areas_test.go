@@ -0,0 +1,54 @@
+package lbs
+
+import (
+	"math"
+	"testing"
+
+	"github.com/geotrace/geo"
+)
+
+func TestAreaOf(t *testing.T) {
+	if _, ok := AreaOf(nil); ok {
+		t.Error("area of no cells")
+	}
+	cells := []Cell{
+		{Key: Key{RadioType: "gsm", MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 7, CellId: 1},
+			Data: Data{Location: geo.NewPoint(37.60, 55.75), Accuracy: 1000}},
+		{Key: Key{RadioType: "gsm", MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 7, CellId: 2},
+			Data: Data{Location: geo.NewPoint(37.62, 55.75), Accuracy: 2000}},
+	}
+	area, ok := AreaOf(cells)
+	if !ok || area.CellId != 0 || area.LocationAreaCode != 7 || area.Cells != 2 {
+		t.Fatalf("bad area: %+v", area)
+	}
+	if math.Abs(area.Location.Longitude()-37.61) > 1e-9 || math.Abs(area.Location.Latitude()-55.75) > 1e-9 {
+		t.Errorf("bad area center: %v", area.Location)
+	}
+	// радиус покрывает зону действия дальней вышки с большим радиусом
+	if want := Distance(area.Location, cells[1].Location) + 2000; math.Abs(area.Accuracy-want) > 1e-6 {
+		t.Errorf("bad area range: %.0f, want %.0f", area.Accuracy, want)
+	}
+}
+
+func TestAdjacentAreas(t *testing.T) {
+	area := func(mnc uint16, lac uint32, lon float64) Area {
+		return Area{Key: Key{RadioType: "gsm", MobileCountryCode: 250, MobileNetworkCode: mnc, LocationAreaCode: lac},
+			Data: Data{Location: geo.NewPoint(lon, 55.75), Accuracy: 5000}}
+	}
+	areas := []Area{
+		area(1, 3, 37.60),
+		area(1, 1, 37.65), // около 3 км от первой
+		area(1, 2, 38.60), // далеко от остальных
+		area(2, 4, 37.60), // другой оператор в том же месте
+	}
+	adjacency := AdjacentAreas(areas)
+	if len(adjacency) != 2 {
+		t.Fatalf("bad adjacency: %+v", adjacency)
+	}
+	if adjacency[0].LocationAreaCode != 1 || len(adjacency[0].Neighbors) != 1 || adjacency[0].Neighbors[0] != 3 {
+		t.Errorf("bad neighbors: %+v", adjacency[0])
+	}
+	if adjacency[1].LocationAreaCode != 3 || adjacency[1].Neighbors[0] != 1 {
+		t.Errorf("bad neighbors: %+v", adjacency[1])
+	}
+}
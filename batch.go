@@ -0,0 +1,132 @@
+package lbs
+
+import (
+	"context"
+	"errors"
+
+	"github.com/geotrace/locator"
+)
+
+// GetBatch вычисляет координаты сразу для нескольких запросов. Вышки и точки доступа Wi-Fi из всех
+// запросов ищутся общими запросами к MongoDB (по одному на каждую сеть и тип радио и один для
+// точек доступа), поэтому пакет запросов от устройств, находящихся рядом, обходится гораздо
+// дешевле, чем такое же количество вызовов GetDetailed. Результаты и ошибки возвращаются в том же
+// порядке, что и запросы; ошибки для отдельных запросов совпадают с ошибками GetDetailed. При
+// отмене контекста все еще не выполненные запросы завершаются с ошибкой контекста. Результаты,
+// найденные в кеше WithResultCache, в общие запросы не включаются.
+func (db *DB) GetBatch(ctx context.Context, reqs []Request) ([]*Details, []error) {
+	results := make([]*Details, len(reqs))
+	errs := make([]error, len(reqs))
+	keys := make([][]Key, len(reqs))
+	defer func() {
+		for i := range reqs {
+			db.metrics.lookup(len(keys[i]), results[i], errs[i])
+			db.logLookup(ctx, keys[i], results[i], errs[i])
+		}
+	}()
+	candidates := make([][][]Key, len(reqs))
+	towers := make([][]*locator.CellTower, len(reqs))
+	macs := make([][]string, len(reqs))
+	resultKeys := make([]string, len(reqs))
+	var all []Key
+	var allMacs []string
+	seen := make(map[Key]bool)
+	seenMacs := make(map[string]bool)
+	for i, req := range reqs {
+		if errs[i] = db.checkRequest(req); errs[i] != nil {
+			if errs[i] == ErrEmptyRequest && db.ipLookup(req) {
+				results[i], errs[i] = db.locateIP(ctx, req, nil, nil, errs[i])
+			}
+			continue
+		}
+		keys[i], towers[i], candidates[i] = db.requestCandidates(req)
+		macs[i] = db.requestMacs(req)
+		if db.resultsTTL > 0 {
+			resultKeys[i] = db.resultKey(allCandidates(candidates[i]), macs[i]) + req.Options.cacheKey()
+			if details, ok := db.cachedResult(ctx, resultKeys[i]); ok {
+				results[i] = details
+				continue
+			}
+		}
+		for _, key := range allCandidates(candidates[i]) {
+			if !seen[key] {
+				seen[key] = true
+				all = append(all, key)
+			}
+		}
+		for _, mac := range macs[i] {
+			if !seenMacs[mac] {
+				seenMacs[mac] = true
+				allMacs = append(allMacs, mac)
+			}
+		}
+	}
+	cells, err := db.queryCells(ctx, all)
+	var points []AccessPoint
+	if err == nil {
+		points, err = db.queryWifi(ctx, allMacs)
+	}
+	if err != nil {
+		for i := range errs {
+			if errs[i] == nil && results[i] == nil {
+				errs[i] = err
+			}
+		}
+		return results, errs
+	}
+	index := cellIndex(cells)
+	wifiIndex := make(map[string]AccessPoint, len(points))
+	for _, point := range points {
+		wifiIndex[point.MAC] = point
+	}
+	for i := range reqs {
+		if errs[i] != nil || results[i] != nil {
+			continue
+		}
+		var found []Cell
+		keys[i], found = resolveCandidates(candidates[i], index)
+		var foundWifi []AccessPoint
+		for _, mac := range macs[i] {
+			if point, ok := wifiIndex[mac]; ok {
+				foundWifi = append(foundWifi, point)
+			}
+		}
+		opts := reqs[i].Options
+		results[i], errs[i] = db.locateWith(opts, keys[i], towers[i], found, macs[i], foundWifi)
+		if db.fuzzyLookup(opts) > 0 && errors.Is(errs[i], ErrNotFound) {
+			results[i], errs[i] = db.locateFuzzy(ctx, opts, keys[i], towers[i], macs[i], foundWifi, errs[i])
+		}
+		if db.areaFallback(opts) && errors.Is(errs[i], ErrNotFound) {
+			results[i], errs[i] = db.locateArea(ctx, opts, keys[i], towers[i], macs[i], errs[i])
+		}
+		if db.ipLookup(reqs[i]) && errors.Is(errs[i], ErrNotFound) {
+			results[i], errs[i] = db.locateIP(ctx, reqs[i], keys[i], macs[i], errs[i])
+		}
+		if db.countryLookup(opts) && errors.Is(errs[i], ErrNotFound) {
+			results[i], errs[i] = db.locateCountry(opts, keys[i], towers[i], macs[i], errs[i])
+		}
+		if errs[i] == nil && resultKeys[i] != "" && !results[i].GeoIP {
+			db.cacheResult(ctx, resultKeys[i], results[i])
+		}
+	}
+	return results, errs
+}
+
+// GetResponses вычисляет координаты сразу для нескольких запросов так же, как GetBatch, и
+// возвращает только ответы, как Get. Метод удобен для обработки накопленных данных устройств,
+// когда подробности вычисления не нужны. Ответы и ошибки возвращаются в том же порядке, что и
+// запросы; для запросов, координаты которых вычислить не удалось, ответ равен nil.
+func (db *DB) GetResponses(ctx context.Context, reqs []locator.Request) ([]*locator.Response, []error) {
+	batch := make([]Request, len(reqs))
+	for i, req := range reqs {
+		batch[i] = Request{Request: req}
+	}
+	results, errs := db.GetBatch(ctx, batch)
+	responses := make([]*locator.Response, len(reqs))
+	for i, details := range results {
+		if errs[i] == nil && details != nil {
+			responses[i] = details.Response
+		}
+	}
+	return responses, errs
+}
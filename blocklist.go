@@ -0,0 +1,85 @@
+package lbs
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BlocklistCollectionName описывает название коллекции со списком заблокированных вышек.
+var BlocklistCollectionName = "lbs_blocklist"
+
+// BlockedCell описывает вышку, заблокированную для использования: например, заведомо ошибочную
+// запись или перемещенное тестовое оборудование.
+//
+// Запись о такой вышке в хранилище не удаляется, а помечается флагом blocked и пропускается при
+// вычислении координат. Список хранится отдельно от данных, поэтому lbs-import помечает такие
+// вышки заново при каждом импорте, и они не "воскресают" после обновления базы.
+type BlockedCell struct {
+	Key    Key       `bson:"_id" json:"key"`
+	Reason string    `bson:"reason,omitempty" json:"reason,omitempty"` // причина блокировки
+	Added  time.Time `bson:"added" json:"added"`                       // время блокировки
+}
+
+// notBlocked задает условие запроса, исключающее заблокированные записи.
+var notBlocked = bson.M{"$ne": true}
+
+// Block добавляет вышку в список заблокированных и помечает ее запись в хранилище. Повторная
+// блокировка обновляет причину.
+func (db *DB) Block(ctx context.Context, key Key, reason string) error {
+	mdb, err := db.database()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	_, err = mdb.Collection(BlocklistCollectionName).ReplaceOne(ctx, bson.M{"_id": key}, BlockedCell{
+		Key:    key,
+		Reason: reason,
+		Added:  db.clock.Now(),
+	}, options.Replace().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	_, err = mdb.Collection(db.collection(key.MobileCountryCode)).UpdateMany(ctx, key,
+		bson.M{"$set": bson.M{"blocked": true}})
+	if db.cache != nil {
+		db.cache.Delete(db.cacheKey(key))
+	}
+	return err
+}
+
+// Unblock удаляет вышку из списка заблокированных и снимает пометку с ее записи в хранилище.
+// Пометка снимается, даже если вышки нет в списке.
+func (db *DB) Unblock(ctx context.Context, key Key) error {
+	mdb, err := db.database()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	if _, err = mdb.Collection(BlocklistCollectionName).DeleteOne(ctx, bson.M{"_id": key}); err != nil {
+		return err
+	}
+	_, err = mdb.Collection(db.collection(key.MobileCountryCode)).UpdateMany(ctx, key,
+		bson.M{"$unset": bson.M{"blocked": ""}})
+	if db.cache != nil {
+		db.cache.Delete(db.cacheKey(key))
+	}
+	return err
+}
+
+// Blocklist возвращает список заблокированных вышек.
+func (db *DB) Blocklist(ctx context.Context) (cells []BlockedCell, err error) {
+	mdb, err := db.database()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	err = findAll(ctx, mdb.Collection(BlocklistCollectionName), bson.M{}, &cells,
+		options.Find().SetSort(bson.M{"added": 1}))
+	return cells, err
+}
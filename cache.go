@@ -0,0 +1,271 @@
+package lbs
+
+import (
+	"container/list"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/geotrace/geo"
+)
+
+// Cache описывает кеш записей о вышках, используемый при вычислении координат. Значения
+// передаются в виде байтов, поэтому кеш может быть реализован поверх любого внешнего хранилища
+// (memcached, Redis и т.п.). Пустое значение тоже является допустимым: так кешируется отсутствие
+// записи о вышке.
+//
+// Реализации должны быть безопасны для использования из нескольких потоков. Ошибки внешнего
+// хранилища не должны мешать вычислению координат, поэтому методы их не возвращают: при ошибке Get
+// просто сообщает об отсутствии значения.
+type Cache interface {
+	Get(key string) (value []byte, ok bool)
+	Set(key string, value []byte, ttl time.Duration)
+	Delete(key string)
+}
+
+// BatchCache описывает кеш, который читает и сохраняет несколько значений за одно обращение к
+// внешнему хранилищу. Если кеш WithCache реализует этот интерфейс, то записи о вышках одного
+// запроса читаются из кеша и сохраняются в него за одно обращение, а не по одной, что сокращает
+// задержку при удаленном кеше (см. пакет github.com/geotrace/lbs/v2/rediscache).
+type BatchCache interface {
+	Cache
+	// GetMulti возвращает найденные в кеше значения по ключу. Ошибки внешнего хранилища
+	// считаются отсутствием значений.
+	GetMulti(keys []string) map[string][]byte
+	// SetMulti сохраняет значения в кеш на время ttl.
+	SetMulti(values map[string][]byte, ttl time.Duration)
+}
+
+// WithCache включает кеширование записей о вышках (в том числе об их отсутствии) на время ttl.
+// Изменения, внесенные в хранилище импортом, становятся видны после устаревания записей в кеше;
+// Block и Unblock удаляют запись о вышке из кеша сразу.
+func WithCache(cache Cache, ttl time.Duration) Option {
+	return func(db *DB) {
+		db.cache = cache
+		db.cacheTTL = ttl
+	}
+}
+
+// cacheKey возвращает ключ кеша для записи о вышке.
+func (db *DB) cacheKey(key Key) string {
+	return fmt.Sprintf("lbs:%s.%s:%s:%d:%d:%d:%d", db.name, db.collection(key.MobileCountryCode),
+		key.RadioType, key.MobileCountryCode, key.MobileNetworkCode, key.LocationAreaCode, key.CellId)
+}
+
+// cachedCells возвращает записи о вышках, найденные в кеше, и ключи вышек, которых в кеше нет.
+func (db *DB) cachedCells(keys []Key) (cells []Cell, missing []Key) {
+	var values map[string][]byte
+	if batch, ok := db.cache.(BatchCache); ok && len(keys) > 1 {
+		names := make([]string, len(keys))
+		for i, key := range keys {
+			names[i] = db.cacheKey(key)
+		}
+		values = batch.GetMulti(names)
+	}
+	for _, key := range keys {
+		var (
+			value []byte
+			ok    bool
+		)
+		if values != nil {
+			value, ok = values[db.cacheKey(key)]
+		} else {
+			value, ok = db.cache.Get(db.cacheKey(key))
+		}
+		if !ok {
+			missing = append(missing, key)
+			continue
+		}
+		if len(value) == 0 {
+			continue // в хранилище нет записи о вышке
+		}
+		cell, err := unpackCell(key, value)
+		if err != nil {
+			missing = append(missing, key)
+			continue
+		}
+		cells = append(cells, cell)
+	}
+	return cells, missing
+}
+
+// cacheCells сохраняет в кеш найденные записи о вышках и отсутствие записей для остальных ключей.
+func (db *DB) cacheCells(keys []Key, cells []Cell) {
+	values := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		values[db.cacheKey(key)] = []byte{}
+	}
+	for _, cell := range cells {
+		values[db.cacheKey(cell.Key)] = packCell(cell)
+	}
+	if batch, ok := db.cache.(BatchCache); ok && len(values) > 1 {
+		batch.SetMulti(values, db.cacheTTL)
+		return
+	}
+	for name, value := range values {
+		db.cache.Set(name, value, db.cacheTTL)
+	}
+}
+
+// packedCellVersion задает первый байт значения packCell. Значения в JSON, сохраненные прежними
+// версиями, начинаются с '{' и по-прежнему читаются unpackCell.
+const packedCellVersion = 1
+
+// packedScale задает количество единиц координат packCell в одном градусе.
+const packedScale = 1e7
+
+// errBadPackedCell возвращается unpackCell для поврежденного значения.
+var errBadPackedCell = errors.New("lbs: bad cached cell")
+
+// packCell возвращает компактное двоичное значение записи о вышке для кеша: координаты в единицах
+// 1/packedScale градуса, радиус, количество подтверждений, дисперсия, площадка и время измерений в
+// секундах Unix. Ключ записи в значение не входит: он уже содержится в ключе кеша, поэтому значение
+// занимает около 30 байт против нескольких сотен в JSON.
+func packCell(cell Cell) []byte {
+	buf := make([]byte, 0, 48)
+	buf = append(buf, packedCellVersion)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(int32(math.Round(cell.Location.Longitude()*packedScale))))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(int32(math.Round(cell.Location.Latitude()*packedScale))))
+	buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(cell.Accuracy)))
+	buf = binary.AppendUvarint(buf, uint64(cell.Samples))
+	buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(cell.Variance)))
+	buf = binary.AppendUvarint(buf, cell.Site)
+	buf = binary.AppendUvarint(buf, uint64(unixSeconds(cell.Created)))
+	buf = binary.AppendUvarint(buf, uint64(unixSeconds(cell.Updated)))
+	return buf
+}
+
+// unixSeconds возвращает время в секундах Unix или 0 для нулевого времени.
+func unixSeconds(t time.Time) uint32 {
+	if t.IsZero() || t.Unix() <= 0 {
+		return 0
+	}
+	return uint32(t.Unix())
+}
+
+// unpackCell восстанавливает запись о вышке с ключом key из значения packCell или из JSON.
+func unpackCell(key Key, value []byte) (cell Cell, err error) {
+	if len(value) > 0 && value[0] == '{' {
+		err = json.Unmarshal(value, &cell)
+		return cell, err
+	}
+	if len(value) < 17 || value[0] != packedCellVersion {
+		return cell, errBadPackedCell
+	}
+	cell.Key = key
+	lon := int32(binary.LittleEndian.Uint32(value[1:]))
+	lat := int32(binary.LittleEndian.Uint32(value[5:]))
+	cell.Location = geo.NewPoint(float64(lon)/packedScale, float64(lat)/packedScale)
+	cell.Accuracy = float64(math.Float32frombits(binary.LittleEndian.Uint32(value[9:])))
+	value = value[13:]
+	samples, n := binary.Uvarint(value)
+	if n <= 0 || len(value) < n+4 {
+		return cell, errBadPackedCell
+	}
+	cell.Samples = int(samples)
+	cell.Variance = float64(math.Float32frombits(binary.LittleEndian.Uint32(value[n:])))
+	value = value[n+4:]
+	var fields [3]uint64
+	for i := range fields {
+		if fields[i], n = binary.Uvarint(value); n <= 0 {
+			return cell, errBadPackedCell
+		}
+		value = value[n:]
+	}
+	cell.Site = fields[0]
+	if fields[1] > 0 {
+		cell.Created = time.Unix(int64(fields[1]), 0).UTC()
+	}
+	if fields[2] > 0 {
+		cell.Updated = time.Unix(int64(fields[2]), 0).UTC()
+	}
+	return cell, nil
+}
+
+// LRUCache описывает кеш в памяти ограниченного размера: при переполнении удаляются значения,
+// которые дольше всего не запрашивались.
+type LRUCache struct {
+	mu    sync.Mutex
+	size  int                      // максимальное количество значений
+	items map[string]*list.Element // значения по ключу
+	order *list.List               // порядок использования: в начале — последние
+	clock Clock                    // источник текущего времени
+}
+
+// lruEntry описывает значение в LRUCache.
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRUCache возвращает новый кеш в памяти, хранящий не более size значений.
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{
+		size:  size,
+		items: make(map[string]*list.Element, size),
+		order: list.New(),
+		clock: systemClock{},
+	}
+}
+
+// Get возвращает значение из кеша, если оно есть и еще не устарело.
+func (c *LRUCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := item.Value.(*lruEntry)
+	if !c.clock.Now().Before(entry.expires) {
+		c.order.Remove(item)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.order.MoveToFront(item)
+	return entry.value, true
+}
+
+// Set сохраняет значение в кеш на время ttl.
+func (c *LRUCache) Set(key string, value []byte, ttl time.Duration) {
+	if c.size <= 0 || ttl <= 0 {
+		return
+	}
+	expires := c.clock.Now().Add(ttl)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if item, ok := c.items[key]; ok {
+		entry := item.Value.(*lruEntry)
+		entry.value, entry.expires = value, expires
+		c.order.MoveToFront(item)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// Delete удаляет значение из кеша.
+func (c *LRUCache) Delete(key string) {
+	c.mu.Lock()
+	if item, ok := c.items[key]; ok {
+		c.order.Remove(item)
+		delete(c.items, key)
+	}
+	c.mu.Unlock()
+}
+
+// Len возвращает количество значений в кеше.
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
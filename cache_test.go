@@ -0,0 +1,114 @@
+package lbs
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/geotrace/geo"
+)
+
+var _ Cache = (*LRUCache)(nil)
+
+func TestLRUCache(t *testing.T) {
+	clock := &testClock{now: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cache := NewLRUCache(2)
+	cache.clock = clock
+	cache.Set("a", []byte("1"), time.Minute)
+	cache.Set("b", []byte{}, time.Minute)
+	if value, ok := cache.Get("a"); !ok || string(value) != "1" {
+		t.Errorf("bad value: %q, %v", value, ok)
+	}
+	if value, ok := cache.Get("b"); !ok || len(value) != 0 {
+		t.Errorf("empty value not cached: %q, %v", value, ok)
+	}
+	cache.Get("a")
+	cache.Set("c", []byte("3"), time.Minute) // вытесняет b, который дольше не запрашивался
+	if _, ok := cache.Get("b"); ok {
+		t.Error("least recently used value not evicted")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("bad cache size: %d", cache.Len())
+	}
+	cache.Delete("c")
+	if _, ok := cache.Get("c"); ok {
+		t.Error("deleted value returned")
+	}
+	clock.add(time.Minute)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("expired value returned")
+	}
+}
+
+func TestPackCell(t *testing.T) {
+	cell := Cell{
+		Key:      Key{RadioType: "lte", MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 10, CellId: 26216458},
+		Data:     Data{Location: geo.NewPoint(37.6173, 55.7558), Accuracy: 500},
+		Samples:  1200,
+		Variance: 2500,
+		Site:     26216457,
+		Created:  time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+		Updated:  time.Date(2020, 1, 1, 12, 30, 0, 0, time.UTC),
+	}
+	value := packCell(cell)
+	got, err := unpackCell(cell.Key, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Key != cell.Key || got.Accuracy != cell.Accuracy || got.Samples != cell.Samples ||
+		got.Variance != cell.Variance || got.Site != cell.Site || !got.Created.Equal(cell.Created) ||
+		!got.Updated.Equal(cell.Updated) || Distance(got.Location, cell.Location) > 0.1 {
+		t.Errorf("bad unpacked cell: %+v", got)
+	}
+	if _, err := unpackCell(cell.Key, value[:len(value)-1]); err == nil {
+		t.Error("truncated value unpacked")
+	}
+	old, _ := json.Marshal(cell)
+	if got, err := unpackCell(cell.Key, old); err != nil || got.Samples != cell.Samples {
+		t.Errorf("JSON value not unpacked: %+v, %v", got, err)
+	}
+}
+
+// batchCache считает обращения к кешу за несколькими значениями.
+type batchCache struct {
+	*LRUCache
+	gets, sets int
+}
+
+func (c *batchCache) GetMulti(keys []string) map[string][]byte {
+	c.gets++
+	values := make(map[string][]byte)
+	for _, key := range keys {
+		if value, ok := c.Get(key); ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+func (c *batchCache) SetMulti(values map[string][]byte, ttl time.Duration) {
+	c.sets++
+	for key, value := range values {
+		c.Set(key, value, ttl)
+	}
+}
+
+func TestBatchCache(t *testing.T) {
+	cache := &batchCache{LRUCache: NewLRUCache(10)}
+	db := &DB{cache: cache, cacheTTL: time.Minute}
+	keys := []Key{
+		{RadioType: "gsm", MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517},
+		{RadioType: "gsm", MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22518},
+	}
+	if cells, missing := db.cachedCells(keys); len(cells) != 0 || len(missing) != 2 {
+		t.Fatalf("bad empty cache: %+v, %+v", cells, missing)
+	}
+	db.cacheCells(keys, []Cell{{Key: keys[0], Data: Data{Location: geo.NewPoint(37.6, 55.7), Accuracy: 1000}}})
+	cells, missing := db.cachedCells(keys)
+	if len(cells) != 1 || cells[0].Key != keys[0] || cells[0].Accuracy != 1000 || len(missing) != 0 {
+		t.Errorf("bad cached cells: %+v, %+v", cells, missing)
+	}
+	if cache.gets != 2 || cache.sets != 1 {
+		t.Errorf("cache not batched: %d gets, %d sets", cache.gets, cache.sets)
+	}
+}
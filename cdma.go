@@ -0,0 +1,9 @@
+package lbs
+
+import "github.com/geotrace/lbs/v2/storage"
+
+// CDMAKey возвращает ключ вышки CDMA по номерам системы (SID), сети (NID) и базовой станции (BID)
+// (см. storage.CDMAKey).
+func CDMAKey(mcc, sid, nid, bid uint16) Key {
+	return storage.CDMAKey(mcc, sid, nid, bid)
+}
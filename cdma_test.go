@@ -0,0 +1,52 @@
+package lbs
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/geotrace/lbs/v2/storage"
+	"github.com/geotrace/locator"
+)
+
+func TestCDMA(t *testing.T) {
+	key := CDMAKey(310, 4143, 0, 21)
+	if !key.Valid() {
+		t.Errorf("CDMA key with NID 0 is not valid: %+v", key)
+	}
+	if sid, nid, bid, ok := key.CDMA(); !ok || sid != 4143 || nid != 0 || bid != 21 {
+		t.Errorf("bad CDMA identities: %d, %d, %d, %v", sid, nid, bid, ok)
+	}
+	if CDMAKey(310, 4143, storage.MaxCDMANetworkId, 21).Valid() || CDMAKey(310, 4143, 1, 0).Valid() {
+		t.Error("reserved CDMA identities are valid")
+	}
+
+	var request Request
+	err := json.Unmarshal([]byte(`{"cellTowers": [
+		{"mobileCountryCode": 310, "systemId": 4143, "networkId": 0, "basestationId": 21, "signalStrength": -80},
+		{"radioType": "cdma", "mobileCountryCode": 310, "mobileNetworkCode": 4143, "locationAreaCode": 2, "cellId": 22}
+	]}`), &request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := request.Validate(); err != nil {
+		t.Errorf("CDMA request is not valid: %v", err)
+	}
+	keys := requestKeys(request, 0)
+	if len(keys) != 2 || keys[0] != key || keys[1] != CDMAKey(310, 4143, 2, 22) {
+		t.Errorf("bad CDMA keys: %+v", keys)
+	}
+	bad := Request{
+		Request: locator.Request{RadioType: "cdma", CellTowers: []*locator.CellTower{
+			{MobileCountryCode: 310, MobileNetworkCode: 40000, LocationAreaCode: 1, CellId: 70000},
+		}},
+	}
+	if errs, ok := bad.Validate().(ValidationError); !ok || len(errs) != 2 {
+		t.Errorf("bad CDMA identities accepted: %v", errs)
+	}
+
+	var cell Cell
+	if err := cell.UnmarshalCSV([]string{"CDMA", "310", "4143", "0", "21", "", "-97.7", "30.3", "3000", "5"}); err != nil ||
+		cell.Key != key || !cell.Valid() {
+		t.Errorf("bad imported CDMA cell: %+v, %v", cell.Key, err)
+	}
+}
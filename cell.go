@@ -0,0 +1,9 @@
+package lbs
+
+import "github.com/geotrace/lbs/v2/storage"
+
+// Cell описывает запись о сотовой вышке в хранилище (см. storage.Cell).
+type Cell = storage.Cell
+
+// CellHeader содержит заголовок файла с вышками в формате OpenCellID и Mozilla Location Service.
+var CellHeader = storage.CellHeader
@@ -0,0 +1,68 @@
+package lbs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/geotrace/geo"
+)
+
+func TestCellCSV(t *testing.T) {
+	cell := Cell{
+		Key:     Key{RadioType: "lte", MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 7703, CellId: 26310},
+		Data:    Data{Location: geo.NewPoint(37.617698, 55.755864), Accuracy: 1250},
+		Samples: 12,
+		Created: time.Date(2014, 3, 1, 10, 0, 0, 0, time.UTC),
+		Updated: time.Date(2016, 5, 2, 12, 30, 0, 0, time.UTC),
+	}
+	record := cell.MarshalCSV()
+	want := []string{"LTE", "250", "1", "7703", "26310", "", "37.617698", "55.755864", "1250", "12",
+		"1", "1393668000", "1462192200", ""}
+	if !reflect.DeepEqual(record, want) {
+		t.Fatalf("bad CSV record: %q", record)
+	}
+	var parsed Cell
+	if err := parsed.UnmarshalCSV(record); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, cell) {
+		t.Errorf("bad parsed cell: %+v", parsed)
+	}
+	// время создания и изменения необязательно
+	if err := parsed.UnmarshalCSV(record[:10]); err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Created.IsZero() || !parsed.Updated.IsZero() || parsed.Samples != 12 {
+		t.Errorf("bad parsed cell: %+v", parsed)
+	}
+	if record := parsed.MarshalCSV(); record[11] != "" || record[12] != "" {
+		t.Errorf("zero time marshaled: %q", record)
+	}
+
+	// TAC и NCI сетей 5G NR не помещаются в 16 и 32 бита
+	wide := []string{"NR", "250", "1", "16777215", "68719476735", "", "37.6", "55.7", "1000", "1"}
+	if err := parsed.UnmarshalCSV(wide); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.LocationAreaCode != 1<<24-1 || parsed.CellId != 1<<36-1 {
+		t.Errorf("wide identifiers truncated: %+v", parsed.Key)
+	}
+	if record := parsed.MarshalCSV(); record[3] != wide[3] || record[4] != wide[4] {
+		t.Errorf("bad wide identifiers: %q", record)
+	}
+
+	for _, bad := range [][]string{
+		record[:9],
+		{"GSM", "250x", "1", "1", "1", "", "37.6", "55.7", "1000", "1"},
+		{"GSM", "250", "1", "16777216", "1", "", "37.6", "55.7", "1000", "1"},
+		{"NR", "250", "1", "1", "68719476736", "", "37.6", "55.7", "1000", "1"},
+		{"GSM", "250", "1", "1", "1", "", "east", "55.7", "1000", "1"},
+		{"GSM", "250", "1", "1", "1", "", "37.6", "55.7", "1000", "many"},
+		{"GSM", "250", "1", "1", "1", "", "37.6", "55.7", "1000", "1", "1", "yesterday"},
+	} {
+		if err := parsed.UnmarshalCSV(bad); err == nil {
+			t.Errorf("bad record accepted: %q", bad)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package lbs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/locator"
+)
+
+// Locator описывает сервис вычисления координат по данным вышек сотовой связи. Этому интерфейсу
+// соответствует DB, а клиенты удаленных сервисов геолокации из github.com/geotrace/locator можно
+// привести к нему с помощью Remote.
+type Locator interface {
+	Get(ctx context.Context, req locator.Request) (*locator.Response, error)
+}
+
+var _ Locator = (*DB)(nil)
+
+// Service описывает сервис вычисления координат без поддержки контекста, например, клиент
+// удаленного сервиса геолокации из github.com/geotrace/locator.
+type Service interface {
+	Get(req locator.Request) (*locator.Response, error)
+}
+
+// Remote возвращает Locator для сервиса без поддержки контекста. При отмене контекста Get сразу
+// возвращает его ошибку, не дожидаясь ответа, но сам запрос к сервису продолжает выполняться.
+//
+// Ответ сервиса без координат и ошибки, которые IsRemoteNotFound считает ненайденными
+// координатами, возвращаются как ErrNotFound (исходная ошибка доступна через errors.Is и
+// errors.As), поэтому Chain переходит к следующему сервису. Если клиент сервиса сообщает об этом
+// иначе, используйте RemoteNotFound.
+func Remote(s Service) Locator {
+	return RemoteNotFound(s, IsRemoteNotFound)
+}
+
+// RemoteNotFound возвращает Locator для сервиса без поддержки контекста, как и Remote, но ошибки
+// сервиса заменяются на ErrNotFound, если для них notFound возвращает true.
+func RemoteNotFound(s Service, notFound func(error) bool) Locator {
+	return remote{Service: s, notFound: notFound}
+}
+
+// IsRemoteNotFound возвращает true, если ошибка удаленного сервиса означает, что координаты не
+// найдены: это ErrNotFound или ошибка со словами "not found" или "notFound" в описании, как в
+// ответах 404 Google Geolocation API и Mozilla Location Service.
+func IsRemoteNotFound(err error) bool {
+	if errors.Is(err, ErrNotFound) {
+		return true
+	}
+	message := strings.ToLower(strings.ReplaceAll(err.Error(), " ", ""))
+	return strings.Contains(message, "notfound")
+}
+
+// remote приводит Service к интерфейсу Locator.
+type remote struct {
+	Service
+	notFound func(error) bool // ошибки сервиса, означающие ненайденные координаты
+}
+
+// Get возвращает ответ сервиса или ошибку контекста, если он был отменен раньше.
+func (r remote) Get(ctx context.Context, req locator.Request) (resp *locator.Response, err error) {
+	err = withContext(ctx, func() (err error) {
+		resp, err = r.Service.Get(req)
+		return err
+	})
+	switch {
+	case err == nil && (resp == nil || resp.Location == locator.Point{}):
+		return nil, ErrNotFound
+	case err == nil:
+		return resp, nil
+	case ctx.Err() == nil && !errors.Is(err, ErrNotFound) && r.notFound != nil && r.notFound(err):
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return nil, err
+}
+
+// Chain объединяет несколько сервисов вычисления координат: запрос передается им по очереди до
+// первого успешного ответа. Обычно первым указывается DB, а за ним — удаленные сервисы, к которым
+// стоит обращаться, только если вышки не найдены во внутренней базе:
+//
+//	chain := lbs.Chain{db, lbs.Remote(mozilla), lbs.Remote(yandex)}
+//	resp, err := chain.Get(ctx, req)
+//
+// Следующий сервис опрашивается, только если предыдущий вернул ErrNotFound. Остальные ошибки, в
+// том числе недоступность MongoDB, истечение времени запроса или неверный запрос, возвращаются
+// сразу: иначе сбой внутренней базы незаметно перенаправил бы все запросы в удаленные сервисы.
+// Remote заменяет на ErrNotFound ответы удаленных сервисов, не нашедших координаты. Если ни один
+// сервис не нашел координаты, то возвращается ошибка последнего из них. При отмене контекста
+// оставшиеся сервисы не опрашиваются. Чтобы ответы удаленных сервисов пополняли внутреннюю базу,
+// оберните их с помощью DB.Learn.
+type Chain []Locator
+
+// Get возвращает первый успешный ответ сервисов из списка.
+func (c Chain) Get(ctx context.Context, req locator.Request) (resp *locator.Response, err error) {
+	err = ErrNotFound
+	for _, l := range c {
+		if resp, err = l.Get(ctx, req); err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// Multi объединяет несколько сервисов вычисления координат: запрос передается всем сервисам
+// одновременно и возвращается ответ с наилучшей точностью. Если ни один сервис не ответил, то
+// возвращается ошибка первого из них.
+type Multi []Locator
+
+// Get возвращает ответ с наименьшим радиусом точности из всех успешных ответов сервисов.
+func (m Multi) Get(ctx context.Context, req locator.Request) (*locator.Response, error) {
+	if len(m) == 0 {
+		return nil, ErrNotFound
+	}
+	responses := make([]*locator.Response, len(m))
+	errs := make([]error, len(m))
+	var wg sync.WaitGroup
+	for i, l := range m {
+		wg.Add(1)
+		go func(i int, l Locator) {
+			responses[i], errs[i] = l.Get(ctx, req)
+			wg.Done()
+		}(i, l)
+	}
+	wg.Wait()
+	var best *locator.Response
+	for i, resp := range responses {
+		if errs[i] != nil || resp == nil {
+			continue
+		}
+		if best == nil || resp.Accuracy < best.Accuracy {
+			best = resp
+		}
+	}
+	if best == nil {
+		if errs[0] != nil {
+			return nil, errs[0]
+		}
+		return nil, ErrNotFound
+	}
+	return best, nil
+}
+
+// Learn возвращает Locator, который передает запросы l, а каждый успешный ответ сохраняет как
+// исходные измерения (см. Observation) всех вышек из запроса в вычисленной точке. Команда
+// lbs-admin estimate вычисляет по накопленным измерениям местоположение вышек, поэтому вышки,
+// которые приходилось искать в удаленных сервисах, со временем появляются во внутренней базе:
+//
+//	chain := lbs.Chain{db, db.Learn(lbs.Remote(mozilla))}
+//
+// Ответы с точностью хуже 1000 м не сохраняются: EstimateCell их все равно не использует.
+// Ошибка сохранения не влияет на ответ.
+func (db *DB) Learn(l Locator) Locator {
+	return &learner{db: db, Locator: l}
+}
+
+// learner сохраняет ответы сервиса как измерения в хранилище.
+type learner struct {
+	Locator
+	db *DB
+}
+
+// Get возвращает ответ сервиса и сохраняет его как измерения вышек из запроса.
+func (l *learner) Get(ctx context.Context, req locator.Request) (*locator.Response, error) {
+	resp, err := l.Locator.Get(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	observations := l.db.observations(req, resp)
+	if len(observations) == 0 {
+		return resp, nil
+	}
+	if mdb, err := l.db.database(); err == nil {
+		ctx, cancel := l.db.queryContext(ctx)
+		defer cancel()
+		docs := make([]interface{}, len(observations))
+		for i := range observations {
+			docs[i] = observations[i]
+		}
+		// ошибка не влияет на ответ
+		if _, err := mdb.Collection(ObservationsCollectionName).InsertMany(ctx, docs); err != nil {
+			l.db.log().WarnContext(ctx, "Observations not saved", "count", len(docs), "error", err)
+		}
+	}
+	return resp, nil
+}
+
+// observations возвращает измерения вышек из запроса в точке, вычисленной удаленным сервисом.
+func (db *DB) observations(req locator.Request, resp *locator.Response) []Observation {
+	if resp == nil || resp.Accuracy <= 0 || resp.Accuracy > estimateMaxAccuracy {
+		return nil
+	}
+	return db.observationsAt(Request{Request: req}, geo.NewPoint(resp.Location.Lng, resp.Location.Lat),
+		resp.Accuracy, db.clock.Now())
+}
+
+// observationsAt возвращает измерения вышек из запроса в точке location с точностью accuracy.
+func (db *DB) observationsAt(req Request, location geo.Point, accuracy float64, measured time.Time) []Observation {
+	keys, towers := requestTowers(req, db.maxTowers)
+	observations := make([]Observation, len(keys))
+	for i, key := range keys {
+		observations[i] = Observation{
+			Key:           key,
+			Location:      location,
+			Accuracy:      accuracy,
+			TimingAdvance: int(towers[i].TimingAdvance),
+			Measured:      measured,
+		}
+		if signal, ok := SignalDBm(key.RadioType, int(towers[i].SignalStrength)); ok {
+			observations[i].Signal = signal
+		}
+	}
+	return observations
+}
@@ -0,0 +1,173 @@
+package lbs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/geotrace/locator"
+)
+
+// fixedLocator всегда возвращает один и тот же ответ или ошибку.
+type fixedLocator struct {
+	resp  *locator.Response
+	err   error
+	calls int
+}
+
+func (l *fixedLocator) Get(ctx context.Context, req locator.Request) (*locator.Response, error) {
+	l.calls++
+	return l.resp, l.err
+}
+
+func TestChain(t *testing.T) {
+	errRemote := errors.New("remote error")
+	failed := &fixedLocator{err: ErrNotFound}
+	coarse := &fixedLocator{resp: &locator.Response{Accuracy: 5000}}
+	fine := &fixedLocator{resp: &locator.Response{Accuracy: 100}}
+	broken := &fixedLocator{err: errRemote}
+
+	resp, err := Chain{failed, coarse, fine}.Get(context.Background(), locator.Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != coarse.resp {
+		t.Error("chain returned not first success")
+	}
+	if fine.calls != 0 {
+		t.Error("chain called locator after success")
+	}
+	if _, err := (Chain{failed, broken}).Get(context.Background(), locator.Request{}); err != errRemote {
+		t.Errorf("chain returned bad error: %v", err)
+	}
+	// ошибки, кроме ErrNotFound, не передают запрос следующему сервису
+	if _, err := (Chain{broken, fine}).Get(context.Background(), locator.Request{}); err != errRemote {
+		t.Errorf("chain returned bad error: %v", err)
+	}
+	if fine.calls != 0 {
+		t.Error("chain called locator after error")
+	}
+	missing := &fixedLocator{err: &NotFoundError{Missing: []Key{{CellId: 1}}}}
+	if resp, err := (Chain{missing, fine}).Get(context.Background(), locator.Request{}); err != nil || resp != fine.resp {
+		t.Errorf("chain not fell back after not found: %v", err)
+	}
+	if _, err := (Chain{}).Get(context.Background(), locator.Request{}); err != ErrNotFound {
+		t.Errorf("empty chain returned bad error: %v", err)
+	}
+
+	resp, err = Multi{failed, coarse, fine, broken}.Get(context.Background(), locator.Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != fine.resp {
+		t.Error("multi returned not best accuracy")
+	}
+	if _, err := (Multi{broken, failed}).Get(context.Background(), locator.Request{}); err != errRemote {
+		t.Errorf("multi returned bad error: %v", err)
+	}
+}
+
+// blockedService не отвечает, пока не закрыт канал release.
+type blockedService struct {
+	release chan struct{}
+}
+
+func (s blockedService) Get(req locator.Request) (*locator.Response, error) {
+	<-s.release
+	return &locator.Response{}, nil
+}
+
+func TestRemote(t *testing.T) {
+	service := blockedService{release: make(chan struct{})}
+	defer close(service.release)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Remote(service).Get(ctx, locator.Request{}); err != context.Canceled {
+		t.Errorf("remote returned bad error: %v", err)
+	}
+	fine := &fixedLocator{resp: &locator.Response{Accuracy: 100}}
+	if _, err := (Chain{Remote(service), fine}).Get(ctx, locator.Request{}); err != context.Canceled {
+		t.Errorf("chain returned bad error: %v", err)
+	}
+	if fine.calls != 0 {
+		t.Error("chain called locator after cancel")
+	}
+}
+
+func TestLearnObservations(t *testing.T) {
+	clock := &testClock{now: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)}
+	db := newDB("test", []Option{WithClock(clock)})
+	req := locator.Request{RadioType: "lte", CellTowers: []*locator.CellTower{
+		{MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 7703, CellId: 26310, SignalStrength: 40},
+		{MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 0, CellId: 1}, // зарезервированный LAC
+	}}
+	resp := &locator.Response{Location: locator.Point{Lat: 55.75, Lng: 37.62}, Accuracy: 150}
+	observations := db.observations(req, resp)
+	if len(observations) != 1 {
+		t.Fatalf("bad observations: %+v", observations)
+	}
+	obs := observations[0]
+	if obs.CellId != 26310 || obs.RadioType != "lte" || obs.Accuracy != 150 || !obs.Measured.Equal(clock.now) ||
+		obs.Location.Latitude() != 55.75 || obs.Signal != -100 {
+		t.Errorf("bad observation: %+v", obs)
+	}
+	if observations := db.observations(req, &locator.Response{Accuracy: 25000}); observations != nil {
+		t.Errorf("coarse response saved: %+v", observations)
+	}
+}
+
+// fixedService всегда возвращает один и тот же ответ или ошибку.
+type fixedService struct {
+	resp  *locator.Response
+	err   error
+	calls *int
+}
+
+func (s fixedService) Get(req locator.Request) (*locator.Response, error) {
+	*s.calls++
+	return s.resp, s.err
+}
+
+func TestChainRemote(t *testing.T) {
+	var calls [3]int
+	local := &fixedLocator{err: &NotFoundError{Missing: []Key{{CellId: 1}}}}
+	mozilla := fixedService{err: errors.New("mozilla: 404 Not Found"), calls: &calls[0]}
+	google := fixedService{err: errors.New(`google: notFound: location not available`), calls: &calls[1]}
+	yandex := fixedService{resp: &locator.Response{Location: locator.Point{Lat: 55.7, Lng: 37.6}, Accuracy: 500},
+		calls: &calls[2]}
+	chain := Chain{local, Remote(mozilla), Remote(google), Remote(yandex)}
+	resp, err := chain.Get(context.Background(), locator.Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != yandex.resp || calls != [3]int{1, 1, 1} {
+		t.Errorf("chain not fell back to the last remote service: %+v, %v", resp, calls)
+	}
+
+	// ответ без координат тоже означает, что координаты не найдены
+	empty := fixedService{resp: &locator.Response{}, calls: &calls[0]}
+	none := fixedService{calls: &calls[1]}
+	chain = Chain{Remote(empty), Remote(none), Remote(yandex)}
+	if resp, err := chain.Get(context.Background(), locator.Request{}); err != nil || resp != yandex.resp {
+		t.Errorf("chain not fell back after empty response: %v", err)
+	}
+
+	// исходная ошибка последнего сервиса сохраняется
+	_, err = Chain{local, Remote(mozilla)}.Get(context.Background(), locator.Request{})
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, mozilla.err) {
+		t.Errorf("bad remote not found error: %v", err)
+	}
+
+	// остальные ошибки удаленных сервисов возвращаются сразу
+	calls = [3]int{}
+	down := fixedService{err: errors.New("dial tcp: connection refused"), calls: &calls[0]}
+	chain = Chain{Remote(down), Remote(yandex)}
+	if _, err := chain.Get(context.Background(), locator.Request{}); err != down.err || calls[2] != 0 {
+		t.Errorf("chain fell back after remote error: %v", err)
+	}
+	chain = Chain{RemoteNotFound(down, func(err error) bool { return err == down.err }), Remote(yandex)}
+	if resp, err := chain.Get(context.Background(), locator.Request{}); err != nil || resp != yandex.resp {
+		t.Errorf("chain not fell back with custom not found: %v", err)
+	}
+}
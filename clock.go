@@ -0,0 +1,23 @@
+package lbs
+
+import "time"
+
+// Clock описывает источник текущего времени. Все зависящие от времени вычисления (время
+// устаревания кешей, возраст данных и т.п.) используют Clock, заданный для DB, поэтому в тестах
+// его можно заменить и получить предсказуемое поведение.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock возвращает системное время.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// WithClock задает источник текущего времени, используемый DB вместо системного времени. Время
+// выполнения запросов в Metrics всегда измеряется по системным часам.
+func WithClock(clock Clock) Option {
+	return func(db *DB) {
+		db.clock = clock
+	}
+}
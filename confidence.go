@@ -0,0 +1,96 @@
+package lbs
+
+import (
+	"math"
+	"time"
+)
+
+// Параметры оценки достоверности координат (см. Details.Confidence).
+const (
+	confidenceSamples   = 5.0                      // подтверждений, при которых запись заслуживает доверия на 63%
+	confidenceAge       = 2 * 365 * 24 * time.Hour // возраст, при котором доверие к записи падает в e раз
+	confidenceUnknown   = 0.5                      // доверие к записи без количества подтверждений или времени измерений
+	confidenceFuzzy     = 0.5                      // множитель для координат по соседним вышкам зоны
+	confidenceArea      = 0.1                      // доверие к координатам центра зоны
+	confidenceIP        = 0.05                     // доверие к координатам по IP-адресу клиента
+	confidenceCountry   = 0.01                     // доверие к координатам центра страны
+	confidencePrecision = 100                      // точность округления оценки
+)
+
+// confidence оценивает достоверность вычисленных координат числом от 0 до 1. Оценка растет с
+// количеством использованных вышек и точек доступа и долей найденных среди запрошенных, а
+// каждая запись учитывается с ее весом и доверием, которое растет с количеством подтверждений и
+// падает с возрастом последнего измерения. Вышки и точки доступа Wi-Fi независимо подтверждают
+// координаты, поэтому оценки групп объединяются как вероятности независимых событий. Координаты
+// по соседним вышкам зоны (см. FuzzyAreaLookup) заслуживают вдвое меньше доверия, а центру зоны
+// (см. GetOptions.AreaFallback), координатам по IP-адресу (см. IPFallback) и центру страны (см.
+// CountryFallback) всегда соответствуют низкие оценки confidenceArea, confidenceIP и
+// confidenceCountry.
+func (db *DB) confidence(details *Details) float64 {
+	switch {
+	case details.Country != "":
+		return confidenceCountry
+	case details.GeoIP:
+		return confidenceIP
+	case details.Area != nil:
+		return confidenceArea
+	}
+	now := db.clock.Now()
+	cells := make([]float64, len(details.Cells))
+	cellWeights := make([]float64, len(details.Cells))
+	for i, match := range details.Cells {
+		cells[i] = recordConfidence(match.Samples, match.LastSeen(), now)
+		cellWeights[i] = match.Weight
+	}
+	wifi := make([]float64, len(details.Wifi))
+	wifiWeights := make([]float64, len(details.Wifi))
+	for i, match := range details.Wifi {
+		seen := match.Updated
+		if seen.IsZero() {
+			seen = match.Created
+		}
+		wifi[i] = recordConfidence(match.Samples, seen, now)
+		wifiWeights[i] = match.Weight
+	}
+	cellScore := groupConfidence(cells, cellWeights, details.CellCounts)
+	wifiScore := groupConfidence(wifi, wifiWeights, details.WifiCounts)
+	score := 1 - (1-cellScore)*(1-wifiScore)
+	if details.Fuzzy {
+		score *= confidenceFuzzy
+	}
+	return math.Round(score*confidencePrecision) / confidencePrecision
+}
+
+// groupConfidence возвращает оценку достоверности координат группы записей (вышек или точек
+// доступа) с доверием values и весами weights.
+func groupConfidence(values, weights []float64, counts Counts) float64 {
+	if counts.Used == 0 || counts.Requested == 0 {
+		return 0
+	}
+	var sum, total float64
+	for i, value := range values {
+		sum += value * weights[i]
+		total += weights[i]
+	}
+	if total <= 0 {
+		return 0
+	}
+	used := float64(counts.Used)
+	return sum / total * used / (used + 1) * math.Min(float64(counts.Matched)/float64(counts.Requested), 1)
+}
+
+// recordConfidence возвращает доверие к записи с количеством подтверждений samples и временем
+// последнего измерения seen на момент now.
+func recordConfidence(samples int, seen, now time.Time) float64 {
+	value := confidenceUnknown
+	if samples > 0 {
+		value = 1 - math.Exp(-float64(samples)/confidenceSamples)
+	}
+	if seen.IsZero() {
+		return value * confidenceUnknown
+	}
+	if age := now.Sub(seen); age > 0 {
+		value *= math.Exp(-float64(age) / float64(confidenceAge))
+	}
+	return value
+}
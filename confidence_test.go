@@ -0,0 +1,37 @@
+package lbs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/geotrace/geo"
+)
+
+func TestConfidence(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	db := newDB("test", []Option{WithClock(&testClock{now: now})})
+	cell := func(id uint64, samples int, updated time.Time) Cell {
+		return Cell{Key: Key{RadioType: "gsm", MobileCountryCode: 250, LocationAreaCode: 1, CellId: id},
+			Data:    Data{Location: geo.NewPoint(37.6+float64(id)/100, 55.75), Accuracy: 1000},
+			Samples: samples, Updated: updated}
+	}
+	locate := func(keys []Key, cells ...Cell) float64 {
+		details, err := db.locate(keys, nil, cells, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if details.Confidence < 0 || details.Confidence > 1 {
+			t.Fatalf("confidence out of range: %v", details.Confidence)
+		}
+		return details.Confidence
+	}
+	fresh := []Cell{cell(1, 100, now.AddDate(0, -1, 0)), cell(2, 80, now), cell(3, 50, now)}
+	keys := []Key{fresh[0].Key, fresh[1].Key, fresh[2].Key}
+	many := locate(keys, fresh...)
+	one := locate(keys, fresh[0])
+	old := locate(keys[:1], cell(1, 100, now.AddDate(-6, 0, 0)))
+	few := locate(keys[:1], cell(1, 1, now))
+	if many < 0.6 || one >= many || old >= one || few >= one {
+		t.Errorf("bad confidence: many %v, one %v, old %v, few samples %v", many, one, old, few)
+	}
+}
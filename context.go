@@ -0,0 +1,23 @@
+package lbs
+
+import "context"
+
+// withContext выполняет функцию и возвращает ее ошибку, но не дожидается ее завершения, если
+// контекст был отменен раньше: в этом случае возвращается ошибка контекста. Используется для
+// вызовов, которые сами не поддерживают отмену, поэтому функция продолжит выполняться в фоне до
+// завершения.
+func withContext(ctx context.Context, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,336 @@
+package lbs
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/geotrace/geo"
+)
+
+// Country описывает страну, в которой работают операторы с кодом страны (MCC), и ее
+// прямоугольные границы.
+type Country struct {
+	Code  string  `json:"code"` // код страны ISO 3166-1 alpha-2
+	Name  string  `json:"name"` // название страны на английском языке
+	West  float64 `json:"-"`    // долгота западной границы; больше East, если страна пересекает 180-й меридиан
+	South float64 `json:"-"`    // широта южной границы
+	East  float64 `json:"-"`    // долгота восточной границы
+	North float64 `json:"-"`    // широта северной границы
+}
+
+// Countries задает страну по коду страны (MCC). Границы стран приблизительные и охватывают
+// основную территорию без удаленных островов и заморских владений, поэтому их центр близок к
+// центру страны. Таблицу можно дополнить или исправить до начала работы с DB.
+var Countries = map[uint16]Country{
+	202: {"GR", "Greece", 19.4, 34.8, 29.7, 41.8},
+	204: {"NL", "Netherlands", 3.3, 50.8, 7.2, 53.6},
+	206: {"BE", "Belgium", 2.5, 49.5, 6.4, 51.5},
+	208: {"FR", "France", -5.2, 41.3, 9.6, 51.1},
+	212: {"MC", "Monaco", 7.40, 43.72, 7.44, 43.75},
+	213: {"AD", "Andorra", 1.41, 42.43, 1.79, 42.66},
+	214: {"ES", "Spain", -9.4, 35.9, 4.4, 43.8},
+	216: {"HU", "Hungary", 16.1, 45.7, 22.9, 48.6},
+	218: {"BA", "Bosnia and Herzegovina", 15.7, 42.6, 19.6, 45.3},
+	219: {"HR", "Croatia", 13.5, 42.4, 19.5, 46.6},
+	220: {"RS", "Serbia", 18.8, 42.2, 23.0, 46.2},
+	221: {"XK", "Kosovo", 20.0, 41.9, 21.8, 43.3},
+	222: {"IT", "Italy", 6.6, 35.5, 18.5, 47.1},
+	226: {"RO", "Romania", 20.2, 43.6, 29.7, 48.3},
+	228: {"CH", "Switzerland", 5.9, 45.8, 10.5, 47.8},
+	230: {"CZ", "Czechia", 12.1, 48.5, 18.9, 51.1},
+	231: {"SK", "Slovakia", 16.8, 47.7, 22.6, 49.6},
+	232: {"AT", "Austria", 9.5, 46.4, 17.2, 49.0},
+	234: {"GB", "United Kingdom", -8.6, 49.9, 1.8, 60.9},
+	235: {"GB", "United Kingdom", -8.6, 49.9, 1.8, 60.9},
+	238: {"DK", "Denmark", 8.0, 54.5, 15.2, 57.8},
+	240: {"SE", "Sweden", 11.0, 55.3, 24.2, 69.1},
+	242: {"NO", "Norway", 4.6, 57.9, 31.1, 71.2},
+	244: {"FI", "Finland", 20.5, 59.8, 31.6, 70.1},
+	246: {"LT", "Lithuania", 21.0, 53.9, 26.8, 56.5},
+	247: {"LV", "Latvia", 20.9, 55.7, 28.2, 58.1},
+	248: {"EE", "Estonia", 21.8, 57.5, 28.2, 59.7},
+	250: {"RU", "Russia", 19.6, 41.2, -169.0, 81.9},
+	255: {"UA", "Ukraine", 22.1, 44.4, 40.2, 52.4},
+	257: {"BY", "Belarus", 23.2, 51.3, 32.8, 56.2},
+	259: {"MD", "Moldova", 26.6, 45.5, 30.1, 48.5},
+	260: {"PL", "Poland", 14.1, 49.0, 24.2, 54.8},
+	262: {"DE", "Germany", 5.9, 47.3, 15.0, 55.1},
+	266: {"GI", "Gibraltar", -5.36, 36.11, -5.34, 36.16},
+	268: {"PT", "Portugal", -9.5, 37.0, -6.2, 42.2},
+	270: {"LU", "Luxembourg", 5.7, 49.4, 6.5, 50.2},
+	272: {"IE", "Ireland", -10.5, 51.4, -6.0, 55.4},
+	274: {"IS", "Iceland", -24.5, 63.3, -13.5, 66.6},
+	276: {"AL", "Albania", 19.3, 39.6, 21.1, 42.7},
+	278: {"MT", "Malta", 14.2, 35.8, 14.6, 36.1},
+	280: {"CY", "Cyprus", 32.3, 34.6, 34.6, 35.7},
+	282: {"GE", "Georgia", 40.0, 41.0, 46.7, 43.6},
+	283: {"AM", "Armenia", 43.4, 38.8, 46.6, 41.3},
+	284: {"BG", "Bulgaria", 22.4, 41.2, 28.6, 44.2},
+	286: {"TR", "Turkey", 26.0, 35.8, 44.8, 42.1},
+	288: {"FO", "Faroe Islands", -7.7, 61.4, -6.3, 62.4},
+	290: {"GL", "Greenland", -73.3, 59.8, -12.2, 83.6},
+	292: {"SM", "San Marino", 12.40, 43.89, 12.52, 43.99},
+	293: {"SI", "Slovenia", 13.4, 45.4, 16.6, 46.9},
+	294: {"MK", "North Macedonia", 20.5, 40.9, 23.0, 42.4},
+	295: {"LI", "Liechtenstein", 9.47, 47.05, 9.64, 47.27},
+	297: {"ME", "Montenegro", 18.4, 41.8, 20.4, 43.6},
+	302: {"CA", "Canada", -141.0, 41.7, -52.6, 83.1},
+	308: {"PM", "Saint Pierre and Miquelon", -56.4, 46.7, -56.1, 47.1},
+	310: {"US", "United States", -124.8, 24.5, -66.9, 49.4},
+	311: {"US", "United States", -124.8, 24.5, -66.9, 49.4},
+	312: {"US", "United States", -124.8, 24.5, -66.9, 49.4},
+	313: {"US", "United States", -124.8, 24.5, -66.9, 49.4},
+	314: {"US", "United States", -124.8, 24.5, -66.9, 49.4},
+	315: {"US", "United States", -124.8, 24.5, -66.9, 49.4},
+	316: {"US", "United States", -124.8, 24.5, -66.9, 49.4},
+	330: {"PR", "Puerto Rico", -67.3, 17.9, -65.2, 18.5},
+	334: {"MX", "Mexico", -117.1, 14.5, -86.7, 32.7},
+	338: {"JM", "Jamaica", -78.4, 17.7, -76.2, 18.5},
+	340: {"GP", "Guadeloupe", -61.8, 14.4, -60.8, 16.5},
+	342: {"BB", "Barbados", -59.65, 13.04, -59.42, 13.34},
+	344: {"AG", "Antigua and Barbuda", -61.9, 16.9, -61.7, 17.7},
+	346: {"KY", "Cayman Islands", -81.4, 19.3, -79.7, 19.8},
+	348: {"VG", "British Virgin Islands", -64.8, 18.3, -64.3, 18.8},
+	350: {"BM", "Bermuda", -64.9, 32.2, -64.6, 32.4},
+	352: {"GD", "Grenada", -61.8, 11.98, -61.4, 12.5},
+	354: {"MS", "Montserrat", -62.25, 16.67, -62.14, 16.82},
+	356: {"KN", "Saint Kitts and Nevis", -62.9, 17.1, -62.5, 17.4},
+	358: {"LC", "Saint Lucia", -61.1, 13.7, -60.9, 14.1},
+	360: {"VC", "Saint Vincent and the Grenadines", -61.5, 12.6, -61.1, 13.4},
+	362: {"CW", "Curaçao", -69.2, 12.0, -68.7, 12.4},
+	363: {"AW", "Aruba", -70.1, 12.4, -69.9, 12.6},
+	364: {"BS", "Bahamas", -79.3, 20.9, -72.7, 27.3},
+	365: {"AI", "Anguilla", -63.2, 18.1, -62.9, 18.3},
+	366: {"DM", "Dominica", -61.5, 15.2, -61.2, 15.6},
+	368: {"CU", "Cuba", -85.0, 19.8, -74.1, 23.3},
+	370: {"DO", "Dominican Republic", -72.0, 17.5, -68.3, 19.9},
+	372: {"HT", "Haiti", -74.5, 18.0, -71.6, 20.1},
+	374: {"TT", "Trinidad and Tobago", -61.9, 10.0, -60.5, 11.4},
+	376: {"TC", "Turks and Caicos Islands", -72.5, 21.2, -71.1, 21.96},
+	400: {"AZ", "Azerbaijan", 44.8, 38.4, 50.4, 41.9},
+	401: {"KZ", "Kazakhstan", 46.5, 40.6, 87.3, 55.4},
+	402: {"BT", "Bhutan", 88.7, 26.7, 92.1, 28.3},
+	404: {"IN", "India", 68.2, 6.7, 97.4, 35.5},
+	405: {"IN", "India", 68.2, 6.7, 97.4, 35.5},
+	406: {"IN", "India", 68.2, 6.7, 97.4, 35.5},
+	410: {"PK", "Pakistan", 60.9, 23.7, 77.8, 37.1},
+	412: {"AF", "Afghanistan", 60.5, 29.4, 74.9, 38.5},
+	413: {"LK", "Sri Lanka", 79.7, 5.9, 81.9, 9.8},
+	414: {"MM", "Myanmar", 92.2, 9.8, 101.2, 28.5},
+	415: {"LB", "Lebanon", 35.1, 33.1, 36.6, 34.7},
+	416: {"JO", "Jordan", 34.9, 29.2, 39.3, 33.4},
+	417: {"SY", "Syria", 35.7, 32.3, 42.4, 37.3},
+	418: {"IQ", "Iraq", 38.8, 29.1, 48.6, 37.4},
+	419: {"KW", "Kuwait", 46.6, 28.5, 48.4, 30.1},
+	420: {"SA", "Saudi Arabia", 34.5, 16.4, 55.7, 32.2},
+	421: {"YE", "Yemen", 42.6, 12.1, 53.1, 19.0},
+	422: {"OM", "Oman", 52.0, 16.6, 59.8, 26.4},
+	424: {"AE", "United Arab Emirates", 51.6, 22.6, 56.4, 26.1},
+	425: {"IL", "Israel", 34.3, 29.5, 35.9, 33.3},
+	426: {"BH", "Bahrain", 50.4, 25.8, 50.8, 26.3},
+	427: {"QA", "Qatar", 50.7, 24.5, 51.7, 26.2},
+	428: {"MN", "Mongolia", 87.7, 41.6, 119.9, 52.1},
+	429: {"NP", "Nepal", 80.1, 26.3, 88.2, 30.4},
+	432: {"IR", "Iran", 44.0, 25.1, 63.3, 39.8},
+	434: {"UZ", "Uzbekistan", 56.0, 37.2, 73.1, 45.6},
+	436: {"TJ", "Tajikistan", 67.4, 36.7, 75.1, 41.0},
+	437: {"KG", "Kyrgyzstan", 69.3, 39.2, 80.3, 43.3},
+	438: {"TM", "Turkmenistan", 52.4, 35.1, 66.7, 42.8},
+	440: {"JP", "Japan", 129.4, 31.0, 145.8, 45.5},
+	441: {"JP", "Japan", 129.4, 31.0, 145.8, 45.5},
+	450: {"KR", "South Korea", 126.1, 34.4, 129.6, 38.6},
+	452: {"VN", "Vietnam", 102.1, 8.6, 109.5, 23.4},
+	454: {"HK", "Hong Kong", 113.8, 22.15, 114.4, 22.56},
+	455: {"MO", "Macao", 113.53, 22.11, 113.6, 22.22},
+	456: {"KH", "Cambodia", 102.3, 10.4, 107.6, 14.7},
+	457: {"LA", "Laos", 100.1, 13.9, 107.7, 22.5},
+	460: {"CN", "China", 73.5, 18.2, 134.8, 53.6},
+	466: {"TW", "Taiwan", 120.0, 21.9, 122.0, 25.3},
+	467: {"KP", "North Korea", 124.2, 37.7, 130.7, 43.0},
+	470: {"BD", "Bangladesh", 88.0, 20.7, 92.7, 26.6},
+	472: {"MV", "Maldives", 72.7, -0.7, 73.8, 7.1},
+	502: {"MY", "Malaysia", 99.6, 0.9, 119.3, 7.4},
+	505: {"AU", "Australia", 113.2, -43.6, 153.6, -10.7},
+	510: {"ID", "Indonesia", 95.0, -11.0, 141.0, 6.1},
+	514: {"TL", "Timor-Leste", 124.0, -9.5, 127.3, -8.1},
+	515: {"PH", "Philippines", 116.9, 4.6, 126.6, 21.1},
+	520: {"TH", "Thailand", 97.3, 5.6, 105.6, 20.5},
+	525: {"SG", "Singapore", 103.6, 1.16, 104.1, 1.47},
+	528: {"BN", "Brunei", 114.1, 4.0, 115.4, 5.1},
+	530: {"NZ", "New Zealand", 166.4, -47.3, 178.6, -34.4},
+	537: {"PG", "Papua New Guinea", 141.0, -11.7, 156.0, -1.3},
+	539: {"TO", "Tonga", -175.4, -21.5, -173.9, -15.6},
+	540: {"SB", "Solomon Islands", 155.5, -11.9, 167.0, -6.6},
+	541: {"VU", "Vanuatu", 166.5, -20.3, 170.2, -13.1},
+	542: {"FJ", "Fiji", 177.0, -19.2, -178.2, -16.0},
+	544: {"AS", "American Samoa", -171.1, -14.4, -169.4, -14.1},
+	546: {"NC", "New Caledonia", 163.6, -22.7, 168.1, -19.5},
+	547: {"PF", "French Polynesia", -154.7, -27.7, -134.9, -7.9},
+	549: {"WS", "Samoa", -172.8, -14.1, -171.4, -13.4},
+	550: {"FM", "Micronesia", 138.0, 5.2, 163.1, 10.1},
+	602: {"EG", "Egypt", 24.7, 22.0, 36.9, 31.7},
+	603: {"DZ", "Algeria", -8.7, 19.0, 12.0, 37.1},
+	604: {"MA", "Morocco", -13.2, 27.7, -1.0, 35.9},
+	605: {"TN", "Tunisia", 7.5, 30.2, 11.6, 37.5},
+	606: {"LY", "Libya", 9.3, 19.5, 25.2, 33.2},
+	607: {"GM", "Gambia", -16.8, 13.1, -13.8, 13.8},
+	608: {"SN", "Senegal", -17.5, 12.3, -11.3, 16.7},
+	609: {"MR", "Mauritania", -17.1, 14.7, -4.8, 27.3},
+	610: {"ML", "Mali", -12.2, 10.1, 4.3, 25.0},
+	611: {"GN", "Guinea", -15.1, 7.2, -7.6, 12.7},
+	612: {"CI", "Côte d'Ivoire", -8.6, 4.3, -2.5, 10.7},
+	613: {"BF", "Burkina Faso", -5.5, 9.4, 2.4, 15.1},
+	614: {"NE", "Niger", 0.2, 11.7, 16.0, 23.5},
+	615: {"TG", "Togo", -0.1, 6.1, 1.8, 11.1},
+	616: {"BJ", "Benin", 0.8, 6.2, 3.8, 12.4},
+	617: {"MU", "Mauritius", 57.3, -20.5, 57.8, -19.9},
+	618: {"LR", "Liberia", -11.5, 4.4, -7.4, 8.6},
+	619: {"SL", "Sierra Leone", -13.3, 6.9, -10.3, 10.0},
+	620: {"GH", "Ghana", -3.3, 4.7, 1.2, 11.2},
+	621: {"NG", "Nigeria", 2.7, 4.3, 14.7, 13.9},
+	622: {"TD", "Chad", 13.5, 7.4, 24.0, 23.4},
+	623: {"CF", "Central African Republic", 14.4, 2.2, 27.5, 11.0},
+	624: {"CM", "Cameroon", 8.5, 1.7, 16.2, 13.1},
+	625: {"CV", "Cape Verde", -25.4, 14.8, -22.7, 17.2},
+	626: {"ST", "Sao Tome and Principe", 6.4, 0.0, 7.5, 1.7},
+	627: {"GQ", "Equatorial Guinea", 5.6, -1.5, 11.3, 3.8},
+	628: {"GA", "Gabon", 8.7, -4.0, 14.5, 2.3},
+	629: {"CG", "Congo", 11.1, -5.0, 18.6, 3.7},
+	630: {"CD", "DR Congo", 12.2, -13.5, 31.3, 5.4},
+	631: {"AO", "Angola", 11.7, -18.0, 24.1, -4.4},
+	632: {"GW", "Guinea-Bissau", -16.7, 10.9, -13.6, 12.7},
+	633: {"SC", "Seychelles", 55.2, -4.8, 55.9, -4.2},
+	634: {"SD", "Sudan", 21.8, 8.7, 38.6, 22.2},
+	635: {"RW", "Rwanda", 28.9, -2.8, 30.9, -1.1},
+	636: {"ET", "Ethiopia", 33.0, 3.4, 48.0, 14.9},
+	637: {"SO", "Somalia", 41.0, -1.7, 51.4, 12.0},
+	638: {"DJ", "Djibouti", 41.8, 10.9, 43.4, 12.7},
+	639: {"KE", "Kenya", 33.9, -4.7, 41.9, 5.0},
+	640: {"TZ", "Tanzania", 29.3, -11.7, 40.4, -1.0},
+	641: {"UG", "Uganda", 29.6, -1.5, 35.0, 4.2},
+	642: {"BI", "Burundi", 29.0, -4.5, 30.8, -2.3},
+	643: {"MZ", "Mozambique", 30.2, -26.9, 40.8, -10.5},
+	645: {"ZM", "Zambia", 22.0, -18.1, 33.7, -8.2},
+	646: {"MG", "Madagascar", 43.2, -25.6, 50.5, -11.9},
+	647: {"RE", "Réunion", 55.2, -21.4, 55.8, -20.9},
+	648: {"ZW", "Zimbabwe", 25.2, -22.4, 33.1, -15.6},
+	649: {"NA", "Namibia", 11.7, -29.0, 25.3, -16.9},
+	650: {"MW", "Malawi", 32.7, -17.1, 35.9, -9.4},
+	651: {"LS", "Lesotho", 27.0, -30.7, 29.5, -28.6},
+	652: {"BW", "Botswana", 20.0, -26.9, 29.4, -17.8},
+	653: {"SZ", "Eswatini", 30.8, -27.3, 32.1, -25.7},
+	654: {"KM", "Comoros", 43.2, -12.4, 44.5, -11.4},
+	655: {"ZA", "South Africa", 16.5, -34.8, 32.9, -22.1},
+	657: {"ER", "Eritrea", 36.4, 12.4, 43.1, 18.0},
+	659: {"SS", "South Sudan", 24.1, 3.5, 35.9, 12.2},
+	702: {"BZ", "Belize", -89.2, 15.9, -87.5, 18.5},
+	704: {"GT", "Guatemala", -92.2, 13.7, -88.2, 17.8},
+	706: {"SV", "El Salvador", -90.1, 13.1, -87.7, 14.5},
+	708: {"HN", "Honduras", -89.4, 13.0, -83.1, 16.5},
+	710: {"NI", "Nicaragua", -87.7, 10.7, -83.1, 15.0},
+	712: {"CR", "Costa Rica", -85.9, 8.0, -82.5, 11.2},
+	714: {"PA", "Panama", -83.0, 7.2, -77.2, 9.6},
+	716: {"PE", "Peru", -81.3, -18.4, -68.7, 0.0},
+	722: {"AR", "Argentina", -73.6, -55.1, -53.6, -21.8},
+	724: {"BR", "Brazil", -74.0, -33.8, -34.8, 5.3},
+	730: {"CL", "Chile", -75.7, -55.9, -66.4, -17.5},
+	732: {"CO", "Colombia", -79.0, -4.2, -66.9, 12.5},
+	734: {"VE", "Venezuela", -73.4, 0.6, -59.8, 12.2},
+	736: {"BO", "Bolivia", -69.6, -22.9, -57.5, -9.7},
+	738: {"GY", "Guyana", -61.4, 1.2, -56.5, 8.6},
+	740: {"EC", "Ecuador", -81.0, -5.0, -75.2, 1.4},
+	742: {"GF", "French Guiana", -54.6, 2.1, -51.6, 5.8},
+	744: {"PY", "Paraguay", -62.6, -27.6, -54.3, -19.3},
+	746: {"SR", "Suriname", -58.1, 1.8, -54.0, 6.0},
+	748: {"UY", "Uruguay", -58.4, -35.0, -53.1, -30.1},
+	750: {"FK", "Falkland Islands", -61.3, -52.4, -57.7, -51.0},
+}
+
+// Region определяет страну, в которой находится устройство, так же, как запрос /v1/country Mozilla
+// Location Service: по коду страны (MCC) вышки из запроса с самым сильным сигналом, если он есть в
+// Countries. Иначе (например, для запроса только с точками доступа Wi-Fi) вычисляются координаты
+// по записям в хранилище (см. GetDetailed), и возвращается страна, в границы которой они попадают.
+// Если страну определить не удалось, то возвращается ошибка ErrNotFound. Для грубой географической
+// привязки этого достаточно, а точные координаты не нужны.
+func (db *DB) Region(ctx context.Context, req Request) (Country, error) {
+	if len(req.CellTowers) == 0 && len(req.WifiAccessPoints) == 0 {
+		return Country{}, ErrEmptyRequest
+	}
+	if err := req.Options.check(); err != nil {
+		return Country{}, err
+	}
+	keys, towers, _ := db.requestCandidates(req)
+	for _, i := range towerOrder(keys, towers) {
+		if country, ok := Countries[keys[i].MobileCountryCode]; ok {
+			return country, nil
+		}
+	}
+	details, err := db.GetDetailed(ctx, req)
+	if err != nil {
+		return Country{}, err
+	}
+	location := details.Response.Location
+	if country, ok := countryAt(location.Lat, location.Lng); ok {
+		return country, nil
+	}
+	return Country{}, ErrNotFound
+}
+
+// countryAt возвращает страну, в границы которой попадает точка. Границы соседних стран
+// пересекаются, поэтому из нескольких подходящих выбирается страна с наименьшими границами.
+func countryAt(lat, lon float64) (Country, bool) {
+	var found []Country
+	for _, country := range Countries {
+		if country.contains(lat, lon) {
+			found = append(found, country)
+		}
+	}
+	if len(found) == 0 {
+		return Country{}, false
+	}
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i].radius(), found[j].radius()
+		if a != b {
+			return a < b
+		}
+		return found[i].Code < found[j].Code
+	})
+	return found[0], true
+}
+
+// contains возвращает, попадает ли точка в границы страны.
+func (c Country) contains(lat, lon float64) bool {
+	if lat < c.South || lat > c.North {
+		return false
+	}
+	if c.West > c.East {
+		return lon >= c.West || lon <= c.East
+	}
+	return lon >= c.West && lon <= c.East
+}
+
+// center возвращает центр границ страны.
+func (c Country) center() geo.Point {
+	east := c.East
+	if c.West > east {
+		east += 360
+	}
+	lon := (c.West + east) / 2
+	if lon > 180 {
+		lon -= 360
+	}
+	return geo.NewPoint(lon, (c.South+c.North)/2)
+}
+
+// radius возвращает радиус круга с центром center, покрывающего границы страны, в метрах.
+func (c Country) radius() (radius float64) {
+	center := c.center()
+	for _, lat := range []float64{c.South, c.North} {
+		for _, lon := range []float64{c.West, c.East} {
+			dist := distance(center.Latitude(), center.Longitude(), lat, lon)
+			radius = math.Max(radius, dist)
+		}
+	}
+	return radius
+}
@@ -0,0 +1,82 @@
+package lbs
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/geotrace/locator"
+)
+
+func TestCountryCenter(t *testing.T) {
+	for _, test := range []struct {
+		mcc      uint16
+		lat, lon float64
+		radius   float64 // км
+	}{
+		{250, 61.55, 105.3, 5900},
+		{542, -17.6, 179.4, 300},
+		{262, 51.2, 10.45, 550},
+	} {
+		country, ok := Countries[test.mcc]
+		if !ok {
+			t.Fatalf("unknown mcc %d", test.mcc)
+		}
+		center := country.center()
+		if math.Abs(center.Latitude()-test.lat) > 0.01 || math.Abs(center.Longitude()-test.lon) > 0.01 {
+			t.Errorf("%s: bad center %v", country.Code, center)
+		}
+		if radius := country.radius() / 1000; math.Abs(radius-test.radius) > test.radius/4 {
+			t.Errorf("%s: bad radius %.0f km", country.Code, radius)
+		}
+	}
+}
+
+func TestCountryFallback(t *testing.T) {
+	db := newDB("test", []Option{CountryFallback()})
+	req := Request{Request: locator.Request{
+		RadioType:  "gsm",
+		CellTowers: []*locator.CellTower{{MobileCountryCode: 999, MobileNetworkCode: 1, LocationAreaCode: 1, CellId: 1, SignalStrength: -90}, {MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 7743, CellId: 22517, SignalStrength: -78}},
+	}}
+	keys, towers, _ := db.requestCandidates(req)
+	notFound := &NotFoundError{Missing: keys}
+	details, err := db.locateCountry(nil, keys, towers, nil, notFound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Country != "RU" || details.Confidence != confidenceCountry || len(details.Missing) != 2 ||
+		details.Response.Accuracy < 1000000 {
+		t.Errorf("bad details: %+v", details)
+	}
+	if db.countryLookup(&GetOptions{NoFallback: true}) {
+		t.Error("country fallback not disabled")
+	}
+	if _, err := db.locateCountry(nil, keys[:1], towers[:1], nil, notFound); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown country found: %v", err)
+	}
+}
+
+func TestCountryAt(t *testing.T) {
+	for _, test := range []struct {
+		lat, lon float64
+		code     string
+	}{
+		{55.75, 37.62, "RU"},
+		{64.7, 177.5, "RU"},
+		{43.73, 7.42, "MC"},
+		{52.52, 13.4, "DE"},
+		{-17.7, -179.5, "FJ"},
+		{0, -30, ""},
+	} {
+		country, ok := countryAt(test.lat, test.lon)
+		if country.Code != test.code || ok != (test.code != "") {
+			t.Errorf("countryAt(%g, %g) = %q; want %q", test.lat, test.lon, country.Code, test.code)
+		}
+	}
+	db := newDB("test", nil)
+	req := Request{Request: locator.Request{CellTowers: []*locator.CellTower{{MobileCountryCode: 257, MobileNetworkCode: 1, LocationAreaCode: 1, CellId: 1, SignalStrength: -78}}}}
+	if country, err := db.Region(context.Background(), req); err != nil || country.Name != "Belarus" {
+		t.Errorf("bad region: %+v, %v", country, err)
+	}
+}
@@ -10,146 +10,646 @@
 //
 // В качестве хранилища для данных используется MongoDB.
 //
+// Модель данных и интерфейс хранилища вышек описаны в пакете github.com/geotrace/lbs/v2/storage, а
+// Key, Data, Cell и Storage — псевдонимы его типов. Хранилища без MongoDB находятся в пакете
+// offline, средства импорта — в importer, а HTTP API программы lbs-serve — в server.
+//
 // В состав библиотеке так же входит программа lbs-import, для импорта данных о сотовых вышках и их
-// координатах, представленных в формате CSV.
+// координатах, представленных в формате CSV, и программа lbs-admin для обслуживания базы.
 package lbs
 
 import (
+	"context"
 	"errors"
-	"math"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
 
-	"github.com/geotrace/geo"
+	"github.com/geotrace/lbs/v2/storage"
 	"github.com/geotrace/locator"
-	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
 )
 
-var CollectionName = "lbs"   // описывает название коллекции с данными для LBS.
-var DefaultRadioType = "gsm" // используемый по умолчанию тип радио.
+var CollectionName = "lbs"          // описывает название коллекции с данными для LBS.
+var MetaCollectionName = "lbs_meta" // описывает название коллекции с метаданными об импорте.
+var DefaultRadioType = "gsm"        // используемый по умолчанию тип радио.
 
 // DB описывает хранилище LBS данных и работу с ними.
 type DB struct {
-	name    string       // название базы данных
-	session *mgo.Session // хранилище MogoDB
+	name           string          // название базы данных
+	collectionName string          // название коллекции с данными
+	client         *mongo.Client   // клиент MongoDB
+	owned          bool            // клиент принадлежит DB и отключается вместе с ним
+	metrics        *Metrics        // время выполнения этапов обработки запросов
+	dialTimeout    time.Duration   // время установки соединения с сервером
+	socketTimeout  time.Duration   // время ожидания ответа сервера
+	syncTimeout    time.Duration   // время ожидания доступного сервера
+	queryTimeout   time.Duration   // максимальное время выполнения запроса
+	readMode       readpref.Mode   // выбор сервера набора реплик для чтения (0 - из клиента)
+	maxPoolSize    uint64          // максимальное количество соединений с сервером (0 - из URL)
+	retryReads     *bool           // повтор чтения при сетевой ошибке (nil - из URL)
+	retryWrites    *bool           // повтор записи при сетевой ошибке (nil - из URL)
+	maxTowers      int             // максимальное количество вышек в запросе
+	queryWorkers   int             // количество одновременных запросов групп вышек
+	signalWeighted bool            // веса вышек зависят от уровня сигнала
+	leastSquares   bool            // координаты вычисляются по расстояниям до вышек
+	radioFallback  []string        // типы радио для вышек без известного типа
+	wifiIgnored    bool            // точки доступа Wi-Fi не используются
+	outlierFactor  float64         // порог отбрасывания далеких вышек (0 - не отбрасываются)
+	staleAge       time.Duration   // возраст устаревших записей о вышках (0 - не учитывается)
+	staleWeight    float64         // множитель веса устаревших вышек (0 - не используются)
+	fuzzyCells     int             // вышек зоны для приблизительного поиска (0 - не используется)
+	countryCenter  bool            // возвращать центр страны, если ничего не найдено
+	geoip          GeoIP           // источник координат по IP-адресу клиента (nil - не используется)
+	accuracyMin    float64         // минимальный радиус точности ответа
+	accuracyMax    float64         // максимальный радиус точности ответа
+	healthInterval time.Duration   // интервал проверки доступности сервера
+	healthy        int32           // флаг доступности сервера (1 - доступен)
+	clock          Clock           // источник текущего времени
+	records        recordsCache    // кеш результатов RecordsBy
+	cache          Cache           // кеш записей о вышках (nil - без кеширования)
+	cacheTTL       time.Duration   // время хранения записей в кеше
+	results        Cache           // кеш результатов вычисления координат (nil - без кеширования)
+	resultsTTL     time.Duration   // время хранения результатов
+	resultsStored  bool            // результаты сохраняются в MongoDB
+	resultsIndexed int32           // флаг созданного индекса коллекции результатов
+	accuracy       accuracyTable   // радиусы действия вышек по умолчанию
+	usageIndexed   int32           // флаг созданного индекса статистики использования
+	partitions     *partitionTable // распределение данных по коллекциям (nil - одна коллекция)
+	logger         *slog.Logger    // журнал событий (nil - сообщения не выводятся)
+	storage        Storage         // хранилище вышек вместо MongoDB (см. OpenStorage)
+	done           chan struct{}   // закрывается при вызове Close
+	closeOnce      sync.Once       // защита от повторного закрытия
+}
+
+// newDB возвращает новый объект DB с примененными параметрами, но без клиента MongoDB.
+func newDB(dbName string, options []Option) *DB {
+	db := &DB{
+		name:           dbName,
+		collectionName: CollectionName,
+		metrics:        newMetrics(),
+		dialTimeout:    10 * time.Second,
+		maxTowers:      DefaultMaxTowers,
+		queryWorkers:   DefaultQueryConcurrency,
+		healthy:        1,
+		clock:          systemClock{},
+		records:        recordsCache{ttl: defaultRecordsCacheTTL},
+		done:           make(chan struct{}),
+	}
+	for _, option := range options {
+		option(db)
+	}
+	return db
 }
 
 // InitDB возвращает инициализированный объект для работы с хранилищем LBS данных.
-func InitDB(session *mgo.Session, dbName string) (db *DB, err error) {
-	db = &DB{
-		session: session,
-		name:    dbName,
+//
+// Переданный клиент MongoDB по умолчанию остается во владении вызывающей стороны: DB не
+// отключает его при вызове Close. Если отключение клиента необходимо передать DB, то укажите
+// параметр OwnClient.
+func InitDB(ctx context.Context, client *mongo.Client, dbName string, options ...Option) (db *DB, err error) {
+	db = newDB(dbName, options)
+	db.client = client
+	if err = db.load(ctx); err != nil {
+		return nil, err
 	}
+	db.start()
 	return
 }
 
-// Key описывает ключ для поиска информации по LBS.
-type Key struct {
-	RadioType         string `bson:"radio"` // The mobile radio type. Supported values are lte, gsm, umts, cdma, and wcdma.
-	MobileCountryCode uint16 `bson:"mcc"`   // country code  (250 - Россия, 255 - Украина, Беларусь - 257)
-	MobileNetworkCode uint16 `bson:"mnc"`   // operator code
-	LocationAreaCode  uint16 `bson:"lac"`   // the base station cell number
-	CellId            uint32 `bson:"cell"`  // base station number
+// Dial устанавливает соединение с сервером MongoDB по указанному URL и возвращает
+// инициализированный объект для работы с хранилищем LBS данных. Название базы данных берется из
+// URL. Созданный клиент принадлежит DB и отключается вызовом Close.
+func Dial(ctx context.Context, url string, options ...Option) (db *DB, err error) {
+	cs, err := connstring.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	db = newDB(cs.Database, options)
+	if db.client, err = mongo.Connect(ctx, db.clientOptions(url)); err != nil {
+		return nil, err
+	}
+	db.owned = true
+	if err = db.load(ctx); err != nil {
+		db.client.Disconnect(context.Background())
+		return nil, err
+	}
+	db.start()
+	return db, nil
 }
 
-// Data описывает данные для вышки сотовой станции.
-type Data struct {
-	Location geo.Point `bson:"location"` // координаты
-	Accuracy float64   `bson:"range"`    // расстояние
+// clientOptions возвращает параметры клиента MongoDB для соединения по указанному URL с учетом
+// таймаутов, размера пула соединений и повторов запросов, заданных для DB. Параметры DB
+// переопределяют одноименные параметры URL.
+func (db *DB) clientOptions(url string) *options.ClientOptions {
+	opts := options.Client().ApplyURI(url).SetConnectTimeout(db.dialTimeout)
+	if db.socketTimeout > 0 {
+		opts.SetSocketTimeout(db.socketTimeout)
+	}
+	if db.syncTimeout > 0 {
+		opts.SetServerSelectionTimeout(db.syncTimeout)
+	}
+	if db.maxPoolSize > 0 {
+		opts.SetMaxPoolSize(db.maxPoolSize)
+	}
+	if db.retryReads != nil {
+		opts.SetRetryReads(*db.retryReads)
+	}
+	if db.retryWrites != nil {
+		opts.SetRetryWrites(*db.retryWrites)
+	}
+	return opts.SetRegistry(Registry)
+}
+
+// load загружает таблицу распределения данных по коллекциям и радиусы действия вышек по
+// умолчанию.
+func (db *DB) load(ctx context.Context) error {
+	if err := db.LoadPartitions(ctx); err != nil {
+		return err
+	}
+	return db.LoadAccuracy(ctx)
+}
+
+// Close освобождает ресурсы, занятые DB, и останавливает фоновую проверку доступности сервера.
+// Если клиент MongoDB принадлежит DB (создан в Dial или передан в InitDB с параметром
+// OwnClient), то он отключается. Повторный вызов Close ничего не делает, а запросы к закрытому DB
+// возвращают ошибку ErrClosed.
+func (db *DB) Close() {
+	db.closeOnce.Do(func() {
+		close(db.done)
+		if db.storage != nil {
+			db.storage.Close()
+		}
+		if db.owned {
+			db.client.Disconnect(context.Background())
+		}
+	})
+}
+
+// database возвращает базу данных MongoDB с данными LBS. Если DB уже закрыт, то возвращается
+// ошибка ErrClosed, а если DB работает без MongoDB (см. OpenStorage) — ErrNoDatabase. Запросы
+// чтения к возвращенной базе выполняются с учетом ReadPreference.
+func (db *DB) database() (*mongo.Database, error) {
+	select {
+	case <-db.done:
+		return nil, ErrClosed
+	default:
+	}
+	if db.client == nil {
+		return nil, ErrNoDatabase
+	}
+	opts := options.Database().SetRegistry(Registry)
+	if db.readMode != 0 {
+		readPref, err := readpref.New(db.readMode)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(readPref)
+	}
+	return db.client.Database(db.name, opts), nil
+}
+
+// queryContext возвращает контекст для выполнения запроса к MongoDB. Если задан QueryTimeout, а
+// переданный контекст не ограничен по времени, то время выполнения запроса ограничивается этим
+// значением. Возвращенную функцию отмены необходимо вызвать после завершения запроса.
+func (db *DB) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || db.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, db.queryTimeout)
 }
 
+// Key описывает ключ для поиска информации по LBS (см. storage.Key).
+type Key = storage.Key
+
+// Разрядность кода зоны и идентификатора вышки: столько занимают TAC и NCI в сетях 5G NR.
+const (
+	AreaCodeBits = storage.AreaCodeBits
+	CellIdBits   = storage.CellIdBits
+)
+
+// Data описывает данные для вышки сотовой станции (см. storage.Data).
+type Data = storage.Data
+
 var (
 	ErrEmptyRequest = errors.New("lbs: empty request")
 	ErrNotFound     = errors.New("lbs: not found")
+	ErrUnavailable  = errors.New("lbs: database unavailable")
+	ErrClosed       = storage.ErrClosed
 )
 
-// GetCells возвращает информацию о найденных сотовых станциях.
-func (db *DB) GetCells(req locator.Request) (cells []Data, err error) {
-	if len(req.CellTowers) == 0 && len(req.WifiAccessPoints) == 0 {
-		return nil, ErrEmptyRequest
+// NotFoundError описывает ошибку ErrNotFound, возвращаемую при вычислении координат, вместе с
+// вышками и точками доступа Wi-Fi из запроса, которых нет в хранилище. По ним вызывающая сторона
+// может решить, стоит ли запрашивать координаты у удаленного сервиса. Ошибка совпадает с
+// ErrNotFound при сравнении через errors.Is:
+//
+//	if errors.Is(err, lbs.ErrNotFound) {
+//		var notFound *lbs.NotFoundError
+//		if errors.As(err, &notFound) {
+//			log.Printf("missing cells: %v", notFound.Missing)
+//		}
+//	}
+type NotFoundError struct {
+	Missing     []Key    `json:"missing,omitempty"`     // вышки из запроса, не найденные в хранилище
+	MissingWifi []string `json:"missingWifi,omitempty"` // точки доступа, не найденные в хранилище
+}
+
+// Error возвращает текст ошибки ErrNotFound.
+func (e *NotFoundError) Error() string {
+	return ErrNotFound.Error()
+}
+
+// Unwrap возвращает ErrNotFound.
+func (e *NotFoundError) Unwrap() error {
+	return ErrNotFound
+}
+
+// GetCells возвращает информацию о найденных сотовых станциях. Точки доступа Wi-Fi из запроса не
+// учитываются: они используются только при вычислении координат в Get. Тип радио в
+// locator.Request задается только для запроса в целом; чтобы указать его для каждой вышки
+// отдельно, используйте FindCells.
+func (db *DB) GetCells(ctx context.Context, req locator.Request) (cells []Data, err error) {
+	found, err := db.findCells(ctx, Request{Request: req})
+	if err != nil {
+		return nil, err
+	}
+	cells = make([]Data, len(found))
+	for i, cell := range found {
+		cells[i] = cell.Data
+	}
+	return cells, nil
+}
+
+// FindCells возвращает записи о найденных в хранилище вышках из запроса вместе с их ключами. В
+// отличие от GetCells тип радио берется для каждой вышки из req.RadioTypes, а если он не указан —
+// из запроса (см. requestKeys): устройство может сообщить соседние вышки GSM и LTE одним
+// запросом. Вышки с разными типами радио ищутся отдельными параллельными запросами к MongoDB.
+func (db *DB) FindCells(ctx context.Context, req Request) ([]Cell, error) {
+	return db.findCells(ctx, req)
+}
+
+// Максимальные идентификаторы вышек: в GSM идентификатор занимает только 16 бит, а в UMTS и LTE
+// — 28 бит.
+const (
+	maxGSMCellId = 0xFFFF
+	maxLTECellId = 0xFFFFFFF
+)
+
+// towerRadio возвращает тип радио, который фактически используется вышкой с идентификатором
+// cellId. Телефоны сообщают в одном запросе соседние вышки разных стандартов, а тип радио
+// указывается только для запроса в целом. Вышка GSM не может иметь идентификатор длиннее 16 бит,
+// поэтому такие вышки считаются вышками LTE, а вышки GSM и LTE с идентификатором длиннее 28 бит —
+// вышками NR.
+func towerRadio(radio string, cellId uint64) string {
+	switch {
+	case (radio == "gsm" || radio == "lte") && cellId > maxLTECellId:
+		return "nr"
+	case radio == "gsm" && cellId > maxGSMCellId:
+		return "lte"
 	}
-	radio, mcc, mnc := req.RadioType, req.HomeMobileCountryCode, req.HomeMobileNetworkCode
+	return radio
+}
+
+// requestKeys возвращает ключи для поиска всех вышек, перечисленных в запросе. Тип радио берется
+// из вышки, если он для нее указан, иначе — из запроса. Код страны и оператора берутся из самой
+// вышки: у границы и в национальном роуминге соседние вышки принадлежат разным сетям. Если для
+// вышки они не указаны, то используются коды из запроса, а затем — из первой вышки. Вышки с
+// недопустимыми идентификаторами (см. Key.Valid) пропускаются.
+//
+// Устройства иногда сообщают одну и ту же вышку несколько раз с разным уровнем сигнала. Такие
+// повторы объединяются в один ключ, чтобы вышка не учитывалась дважды: сохраняется положение
+// первого появления, а сведения — от лучшего измерения (см. betterTower).
+//
+// Если limit больше нуля и вышек в запросе больше, то остаются только limit вышек с лучшими
+// измерениями.
+func requestKeys(req Request, limit int) []Key {
+	keys, _ := requestTowers(req, limit)
+	return keys
+}
+
+// requestTowers возвращает ключи вышек из запроса так же, как requestKeys, и соответствующие им
+// лучшие измерения.
+func requestTowers(req Request, limit int) ([]Key, []*locator.CellTower) {
+	radio := NormalizeRadio(req.RadioType)
 	if radio == "" {
 		radio = DefaultRadioType
 	}
+	return towerKeys(req, radio, limit)
+}
+
+// towerKeys возвращает ключи вышек из запроса и лучшие измерения для них так же, как
+// requestTowers, но для вышек без указанного типа радио использует radio вместо типа радио
+// запроса.
+func towerKeys(req Request, radio string, limit int) ([]Key, []*locator.CellTower) {
+	if len(req.CellTowers) == 0 {
+		return nil, nil
+	}
+	mcc, mnc := req.HomeMobileCountryCode, req.HomeMobileNetworkCode
 	if mcc == 0 {
 		mcc = req.CellTowers[0].MobileCountryCode
 	}
 	if mnc == 0 {
 		mnc = req.CellTowers[0].MobileNetworkCode
 	}
-	// формируем запрос на получение данных о всех вышках
-	cellsData := make([]bson.M, len(req.CellTowers))
+	keys := make([]Key, 0, len(req.CellTowers))
+	towers := make([]*locator.CellTower, 0, len(req.CellTowers)) // измерения для ключей
+	index := make(map[Key]int, len(req.CellTowers))              // номер ключа в списке
 	for i, cell := range req.CellTowers {
-		cellsData[i] = bson.M{
-			"lac":  cell.LocationAreaCode,
-			"cell": cell.CellId,
+		key := Key{
+			RadioType:         req.radio(i),
+			MobileCountryCode: cell.MobileCountryCode,
+			MobileNetworkCode: cell.MobileNetworkCode,
+		}
+		key.LocationAreaCode, key.CellId = req.identity(i)
+		if key.RadioType == "" {
+			key.RadioType = towerRadio(radio, key.CellId)
+		}
+		if key.MobileCountryCode == 0 {
+			key.MobileCountryCode = mcc
+		}
+		if key.MobileNetworkCode == 0 && cell.MobileCountryCode == 0 {
+			key.MobileNetworkCode = mnc // код оператора 0 допустим, если страна указана
 		}
+		if !key.Valid() {
+			continue
+		}
+		if n, ok := index[key]; ok {
+			if betterTower(key.RadioType, cell, key.RadioType, towers[n]) {
+				towers[n] = cell
+			}
+			continue
+		}
+		index[key] = len(keys)
+		keys = append(keys, key)
+		towers = append(towers, cell)
+	}
+	if limit > 0 && len(keys) > limit {
+		order := make([]int, len(keys))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			a, b := order[i], order[j]
+			return betterTower(keys[a].RadioType, towers[a], keys[b].RadioType, towers[b])
+		})
+		order = order[:limit]
+		sort.Ints(order) // сохраняем исходный порядок вышек
+		best := make([]Key, limit)
+		bestTowers := make([]*locator.CellTower, limit)
+		for i, n := range order {
+			best[i], bestTowers[i] = keys[n], towers[n]
+		}
+		keys, towers = best, bestTowers
+	}
+	return keys, towers
+}
+
+// betterTower возвращает true, если измерение a вышки с типом радио radioA предпочтительнее
+// измерения b вышки с типом радио radioB: измерение с известным уровнем сигнала лучше
+// неизвестного, более сильный сигнал лучше слабого, а при равном сигнале лучше более свежее
+// измерение. Уровни сигнала сравниваются после приведения к RSSI (см. SignalRSSI), поэтому
+// значения в ASU и дБм, а также RSRP вышек LTE и RSSI вышек GSM можно сравнивать между собой.
+func betterTower(radioA string, a *locator.CellTower, radioB string, b *locator.CellTower) bool {
+	sa, okA := SignalRSSI(radioA, int(a.SignalStrength))
+	sb, okB := SignalRSSI(radioB, int(b.SignalStrength))
+	switch {
+	case okA != okB:
+		return okA
+	case sa != sb:
+		return sa > sb
+	default:
+		return a.Age < b.Age
+	}
+}
+
+// keyGroup описывает вышки одной сети с одним типом радио, которые можно найти одним запросом.
+type keyGroup struct {
+	radio    string // тип радио
+	mcc, mnc uint16 // код страны и оператора
+	cells    []bson.M
+}
+
+// groupKeys разбивает ключи на группы по типу радио и сети, сохраняя порядок первого появления.
+func groupKeys(keys []Key) []*keyGroup {
+	var groups []*keyGroup
+	index := make(map[Key]*keyGroup)
+	for _, key := range keys {
+		id := Key{
+			RadioType:         key.RadioType,
+			MobileCountryCode: key.MobileCountryCode,
+			MobileNetworkCode: key.MobileNetworkCode,
+		}
+		group, ok := index[id]
+		if !ok {
+			group = &keyGroup{
+				radio: key.RadioType,
+				mcc:   key.MobileCountryCode,
+				mnc:   key.MobileNetworkCode,
+			}
+			index[id] = group
+			groups = append(groups, group)
+		}
+		group.cells = append(group.cells, bson.M{
+			"lac":  key.LocationAreaCode,
+			"cell": key.CellId,
+		})
+	}
+	return groups
+}
+
+// checkRequest проверяет, что запрос не пустой, его параметры допустимы и хранилище доступно.
+func (db *DB) checkRequest(req Request) error {
+	if len(req.CellTowers) == 0 && len(req.WifiAccessPoints) == 0 {
+		return ErrEmptyRequest
+	}
+	if err := req.Options.check(); err != nil {
+		return err
+	}
+	if !db.Healthy() {
+		return ErrUnavailable // не дожидаемся таймаута, если сервер заведомо недоступен
+	}
+	return nil
+}
+
+// findCells возвращает записи о найденных в хранилище вышках из запроса вместе с их ключами.
+func (db *DB) findCells(ctx context.Context, req Request) (cells []Cell, err error) {
+	if err := db.checkRequest(req); err != nil {
+		return nil, err
+	}
+	_, _, candidates := db.requestCandidates(req)
+	cells, err = db.queryCells(ctx, allCandidates(candidates))
+	if err != nil || len(db.radioFallbacks(req.Options)) == 0 {
+		return cells, err
+	}
+	_, cells = resolveCandidates(candidates, cellIndex(cells))
+	return cells, nil
+}
+
+// queryCells возвращает записи о найденных в хранилище вышках с указанными ключами. Вышки с
+// разными типами радио и из разных сетей ищутся отдельными параллельными запросами (см.
+// QueryConcurrency), а результаты объединяются. Если задан кеш, то в MongoDB запрашиваются
+// только вышки, которых нет в кеше.
+func (db *DB) queryCells(ctx context.Context, keys []Key) (cells []Cell, err error) {
+	if db.cache == nil {
+		return db.fetchCells(ctx, keys)
+	}
+	cells, missing := db.cachedCells(keys)
+	found, err := db.fetchCells(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	db.cacheCells(missing, found)
+	return append(cells, found...), nil
+}
+
+// fetchCells запрашивает из MongoDB записи о вышках с указанными ключами.
+func (db *DB) fetchCells(ctx context.Context, keys []Key) (cells []Cell, err error) {
+	if len(keys) == 0 {
+		return nil, nil // запрос только по Wi-Fi: искать вышки не нужно
 	}
-	search := bson.M{
-		"radio": radio,
-		"mcc":   mcc,
-		"mnc":   mnc,
-		"$or":   cellsData,
+	if db.storage != nil {
+		return db.storageCells(ctx, keys)
 	}
+	start := time.Now()
+	// формируем запросы на получение данных о вышках каждой группы
+	groups := groupKeys(keys)
 	// фильтруем поля получаемых данных
-	selector := bson.M{"location": 1, "range": 1, "_id": 0}
+	find := options.Find().SetProjection(bson.M{"_id": 0})
 	// инициализируем приемник данных
-	cells = make([]Data, 0, len(req.CellTowers))
+	cells = make([]Cell, 0, len(keys))
+	db.metrics.Query.Since(start)
 	// запрашиваем данные из коллекции
-	session := db.session.Copy()
-	coll := session.DB(db.name).C(CollectionName)
-	err = coll.Find(search).Select(selector).All(&cells)
-	session.Close()
-	return cells, err
+	start = time.Now()
+	mdb, err := db.database()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	// группы запрашиваются параллельно, но не более queryWorkers запросов одновременно;
+	// результаты объединяются в порядке групп
+	found := make([][]Cell, len(groups))
+	errs := make([]error, len(groups))
+	workers := db.queryWorkers
+	if workers <= 0 || workers > len(groups) {
+		workers = len(groups)
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		search := bson.M{
+			"radio":   group.radio,
+			"mcc":     group.mcc,
+			"mnc":     group.mnc,
+			"$or":     group.cells,
+			"blocked": notBlocked, // заблокированные вышки не используются
+		}
+		coll := mdb.Collection(db.collection(group.mcc))
+		if len(groups) == 1 {
+			errs[i] = findAll(ctx, coll, search, &found[i], find) // без лишней горутины
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			if errs[i] = findAll(ctx, coll, search, &found[i], find); errs[i] != nil {
+				cancel() // остальные запросы уже не нужны
+			}
+		}(i)
+	}
+	wg.Wait()
+	db.metrics.Mongo.Since(start)
+	for i := range groups {
+		if errs[i] != nil {
+			return nil, firstError(errs)
+		}
+		cells = append(cells, found[i]...)
+	}
+	return cells, nil
+}
+
+// firstError возвращает первую ошибку, отличную от отмены контекста, которой завершились
+// остальные запросы после первой ошибки, или просто первую ошибку.
+func firstError(errs []error) error {
+	var first error
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, context.Canceled) {
+			return err
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// findAll выполняет запрос к коллекции и декодирует все найденные документы в result.
+func findAll(ctx context.Context, coll *mongo.Collection, filter, result interface{},
+	opts ...*options.FindOptions) error {
+	cursor, err := coll.Find(ctx, filter, opts...)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, result)
+}
+
+// aggregateAll выполняет агрегацию в коллекции и декодирует все полученные документы в result.
+func aggregateAll(ctx context.Context, coll *mongo.Collection, pipeline, result interface{}) error {
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, result)
 }
 
 // AveragePoint ищет и вычисляет координаты, переданные в запросе, на основании данных вышек сотовой
-// связи. Если данных не достаточно или необходимая для вычислений информация не найдена в
-// хранилище, то возвращается ошибка.
-func (db *DB) Get(req locator.Request) (response *locator.Response, err error) {
-	cells, err := db.GetCells(req)
+// связи и точек доступа Wi-Fi. Если данных не достаточно или необходимая для вычислений информация
+// не найдена в хранилище, то возвращается ошибка.
+func (db *DB) Get(ctx context.Context, req locator.Request) (response *locator.Response, err error) {
+	details, err := db.GetDetailed(ctx, Request{Request: req})
 	if err != nil {
 		return nil, err
 	}
-	// перебираем полученные данные
-	var lon, lat float64
-	for _, cell := range cells {
-		lon += cell.Location.Longitude()
-		lat += cell.Location.Latitude()
-	}
-	count := float64(len(cells))
-	lon, lat = lon/count, lat/count // вычисляем среднее значение
-	const EARTH_RADIUS = 6378137.0
-	var accuracy float64
-	for _, cell := range cells {
-		lat2 := cell.Location.Latitude()
-		lon2 := cell.Location.Longitude()
-		dLat := math.Pi / 180.0 * (lat2 - lat) / 2.0
-		dLon := math.Pi / 180.0 * (lon2 - lon) / 2.0
-		lat1 := math.Pi / 180.0 * (lat)
-		lat2 = math.Pi / 180.0 * (lat2)
-		a := math.Pow(math.Sin(dLat), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLon), 2)
-		c := math.Asin(math.Min(1, math.Sqrt(a)))
-		dist := 2*EARTH_RADIUS*c + cell.Accuracy
-		if dist > accuracy {
-			accuracy = dist
-		}
-	}
-	response = &locator.Response{
-		Location: locator.Point{
-			Lat: lat,
-			Lng: lon,
-		},
-		Accuracy: accuracy,
-	}
-	return response, nil
-}
-
-// Records возвращает количество записей в хранилище LBS.
-func (db *DB) Records() int {
-	session := db.session.Copy()
-	coll := session.DB(db.name).C(CollectionName)
-	total, _ := coll.Count()
-	session.Close()
+	return details.Response, nil
+}
+
+// Metrics возвращает гистограммы времени выполнения этапов обработки запросов.
+func (db *DB) Metrics() *Metrics {
+	return db.metrics
+}
+
+// Records возвращает количество записей в хранилище LBS. При ошибке запроса возвращается 0.
+func (db *DB) Records(ctx context.Context) int {
+	total, _ := db.count(ctx)
 	return total
 }
+
+// count возвращает общее количество записей во всех коллекциях с данными.
+func (db *DB) count(ctx context.Context) (total int, err error) {
+	if db.storage != nil {
+		return db.storage.Count(ctx)
+	}
+	mdb, err := db.database()
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	for _, name := range db.collections() {
+		count, err := mdb.Collection(name).EstimatedDocumentCount(ctx)
+		if err != nil {
+			return 0, err
+		}
+		total += int(count)
+	}
+	return total, nil
+}
@@ -1,49 +1,307 @@
 package lbs
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/geotrace/locator"
-	"gopkg.in/mgo.v2"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 func TestSearch(t *testing.T) {
-	mongodb, err := mgo.Dial("mongodb://localhost/geotrace")
+	ctx := context.Background()
+	lbs, err := Dial(ctx, "mongodb://localhost/geotrace", SyncTimeout(time.Second))
 	if err != nil {
 		log.Println("Error connecting to MongoDB:", err)
 		return
 	}
-	defer mongodb.Close()
+	defer lbs.Close()
 
-	lbs, err := InitDB(mongodb, "geotrace")
+	request := locator.Request{
+		CellTowers: []*locator.CellTower{
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517, SignalStrength: -78},
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 39696, SignalStrength: -81},
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22518, SignalStrength: -91},
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 27306, SignalStrength: -101},
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 29909, SignalStrength: -103},
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22516, SignalStrength: -104},
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 20736, SignalStrength: -105},
+		},
+	}
+
+	cells, err := lbs.GetCells(ctx, request)
 	if err != nil {
 		t.Fatal(err)
 	}
+	for _, cell := range cells {
+		fmt.Println(cell)
+	}
+	resp, err := lbs.Get(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(resp)
+}
 
+func TestRequestKeys(t *testing.T) {
 	request := locator.Request{
 		CellTowers: []*locator.CellTower{
-			{250, 2, 7743, 22517, -78, 0, 0},
-			{250, 2, 7743, 39696, -81, 0, 0},
-			{250, 2, 7743, 22518, -91, 0, 0},
-			{250, 2, 7743, 27306, -101, 0, 0},
-			{250, 2, 7743, 29909, -103, 0, 0},
-			{250, 2, 7743, 22516, -104, 0, 0},
-			{250, 2, 7743, 20736, -105, 0, 0},
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517, SignalStrength: -78},
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 39696, SignalStrength: -81},
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 26216458, SignalStrength: -91}, // LTE
+			{MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 7701, CellId: 11230, SignalStrength: -95},    // другой оператор
+			{MobileCountryCode: 0, MobileNetworkCode: 0, LocationAreaCode: 7743, CellId: 22518, SignalStrength: -99},      // сеть не указана
 		},
 	}
+	keys := requestKeys(Request{Request: request}, 0)
+	if len(keys) != len(request.CellTowers) {
+		t.Fatalf("bad keys count: %d", len(keys))
+	}
+	for i, radio := range []string{"gsm", "gsm", "lte", "gsm", "gsm"} {
+		if keys[i].RadioType != radio {
+			t.Errorf("bad radio for cell %d: %q", keys[i].CellId, keys[i].RadioType)
+		}
+	}
+	for i, mnc := range []uint16{2, 2, 2, 1, 2} {
+		if keys[i].MobileCountryCode != 250 || keys[i].MobileNetworkCode != mnc {
+			t.Errorf("bad network for cell %d: %d/%d", keys[i].CellId,
+				keys[i].MobileCountryCode, keys[i].MobileNetworkCode)
+		}
+	}
+	groups := groupKeys(keys)
+	if len(groups) != 3 {
+		t.Fatalf("bad groups count: %d", len(groups))
+	}
+	if groups[0].radio != "gsm" || groups[0].mnc != 2 || len(groups[0].cells) != 3 ||
+		groups[1].radio != "lte" || len(groups[1].cells) != 1 ||
+		groups[2].radio != "gsm" || groups[2].mnc != 1 || len(groups[2].cells) != 1 {
+		t.Errorf("bad groups: %+v, %+v, %+v", groups[0], groups[1], groups[2])
+	}
+}
 
-	cells, err := lbs.GetCells(request)
+func TestRequestRadioTypes(t *testing.T) {
+	var request Request
+	err := json.Unmarshal([]byte(`{
+		"radioType": "gsm",
+		"cellTowers": [
+			{"mobileCountryCode": 250, "mobileNetworkCode": 2, "locationAreaCode": 7743, "cellId": 22517},
+			{"radioType": "WCDMA", "mobileCountryCode": 250, "mobileNetworkCode": 2, "locationAreaCode": 7743, "cellId": 3917}
+		]
+	}`), &request)
 	if err != nil {
 		t.Fatal(err)
 	}
-	for _, cell := range cells {
-		fmt.Println(cell)
+	if len(request.CellTowers) != 2 || request.RadioType != "gsm" {
+		t.Fatalf("bad request: %+v", request.Request)
+	}
+	keys := requestKeys(request, 0)
+	if keys[0].RadioType != "gsm" || keys[1].RadioType != "umts" {
+		t.Errorf("bad radio types: %q, %q", keys[0].RadioType, keys[1].RadioType)
 	}
-	resp, err := lbs.Get(request)
+}
+
+func TestRequestNR(t *testing.T) {
+	var request Request
+	err := json.Unmarshal([]byte(`{
+		"radioType": "lte",
+		"cellTowers": [
+			{"mobileCountryCode": 250, "mobileNetworkCode": 2, "locationAreaCode": 7743, "cellId": 22517},
+			{"mobileCountryCode": 250, "mobileNetworkCode": 2, "locationAreaCode": 16777000, "newRadioCellId": 68719476000},
+			{"mobileCountryCode": 250, "mobileNetworkCode": 2, "locationAreaCode": 7743, "cellId": 34359738368},
+			{"radioType": "nr", "mobileCountryCode": 250, "mobileNetworkCode": 2, "locationAreaCode": 7743, "cellId": 1234}
+		]
+	}`), &request)
 	if err != nil {
 		t.Fatal(err)
 	}
-	fmt.Println(resp)
+	if len(request.CellTowers) != 4 || request.CellTowers[1].MobileCountryCode != 250 {
+		t.Fatalf("bad request: %+v", request.Request)
+	}
+	if err := request.Validate(); err != nil {
+		t.Errorf("valid request rejected: %v", err)
+	}
+	keys := requestKeys(request, 0)
+	want := []Key{
+		{RadioType: "lte", MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517},
+		{RadioType: "nr", MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 16777000, CellId: 68719476000},
+		{RadioType: "nr", MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 34359738368},
+		{RadioType: "nr", MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 1234},
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("bad keys: %+v", keys)
+	}
+	for _, test := range []struct {
+		radio  string
+		cellId uint64
+		want   string
+	}{
+		{"gsm", 22517, "gsm"},
+		{"gsm", 0x10000, "lte"},
+		{"gsm", 0x10000000, "nr"},
+		{"lte", 0x10000000, "nr"},
+		{"umts", 0xFFFFFFF, "umts"},
+	} {
+		if got := towerRadio(test.radio, test.cellId); got != test.want {
+			t.Errorf("towerRadio(%q, %d) = %q; want %q", test.radio, test.cellId, got, test.want)
+		}
+	}
+}
+
+func TestKeyValid(t *testing.T) {
+	for _, key := range []Key{
+		{LocationAreaCode: 0, CellId: 22517},
+		{LocationAreaCode: 0xFFFE, CellId: 22517},
+		{LocationAreaCode: 0xFFFF, CellId: 22517},
+		{LocationAreaCode: 7743, CellId: 0},
+		{LocationAreaCode: 7743, CellId: 0x7FFFFFFF},
+		{LocationAreaCode: 7743, CellId: 0xFFFFFFFF},
+	} {
+		if key.Valid() {
+			t.Errorf("invalid key accepted: %+v", key)
+		}
+	}
+	if !(Key{LocationAreaCode: 7743, CellId: 22517}).Valid() {
+		t.Error("valid key rejected")
+	}
+	keys := requestKeys(Request{Request: locator.Request{
+		CellTowers: []*locator.CellTower{
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517, SignalStrength: -78},
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 0xFFFF, CellId: 0xFFFFFFFF, SignalStrength: -81},
+		},
+	}}, 0)
+	if len(keys) != 1 || keys[0].CellId != 22517 {
+		t.Errorf("invalid tower not skipped: %+v", keys)
+	}
+}
+
+func TestRequestKeysDuplicates(t *testing.T) {
+	keys := requestKeys(Request{Request: locator.Request{
+		CellTowers: []*locator.CellTower{
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517, SignalStrength: -91},
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 39696, SignalStrength: -81},
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517, SignalStrength: -78},
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517, SignalStrength: 0},
+		},
+	}}, 0)
+	if len(keys) != 2 || keys[0].CellId != 22517 || keys[1].CellId != 39696 {
+		t.Errorf("duplicates not collapsed: %+v", keys)
+	}
+	strong := &locator.CellTower{SignalStrength: -78, Age: 100}
+	weak := &locator.CellTower{SignalStrength: -91}
+	fresh := &locator.CellTower{SignalStrength: -78, Age: 10}
+	unknown := &locator.CellTower{}
+	if !betterTower("gsm", strong, "gsm", weak) || betterTower("gsm", weak, "gsm", strong) {
+		t.Error("stronger signal not preferred")
+	}
+	if !betterTower("gsm", fresh, "gsm", strong) {
+		t.Error("newer measurement not preferred")
+	}
+	if !betterTower("gsm", weak, "gsm", unknown) || betterTower("gsm", unknown, "gsm", weak) {
+		t.Error("known signal not preferred")
+	}
+	asu := &locator.CellTower{SignalStrength: 20} // -73 дБм
+	if !betterTower("gsm", asu, "gsm", strong) {
+		t.Error("signal in ASU not normalized")
+	}
+	rsrp := &locator.CellTower{SignalStrength: -95} // RSSI около -68 дБм
+	if !betterTower("lte", rsrp, "gsm", strong) {
+		t.Error("LTE RSRP not normalized")
+	}
+}
+
+func TestRequestKeysLimit(t *testing.T) {
+	request := Request{Request: locator.Request{
+		CellTowers: []*locator.CellTower{
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517, SignalStrength: -91},
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 39696, SignalStrength: 0},
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22518, SignalStrength: -78},
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 27306, SignalStrength: -101},
+		},
+	}}
+	keys := requestKeys(request, 2)
+	if len(keys) != 2 || keys[0].CellId != 22517 || keys[1].CellId != 22518 {
+		t.Errorf("bad strongest towers: %+v", keys)
+	}
+	if keys := requestKeys(request, 0); len(keys) != 4 {
+		t.Errorf("towers truncated without limit: %d", len(keys))
+	}
+}
+
+func TestQueryContext(t *testing.T) {
+	db := newDB("test", []Option{QueryTimeout(time.Second)})
+	ctx, cancel := db.queryContext(context.Background())
+	deadline, ok := ctx.Deadline()
+	cancel()
+	if !ok || time.Until(deadline) > time.Second {
+		t.Errorf("query timeout not applied: %v", deadline)
+	}
+	parent, cancelParent := context.WithTimeout(context.Background(), time.Hour)
+	defer cancelParent()
+	ctx, cancel = db.queryContext(parent)
+	defer cancel()
+	if deadline, _ := ctx.Deadline(); time.Until(deadline) < time.Minute {
+		t.Errorf("request deadline replaced: %v", deadline)
+	}
+	db.Close()
+	if _, err := db.database(); err != ErrClosed {
+		t.Errorf("closed database returned bad error: %v", err)
+	}
+}
+
+func TestConnectionOptions(t *testing.T) {
+	db := newDB("test", []Option{ReadPreference(readpref.SecondaryPreferredMode), MaxPoolSize(200),
+		RetryReads(false)})
+	if db.readMode != readpref.SecondaryPreferredMode || db.maxPoolSize != 200 {
+		t.Errorf("bad connection options: %v, %d", db.readMode, db.maxPoolSize)
+	}
+	if db.retryReads == nil || *db.retryReads || db.retryWrites != nil {
+		t.Errorf("bad retry options: %v, %v", db.retryReads, db.retryWrites)
+	}
+	if db.clientOptions("mongodb://localhost/geotrace") == nil {
+		t.Error("no client options")
+	}
+	if _, err := db.database(); err != ErrNoDatabase {
+		t.Errorf("database without client returned bad error: %v", err)
+	}
+}
+
+func TestFirstError(t *testing.T) {
+	failed := errors.New("query failed")
+	if err := firstError([]error{nil, context.Canceled, failed, nil}); err != failed {
+		t.Errorf("bad first error: %v", err)
+	}
+	if err := firstError([]error{nil, context.Canceled}); err != context.Canceled {
+		t.Errorf("bad canceled error: %v", err)
+	}
+	if err := firstError([]error{nil, nil}); err != nil {
+		t.Errorf("error without errors: %v", err)
+	}
+}
+
+func TestNotFoundError(t *testing.T) {
+	keys := []Key{
+		{RadioType: "gsm", MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 1, CellId: 10},
+		{RadioType: "gsm", MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 1, CellId: 20},
+	}
+	macs := []string{"00:1a:2b:3c:4d:01"}
+	details, err := newDB("test", nil).locate(keys, nil, nil, macs, nil)
+	if details != nil || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("bad result without cells: %v, %v", details, err)
+	}
+	var notFound *NotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("not a NotFoundError: %T", err)
+	}
+	if len(notFound.Missing) != 2 || notFound.Missing[1] != keys[1] || len(notFound.MissingWifi) != 1 {
+		t.Errorf("bad missing towers: %+v", notFound)
+	}
+	if err.Error() != ErrNotFound.Error() {
+		t.Errorf("bad error text: %v", err)
+	}
 }
@@ -0,0 +1,312 @@
+package lbs
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/locator"
+)
+
+// Match описывает найденную в хранилище вышку и ее вклад в вычисленные координаты.
+type Match struct {
+	Cell
+	Weight   float64 `json:"weight"`   // доля вышки в вычисленных координатах
+	Distance float64 `json:"distance"` // расстояние от вышки до вычисленных координат, м
+}
+
+// Details описывает подробный результат вычисления координат: помимо самого ответа содержит
+// найденные вышки и точки доступа Wi-Fi с их весами, а также вышки и точки доступа из запроса,
+// которых нет в хранилище. Используется для разбора случаев, когда координаты определены неверно.
+// Каждая вышка из запроса попадает в один из списков: Cells (использована, с координатами из
+// хранилища и расстоянием до вычисленных координат), Rejected (отброшена как выброс), Stale
+// (устарела, см. StaleCells) или Missing (не найдена в хранилище). Если устаревшие вышки
+// используются с меньшим весом, то они перечисляются и в Cells, и в Stale.
+//
+// Кроме радиуса точности Details содержит эллипс неопределенности, вычисленный по ковариации
+// положений найденных вышек: он полезен при объединении с данными других датчиков, когда круг
+// слишком грубо описывает погрешность (например, если все вышки расположены вдоль дороги).
+//
+// Поле Confidence оценивает достоверность координат по количеству использованных вышек и точек
+// доступа, доле найденных среди запрошенных, количеству подтверждений записей и их возрасту: по
+// нему клиент может решить, доверять ли координатам, не разбирая остальные поля.
+type Details struct {
+	Response    *locator.Response `json:"response"`              // вычисленные координаты и точность
+	Ellipse     *Ellipse          `json:"ellipse,omitempty"`     // эллипс неопределенности координат
+	Cells       []Match           `json:"cells"`                 // найденные вышки
+	Wifi        []WifiMatch       `json:"wifi,omitempty"`        // найденные точки доступа Wi-Fi
+	Missing     []Key             `json:"missing,omitempty"`     // вышки из запроса, не найденные в хранилище
+	MissingWifi []string          `json:"missingWifi,omitempty"` // точки доступа, не найденные в хранилище
+	Rejected    []Key             `json:"rejected,omitempty"`    // вышки, отброшенные как выбросы (см. RejectOutliers)
+	Stale       []Key             `json:"stale,omitempty"`       // устаревшие вышки (см. StaleCells)
+	CellCounts  Counts            `json:"cellCounts"`            // количество вышек на этапах вычисления
+	WifiCounts  Counts            `json:"wifiCounts"`            // количество точек доступа на этапах вычисления
+	Fuzzy       bool              `json:"fuzzy,omitempty"`       // координаты вычислены по соседним вышкам зоны (см. FuzzyAreaLookup)
+	Area        *Key              `json:"area,omitempty"`        // координаты — центр этой зоны (см. GetOptions.AreaFallback)
+	Country     string            `json:"country,omitempty"`     // координаты — центр страны с этим кодом (см. CountryFallback)
+	GeoIP       bool              `json:"geoip,omitempty"`       // координаты определены по IP-адресу клиента (см. IPFallback)
+	Confidence  float64           `json:"confidence"`            // оценка достоверности координат от 0 до 1
+}
+
+// Counts описывает, сколько вышек или точек доступа Wi-Fi осталось на каждом этапе вычисления
+// координат. По этим числам клиенты и панели мониторинга могут судить о качестве результата:
+// например, координаты по одной найденной вышке из шести запрошенных заслуживают меньше доверия.
+type Counts struct {
+	Requested int `json:"requested"` // уникальных допустимых записей в запросе
+	Matched   int `json:"matched"`   // найдено в хранилище
+	Used      int `json:"used"`      // использовано при вычислении координат
+}
+
+// GetDetailed вычисляет координаты так же, как Get, но возвращает подробности вычисления и
+// учитывает типы радио, указанные для каждой вышки отдельно. Если ни одна вышка и ни одна точка
+// доступа из запроса не найдены в хранилище, то возвращается ошибка *NotFoundError, совпадающая
+// с ErrNotFound при сравнении через errors.Is. Если задан
+// WithResultCache, то результат для того же набора вышек и точек доступа берется из кеша.
+func (db *DB) GetDetailed(ctx context.Context, req Request) (details *Details, err error) {
+	var keys []Key
+	defer func() {
+		db.metrics.lookup(len(keys), details, err)
+		db.logLookup(ctx, keys, details, err)
+	}()
+	if err := db.checkRequest(req); err != nil {
+		if err == ErrEmptyRequest && db.ipLookup(req) {
+			return db.locateIP(ctx, req, nil, nil, err)
+		}
+		return nil, err
+	}
+	keys, towers, candidates := db.requestCandidates(req)
+	macs := db.requestMacs(req)
+	var resultKey string
+	if db.resultsTTL > 0 {
+		resultKey = db.resultKey(allCandidates(candidates), macs) + req.Options.cacheKey()
+		if details, ok := db.cachedResult(ctx, resultKey); ok {
+			return details, nil
+		}
+	}
+	cells, err := db.queryCells(ctx, allCandidates(candidates))
+	if err != nil {
+		return nil, err
+	}
+	if len(db.radioFallbacks(req.Options)) > 0 {
+		keys, cells = resolveCandidates(candidates, cellIndex(cells))
+	}
+	points, err := db.queryWifi(ctx, macs)
+	if err != nil {
+		return nil, err
+	}
+	details, err = db.locateWith(req.Options, keys, towers, cells, macs, points)
+	if db.fuzzyLookup(req.Options) > 0 && errors.Is(err, ErrNotFound) {
+		details, err = db.locateFuzzy(ctx, req.Options, keys, towers, macs, points, err)
+	}
+	if db.areaFallback(req.Options) && errors.Is(err, ErrNotFound) {
+		details, err = db.locateArea(ctx, req.Options, keys, towers, macs, err)
+	}
+	if db.ipLookup(req) && errors.Is(err, ErrNotFound) {
+		details, err = db.locateIP(ctx, req, keys, macs, err)
+	}
+	if db.countryLookup(req.Options) && errors.Is(err, ErrNotFound) {
+		details, err = db.locateCountry(req.Options, keys, towers, macs, err)
+	}
+	if err == nil && resultKey != "" && !details.GeoIP {
+		db.cacheResult(ctx, resultKey, details)
+	}
+	return details, err
+}
+
+// minAccuracy задает минимальную точность в метрах, учитываемую при взвешивании: записи с
+// нулевым радиусом не должны получать бесконечный вес.
+const minAccuracy = 10.0
+
+// weightedPoint описывает положение вышки или точки доступа с ее зоной действия и весом.
+type weightedPoint struct {
+	location geo.Point
+	accuracy float64 // радиус зоны действия, м
+	weight   float64 // доля в вычисленных координатах
+}
+
+// centroid возвращает средневзвешенные координаты точек.
+func centroid(points []weightedPoint) (lat, lon float64) {
+	for _, point := range points {
+		lon += point.location.Longitude() * point.weight
+		lat += point.location.Latitude() * point.weight
+	}
+	return lat, lon
+}
+
+// coverage возвращает радиус круга с центром в точке lat, lon, покрывающего зоны действия всех
+// точек.
+func coverage(lat, lon float64, points []weightedPoint) (accuracy float64) {
+	for _, point := range points {
+		dist := distance(lat, lon, point.location.Latitude(), point.location.Longitude()) + point.accuracy
+		if dist > accuracy {
+			accuracy = dist
+		}
+	}
+	return accuracy
+}
+
+// locate вычисляет координаты по записям о найденных вышках и точках доступа Wi-Fi. Ключи всех
+// вышек и MAC-адреса всех точек доступа из запроса нужны, чтобы отметить не найденные в хранилище.
+//
+// Внутри каждой группы (вышки и точки доступа) все записи имеют одинаковый вес, а если задан
+// параметр SignalWeighted, то вес вышки зависит от расстояния до нее, оцененного по уровню сигнала
+// и времени задержки из соответствующего измерения towers. Если найдены и
+// вышки, и точки доступа, то координаты групп объединяются с весами, обратно пропорциональными
+// квадрату их точности, поэтому гораздо более точные данные Wi-Fi почти полностью определяют
+// результат. Точки доступа, находящиеся вне зоны действия найденных вышек, не используются, а
+// если точек доступа найдено меньше MinWifiAccessPoints, то координаты вычисляются только по
+// вышкам. Если задан параметр Multilateration, то координаты группы вышек вычисляются по
+// расстояниям до них, а не как среднее положение. Для вышек без правдоподобного радиуса
+// действия используется радиус по умолчанию для страны и типа радио (см. LearnAccuracy).
+func (db *DB) locate(keys []Key, towers []*locator.CellTower, cells []Cell, macs []string,
+	points []AccessPoint) (*Details, error) {
+	return db.locateWith(nil, keys, towers, cells, macs, points)
+}
+
+// locateWith вычисляет координаты так же, как locate, но с параметрами запроса opts (см.
+// GetOptions). Если найдено меньше opts.MinTowers вышек и точки доступа не использованы, то
+// возвращается ошибка *NotFoundError.
+func (db *DB) locateWith(opts *GetOptions, keys []Key, towers []*locator.CellTower, cells []Cell,
+	macs []string, points []AccessPoint) (*Details, error) {
+	defer db.metrics.Algorithm.Since(time.Now())
+	for i := range cells {
+		cells[i].Accuracy = db.accuracy.cellRange(cells[i])
+	}
+	matched := len(cells)
+	cells, stale := db.splitStale(cells)
+	cells, rejected := rejectOutliers(cells, db.outlierFactor)
+	// устаревшие и отброшенные вышки найдены в хранилище, хотя и не используются
+	skipped := append(stale[:len(stale):len(stale)], rejected...)
+	cellPoints := make([]weightedPoint, len(cells))
+	for i := range cells {
+		cellPoints[i] = weightedPoint{cells[i].Location, cells[i].Accuracy, 1 / float64(len(cells))}
+	}
+	signalWeighted, leastSquares := db.algorithm(opts)
+	if signalWeighted {
+		signalWeights(cellPoints, cells, keys, towers)
+	}
+	siteWeights(cellPoints, cells)
+	db.staleWeights(cellPoints, cells, stale)
+	cellLat, cellLon := centroid(cellPoints)
+	if leastSquares {
+		cellLat, cellLon, _ = multilaterate(cellLat, cellLon, cells, keys, towers)
+	}
+	cellAccuracy := coverage(cellLat, cellLon, cellPoints)
+	found := points
+	if points = wifiNearCells(points, cells, cellLat, cellLon, cellAccuracy); len(points) < MinWifiAccessPoints {
+		points = nil
+	}
+	if len(cells) == 0 && len(points) == 0 {
+		missing, missingWifi := missingTowers(keys, cells, skipped, macs, found)
+		return nil, &NotFoundError{Missing: missing, MissingWifi: missingWifi}
+	}
+	wifiPoints := make([]weightedPoint, len(points))
+	for i, point := range points {
+		wifiPoints[i] = weightedPoint{point.Location, point.Accuracy, 1 / float64(len(points))}
+	}
+	wifiLat, wifiLon := centroid(wifiPoints)
+	wifiAccuracy := coverage(wifiLat, wifiLon, wifiPoints)
+
+	// веса групп обратно пропорциональны квадрату их точности
+	var cellWeight, wifiWeight float64
+	if len(cells) > 0 {
+		cellWeight = 1 / math.Pow(math.Max(cellAccuracy, minAccuracy), 2)
+	}
+	if len(points) > 0 {
+		wifiWeight = 1 / math.Pow(math.Max(wifiAccuracy, minAccuracy), 2)
+	}
+	total := cellWeight + wifiWeight
+	cellWeight, wifiWeight = cellWeight/total, wifiWeight/total
+	all := make([]weightedPoint, 0, len(cellPoints)+len(wifiPoints))
+	details := &Details{
+		Cells:    make([]Match, len(cells)),
+		Rejected: rejected,
+		Stale:    stale,
+	}
+	for i, cell := range cells {
+		cellPoints[i].weight *= cellWeight
+		details.Cells[i] = Match{Cell: cell, Weight: cellPoints[i].weight}
+	}
+	for i, point := range points {
+		wifiPoints[i].weight *= wifiWeight
+		details.Wifi = append(details.Wifi, WifiMatch{AccessPoint: point, Weight: wifiPoints[i].weight})
+	}
+	all = append(append(all, cellPoints...), wifiPoints...)
+	lat, lon := cellLat*cellWeight+wifiLat*wifiWeight, cellLon*cellWeight+wifiLon*wifiWeight
+	// точность определяется самой точной из групп: вычисленная точка находится в зоне ее действия
+	accuracy := math.Inf(1)
+	if len(cells) > 0 {
+		accuracy = coverage(lat, lon, cellPoints)
+	}
+	if len(points) > 0 {
+		accuracy = math.Min(accuracy, coverage(lat, lon, wifiPoints))
+	}
+	if accuracyMax := db.maxAccuracy(opts); accuracyMax > 0 {
+		accuracy = math.Min(accuracy, accuracyMax)
+	}
+	accuracy = math.Max(accuracy, db.accuracyMin)
+	details.Response = &locator.Response{
+		Location: locator.Point{
+			Lat: lat,
+			Lng: lon,
+		},
+		Accuracy: accuracy,
+	}
+	details.Ellipse = errorEllipse(lat, lon, all)
+	for i := range details.Cells {
+		location := details.Cells[i].Location
+		details.Cells[i].Distance = distance(lat, lon, location.Latitude(), location.Longitude())
+	}
+	for i := range details.Wifi {
+		location := details.Wifi[i].Location
+		details.Wifi[i].Distance = distance(lat, lon, location.Latitude(), location.Longitude())
+	}
+	details.CellCounts = Counts{Requested: len(keys), Matched: matched, Used: used(cellPoints)}
+	details.WifiCounts = Counts{Requested: len(macs), Matched: len(found), Used: used(wifiPoints)}
+	details.Missing, details.MissingWifi = missingTowers(keys, cells, skipped, macs, found)
+	if opts != nil && matched < opts.MinTowers && details.WifiCounts.Used == 0 {
+		return nil, &NotFoundError{Missing: details.Missing, MissingWifi: details.MissingWifi}
+	}
+	details.Confidence = db.confidence(details)
+	return details, nil
+}
+
+// missingTowers возвращает вышки и точки доступа из запроса, которых нет в хранилище. Вышки,
+// отброшенные как выбросы или как устаревшие (skipped), считаются найденными.
+func missingTowers(keys []Key, cells []Cell, skipped []Key, macs []string,
+	found []AccessPoint) (missing []Key, missingWifi []string) {
+	foundCells := make(map[Key]bool, len(cells))
+	for _, cell := range cells {
+		foundCells[cell.Key] = true
+	}
+	for _, key := range skipped {
+		foundCells[key] = true
+	}
+	for _, key := range keys {
+		if !foundCells[key] {
+			missing = append(missing, key)
+		}
+	}
+	foundWifi := make(map[string]bool, len(found))
+	for _, point := range found {
+		foundWifi[point.MAC] = true
+	}
+	for _, mac := range macs {
+		if !foundWifi[mac] {
+			missingWifi = append(missingWifi, mac)
+		}
+	}
+	return missing, missingWifi
+}
+
+// used возвращает количество точек с ненулевым весом.
+func used(points []weightedPoint) (count int) {
+	for _, point := range points {
+		if point.weight > 0 {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,27 @@
+package lbs
+
+import (
+	"math"
+
+	"github.com/geotrace/geo"
+)
+
+// earthRadius задает радиус Земли в метрах.
+const earthRadius = 6378137.0
+
+// distance возвращает расстояние в метрах между двумя точками, заданными широтой и долготой в
+// градусах.
+func distance(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := math.Pi / 180.0 * (lat2 - lat1) / 2.0
+	dLon := math.Pi / 180.0 * (lon2 - lon1) / 2.0
+	lat1 = math.Pi / 180.0 * (lat1)
+	lat2 = math.Pi / 180.0 * (lat2)
+	a := math.Pow(math.Sin(dLat), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLon), 2)
+	c := math.Asin(math.Min(1, math.Sqrt(a)))
+	return 2 * earthRadius * c
+}
+
+// Distance возвращает расстояние в метрах между двумя точками.
+func Distance(p1, p2 geo.Point) float64 {
+	return distance(p1.Latitude(), p1.Longitude(), p2.Latitude(), p2.Longitude())
+}
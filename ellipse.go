@@ -0,0 +1,43 @@
+package lbs
+
+import "math"
+
+// Ellipse описывает эллипс неопределенности вычисленных координат.
+type Ellipse struct {
+	SemiMajor   float64 `json:"semiMajor"`   // большая полуось, м
+	SemiMinor   float64 `json:"semiMinor"`   // малая полуось, м
+	Orientation float64 `json:"orientation"` // азимут большой полуоси от 0 до 180 градусов по часовой стрелке от севера
+}
+
+// errorEllipse вычисляет эллипс неопределенности для координат lat, lon по ковариации положений
+// найденных вышек и точек доступа с учетом их весов. Каждая из них дополнительно вносит
+// неопределенность своей зоны действия: если устройство равномерно распределено внутри круга
+// радиусом range, то дисперсия по каждой оси составляет range²/4. Полуоси эллипса соответствуют
+// одному стандартному отклонению.
+func errorEllipse(lat, lon float64, points []weightedPoint) *Ellipse {
+	if len(points) == 0 {
+		return nil
+	}
+	// переводим координаты вышек в метры относительно вычисленной точки (восток, север)
+	scale := math.Pi / 180 * earthRadius
+	cosLat := math.Cos(lat * math.Pi / 180)
+	var sxx, syy, sxy float64
+	for _, point := range points {
+		x := (point.location.Longitude() - lon) * scale * cosLat
+		y := (point.location.Latitude() - lat) * scale
+		spread := point.accuracy * point.accuracy / 4
+		sxx += point.weight * (x*x + spread)
+		syy += point.weight * (y*y + spread)
+		sxy += point.weight * x * y
+	}
+	// собственные значения и направление главной оси ковариационной матрицы
+	mean := (sxx + syy) / 2
+	diff := math.Hypot((sxx-syy)/2, sxy)
+	angle := math.Atan2(2*sxy, sxx-syy) / 2 * 180 / math.Pi // от направления на восток против часовой стрелки
+	orientation := math.Mod(90-angle+180, 180)
+	return &Ellipse{
+		SemiMajor:   math.Sqrt(mean + diff),
+		SemiMinor:   math.Sqrt(math.Max(0, mean-diff)),
+		Orientation: orientation,
+	}
+}
@@ -0,0 +1,41 @@
+package lbs
+
+import (
+	"math"
+	"testing"
+
+	"github.com/geotrace/geo"
+)
+
+func TestErrorEllipse(t *testing.T) {
+	if errorEllipse(55.75, 37.62, nil) != nil {
+		t.Error("ellipse without cells")
+	}
+	// одна вышка: круг с радиусом в половину зоны действия
+	cell := func(lon, lat, accuracy, weight float64) weightedPoint {
+		return weightedPoint{location: geo.NewPoint(lon, lat), accuracy: accuracy, weight: weight}
+	}
+	ellipse := errorEllipse(55.75, 37.62, []weightedPoint{cell(37.62, 55.75, 1000, 1)})
+	if math.Abs(ellipse.SemiMajor-500) > 1 || math.Abs(ellipse.SemiMinor-500) > 1 {
+		t.Errorf("bad single cell ellipse: %+v", ellipse)
+	}
+	// вышки вдоль линии запад-восток: большая полуось направлена на восток
+	ellipse = errorEllipse(55.75, 37.62, []weightedPoint{
+		cell(37.60, 55.75, 0, 0.5),
+		cell(37.64, 55.75, 0, 0.5),
+	})
+	if math.Abs(ellipse.Orientation-90) > 0.1 {
+		t.Errorf("bad orientation: %.1f", ellipse.Orientation)
+	}
+	if ellipse.SemiMinor > 1 || ellipse.SemiMajor < 1000 {
+		t.Errorf("bad axes: %+v", ellipse)
+	}
+	// вышки вдоль линии юг-север
+	ellipse = errorEllipse(55.75, 37.62, []weightedPoint{
+		cell(37.62, 55.74, 0, 0.5),
+		cell(37.62, 55.76, 0, 0.5),
+	})
+	if ellipse.Orientation > 0.1 && ellipse.Orientation < 179.9 {
+		t.Errorf("bad orientation: %.1f", ellipse.Orientation)
+	}
+}
@@ -0,0 +1,117 @@
+package lbs
+
+import (
+	"math"
+	"sort"
+
+	"github.com/geotrace/geo"
+)
+
+// Параметры вычисления местоположения вышки по исходным измерениям.
+const (
+	estimateOutlierFactor = 3.0  // измерения дальше медианы расстояний в это число раз - выбросы
+	estimateTrim          = 0.1  // доля самых удаленных измерений, отбрасываемых при усреднении
+	estimateMinRange      = 50.0 // минимальный радиус действия вышки, м
+	estimateMaxAccuracy   = 1000 // измерения с худшей точностью координат не используются, м
+)
+
+// Estimate описывает вычисленные по исходным измерениям местоположение и радиус действия вышки.
+type Estimate struct {
+	Data             // координаты и радиус действия
+	Samples  int     // количество использованных измерений
+	Variance float64 // дисперсия координат использованных измерений, м²
+}
+
+// EstimateCell вычисляет местоположение и радиус действия вышки по исходным измерениям одной и
+// той же вышки. Местоположение вычисляется как взвешенное среднее координат измерений: чем сильнее
+// сигнал и точнее координаты, тем больше вес. Затем отбрасываются выбросы (измерения, удаленные
+// от среднего больше, чем в три раза по сравнению с медианой расстояний) и самые удаленные
+// измерения, после чего среднее вычисляется повторно. Радиусом действия считается расстояние до
+// самого удаленного из оставшихся измерений, а дисперсия координат оставшихся измерений позволяет
+// затем уточнять запись отдельными измерениями (см. DB.Submit).
+//
+// Если подходящих измерений нет, то возвращается false.
+func EstimateCell(observations []Observation) (estimate Estimate, ok bool) {
+	points := make([]estimatePoint, 0, len(observations))
+	for _, obs := range observations {
+		if obs.Accuracy > estimateMaxAccuracy {
+			continue
+		}
+		points = append(points, estimatePoint{
+			lat:    obs.Location.Latitude(),
+			lon:    obs.Location.Longitude(),
+			weight: observationWeight(obs),
+		})
+	}
+	if len(points) == 0 {
+		return estimate, false
+	}
+	lat, lon := weightedMean(points)
+	// сортируем измерения по удаленности от предварительного центра
+	for i := range points {
+		points[i].dist = distance(lat, lon, points[i].lat, points[i].lon)
+	}
+	sort.Sort(byDistance(points))
+	// отбрасываем выбросы и самые удаленные измерения
+	median := points[len(points)/2].dist
+	n := len(points)
+	for n > 1 && points[n-1].dist > estimateOutlierFactor*median && points[n-1].dist > estimateMinRange {
+		n--
+	}
+	if trim := int(float64(n) * estimateTrim); trim > 0 {
+		n -= trim
+	}
+	points = points[:n]
+	lat, lon = weightedMean(points)
+	var radius float64
+	for _, point := range points {
+		dist := distance(lat, lon, point.lat, point.lon)
+		if dist > radius {
+			radius = dist
+		}
+		estimate.Variance += dist * dist / float64(len(points))
+	}
+	estimate.Location = geo.NewPoint(lon, lat)
+	estimate.Accuracy = math.Max(radius, estimateMinRange)
+	estimate.Samples = len(points)
+	return estimate, true
+}
+
+// observationWeight возвращает вес измерения: сильный сигнал означает, что точка измерения
+// находится ближе к вышке, а точные координаты заслуживают большего доверия.
+func observationWeight(obs Observation) float64 {
+	weight := 1.0
+	if rssi, ok := SignalRSSI(obs.RadioType, obs.Signal); ok {
+		// RSSI обычно находится в диапазоне от -121 до -51 дБм
+		weight = math.Max(1, rssi+121)
+	}
+	if obs.Accuracy > 0 {
+		weight /= 1 + obs.Accuracy/100
+	}
+	return weight
+}
+
+// estimatePoint описывает координаты измерения, его вес и расстояние до предварительного центра.
+type estimatePoint struct {
+	lat, lon float64
+	weight   float64
+	dist     float64
+}
+
+// weightedMean возвращает взвешенное среднее координат.
+func weightedMean(points []estimatePoint) (lat, lon float64) {
+	var total float64
+	for _, point := range points {
+		lat += point.lat * point.weight
+		lon += point.lon * point.weight
+		total += point.weight
+	}
+	return lat / total, lon / total
+}
+
+// byDistance сортирует измерения по удаленности от центра.
+type byDistance []estimatePoint
+
+func (p byDistance) Len() int           { return len(p) }
+func (p byDistance) Less(i, j int) bool { return p[i].dist < p[j].dist }
+func (p byDistance) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
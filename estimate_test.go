@@ -0,0 +1,35 @@
+package lbs
+
+import (
+	"testing"
+
+	"github.com/geotrace/geo"
+)
+
+func TestEstimateCell(t *testing.T) {
+	if _, ok := EstimateCell(nil); ok {
+		t.Error("estimated cell without observations")
+	}
+	observations := []Observation{
+		{Location: geo.NewPoint(37.6170, 55.7550), Signal: -70},
+		{Location: geo.NewPoint(37.6180, 55.7560), Signal: -80},
+		{Location: geo.NewPoint(37.6160, 55.7555), Signal: -75},
+		{Location: geo.NewPoint(37.6175, 55.7545), Signal: -90},
+		{Location: geo.NewPoint(37.6165, 55.7558), Signal: -85},
+		{Location: geo.NewPoint(38.5000, 56.5000), Signal: -100}, // выброс
+	}
+	estimate, ok := EstimateCell(observations)
+	if !ok {
+		t.Fatal("cell not estimated")
+	}
+	if estimate.Samples != len(observations)-1 {
+		t.Errorf("bad samples count: %d", estimate.Samples)
+	}
+	if dist := distance(55.7553, 37.6170, estimate.Location.Latitude(),
+		estimate.Location.Longitude()); dist > 100 {
+		t.Errorf("estimated location too far: %.0f m", dist)
+	}
+	if estimate.Accuracy < estimateMinRange || estimate.Accuracy > 1000 {
+		t.Errorf("bad range: %.0f", estimate.Accuracy)
+	}
+}
@@ -0,0 +1,87 @@
+package lbs
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExportFilter описывает условия выбора записей о вышках для Export. Незаданные поля в выборе не
+// участвуют.
+type ExportFilter struct {
+	Filter             // тип радио, код страны, код оператора и другие поля ключа
+	Box    *[4]float64 // прямоугольник minlon, minlat, maxlon, maxlat
+}
+
+// query возвращает запрос к MongoDB, соответствующий фильтру.
+func (f ExportFilter) query() (bson.M, error) {
+	query := f.Filter.query()
+	if f.Box != nil {
+		box := *f.Box
+		if err := checkBox(box); err != nil {
+			return nil, err
+		}
+		for key, value := range withinBox(box) {
+			query[key] = value
+		}
+	}
+	query["blocked"] = notBlocked
+	return query, nil
+}
+
+// Export записывает записи о вышках, удовлетворяющие фильтру, в w в формате CSV OpenCellID (см.
+// CellHeader) и возвращает количество записанных вышек. Такой файл можно загрузить программой
+// lbs-import, поэтому Export позволяет поделиться исправленными данными или сохранить их в
+// переносимом виде. Если задан Partitioned, то записи выбираются из всех коллекций стран, а если
+// в фильтре указан код страны — только из ее коллекции. Заблокированные вышки пропускаются: в этом
+// формате их нельзя отметить, а после импорта они снова участвовали бы в вычислении координат.
+//
+// Если progress не nil, то он вызывается после каждых 100000 записанных вышек.
+func (db *DB) Export(ctx context.Context, w io.Writer, filter ExportFilter, progress func(count int)) (count int, err error) {
+	query, err := filter.query()
+	if err != nil {
+		return 0, err
+	}
+	mdb, err := db.database()
+	if err != nil {
+		return 0, err
+	}
+	names := db.collections()
+	if filter.MobileCountryCode != nil {
+		names = []string{db.collection(*filter.MobileCountryCode)}
+	}
+	out := csv.NewWriter(w)
+	if err := out.Write(CellHeader); err != nil {
+		return 0, err
+	}
+	for _, name := range names {
+		cursor, err := mdb.Collection(name).Find(ctx, query, options.Find().SetProjection(bson.M{"_id": 0}))
+		if err != nil {
+			return count, err
+		}
+		for cursor.Next(ctx) {
+			var cell Cell
+			if err := cursor.Decode(&cell); err != nil {
+				cursor.Close(context.Background())
+				return count, err
+			}
+			if err := out.Write(cell.MarshalCSV()); err != nil {
+				cursor.Close(context.Background())
+				return count, err
+			}
+			if count++; progress != nil && count%100000 == 0 {
+				progress(count)
+			}
+		}
+		err = cursor.Err()
+		cursor.Close(context.Background())
+		if err != nil {
+			return count, err
+		}
+	}
+	out.Flush()
+	return count, out.Error()
+}
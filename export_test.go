@@ -0,0 +1,35 @@
+package lbs
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestExportFilter(t *testing.T) {
+	mcc := uint16(250)
+	query, err := ExportFilter{
+		Filter: Filter{RadioType: "lte", MobileCountryCode: &mcc},
+		Box:    &[4]float64{37.3, 55.5, 37.9, 56.0},
+	}.query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query["radio"] != "lte" || query["mcc"] != mcc || query["location"] == nil {
+		t.Errorf("bad query: %v", query)
+	}
+	if blocked, ok := query["blocked"].(bson.M); !ok || blocked["$ne"] != true {
+		t.Errorf("blocked cells not skipped: %v", query)
+	}
+	for _, box := range [][4]float64{
+		{37.9, 55.5, 37.3, 56.0},
+		{37.3, 56.0, 37.9, 55.5},
+		{-181, 55.5, 37.9, 56.0},
+		{37.3, 55.5, 37.9, 91},
+	} {
+		box := box
+		if _, err := (ExportFilter{Box: &box}).query(); err == nil {
+			t.Errorf("bad box accepted: %v", box)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package lbs
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/geotrace/locator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// areaAccuracyFactor задает, во сколько раз увеличивается радиус зоны в ответе по ее центру (см.
+// GetOptions.AreaFallback): неизвестная вышка может оказаться новой вышкой на краю зоны.
+const areaAccuracyFactor = 1.5
+
+// maxAreaCells ограничивает количество вышек зоны, по которым вычисляется ее центр, если зоны
+// нет в коллекции зон, вычисленной командой lbs-admin rebuild (в хранилищах Storage ее нет вовсе).
+const maxAreaCells = 1000
+
+// CountryFallback включает последний вариант поиска для запросов, ни одна вышка и ни одна точка
+// доступа которых не найдены и для которых не сработали FuzzyAreaLookup и GetOptions.AreaFallback:
+// возвращается центр страны (см. Countries) по коду страны вышки с самым сильным сигналом, а в
+// Details устанавливается поле Country. Радиус точности такого ответа покрывает всю страну и не
+// ограничивается MaxAccuracy: иначе ответ выглядел бы точнее, чем он есть. Так же поступает
+// Mozilla Location Service, когда может определить только страну. GetOptions.NoFallback отключает
+// этот вариант для отдельного запроса.
+func CountryFallback() Option {
+	return func(db *DB) {
+		db.countryCenter = true
+	}
+}
+
+// countryLookup возвращает, можно ли вернуть центр страны с учетом параметров запроса (см.
+// CountryFallback).
+func (db *DB) countryLookup(opts *GetOptions) bool {
+	return db.countryCenter && (opts == nil || !opts.NoFallback)
+}
+
+// areaFallback возвращает, можно ли вернуть центр зоны для запроса, ни одна вышка которого не
+// найдена (см. GetOptions.AreaFallback).
+func (db *DB) areaFallback(opts *GetOptions) bool {
+	return opts != nil && opts.AreaFallback && !opts.NoFallback && !opts.NoAreaFallback
+}
+
+// locateArea возвращает центр зоны вышки из запроса, ни одна вышка которого не найдена (см.
+// GetOptions.AreaFallback). Зоны перебираются начиная с вышки с самым сильным сигналом. Если
+// ни одной зоны в хранилище нет, то возвращается исходная ошибка notFound.
+func (db *DB) locateArea(ctx context.Context, opts *GetOptions, keys []Key, towers []*locator.CellTower,
+	macs []string, notFound error) (*Details, error) {
+	tried := make(map[Key]bool, len(keys))
+	for _, i := range towerOrder(keys, towers) {
+		key := keys[i].Area()
+		if tried[key] {
+			continue
+		}
+		tried[key] = true
+		area, ok, err := db.findArea(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		accuracy := area.Accuracy * areaAccuracyFactor
+		if accuracyMax := db.maxAccuracy(opts); accuracyMax > 0 {
+			accuracy = math.Min(accuracy, accuracyMax)
+		}
+		details := &Details{
+			Response: &locator.Response{
+				Location: locator.Point{
+					Lat: area.Location.Latitude(),
+					Lng: area.Location.Longitude(),
+				},
+				Accuracy: math.Max(accuracy, db.accuracyMin),
+			},
+			Cells:      []Match{},
+			CellCounts: Counts{Requested: len(keys)},
+			WifiCounts: Counts{Requested: len(macs)},
+			Area:       &key,
+		}
+		var missing *NotFoundError
+		if errors.As(notFound, &missing) {
+			details.Missing, details.MissingWifi = missing.Missing, missing.MissingWifi
+		}
+		details.Confidence = db.confidence(details)
+		return details, nil
+	}
+	return nil, notFound
+}
+
+// locateCountry возвращает центр страны вышки из запроса, ни одна вышка которого не найдена (см.
+// CountryFallback). Если ни один код страны из запроса неизвестен, то возвращается исходная
+// ошибка notFound.
+func (db *DB) locateCountry(opts *GetOptions, keys []Key, towers []*locator.CellTower, macs []string,
+	notFound error) (*Details, error) {
+	for _, i := range towerOrder(keys, towers) {
+		country, ok := Countries[keys[i].MobileCountryCode]
+		if !ok {
+			continue
+		}
+		center := country.center()
+		details := &Details{
+			Response: &locator.Response{
+				Location: locator.Point{
+					Lat: center.Latitude(),
+					Lng: center.Longitude(),
+				},
+				Accuracy: math.Max(country.radius(), db.accuracyMin),
+			},
+			Cells:      []Match{},
+			CellCounts: Counts{Requested: len(keys)},
+			WifiCounts: Counts{Requested: len(macs)},
+			Country:    country.Code,
+		}
+		var missing *NotFoundError
+		if errors.As(notFound, &missing) {
+			details.Missing, details.MissingWifi = missing.Missing, missing.MissingWifi
+		}
+		details.Confidence = db.confidence(details)
+		return details, nil
+	}
+	return nil, notFound
+}
+
+// findArea возвращает зону с ключом key (CellId равен 0). Зоны из MongoDB берутся из коллекции
+// AreasCollectionName, а если зоны там нет (например, после импорта lbs-admin rebuild еще не
+// выполнялся) и для хранилищ Storage, центр зоны вычисляется по ее вышкам (см. AreaOf).
+func (db *DB) findArea(ctx context.Context, key Key) (Area, bool, error) {
+	if db.storage == nil {
+		area, ok, err := db.storedArea(ctx, key)
+		if err != nil || ok {
+			return area, ok, err
+		}
+	}
+	cells, err := db.areaNeighbors(ctx, key, maxAreaCells)
+	if err != nil {
+		return Area{}, false, err
+	}
+	area, ok := AreaOf(cells)
+	return area, ok, nil
+}
+
+// storedArea возвращает зону с ключом key из коллекции AreasCollectionName.
+func (db *DB) storedArea(ctx context.Context, key Key) (Area, bool, error) {
+	start := time.Now()
+	mdb, err := db.database()
+	if err != nil {
+		return Area{}, false, err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	var area Area
+	err = mdb.Collection(AreasCollectionName).FindOne(ctx, bson.M{
+		"radio": key.RadioType,
+		"mcc":   key.MobileCountryCode,
+		"mnc":   key.MobileNetworkCode,
+		"lac":   key.LocationAreaCode,
+	}).Decode(&area)
+	db.metrics.Mongo.Since(start)
+	if err == mongo.ErrNoDocuments {
+		return Area{}, false, nil
+	}
+	return area, err == nil, err
+}
+
+// towerOrder возвращает индексы вышек запроса в порядке убывания уровня сигнала: вышка с самым
+// сильным сигналом, скорее всего, ближе всех.
+func towerOrder(keys []Key, towers []*locator.CellTower) []int {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		return betterTower(keys[a].RadioType, towers[a], keys[b].RadioType, towers[b])
+	})
+	return order
+}
@@ -0,0 +1,107 @@
+package lbs
+
+import (
+	"context"
+	"time"
+
+	"github.com/geotrace/lbs/v2/storage"
+	"github.com/geotrace/locator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultFuzzyCells задает рекомендуемое количество вышек зоны для FuzzyAreaLookup: обычно столько
+// секторов у одной площадки.
+const DefaultFuzzyCells = 3
+
+// fuzzyAccuracyFactor задает, во сколько раз увеличивается радиус точности координат, вычисленных
+// по соседним вышкам зоны: вышка с новым идентификатором может стоять рядом с любой из них.
+const fuzzyAccuracyFactor = 2
+
+// FuzzyAreaLookup включает приблизительный поиск для запросов, ни одна вышка которых не найдена:
+// операторы время от времени перенумеровывают вышки, и после этого новые идентификаторы еще
+// отсутствуют в базе, хотя зона (LAC) осталась прежней. Для вышки из запроса с самым сильным
+// сигналом, зона которой есть в хранилище, берутся n вышек той же зоны с ближайшими
+// идентификаторами: при перенумерации вышки одной площадки обычно получают соседние номера.
+// Координаты вычисляются по этим вышкам, радиус точности увеличивается вдвое, а в Details
+// устанавливается флаг Fuzzy. Сами вышки из запроса по-прежнему перечисляются в Details.Missing.
+// Значение 0 (по умолчанию) отключает приблизительный поиск; рекомендуемое значение —
+// DefaultFuzzyCells.
+func FuzzyAreaLookup(n int) Option {
+	return func(db *DB) {
+		db.fuzzyCells = n
+	}
+}
+
+// locateFuzzy вычисляет координаты по соседним вышкам зоны (см. FuzzyAreaLookup) для запроса,
+// ни одна вышка которого не найдена, с параметрами запроса opts. Если подходящих вышек в
+// хранилище нет, то возвращается исходная ошибка notFound.
+func (db *DB) locateFuzzy(ctx context.Context, opts *GetOptions, keys []Key, towers []*locator.CellTower,
+	macs []string, points []AccessPoint, notFound error) (*Details, error) {
+	tried := make(map[Key]bool, len(keys))
+	for _, i := range towerOrder(keys, towers) {
+		area := keys[i].Area()
+		if tried[area] {
+			continue // у вышек одной зоны одни и те же соседи
+		}
+		tried[area] = true
+		cells, err := db.areaNeighbors(ctx, keys[i], db.fuzzyLookup(opts))
+		if err != nil {
+			return nil, err
+		}
+		if len(cells) == 0 {
+			continue
+		}
+		details, err := db.locateWith(opts, keys, towers, cells, macs, points)
+		if err != nil {
+			return nil, err
+		}
+		details.Fuzzy = true
+		details.Confidence = db.confidence(details)
+		details.Response.Accuracy *= fuzzyAccuracyFactor
+		if accuracyMax := db.maxAccuracy(opts); accuracyMax > 0 && details.Response.Accuracy > accuracyMax {
+			details.Response.Accuracy = accuracyMax
+		}
+		return details, nil
+	}
+	return nil, notFound
+}
+
+// areaNeighbors возвращает не более n незаблокированных вышек зоны ключа key с идентификаторами,
+// ближайшими к key.CellId.
+func (db *DB) areaNeighbors(ctx context.Context, key Key, n int) ([]Cell, error) {
+	if db.storage != nil {
+		return db.storage.Neighbors(ctx, key, n)
+	}
+	start := time.Now()
+	mdb, err := db.database()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	coll := mdb.Collection(db.collection(key.MobileCountryCode))
+	area := func(cell bson.M) bson.M {
+		return bson.M{
+			"radio":   key.RadioType,
+			"mcc":     key.MobileCountryCode,
+			"mnc":     key.MobileNetworkCode,
+			"lac":     key.LocationAreaCode,
+			"cell":    cell,
+			"blocked": notBlocked,
+		}
+	}
+	// ближайшие идентификаторы ищутся по индексу с обеих сторон от key.CellId
+	var above, below []Cell
+	err = findAll(ctx, coll, area(bson.M{"$gt": key.CellId}), &above,
+		options.Find().SetProjection(bson.M{"_id": 0}).SetSort(bson.M{"cell": 1}).SetLimit(int64(n)))
+	if err == nil {
+		err = findAll(ctx, coll, area(bson.M{"$lt": key.CellId}), &below,
+			options.Find().SetProjection(bson.M{"_id": 0}).SetSort(bson.M{"cell": -1}).SetLimit(int64(n)))
+	}
+	db.metrics.Mongo.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NearestCells(key.CellId, above, below, n), nil
+}
@@ -0,0 +1,97 @@
+package lbs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/lbs/v2/offline"
+	"github.com/geotrace/locator"
+)
+
+func TestAreaFallback(t *testing.T) {
+	table, err := offline.ReadCSV(strings.NewReader(storageCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := OpenStorage(table)
+	defer db.Close()
+	ctx := context.Background()
+	req := Request{Request: locator.Request{
+		RadioType:  "gsm",
+		CellTowers: []*locator.CellTower{{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22519, SignalStrength: -78}, {MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7744, CellId: 1, SignalStrength: -60}},
+	}}
+	if _, err := db.GetDetailed(ctx, req); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("area fallback used without options: %v", err)
+	}
+	req.Options = &GetOptions{AreaFallback: true, NoFallback: true}
+	if _, err := db.GetDetailed(ctx, req); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("area fallback not disabled: %v", err)
+	}
+	req.Options = &GetOptions{AreaFallback: true}
+	details, err := db.GetDetailed(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Key{RadioType: "gsm", MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743}
+	if details.Area == nil || *details.Area != want || len(details.Cells) != 0 || len(details.Missing) != 2 {
+		t.Fatalf("bad details: %+v", details)
+	}
+	if lat := details.Response.Location.Lat; lat < 55.7 || lat > 55.9 {
+		t.Errorf("bad area center: %+v", details.Response.Location)
+	}
+	if details.Response.Accuracy <= 1000 || details.Confidence != confidenceArea {
+		t.Errorf("bad accuracy or confidence: %+v", details)
+	}
+}
+
+func TestAreaFallbackMongo(t *testing.T) {
+	ctx := context.Background()
+	db, err := Dial(ctx, "mongodb://localhost/geotrace", SyncTimeout(time.Second), Collection("lbs_test_areas"))
+	if err != nil {
+		log.Println("Error connecting to MongoDB:", err)
+		return
+	}
+	defer db.Close()
+	mdb, err := db.database()
+	if err != nil {
+		t.Fatal(err)
+	}
+	coll := mdb.Collection("lbs_test_areas")
+	defer coll.Drop(ctx)
+	// зоны нет в коллекции AreasCollectionName: lbs-admin rebuild после импорта не выполнялся
+	area := Key{RadioType: "gsm", MobileCountryCode: 250, MobileNetworkCode: 99, LocationAreaCode: 4242}
+	docs := make([]interface{}, 3)
+	for i, lon := range []float64{37.60, 37.62, 37.64} {
+		cell := Cell{Key: area, Data: Data{Location: geo.NewPoint(lon, 55.75), Accuracy: 500}}
+		cell.CellId = uint64(100 + i)
+		docs[i] = cell
+	}
+	if _, err := coll.InsertMany(ctx, docs); err != nil {
+		t.Fatal(err)
+	}
+	req := Request{
+		Request: locator.Request{
+			RadioType:  "gsm",
+			CellTowers: []*locator.CellTower{{MobileCountryCode: 250, MobileNetworkCode: 99, LocationAreaCode: 4242, CellId: 1, SignalStrength: -78}},
+		},
+		Options: &GetOptions{AreaFallback: true},
+	}
+	details, err := db.GetDetailed(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Area == nil || *details.Area != area || details.Confidence != confidenceArea {
+		t.Fatalf("bad details: %+v", details)
+	}
+	if loc := details.Response.Location; loc.Lat < 55.74 || loc.Lat > 55.76 || loc.Lng < 37.61 || loc.Lng > 37.63 {
+		t.Errorf("bad area center: %+v", loc)
+	}
+	if details.Response.Accuracy <= 500*areaAccuracyFactor {
+		t.Errorf("bad accuracy: %g", details.Response.Accuracy)
+	}
+}
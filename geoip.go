@@ -0,0 +1,72 @@
+package lbs
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+
+	"github.com/geotrace/locator"
+)
+
+// GeoIP описывает источник приблизительных координат по IP-адресу клиента (см. IPFallback).
+// Реализация для баз MaxMind GeoIP2 и GeoLite2 City находится в пакете
+// github.com/geotrace/lbs/v2/geoip.
+type GeoIP interface {
+	// LookupIP возвращает координаты и радиус точности для адреса ip или false, если адрес
+	// неизвестен.
+	LookupIP(ctx context.Context, ip net.IP) (Data, bool, error)
+}
+
+// IPFallback включает приблизительный поиск по IP-адресу клиента (Request.IP) для запросов, по
+// вышкам и точкам доступа Wi-Fi которых координаты вычислить не удалось, в том числе для запросов
+// без вышек и точек доступа. Так поступают Mozilla Location Service и Google Geolocation API:
+// вместо ошибки возвращаются координаты города с соответствующим радиусом точности, а в Details
+// устанавливается флаг GeoIP. Такие результаты зависят от адреса клиента и не сохраняются в кеше
+// WithResultCache. GetOptions.NoFallback и GetOptions.NoIPFallback отключают этот вариант для
+// отдельного запроса.
+func IPFallback(geoip GeoIP) Option {
+	return func(db *DB) {
+		db.geoip = geoip
+	}
+}
+
+// ipLookup возвращает, можно ли вычислить координаты запроса по IP-адресу клиента с учетом
+// параметров запроса (см. IPFallback).
+func (db *DB) ipLookup(req Request) bool {
+	opts := req.Options
+	return db.geoip != nil && req.IP != nil && (opts == nil || !opts.NoFallback && !opts.NoIPFallback)
+}
+
+// locateIP возвращает координаты по IP-адресу клиента из запроса, вышки и точки доступа Wi-Fi
+// которого не найдены (см. IPFallback). Если адрес неизвестен, то возвращается исходная ошибка
+// notFound.
+func (db *DB) locateIP(ctx context.Context, req Request, keys []Key, macs []string,
+	notFound error) (*Details, error) {
+	data, ok, err := db.geoip.LookupIP(ctx, req.IP)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, notFound
+	}
+	details := &Details{
+		Response: &locator.Response{
+			Location: locator.Point{
+				Lat: data.Location.Latitude(),
+				Lng: data.Location.Longitude(),
+			},
+			Accuracy: math.Max(data.Accuracy, db.accuracyMin),
+		},
+		Cells:      []Match{},
+		CellCounts: Counts{Requested: len(keys)},
+		WifiCounts: Counts{Requested: len(macs)},
+		GeoIP:      true,
+	}
+	var missing *NotFoundError
+	if errors.As(notFound, &missing) {
+		details.Missing, details.MissingWifi = missing.Missing, missing.MissingWifi
+	}
+	details.Confidence = db.confidence(details)
+	return details, nil
+}
@@ -0,0 +1,77 @@
+// Package geoip реализует источник координат по IP-адресу lbs.GeoIP для баз MaxMind GeoIP2 City
+// и GeoLite2 City в формате MMDB. Для адреса возвращаются координаты города с радиусом точности
+// из базы, поэтому запросы без найденных вышек и точек доступа Wi-Fi получают ответ с точностью
+// до города (см. lbs.IPFallback).
+//
+//	reader, err := geoip.Open("GeoLite2-City.mmdb")
+//	if err != nil {
+//		return err
+//	}
+//	defer reader.Close()
+//	db, err := lbs.Dial(ctx, url, lbs.IPFallback(reader))
+package geoip
+
+import (
+	"context"
+	"net"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/lbs/v2"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// DefaultAccuracy задает радиус точности в метрах для адресов, для которых база не указывает
+// радиус: примерно столько занимает крупный город.
+var DefaultAccuracy = 25000.0
+
+// Reader описывает открытую базу MaxMind.
+type Reader struct {
+	db *maxminddb.Reader
+}
+
+var _ lbs.GeoIP = (*Reader)(nil)
+
+// record описывает поля записи базы GeoIP2 City, нужные для ответа.
+type record struct {
+	City struct {
+		GeoNameID uint `maxminddb:"geoname_id"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude       float64 `maxminddb:"latitude"`
+		Longitude      float64 `maxminddb:"longitude"`
+		AccuracyRadius uint16  `maxminddb:"accuracy_radius"` // км
+	} `maxminddb:"location"`
+}
+
+// Open открывает файл базы в формате MMDB.
+func Open(filename string) (*Reader, error) {
+	db, err := maxminddb.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{db: db}, nil
+}
+
+// LookupIP возвращает координаты города для адреса ip. Адреса, для которых в базе известна только
+// страна, считаются неизвестными: координаты центра страны бесполезны для ответа (для них есть
+// lbs.CountryFallback).
+func (r *Reader) LookupIP(ctx context.Context, ip net.IP) (lbs.Data, bool, error) {
+	var rec record
+	_, ok, err := r.db.LookupNetwork(ip, &rec)
+	if err != nil || !ok || rec.City.GeoNameID == 0 {
+		return lbs.Data{}, false, err
+	}
+	accuracy := float64(rec.Location.AccuracyRadius) * 1000
+	if accuracy == 0 {
+		accuracy = DefaultAccuracy
+	}
+	return lbs.Data{
+		Location: geo.NewPoint(rec.Location.Longitude, rec.Location.Latitude),
+		Accuracy: accuracy,
+	}, true, nil
+}
+
+// Close закрывает базу.
+func (r *Reader) Close() error {
+	return r.db.Close()
+}
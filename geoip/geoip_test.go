@@ -0,0 +1,196 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/lbs/v2"
+)
+
+// testNetwork описывает сеть IPv4 тестовой базы и ее запись.
+type testNetwork struct {
+	ip     string
+	bits   int
+	record map[string]interface{}
+}
+
+// mmdbEncoder кодирует значения в формате данных MaxMind DB. Поддерживаются только типы, нужные
+// тестовой базе, и размеры меньше 29.
+type mmdbEncoder struct {
+	bytes.Buffer
+}
+
+func (e *mmdbEncoder) control(kind, size int) {
+	if kind <= 7 {
+		e.WriteByte(byte(kind<<5 | size))
+	} else {
+		e.WriteByte(byte(size))
+		e.WriteByte(byte(kind - 7))
+	}
+}
+
+func (e *mmdbEncoder) encode(value interface{}) {
+	switch value := value.(type) {
+	case string:
+		e.control(2, len(value))
+		e.WriteString(value)
+	case float64:
+		e.control(3, 8)
+		binary.Write(e, binary.BigEndian, value)
+	case uint16:
+		e.control(5, 2)
+		binary.Write(e, binary.BigEndian, value)
+	case uint32:
+		e.control(6, 4)
+		binary.Write(e, binary.BigEndian, value)
+	case uint64:
+		e.control(9, 8)
+		binary.Write(e, binary.BigEndian, value)
+	case []interface{}:
+		e.control(11, len(value))
+		for _, item := range value {
+			e.encode(item)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		e.control(7, len(keys))
+		for _, key := range keys {
+			e.encode(key)
+			e.encode(value[key])
+		}
+	default:
+		panic("unsupported type")
+	}
+}
+
+// writeTestDB записывает базу GeoIP2-City для IPv4 с 24-битными записями дерева поиска. Сети не
+// должны пересекаться.
+func writeTestDB(t *testing.T, networks []testNetwork) string {
+	t.Helper()
+	var data mmdbEncoder
+	// записи узлов: 0 — нет данных, положительное значение — номер узла, отрицательное — смещение
+	// данных -(offset+1)
+	nodes := [][2]int{{}}
+	for _, network := range networks {
+		offset := data.Len()
+		data.encode(network.record)
+		ip := binary.BigEndian.Uint32(net.ParseIP(network.ip).To4())
+		node := 0
+		for bit := 0; bit < network.bits; bit++ {
+			branch := ip >> (31 - bit) & 1
+			if bit == network.bits-1 {
+				nodes[node][branch] = -(offset + 1)
+				break
+			}
+			if nodes[node][branch] == 0 {
+				nodes = append(nodes, [2]int{})
+				nodes[node][branch] = len(nodes) - 1
+			}
+			node = nodes[node][branch]
+		}
+	}
+	var file bytes.Buffer
+	count := len(nodes)
+	for _, node := range nodes {
+		for _, record := range node {
+			value := count
+			switch {
+			case record > 0:
+				value = record
+			case record < 0:
+				value = count + 16 + (-record - 1)
+			}
+			file.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	file.Write(make([]byte, 16))
+	file.Write(data.Bytes())
+	file.WriteString("\xab\xcd\xefMaxMind.com")
+	var metadata mmdbEncoder
+	metadata.encode(map[string]interface{}{
+		"node_count":                  uint32(count),
+		"record_size":                 uint16(24),
+		"ip_version":                  uint16(4),
+		"database_type":               "GeoIP2-City",
+		"languages":                   []interface{}{"en"},
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"description":                 map[string]interface{}{"en": "lbs test database"},
+	})
+	file.Write(metadata.Bytes())
+	name := filepath.Join(t.TempDir(), "GeoIP2-City-Test.mmdb")
+	if err := os.WriteFile(name, file.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLookupIP(t *testing.T) {
+	name := writeTestDB(t, []testNetwork{
+		{"81.2.69.142", 31, map[string]interface{}{
+			"city": map[string]interface{}{"geoname_id": uint32(2643743),
+				"names": map[string]interface{}{"en": "London"}},
+			"location": map[string]interface{}{"latitude": 51.5142, "longitude": -0.0931,
+				"accuracy_radius": uint16(10)},
+		}},
+		{"2.125.160.216", 29, map[string]interface{}{
+			"city":     map[string]interface{}{"geoname_id": uint32(2655045)},
+			"location": map[string]interface{}{"latitude": 50.8, "longitude": -0.9},
+		}},
+		// для сети известна только страна
+		{"5.255.0.0", 16, map[string]interface{}{
+			"country":  map[string]interface{}{"geoname_id": uint32(2017370), "iso_code": "RU"},
+			"location": map[string]interface{}{"latitude": 60.0, "longitude": 100.0, "accuracy_radius": uint16(1000)},
+		}},
+	})
+	reader, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if err := reader.db.Verify(); err != nil {
+		t.Fatalf("bad test database: %v", err)
+	}
+	ctx := context.Background()
+	for _, test := range []struct {
+		ip       string
+		lon, lat float64
+		accuracy float64
+	}{
+		{"81.2.69.142", -0.0931, 51.5142, 10000},
+		{"81.2.69.143", -0.0931, 51.5142, 10000},
+		{"2.125.160.220", -0.9, 50.8, DefaultAccuracy},
+	} {
+		data, ok, err := reader.LookupIP(ctx, net.ParseIP(test.ip))
+		if err != nil || !ok {
+			t.Errorf("%s: not found: %v", test.ip, err)
+			continue
+		}
+		if d := lbs.Distance(data.Location, geo.NewPoint(test.lon, test.lat)); d > 1 || data.Accuracy != test.accuracy {
+			t.Errorf("%s: bad location: %+v", test.ip, data)
+		}
+	}
+	for _, ip := range []string{"81.2.69.144", "10.0.0.1", "5.255.1.1"} {
+		if data, ok, err := reader.LookupIP(ctx, net.ParseIP(ip)); err != nil || ok {
+			t.Errorf("%s: unexpected result: %+v, %t, %v", ip, data, ok, err)
+		}
+	}
+}
+
+func TestOpenError(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Error("missing database opened")
+	}
+}
@@ -0,0 +1,49 @@
+package lbs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/locator"
+)
+
+// staticGeoIP возвращает одни и те же координаты для адресов из сети network.
+type staticGeoIP struct {
+	network *net.IPNet
+	data    Data
+}
+
+func (g staticGeoIP) LookupIP(ctx context.Context, ip net.IP) (Data, bool, error) {
+	return g.data, g.network.Contains(ip), nil
+}
+
+func TestIPFallback(t *testing.T) {
+	_, network, _ := net.ParseCIDR("192.0.2.0/24")
+	geoip := staticGeoIP{network, Data{Location: geo.NewPoint(37.62, 55.75), Accuracy: 25000}}
+	db := newDB("test", []Option{IPFallback(geoip)})
+	req := Request{Request: locator.Request{
+		CellTowers: []*locator.CellTower{{MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 7743, CellId: 22517, SignalStrength: -78}},
+	}, IP: net.ParseIP("192.0.2.10")}
+	keys, _, _ := db.requestCandidates(req)
+	details, err := db.locateIP(context.Background(), req, keys, nil, &NotFoundError{Missing: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !details.GeoIP || details.Response.Accuracy != 25000 || details.Confidence != confidenceIP ||
+		len(details.Missing) != 1 {
+		t.Errorf("bad details: %+v", details)
+	}
+	req.IP = net.ParseIP("198.51.100.1")
+	if _, err := db.locateIP(context.Background(), req, keys, nil, ErrNotFound); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown address located: %v", err)
+	}
+	if req.Options = (&GetOptions{NoFallback: true}); db.ipLookup(req) {
+		t.Error("IP fallback not disabled")
+	}
+	if db.ipLookup(Request{}) {
+		t.Error("IP fallback used without address")
+	}
+}
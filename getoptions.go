@@ -0,0 +1,141 @@
+package lbs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/geotrace/locator"
+)
+
+// Алгоритмы вычисления координат для GetOptions.Algorithm.
+const (
+	AlgorithmCentroid        = "centroid"        // среднее положение найденных вышек
+	AlgorithmWeighted        = "weighted"        // веса вышек по уровню сигнала (см. SignalWeighted)
+	AlgorithmMultilateration = "multilateration" // метод наименьших квадратов (см. Multilateration)
+)
+
+// ErrUnknownAlgorithm возвращается для запроса с неизвестным GetOptions.Algorithm.
+var ErrUnknownAlgorithm = errors.New("lbs: unknown algorithm")
+
+// GetOptions задает параметры вычисления координат для одного запроса вместо параметров,
+// заданных опциями DB при подключении. Так один DB можно использовать для клиентов с разными
+// требованиями: например, одному нужен любой ответ, а другому — только координаты не менее чем по
+// трем вышкам. Нулевое значение поля означает, что используется параметр DB. Опции уровня запроса
+// могут только отключить поиск Wi-Fi и дополнительные варианты поиска, но не включить их, если
+// они отключены для DB. Исключение — AreaFallback: центр зоны вместо координат нужен не всем
+// клиентам, поэтому он возвращается только по запросу.
+//
+// Параметры передаются в Request.Options для GetDetailed и GetBatch либо в GetWith и
+// GetCellsWith.
+type GetOptions struct {
+	RadioType   string  // тип радио вышек без указанного типа вместо DefaultRadioType и RadioFallback
+	Algorithm   string  // алгоритм вычисления координат (Algorithm*)
+	MinTowers   int     // минимальное количество найденных вышек, если не использованы точки доступа Wi-Fi
+	MaxAccuracy float64 // максимальный радиус точности ответа вместо MaxAccuracy
+	IgnoreWifi  bool    // не использовать точки доступа Wi-Fi (см. IgnoreWifi)
+	NoFallback  bool    // не использовать RadioFallback, FuzzyAreaLookup, AreaFallback, IPFallback и CountryFallback
+
+	NoAreaFallback bool // не использовать FuzzyAreaLookup и AreaFallback ("lacf": false в запросе MLS)
+	NoIPFallback   bool // не использовать IPFallback ("ipf": false в запросе MLS)
+
+	// AreaFallback разрешает вернуть для запроса, ни одна вышка которого не найдена, центр зоны
+	// (LAC) вышки с самым сильным сигналом, если зона известна. Радиус точности такого ответа
+	// превышает радиус зоны в полтора раза, а в Details устанавливается поле Area. Центр и радиус
+	// зоны берутся из коллекции AreasCollectionName, которую заполняет lbs-admin rebuild, а до
+	// ее заполнения вычисляются по вышкам зоны в хранилище (не более 1000 вышек).
+	AreaFallback bool
+}
+
+// check проверяет параметры запроса.
+func (o *GetOptions) check() error {
+	if o == nil {
+		return nil
+	}
+	switch o.Algorithm {
+	case "", AlgorithmCentroid, AlgorithmWeighted, AlgorithmMultilateration:
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownAlgorithm, o.Algorithm)
+	}
+	if o.MinTowers < 0 || o.MaxAccuracy < 0 {
+		return errors.New("lbs: bad options")
+	}
+	return nil
+}
+
+// cacheKey возвращает добавку к ключу кеша результатов: результаты для одного и того же набора
+// вышек, вычисленные с разными параметрами, различаются.
+func (o *GetOptions) cacheKey() string {
+	if o == nil || *o == (GetOptions{}) {
+		return ""
+	}
+	return fmt.Sprintf(":%s:%s:%d:%g:%t:%t:%t:%t:%t", o.RadioType, o.Algorithm, o.MinTowers, o.MaxAccuracy,
+		o.IgnoreWifi, o.NoFallback, o.AreaFallback, o.NoAreaFallback, o.NoIPFallback)
+}
+
+// GetWith вычисляет координаты так же, как Get, но с параметрами opts вместо параметров DB.
+func (db *DB) GetWith(ctx context.Context, req locator.Request, opts GetOptions) (*locator.Response, error) {
+	details, err := db.GetDetailed(ctx, Request{Request: req, Options: &opts})
+	if err != nil {
+		return nil, err
+	}
+	return details.Response, nil
+}
+
+// GetCellsWith возвращает информацию о найденных сотовых станциях так же, как GetCells, но ищет
+// вышки с типом радио и вариантами поиска из opts.
+func (db *DB) GetCellsWith(ctx context.Context, req locator.Request, opts GetOptions) ([]Data, error) {
+	found, err := db.findCells(ctx, Request{Request: req, Options: &opts})
+	if err != nil {
+		return nil, err
+	}
+	cells := make([]Data, len(found))
+	for i, cell := range found {
+		cells[i] = cell.Data
+	}
+	return cells, nil
+}
+
+// radioFallbacks возвращает типы радио для вышек без известного типа с учетом параметров запроса
+// (см. RadioFallback).
+func (db *DB) radioFallbacks(opts *GetOptions) []string {
+	if opts != nil && (opts.NoFallback || opts.RadioType != "") {
+		return nil
+	}
+	return db.radioFallback
+}
+
+// fuzzyLookup возвращает количество вышек зоны для приблизительного поиска с учетом параметров
+// запроса (см. FuzzyAreaLookup).
+func (db *DB) fuzzyLookup(opts *GetOptions) int {
+	if opts != nil && (opts.NoFallback || opts.NoAreaFallback) {
+		return 0
+	}
+	return db.fuzzyCells
+}
+
+// maxAccuracy возвращает максимальный радиус точности ответа с учетом параметров запроса.
+func (db *DB) maxAccuracy(opts *GetOptions) float64 {
+	if opts != nil && opts.MaxAccuracy > 0 {
+		return opts.MaxAccuracy
+	}
+	return db.accuracyMax
+}
+
+// algorithm возвращает, учитываются ли при вычислении координат уровни сигнала вышек и
+// вычисляются ли координаты методом наименьших квадратов, с учетом параметров запроса.
+// Метод наименьших квадратов, если он неприменим, дает средневзвешенное положение вышек.
+func (db *DB) algorithm(opts *GetOptions) (signalWeighted, leastSquares bool) {
+	if opts == nil {
+		return db.signalWeighted, db.leastSquares
+	}
+	switch opts.Algorithm {
+	case AlgorithmCentroid:
+		return false, false
+	case AlgorithmWeighted:
+		return true, false
+	case AlgorithmMultilateration:
+		return true, true
+	}
+	return db.signalWeighted, db.leastSquares
+}
@@ -0,0 +1,107 @@
+package lbs
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/locator"
+)
+
+func TestGetOptionsAlgorithm(t *testing.T) {
+	cell := func(id uint64, lon float64) Cell {
+		return Cell{Key: Key{RadioType: "gsm", MobileCountryCode: 250, LocationAreaCode: 1, CellId: id},
+			Data: Data{Location: geo.NewPoint(lon, 55.75), Accuracy: 3000}}
+	}
+	cells := []Cell{cell(1, 37.60), cell(2, 37.64)}
+	keys := []Key{cells[0].Key, cells[1].Key}
+	towers := []*locator.CellTower{{SignalStrength: -60}, {SignalStrength: -90}}
+	db := newDB("test", []Option{SignalWeighted()})
+
+	centroid, err := db.locateWith(&GetOptions{Algorithm: AlgorithmCentroid}, keys, towers,
+		append([]Cell(nil), cells...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if centroid.Cells[0].Weight != centroid.Cells[1].Weight {
+		t.Errorf("request algorithm ignored: %+v", centroid.Cells)
+	}
+	weighted, err := db.locateWith(nil, keys, towers, append([]Cell(nil), cells...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if weighted.Cells[0].Weight <= weighted.Cells[1].Weight {
+		t.Errorf("DB algorithm ignored: %+v", weighted.Cells)
+	}
+	limited, err := db.locateWith(&GetOptions{MaxAccuracy: 500}, keys, towers,
+		append([]Cell(nil), cells...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limited.Response.Accuracy != 500 {
+		t.Errorf("request accuracy limit ignored: %f", limited.Response.Accuracy)
+	}
+	_, err = db.locateWith(&GetOptions{MinTowers: 3}, keys, towers, append([]Cell(nil), cells...), nil, nil)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("result with too few towers: %v", err)
+	}
+}
+
+func TestGetOptionsCheck(t *testing.T) {
+	db := newDB("test", []Option{RadioFallback("lte", "gsm"), FuzzyAreaLookup(DefaultFuzzyCells)})
+	req := Request{Request: locator.Request{CellTowers: []*locator.CellTower{{MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 7743, CellId: 22517, SignalStrength: -78}}}}
+	req.Options = &GetOptions{Algorithm: "kalman"}
+	if err := req.Options.check(); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("unknown algorithm accepted: %v", err)
+	}
+	req.Options = &GetOptions{RadioType: "umts"}
+	keys, _, candidates := db.requestCandidates(req)
+	if len(candidates[0]) != 1 || keys[0].RadioType != "umts" {
+		t.Errorf("request radio type ignored: %v", candidates)
+	}
+	if opts := (&GetOptions{NoFallback: true}); db.fuzzyLookup(opts) != 0 || len(db.radioFallbacks(opts)) != 0 {
+		t.Error("fallback not disabled")
+	}
+	if (*GetOptions)(nil).cacheKey() != "" || (&GetOptions{}).cacheKey() != "" {
+		t.Error("cache key changed without options")
+	}
+	if (&GetOptions{IgnoreWifi: true}).cacheKey() == (&GetOptions{NoFallback: true}).cacheKey() {
+		t.Error("same cache key for different options")
+	}
+}
+
+func TestRequestFallbacks(t *testing.T) {
+	db := newDB("test", []Option{FuzzyAreaLookup(DefaultFuzzyCells)})
+	for _, test := range []struct {
+		body         string
+		noArea, noIP bool
+	}{
+		{`{"cellTowers": []}`, false, false},
+		{`{"fallbacks": {"lacf": true, "ipf": true}}`, false, false},
+		{`{"fallbacks": {"lacf": false}}`, true, false},
+		{`{"fallbacks": {"ipf": false}}`, false, true},
+		{`{"considerIp": false, "fallbacks": {"lacf": false}}`, true, true},
+	} {
+		var req Request
+		if err := json.Unmarshal([]byte(test.body), &req); err != nil {
+			t.Fatal(err)
+		}
+		var noArea, noIP bool
+		if req.Options != nil {
+			noArea, noIP = req.Options.NoAreaFallback, req.Options.NoIPFallback
+		}
+		if noArea != test.noArea || noIP != test.noIP {
+			t.Errorf("%s: bad options %+v", test.body, req.Options)
+		}
+		if fuzzy := db.fuzzyLookup(req.Options) > 0; fuzzy == test.noArea {
+			t.Errorf("%s: fuzzy lookup %t", test.body, fuzzy)
+		}
+	}
+	// параметры, заданные до разбора запроса, сохраняются
+	req := Request{Options: &GetOptions{MinTowers: 2}}
+	if err := json.Unmarshal([]byte(`{"fallbacks": {"ipf": false}}`), &req); err != nil ||
+		req.Options.MinTowers != 2 || !req.Options.NoIPFallback {
+		t.Errorf("options replaced: %+v, %v", req.Options, err)
+	}
+}
@@ -0,0 +1,133 @@
+package lbs
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// HealthCheck включает фоновую проверку доступности сервера MongoDB с указанным интервалом.
+// Соединения с сервером драйвер восстанавливает сам, но пока сервер недоступен, Healthy
+// возвращает false, а запросы сразу завершаются с ошибкой ErrUnavailable, не дожидаясь таймаута.
+func HealthCheck(interval time.Duration) Option {
+	return func(db *DB) {
+		db.healthInterval = interval
+	}
+}
+
+// Healthy возвращает false, если последняя проверка доступности сервера MongoDB завершилась
+// ошибкой. Если фоновая проверка не включена, то всегда возвращает true.
+func (db *DB) Healthy() bool {
+	return atomic.LoadInt32(&db.healthy) == 1
+}
+
+// start запускает фоновые процессы DB.
+func (db *DB) start() {
+	if db.healthInterval > 0 {
+		go db.healthLoop(db.healthInterval)
+	}
+}
+
+// healthLoop периодически проверяет доступность сервера MongoDB до закрытия DB.
+func (db *DB) healthLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.done:
+			return
+		case <-ticker.C:
+			db.checkHealth(interval)
+		}
+	}
+}
+
+// checkHealth проверяет доступность сервера MongoDB и устанавливает флаг доступности. Время
+// проверки ограничено интервалом между проверками.
+func (db *DB) checkHealth(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := db.ping(ctx); err == nil {
+		if atomic.SwapInt32(&db.healthy, 1) == 0 {
+			db.log().Info("MongoDB available")
+		}
+	} else if atomic.SwapInt32(&db.healthy, 0) == 1 {
+		db.log().Warn("MongoDB unavailable", "error", err)
+	}
+}
+
+// ping проверяет доступность сервера MongoDB.
+func (db *DB) ping(ctx context.Context) error {
+	if _, err := db.database(); err != nil {
+		return err
+	}
+	return db.client.Ping(ctx, nil)
+}
+
+// ServerInfo описывает сведения о сервере MongoDB, с которым работает DB.
+type ServerInfo struct {
+	Version    string `json:"version"`              // версия сервера
+	Role       string `json:"role"`                 // primary, secondary, mongos или standalone
+	ReplicaSet string `json:"replicaSet,omitempty"` // название набора реплик
+	Host       string `json:"host,omitempty"`       // адрес сервера, ответившего на запрос
+}
+
+// String возвращает строковое представление сведений о сервере для вывода в лог.
+func (info *ServerInfo) String() string {
+	str := "MongoDB " + info.Version + " " + info.Role
+	if info.ReplicaSet != "" {
+		str += " [" + info.ReplicaSet + "]"
+	}
+	if info.Host != "" {
+		str += " at " + info.Host
+	}
+	return str
+}
+
+// Ping проверяет доступность сервера MongoDB и возвращает его версию и роль в наборе реплик.
+// Полученные сведения удобно выводить в лог при запуске приложения: это позволяет сразу заметить
+// подключение к неправильному серверу.
+func (db *DB) Ping(ctx context.Context) (*ServerInfo, error) {
+	mdb, err := db.database()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	var build struct {
+		Version string `bson:"version"`
+	}
+	if err := mdb.RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&build); err != nil {
+		return nil, err
+	}
+	var status struct {
+		IsMaster  bool   `bson:"ismaster"`
+		Secondary bool   `bson:"secondary"`
+		SetName   string `bson:"setName"`
+		Msg       string `bson:"msg"`
+		Me        string `bson:"me"`
+	}
+	if err := mdb.RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&status); err != nil {
+		return nil, err
+	}
+	info := &ServerInfo{
+		Version:    build.Version,
+		ReplicaSet: status.SetName,
+		Host:       status.Me,
+	}
+	switch {
+	case status.Msg == "isdbgrid":
+		info.Role = "mongos"
+	case status.SetName == "":
+		info.Role = "standalone"
+	case status.IsMaster:
+		info.Role = "primary"
+	case status.Secondary:
+		info.Role = "secondary"
+	default:
+		info.Role = "other"
+	}
+	return info, nil
+}
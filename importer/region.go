@@ -0,0 +1,162 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Region описывает географический фильтр импорта: прямоугольник и (или) многоугольники. Запись
+// проходит фильтр, если ее координаты попадают во все заданные условия.
+type Region struct {
+	Box      *[4]float64      // прямоугольник minlon, minlat, maxlon, maxlat
+	Polygons [][][][2]float64 // многоугольники: внешнее кольцо и кольца отверстий
+}
+
+// Contains сообщает, попадает ли точка с указанными координатами в регион.
+func (r *Region) Contains(lon, lat float64) bool {
+	if r.Box != nil && (lon < r.Box[0] || lon > r.Box[2] || lat < r.Box[1] || lat > r.Box[3]) {
+		return false
+	}
+	if r.Polygons == nil {
+		return true
+	}
+	for _, polygon := range r.Polygons {
+		if inPolygon(polygon, lon, lat) {
+			return true
+		}
+	}
+	return false
+}
+
+// inPolygon сообщает, находится ли точка внутри внешнего кольца многоугольника и вне его
+// отверстий.
+func inPolygon(polygon [][][2]float64, lon, lat float64) bool {
+	if len(polygon) == 0 || !inRing(polygon[0], lon, lat) {
+		return false
+	}
+	for _, hole := range polygon[1:] {
+		if inRing(hole, lon, lat) {
+			return false
+		}
+	}
+	return true
+}
+
+// inRing сообщает, находится ли точка внутри кольца многоугольника (метод трассировки луча).
+// Точки на западной и южной границах считаются внутренними, а на восточной и северной — внешними,
+// поэтому точка на общей границе соседних многоугольников попадает ровно в один из них.
+func inRing(ring [][2]float64, lon, lat float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > lat) != (b[1] > lat) && lon < (b[0]-a[0])*(lat-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// ParseBox разбирает прямоугольник, заданный строкой minlon,minlat,maxlon,maxlat.
+func ParseBox(value string) (*[4]float64, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("4 comma separated numbers expected in %q", value)
+	}
+	var box [4]float64
+	for i, field := range fields {
+		var err error
+		if box[i], err = strconv.ParseFloat(strings.TrimSpace(field), 64); err != nil {
+			return nil, err
+		}
+	}
+	if box[0] >= box[2] || box[1] >= box[3] ||
+		box[0] < -180 || box[2] > 180 || box[1] < -90 || box[3] > 90 {
+		return nil, fmt.Errorf("bad bounding box %q", value)
+	}
+	return &box, nil
+}
+
+// geoJSON описывает объект GeoJSON: геометрию, объект Feature или FeatureCollection.
+type geoJSON struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSON        `json:"geometry"`
+	Features    []*geoJSON      `json:"features"`
+}
+
+// polygons возвращает все многоугольники объекта GeoJSON: внешнее кольцо каждого многоугольника
+// и кольца его отверстий. Пустые многоугольники пропускаются.
+func (g *geoJSON) polygons() ([][][][2]float64, error) {
+	switch g.Type {
+	case "Feature":
+		if g.Geometry == nil {
+			return nil, errors.New("feature without geometry")
+		}
+		return g.Geometry.polygons()
+	case "FeatureCollection":
+		var polygons [][][][2]float64
+		for _, feature := range g.Features {
+			found, err := feature.polygons()
+			if err != nil {
+				return nil, err
+			}
+			polygons = append(polygons, found...)
+		}
+		return polygons, nil
+	case "Polygon":
+		var polygon [][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &polygon); err != nil {
+			return nil, err
+		}
+		if len(polygon) == 0 {
+			return nil, nil
+		}
+		return [][][][2]float64{polygon}, nil
+	case "MultiPolygon":
+		var polygons [][][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &polygons); err != nil {
+			return nil, err
+		}
+		result := make([][][][2]float64, 0, len(polygons))
+		for _, polygon := range polygons {
+			if len(polygon) > 0 {
+				result = append(result, polygon)
+			}
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("unsupported GeoJSON type %q", g.Type)
+	}
+}
+
+// LoadPolygons загружает многоугольники (Polygon или MultiPolygon) с их отверстиями из файла
+// GeoJSON.
+func LoadPolygons(filename string) ([][][][2]float64, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var object geoJSON
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	polygons, err := object.polygons()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	for _, polygon := range polygons {
+		for _, ring := range polygon {
+			if len(ring) < 3 {
+				return nil, fmt.Errorf("%s: polygon ring with %d points", filename, len(ring))
+			}
+		}
+	}
+	if len(polygons) == 0 {
+		return nil, fmt.Errorf("%s: no polygons", filename)
+	}
+	return polygons, nil
+}
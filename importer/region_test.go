@@ -0,0 +1,146 @@
+package importer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// square возвращает замкнутое кольцо квадрата с юго-западным углом lon, lat и стороной size.
+func square(lon, lat, size float64) [][2]float64 {
+	return [][2]float64{{lon, lat}, {lon + size, lat}, {lon + size, lat + size}, {lon, lat + size}, {lon, lat}}
+}
+
+func TestInRing(t *testing.T) {
+	ring := square(0, 0, 10)
+	for _, test := range []struct {
+		name     string
+		lon, lat float64
+		inside   bool
+	}{
+		{"inside", 5, 5, true},
+		{"near corner", 0.001, 9.999, true},
+		{"outside east", 15, 5, false},
+		{"outside south", 5, -0.001, false},
+		{"west edge", 0, 5, true},
+		{"south edge", 5, 0, true},
+		{"east edge", 10, 5, false},
+		{"north edge", 5, 10, false},
+	} {
+		if inside := inRing(ring, test.lon, test.lat); inside != test.inside {
+			t.Errorf("%s: inRing(%g, %g) = %t", test.name, test.lon, test.lat, inside)
+		}
+	}
+	// вогнутый многоугольник: точка в вырезе буквы П находится снаружи
+	concave := [][2]float64{{0, 0}, {3, 0}, {3, 3}, {2, 3}, {2, 1}, {1, 1}, {1, 3}, {0, 3}, {0, 0}}
+	if inRing(concave, 1.5, 2) || !inRing(concave, 0.5, 2) {
+		t.Error("bad concave polygon test")
+	}
+}
+
+func TestRegionHoles(t *testing.T) {
+	area := &Region{Polygons: [][][][2]float64{
+		{square(0, 0, 10), square(4, 4, 2)}, // квадрат с отверстием в центре
+		{square(20, 0, 5)},
+	}}
+	for _, test := range []struct {
+		lon, lat float64
+		inside   bool
+	}{
+		{2, 2, true},
+		{5, 5, false}, // в отверстии
+		{4, 5, false}, // на западной границе отверстия
+		{6, 5, true},  // на восточной границе отверстия
+		{22, 2, true},
+		{15, 2, false},
+	} {
+		if inside := area.Contains(test.lon, test.lat); inside != test.inside {
+			t.Errorf("contains(%g, %g) = %t", test.lon, test.lat, inside)
+		}
+	}
+	box, err := ParseBox("0,0,3,3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	area.Box = box
+	if !area.Contains(2, 2) || area.Contains(22, 2) {
+		t.Error("bounding box not combined with polygons")
+	}
+	if !(&Region{}).Contains(100, 50) {
+		t.Error("empty region rejected point")
+	}
+}
+
+func TestParseBox(t *testing.T) {
+	box, err := ParseBox(" 37.3, 55.5 ,37.9,56")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *box != [4]float64{37.3, 55.5, 37.9, 56} {
+		t.Errorf("bad Box: %v", *box)
+	}
+	for _, value := range []string{
+		"",
+		"37.3,55.5,37.9",
+		"37.3,55.5,37.9,56,1",
+		"37.3,55.5,east,56",
+		"37.9,55.5,37.3,56", // minlon > maxlon
+		"37.3,56,37.9,56",   // пустой прямоугольник
+		"-181,0,10,10",
+		"0,0,181,10",
+		"0,-91,10,10",
+		"0,0,10,91",
+	} {
+		if _, err := ParseBox(value); err == nil {
+			t.Errorf("bad box accepted: %q", value)
+		}
+	}
+}
+
+func TestLoadPolygons(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	filename := write("region.geojson", `{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "geometry": {"type": "MultiPolygon", "coordinates": [
+			[[[0, 0], [10, 0], [10, 10], [0, 10], [0, 0]], [[4, 4], [6, 4], [6, 6], [4, 6], [4, 4]]],
+			[[[20, 0], [25, 0], [25, 5], [20, 5], [20, 0]]]
+		]}},
+		{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [
+			[[30, 0], [35, 0], [35, 5], [30, 0]]
+		]}}
+	]}`)
+	polygons, err := LoadPolygons(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(polygons) != 3 || len(polygons[0]) != 2 || len(polygons[1]) != 1 || len(polygons[2]) != 1 {
+		t.Fatalf("bad Polygons: %v", polygons)
+	}
+	area := &Region{Polygons: polygons}
+	if !area.Contains(2, 2) || area.Contains(5, 5) || !area.Contains(22, 2) || !area.Contains(34, 1) {
+		t.Error("bad MultiPolygon region")
+	}
+	for name, data := range map[string]string{
+		"json":     `{"type": "Polygon", "coordinates": [[[0, 0], [1, 0]`,
+		"point":    `{"type": "Point", "coordinates": [0, 0]}`,
+		"feature":  `{"type": "Feature"}`,
+		"empty":    `{"type": "FeatureCollection", "features": []}`,
+		"ring":     `{"type": "Polygon", "coordinates": [[[0, 0], [1, 0]]]}`,
+		"hole":     `{"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]], [[0, 0]]]}`,
+		"coords":   `{"type": "MultiPolygon", "coordinates": [[0, 0]]}`,
+		"no rings": `{"type": "Polygon", "coordinates": []}`,
+	} {
+		if _, err := LoadPolygons(write(name+".geojson", data)); err == nil {
+			t.Errorf("%s: bad GeoJSON accepted", name)
+		}
+	}
+	if _, err := LoadPolygons(filepath.Join(dir, "missing.geojson")); err == nil {
+		t.Error("missing file accepted")
+	}
+}
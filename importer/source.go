@@ -0,0 +1,265 @@
+// Package importer содержит общие для программ импорта средства: открытие данных из файла или по
+// адресу HTTP с распаковкой gzip и zip (Open), адреса выгрузок OpenCellID и Mozilla Location
+// Service (SourceURL), идентификаторы данных для проверки повторного импорта (SourceID) и
+// географический фильтр по прямоугольнику и многоугольникам GeoJSON (Region). Их использует
+// программа lbs-import, а записи о вышках разбираются методами storage.Cell.
+package importer
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Адреса выгрузок баз вышек, которые можно указать в параметре -source программы lbs-import.
+const (
+	// openCellIDURL — полная выгрузка OpenCellID или выгрузка по одной стране (file=250.csv.gz);
+	// для загрузки необходим ключ API.
+	openCellIDURL = "https://opencellid.org/ocid/downloads?token=%s&type=full&file=%s"
+	// mozillaURL — ежедневная полная выгрузка Mozilla Location Service.
+	mozillaURL = "https://d2koia3g127518.cloudfront.net/export/MLS-full-cell-export-%sT000000.csv.gz"
+)
+
+// SourceURL возвращает адрес выгрузки источника source ("opencellid" или "mozilla"). Для OpenCellID
+// нужен ключ API token; выгрузка по одной стране загружается, если фильтр по стране country
+// содержит ровно один код страны. Выгрузка Mozilla Location Service загружается за дату now.
+func SourceURL(source, token, country string, now time.Time) (string, error) {
+	switch source {
+	case "opencellid":
+		if token == "" {
+			return "", errors.New("OpenCellID download requires an API token")
+		}
+		file := "cell_towers.csv.gz"
+		if _, ok := CountryFromFilename(country); ok {
+			file = country + ".csv.gz"
+		}
+		return fmt.Sprintf(openCellIDURL, url.QueryEscape(token), file), nil
+	case "mozilla":
+		return fmt.Sprintf(mozillaURL, now.UTC().Format("2006-01-02")), nil
+	default:
+		return "", fmt.Errorf("unsupported source %q", source)
+	}
+}
+
+// IsURL возвращает true, если данные для импорта заданы адресом HTTP, а не именем файла.
+func IsURL(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// SourceName возвращает имя файла с данными: для адреса HTTP — имя файла из параметра file или
+// из пути, а для локального файла — само имя. По нему определяются фильтр по стране и режим
+// импорта.
+func SourceName(name string) string {
+	if !IsURL(name) {
+		return name
+	}
+	u, err := url.Parse(name)
+	if err != nil {
+		return name
+	}
+	if file := u.Query().Get("file"); file != "" {
+		return file
+	}
+	return path.Base(u.Path)
+}
+
+// RedactURL скрывает ключ API в адресе выгрузки, чтобы он не попал в журнал и метаданные.
+func RedactURL(name string) string {
+	if !IsURL(name) {
+		return name
+	}
+	u, err := url.Parse(name)
+	if err != nil {
+		return name
+	}
+	query := u.Query()
+	if query.Get("token") == "" {
+		return name
+	}
+	query.Set("token", "xxx")
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// SourceID возвращает идентификатор данных для проверки повторного импорта. Для локального файла
+// это контрольная сумма SHA-256, а для адреса HTTP — контрольная сумма адреса и заголовков ETag,
+// Last-Modified и Content-Length ответа на запрос HEAD: загружать весь файл заранее только ради
+// проверки слишком долго. Если сервер не сообщает ни ETag, ни Last-Modified, то в идентификатор
+// включается текущее время, и данные импортируются при каждом запуске.
+func SourceID(ctx context.Context, name string) (string, error) {
+	if !IsURL(name) {
+		return fileSHA256(name)
+	}
+	req, err := http.NewRequestWithContext(ctx, "HEAD", name, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", RedactURL(name), resp.Status)
+	}
+	etag, modified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag == "" && modified == "" {
+		modified = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n", RedactURL(name), etag, modified, resp.Header.Get("Content-Length"))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CountryFromFilename возвращает код страны (MCC) из имени файла, если файл является выгрузкой
+// данных по одной стране: OpenCellID называет такие файлы по коду страны, например, 250.csv.gz.
+func CountryFromFilename(filename string) (mcc string, ok bool) {
+	name := filepath.Base(filename)
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	if len(name) != 3 {
+		return "", false
+	}
+	if _, err := strconv.ParseUint(name, 10, 16); err != nil {
+		return "", false
+	}
+	return name, true
+}
+
+// fileSHA256 возвращает шестнадцатеричное представление контрольной суммы SHA-256 файла.
+func fileSHA256(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Первые байты сжатых данных: gzip и архива zip.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+// Source описывает поток данных для импорта из файла или по адресу HTTP. Данные, сжатые gzip, и
+// файлы CSV из архивов zip распаковываются на лету, а контрольная сумма исходных (сжатых) данных
+// вычисляется по мере чтения.
+type Source struct {
+	io.Reader
+	closer io.Closer
+	hash   hash.Hash
+}
+
+// Open открывает данные для импорта: локальный файл или файл, загружаемый по адресу HTTP.
+// Сжатые данные определяются по расширению имени файла (.gz, .zip) или по первым байтам данных.
+// Загрузка прерывается вместе с ctx.
+func Open(ctx context.Context, name string) (*Source, error) {
+	var body io.ReadCloser
+	if IsURL(name) {
+		req, err := http.NewRequestWithContext(ctx, "GET", name, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: %s", RedactURL(name), resp.Status)
+		}
+		body = resp.Body
+	} else {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		body = file
+	}
+	s := &Source{closer: body, hash: sha256.New()}
+	r := bufio.NewReaderSize(io.TeeReader(body, s.hash), 1<<16)
+	s.Reader = r
+	ext := strings.ToLower(path.Ext(SourceName(name)))
+	magic, _ := r.Peek(len(zipMagic))
+	switch {
+	case ext == ".zip" || bytes.HasPrefix(magic, zipMagic):
+		file, ok := body.(*os.File)
+		if !ok {
+			body.Close()
+			return nil, errors.New("zip archive can't be read while downloading: download it first or use gzip")
+		}
+		csv, err := openZip(file)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		s.Reader = csv
+	case ext == ".gz" || bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		s.Reader = gz
+	}
+	return s, nil
+}
+
+// openZip возвращает поток данных единственного файла CSV в архиве zip. Если в архиве один файл,
+// то он считается файлом CSV независимо от расширения.
+func openZip(file *os.File) (io.Reader, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	archive, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	var found []*zip.File
+	for _, entry := range archive.File {
+		if !entry.FileInfo().IsDir() && strings.EqualFold(path.Ext(entry.Name), ".csv") {
+			found = append(found, entry)
+		}
+	}
+	if len(found) == 0 && len(archive.File) == 1 {
+		found = archive.File
+	}
+	if len(found) != 1 {
+		return nil, fmt.Errorf("%s: zip archive must contain exactly one CSV file, found %d",
+			file.Name(), len(found))
+	}
+	return found[0].Open()
+}
+
+// SHA256 возвращает контрольную сумму прочитанных исходных данных.
+func (s *Source) SHA256() string {
+	return hex.EncodeToString(s.hash.Sum(nil))
+}
+
+// Close закрывает файл или соединение.
+func (s *Source) Close() error {
+	return s.closer.Close()
+}
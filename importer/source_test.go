@@ -0,0 +1,228 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cellsCSV — начало выгрузки вышек в формате OpenCellID.
+const cellsCSV = "radio,mcc,net,area,cell,unit,lon,lat,range,samples,changeable,created,updated,averageSignal\n" +
+	"GSM,250,2,7743,22517,0,37.6,55.7,1000,5,1,1459692002,1459692002,0\n"
+
+func gzipData(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(gz, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// zipData возвращает архив zip с файлами files (имя, содержимое).
+func zipData(t *testing.T, files ...string) []byte {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for i := 0; i+1 < len(files); i += 2 {
+		w, err := archive.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, files[i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readSource открывает данные и возвращает прочитанное содержимое и контрольную сумму.
+func readSource(name string) (string, string, error) {
+	s, err := Open(context.Background(), name)
+	if err != nil {
+		return "", "", err
+	}
+	defer s.Close()
+	data, err := io.ReadAll(s)
+	if err != nil {
+		return "", "", err
+	}
+	return string(data), s.SHA256(), nil
+}
+
+func TestOpenSource(t *testing.T) {
+	dir := t.TempDir()
+	// архив zip читается не потоком, поэтому контрольная сумма для него не проверяется
+	for _, test := range []struct {
+		name   string
+		data   []byte
+		stream bool
+	}{
+		{"cells.csv", []byte(cellsCSV), true},
+		{"cells.csv.gz", gzipData(t, cellsCSV), true},
+		{"cells.GZ", gzipData(t, cellsCSV), true},
+		{"cells.dat", gzipData(t, cellsCSV), true}, // сжатие определяется по первым байтам
+		{"cells.zip", zipData(t, "cells.csv", cellsCSV), false},
+		{"archive.bin", zipData(t, "readme.txt", "readme", "cells.CSV", cellsCSV), false},
+		{"single.zip", zipData(t, "cells.txt", cellsCSV), false}, // единственный файл архива
+	} {
+		name := filepath.Join(dir, test.name)
+		if err := os.WriteFile(name, test.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		data, sum, err := readSource(name)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if data != cellsCSV {
+			t.Errorf("%s: bad data %q", test.name, data)
+		}
+		if hash := sha256.Sum256(test.data); test.stream && sum != hex.EncodeToString(hash[:]) {
+			t.Errorf("%s: bad SHA-256 %s", test.name, sum)
+		}
+	}
+
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty.zip", zipData(t)},
+		{"many.zip", zipData(t, "a.csv", cellsCSV, "b.csv", cellsCSV)},
+		{"bad.gz", []byte(cellsCSV)},
+		{"bad.zip", []byte(cellsCSV)},
+	} {
+		name := filepath.Join(dir, test.name)
+		if err := os.WriteFile(name, test.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := readSource(name); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+	if _, _, err := readSource(filepath.Join(dir, "missing.csv")); err == nil {
+		t.Error("missing file: expected error")
+	}
+}
+
+func TestOpenSourceURL(t *testing.T) {
+	files := map[string][]byte{
+		"/cells.csv":    []byte(cellsCSV),
+		"/cells.csv.gz": gzipData(t, cellsCSV),
+		"/cells.zip":    zipData(t, "cells.csv", cellsCSV),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	for _, name := range []string{"/cells.csv", "/cells.csv.gz"} {
+		data, sum, err := readSource(server.URL + name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if hash := sha256.Sum256(files[name]); data != cellsCSV || sum != hex.EncodeToString(hash[:]) {
+			t.Errorf("%s: bad data %q or SHA-256 %s", name, data, sum)
+		}
+	}
+	// архив zip нельзя читать при загрузке
+	if _, _, err := readSource(server.URL + "/cells.zip"); err == nil {
+		t.Error("zip download: expected error")
+	}
+	if _, _, err := readSource(server.URL + "/missing.csv"); err == nil {
+		t.Error("not found: expected error")
+	}
+}
+
+func TestSourceName(t *testing.T) {
+	for _, test := range []struct {
+		name, result string
+	}{
+		{"/data/250.csv.gz", "/data/250.csv.gz"},
+		{"https://opencellid.org/ocid/downloads?token=abc&type=full&file=250.csv.gz", "250.csv.gz"},
+		{"https://d2koia3g127518.cloudfront.net/export/MLS-full-cell-export-2024-01-01T000000.csv.gz",
+			"MLS-full-cell-export-2024-01-01T000000.csv.gz"},
+		{"http://localhost:8080/cells.zip?v=1", "cells.zip"},
+	} {
+		if result := SourceName(test.name); result != test.result {
+			t.Errorf("SourceName(%q) = %q", test.name, result)
+		}
+	}
+	if name := RedactURL("https://opencellid.org/ocid/downloads?token=secret&file=250.csv.gz"); strings.Contains(name, "secret") {
+		t.Errorf("token not redacted: %s", name)
+	}
+	if name := RedactURL("/data/token=secret.csv"); name != "/data/token=secret.csv" {
+		t.Errorf("file name changed: %s", name)
+	}
+}
+
+func TestSourceURL(t *testing.T) {
+	now := time.Date(2024, 1, 2, 23, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		source, token, country string
+		file                   string
+	}{
+		{"opencellid", "abc", "", "file=cell_towers.csv.gz"},
+		{"opencellid", "abc", "250", "file=250.csv.gz"},
+		{"opencellid", "abc", "250,262", "file=cell_towers.csv.gz"},
+		{"mozilla", "", "250", "MLS-full-cell-export-2024-01-02T000000.csv.gz"},
+	} {
+		u, err := SourceURL(test.source, test.token, test.country, now)
+		if err != nil {
+			t.Errorf("%s: %v", test.source, err)
+		} else if !strings.HasSuffix(u, test.file) {
+			t.Errorf("SourceURL(%q, %q) = %s", test.source, test.country, u)
+		}
+	}
+	if _, err := SourceURL("opencellid", "", "", now); err == nil {
+		t.Error("OpenCellID without token: expected error")
+	}
+	if _, err := SourceURL("unknown", "abc", "", now); err == nil {
+		t.Error("unknown source: expected error")
+	}
+}
+
+func TestCountryFromFilename(t *testing.T) {
+	for _, test := range []struct {
+		filename string
+		mcc      string
+		ok       bool
+	}{
+		{"250.csv.gz", "250", true},
+		{"/data/opencellid/262.csv", "262", true},
+		{"310.csv.gz.part", "310", true},
+		{"MLS-full-cell-export-2024-01-01T000000.csv.gz", "", false},
+		{"cell_towers.csv.gz", "", false},
+		{"25.csv", "", false},
+		{"2500.csv", "", false},
+		{"abc.csv", "", false},
+		{"", "", false},
+	} {
+		mcc, ok := CountryFromFilename(test.filename)
+		if mcc != test.mcc || ok != test.ok {
+			t.Errorf("CountryFromFilename(%q) = %q, %t", test.filename, mcc, ok)
+		}
+	}
+}
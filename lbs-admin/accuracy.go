@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// accuracy вычисляет радиусы действия вышек по умолчанию для каждого типа радио и страны (см.
+// lbs.DB.LearnAccuracy) и выводит их.
+func accuracy(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := commandFlags("accuracy")
+	partitioned := fs.Bool("partitioned", false, "data for each country is stored in a separate collection")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	ldb, err := blockDB(ctx, db, *partitioned)
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+	defaults, err := ldb.LearnAccuracy(ctx)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(defaults.Ranges))
+	for key := range defaults.Ranges {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("%s\t%.0f\n", key, defaults.Ranges[key])
+	}
+	return nil
+}
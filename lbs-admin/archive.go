@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/geotrace/lbs/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxDocumentSize задает максимальный размер документа BSON, поддерживаемый MongoDB.
+const maxDocumentSize = 16 << 20
+
+// archive сохраняет все документы коллекции в файл в виде сжатой последовательности BSON.
+func archive(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := commandFlags("archive")
+	name := fs.String("collection", lbs.CollectionName, "collection name")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	filename := fs.Arg(0)
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	buf := bufio.NewWriter(file)
+	log.Printf("Archiving %q to %q...", *name, filename)
+	count, err := writeArchive(ctx, db.Collection(*name), bson.M{}, buf)
+	if err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	log.Printf("Archived %d records", count)
+	return file.Close()
+}
+
+// writeArchive записывает документы коллекции, удовлетворяющие условию filter, в виде сжатой
+// gzip последовательности BSON и возвращает количество записанных документов.
+func writeArchive(ctx context.Context, coll *mongo.Collection, filter interface{}, out io.Writer) (count int, err error) {
+	w := gzip.NewWriter(out)
+	cursor, err := coll.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(context.Background())
+	for cursor.Next(ctx) {
+		if _, err := w.Write(cursor.Current); err != nil {
+			return count, err
+		}
+		count++
+		if count%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "\r* archived %8d records ", count)
+		}
+	}
+	if count >= 100000 {
+		fmt.Fprintln(os.Stderr, "")
+	}
+	if err := cursor.Err(); err != nil {
+		return count, err
+	}
+	return count, w.Close()
+}
+
+// restore восстанавливает коллекцию из файла, созданного командой archive. Данные загружаются во
+// временную коллекцию, которая после успешной загрузки заменяет целевую коллекцию.
+func restore(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := commandFlags("restore")
+	name := fs.String("collection", lbs.CollectionName, "collection name")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	filename := fs.Arg(0)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return err
+	}
+
+	tmp := db.Collection(*name + "_restore")
+	if err := tmp.Drop(ctx); err != nil {
+		return err
+	}
+	log.Printf("Restoring %q from %q...", *name, filename)
+	var count int
+	docs := make([]interface{}, 0, 1000)
+	for {
+		data, err := readDocument(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		docs = append(docs, bson.Raw(data))
+		count++
+		if count%1000 == 0 {
+			if err := insertAll(ctx, tmp, docs); err != nil {
+				return err
+			}
+			docs = docs[:0]
+			fmt.Fprintf(os.Stderr, "\r* restored %8d records ", count)
+		}
+	}
+	if err := insertAll(ctx, tmp, docs); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "")
+	if _, err := tmp.Indexes().CreateOne(ctx, cellsIndex); err != nil {
+		return err
+	}
+	if err := renameCollection(ctx, db, tmp.Name(), *name); err != nil {
+		return err
+	}
+	log.Printf("Restored %d records", count)
+	return nil
+}
+
+// insertAll добавляет документы в коллекцию одним запросом без сохранения порядка вставки.
+func insertAll(ctx context.Context, coll *mongo.Collection, docs []interface{}) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	_, err := coll.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	return err
+}
+
+// readDocument читает из потока очередной документ BSON. Документ начинается с его размера в
+// виде 32-битного целого числа.
+func readDocument(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err // io.EOF, если поток закончился ровно на границе документа
+	}
+	length := binary.LittleEndian.Uint32(size[:])
+	if length < 5 || length > maxDocumentSize {
+		return nil, errors.New("bad BSON document size")
+	}
+	data := make([]byte, length)
+	copy(data, size[:])
+	if _, err := io.ReadFull(r, data[4:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// cellsIndex описывает уникальный индекс коллекции с данными о сотовых вышках.
+var cellsIndex = mongo.IndexModel{
+	Keys: bson.D{
+		{Key: "radio", Value: 1},
+		{Key: "mcc", Value: 1},
+		{Key: "mnc", Value: 1},
+		{Key: "lac", Value: 1},
+		{Key: "cell", Value: 1},
+	},
+	Options: options.Index().SetUnique(true),
+}
+
+// renameCollection атомарно заменяет коллекцию to коллекцией from.
+func renameCollection(ctx context.Context, db *mongo.Database, from, to string) error {
+	return db.Client().Database("admin").RunCommand(ctx, bson.D{
+		{Key: "renameCollection", Value: db.Name() + "." + from},
+		{Key: "to", Value: db.Name() + "." + to},
+		{Key: "dropTarget", Value: true},
+	}).Err()
+}
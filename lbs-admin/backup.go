@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/geotrace/lbs/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// backup сохраняет снимки коллекции в хранилище снимков: однократно или периодически, удаляя
+// устаревшие снимки. Периодическое сохранение продолжается до прерывания программы.
+func backup(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := commandFlags("backup")
+	name := fs.String("collection", lbs.CollectionName, "collection name")
+	interval := fs.Duration("interval", 0, "backup interval (0 - backup once and exit)")
+	keep := fs.Int("keep", 7, "number of snapshots to keep (0 - keep all)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	store, err := openStore(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	for {
+		err := backupOnce(ctx, db, store, *name, *keep)
+		if *interval <= 0 {
+			return err
+		}
+		if err != nil {
+			log.Printf("Backup error: %v", err) // при периодическом сохранении не прерываемся
+		}
+		if err := sleep(ctx, *interval); err != nil {
+			return err
+		}
+	}
+}
+
+// backupOnce сохраняет снимок коллекции в хранилище и удаляет самые старые снимки, оставляя
+// только keep последних.
+func backupOnce(ctx context.Context, db *mongo.Database, store snapshotStore, name string, keep int) error {
+	prefix := name + "-"
+	snapshot := prefix + time.Now().UTC().Format("20060102T150405Z") + ".bson.gz"
+	log.Printf("Saving snapshot %q...", snapshot)
+	r, w := io.Pipe()
+	done := make(chan int, 1)
+	go func() {
+		count, err := writeArchive(ctx, db.Collection(name), bson.M{}, w)
+		w.CloseWithError(err)
+		done <- count
+	}()
+	err := store.put(ctx, snapshot, r)
+	r.CloseWithError(err) // останавливаем чтение данных, если сохранение прервалось
+	count := <-done
+	if err != nil {
+		return err
+	}
+	log.Printf("Saved %d records", count)
+	if keep <= 0 {
+		return nil
+	}
+	names, err := store.list(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for len(names) > keep {
+		log.Printf("Deleting old snapshot %q...", names[0])
+		if err := store.remove(ctx, names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// sleep ожидает указанное время или отмены контекста и в этом случае возвращает его ошибку.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/geotrace/lbs/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// parseKey разбирает ключ вышки, заданный аргументами: тип радио, MCC, MNC, LAC и CID.
+func parseKey(args []string) (key lbs.Key, err error) {
+	if len(args) != 5 {
+		return key, fmt.Errorf("bad cell key: %q", strings.Join(args, " "))
+	}
+	key.RadioType = lbs.NormalizeRadio(args[0])
+	var n [4]uint64
+	for i, bits := range []int{16, 16, lbs.AreaCodeBits, lbs.CellIdBits} {
+		if n[i], err = strconv.ParseUint(args[i+1], 10, bits); err != nil {
+			return key, fmt.Errorf("bad cell key: %q", strings.Join(args, " "))
+		}
+	}
+	key.MobileCountryCode = uint16(n[0])
+	key.MobileNetworkCode = uint16(n[1])
+	key.LocationAreaCode = uint32(n[2])
+	key.CellId = n[3]
+	return key, nil
+}
+
+// blockDB возвращает объект для работы с хранилищем LBS с учетом режима хранения данных.
+func blockDB(ctx context.Context, db *mongo.Database, partitioned bool) (*lbs.DB, error) {
+	var options []lbs.Option
+	if partitioned {
+		options = append(options, lbs.Partitioned())
+	}
+	return lbs.InitDB(ctx, db.Client(), db.Name(), options...)
+}
+
+// block добавляет вышку в список заблокированных.
+func block(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := commandFlags("block")
+	reason := fs.String("reason", "", "block reason")
+	partitioned := fs.Bool("partitioned", false, "data for each country is stored in a separate collection")
+	fs.Parse(args)
+	key, err := parseKey(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fs.Usage()
+		os.Exit(2)
+	}
+	ldb, err := blockDB(ctx, db, *partitioned)
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+	if err := ldb.Block(ctx, key, *reason); err != nil {
+		return err
+	}
+	log.Printf("Cell %s %d %d %d %d blocked", key.RadioType, key.MobileCountryCode,
+		key.MobileNetworkCode, key.LocationAreaCode, key.CellId)
+	return nil
+}
+
+// unblock удаляет вышку из списка заблокированных.
+func unblock(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := commandFlags("unblock")
+	partitioned := fs.Bool("partitioned", false, "data for each country is stored in a separate collection")
+	fs.Parse(args)
+	key, err := parseKey(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fs.Usage()
+		os.Exit(2)
+	}
+	ldb, err := blockDB(ctx, db, *partitioned)
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+	if err := ldb.Unblock(ctx, key); err != nil {
+		return err
+	}
+	log.Printf("Cell %s %d %d %d %d unblocked", key.RadioType, key.MobileCountryCode,
+		key.MobileNetworkCode, key.LocationAreaCode, key.CellId)
+	return nil
+}
+
+// blocklist выводит список заблокированных вышек.
+func blocklist(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := commandFlags("blocklist")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	ldb, err := blockDB(ctx, db, false)
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+	cells, err := ldb.Blocklist(ctx)
+	if err != nil {
+		return err
+	}
+	for _, cell := range cells {
+		fmt.Printf("%s\t%d\t%d\t%d\t%d\t%s\t%s\n", cell.Key.RadioType, cell.Key.MobileCountryCode,
+			cell.Key.MobileNetworkCode, cell.Key.LocationAreaCode, cell.Key.CellId,
+			cell.Added.Format(time.RFC3339), cell.Reason)
+	}
+	return nil
+}
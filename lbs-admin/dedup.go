@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/geotrace/lbs/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// dedup объединяет записи о вышках одной площадки (см. lbs.GroupSites) и сохраняет изменения в
+// коллекции с данными о вышках.
+func dedup(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := commandFlags("dedup")
+	name := fs.String("collection", lbs.CollectionName, "collection name")
+	radius := fs.Float64("radius", lbs.DefaultSiteRadius, "max distance between cells of one site in meters")
+	fs.Parse(args)
+	if fs.NArg() != 0 || *radius < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	coll := db.Collection(*name)
+
+	log.Printf("Grouping cells of %q within %.0f m...", *name, *radius)
+	var (
+		area                   []lbs.Cell
+		total, linked, changed int
+	)
+	models := make([]mongo.WriteModel, 0, 1000)
+	// сохраняет накопленные изменения одним запросом
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		_, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		models = models[:0]
+		return err
+	}
+	// объединяет вышки одной зоны, накопленные в area
+	save := func() error {
+		before := make([]uint64, len(area))
+		for i, cell := range area {
+			before[i] = cell.Site
+		}
+		lbs.GroupSites(area, *radius)
+		for i, cell := range area {
+			if cell.Site != 0 {
+				linked++
+			}
+			if cell.Site == before[i] {
+				continue
+			}
+			update := bson.M{"$unset": bson.M{"site": ""}}
+			if cell.Site != 0 {
+				update = bson.M{"$set": bson.M{"site": cell.Site}}
+			}
+			models = append(models, mongo.NewUpdateOneModel().SetFilter(cell.Key).SetUpdate(update))
+			changed++
+		}
+		if len(models) >= 1000 {
+			if err := flush(); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "\r* checked %10d cells, updated %8d ", total, changed)
+		}
+		return nil
+	}
+	// вышки одной зоны идут подряд, т.к. отсортированы по ключу
+	cursor, err := coll.Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"_id": 0}).SetSort(bson.D{
+			{Key: "radio", Value: 1},
+			{Key: "mcc", Value: 1},
+			{Key: "mnc", Value: 1},
+			{Key: "lac", Value: 1},
+		}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+	for cursor.Next(ctx) {
+		var cell lbs.Cell
+		if err := cursor.Decode(&cell); err != nil {
+			return err
+		}
+		total++
+		if len(area) > 0 && !sameArea(area[0].Key, cell.Key) {
+			if err := save(); err != nil {
+				return err
+			}
+			area = area[:0]
+		}
+		area = append(area, cell)
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if err := save(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "")
+	log.Printf("Checked %d cells: %d linked to another cell of the same site, %d updated",
+		total, linked, changed)
+	return nil
+}
+
+// sameArea возвращает true, если вышки находятся в одной зоне: совпадают тип радио, код страны,
+// код оператора и LAC.
+func sameArea(a, b lbs.Key) bool {
+	a.CellId, b.CellId = 0, 0
+	return a == b
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/geotrace/lbs/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// estimate вычисляет местоположение и радиус действия вышек по исходным измерениям и сохраняет
+// результаты в коллекцию с данными о вышках.
+func estimate(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := commandFlags("estimate")
+	name := fs.String("collection", lbs.CollectionName, "collection name")
+	minSamples := fs.Int("minsample", 3, "min measurements count for cell estimation")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	coll := db.Collection(*name)
+	if _, err := coll.Indexes().CreateOne(ctx, cellsIndex); err != nil {
+		return err
+	}
+
+	log.Printf("Estimating cells from %q to %q...", lbs.ObservationsCollectionName, *name)
+	var (
+		observations []lbs.Observation
+		cells, total int
+	)
+	models := make([]mongo.WriteModel, 0, 1000)
+	// сохраняет накопленные изменения одним запросом
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		_, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		models = models[:0]
+		return err
+	}
+	// сохраняет вычисленные данные по вышке, измерения которой накоплены в observations
+	save := func() error {
+		if len(observations) < *minSamples {
+			return nil
+		}
+		estimate, ok := lbs.EstimateCell(observations)
+		if !ok || estimate.Samples < *minSamples {
+			return nil
+		}
+		cell := lbs.Cell{Key: observations[0].Key, Data: estimate.Data, Samples: estimate.Samples,
+			Variance: estimate.Variance}
+		for _, obs := range observations {
+			if obs.Measured.After(cell.Updated) {
+				cell.Updated = obs.Measured
+			}
+		}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(cell.Key).
+			SetUpdate(bson.M{"$set": cell}).SetUpsert(true))
+		if cells++; cells%1000 == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "\r* estimated %8d cells from %10d measurements ", cells, total)
+		}
+		return nil
+	}
+	// измерения одной вышки идут подряд, т.к. отсортированы по ключу
+	cursor, err := db.Collection(lbs.ObservationsCollectionName).Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{
+			{Key: "radio", Value: 1},
+			{Key: "mcc", Value: 1},
+			{Key: "mnc", Value: 1},
+			{Key: "lac", Value: 1},
+			{Key: "cell", Value: 1},
+		}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+	for cursor.Next(ctx) {
+		var obs lbs.Observation
+		if err := cursor.Decode(&obs); err != nil {
+			return err
+		}
+		total++
+		if len(observations) > 0 && observations[0].Key != obs.Key {
+			if err := save(); err != nil {
+				return err
+			}
+			observations = observations[:0]
+		}
+		observations = append(observations, obs)
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if err := save(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "")
+	log.Printf("Estimated %d cells from %d measurements", cells, total)
+	return nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/geotrace/lbs/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// extract сохраняет записи о вышках внутри прямоугольника или многоугольника в отдельный файл:
+// сжатую последовательность BSON, которую можно загрузить командой restore, или CSV в формате
+// OpenCellID для lbs-import.
+func extract(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := commandFlags("extract")
+	name := fs.String("collection", lbs.CollectionName, "collection name")
+	bbox := fs.String("bbox", "", "bounding box: `minlon,minlat,maxlon,maxlat`")
+	polygon := fs.String("polygon", "", "GeoJSON `file` with Polygon or MultiPolygon boundary")
+	format := fs.String("format", "", "output format: bson (compressed BSON) or csv (default - by file extension)")
+	fs.Parse(args)
+	if fs.NArg() != 1 || (*bbox == "") == (*polygon == "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	filename := fs.Arg(0)
+	if *format == "" {
+		*format = "bson"
+		if strings.HasSuffix(filename, ".csv") {
+			*format = "csv"
+		}
+	}
+	if *format != "bson" && *format != "csv" {
+		return fmt.Errorf("unsupported format %q", *format)
+	}
+
+	var (
+		filter bson.M
+		err    error
+	)
+	if *bbox != "" {
+		filter, err = boxFilter(*bbox)
+	} else {
+		filter, err = polygonFilter(*polygon)
+	}
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	buf := bufio.NewWriter(file)
+	log.Printf("Extracting %q to %q...", *name, filename)
+	var count int
+	if *format == "csv" {
+		count, err = writeCSV(ctx, db.Collection(*name), filter, buf)
+	} else {
+		count, err = writeArchive(ctx, db.Collection(*name), filter, buf)
+	}
+	if err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	log.Printf("Extracted %d records", count)
+	return file.Close()
+}
+
+// boxFilter возвращает условие поиска вышек внутри прямоугольника, заданного строкой
+// minlon,minlat,maxlon,maxlat.
+func boxFilter(value string) (bson.M, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("bad bounding box %q", value)
+	}
+	var box [4]float64
+	for i, field := range fields {
+		var err error
+		if box[i], err = strconv.ParseFloat(strings.TrimSpace(field), 64); err != nil {
+			return nil, fmt.Errorf("bad bounding box %q", value)
+		}
+	}
+	if box[0] >= box[2] || box[1] >= box[3] ||
+		box[0] < -180 || box[2] > 180 || box[1] < -90 || box[3] > 90 {
+		return nil, fmt.Errorf("bad bounding box %q", value)
+	}
+	var filters []bson.M
+	for _, ring := range lbs.BoxRings(box) {
+		filters = append(filters, withinPolygon(ring))
+	}
+	if len(filters) == 1 {
+		return filters[0], nil
+	}
+	return bson.M{"$or": filters}, nil
+}
+
+// withinPolygon возвращает условие поиска вышек внутри многоугольника с внешним кольцом ring.
+// Кольцо GeoJSON должно быть замкнуто, поэтому незамкнутое кольцо дополняется первой точкой.
+func withinPolygon(ring [][2]float64) bson.M {
+	if ring[0] != ring[len(ring)-1] {
+		ring = append(ring[:len(ring):len(ring)], ring[0])
+	}
+	return bson.M{"location": bson.M{"$geoWithin": bson.M{
+		"$geometry": bson.M{"type": "Polygon", "coordinates": [][][2]float64{ring}},
+	}}}
+}
+
+// geoJSON описывает объект GeoJSON: геометрию, объект Feature или FeatureCollection.
+type geoJSON struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSON        `json:"geometry"`
+	Features    []*geoJSON      `json:"features"`
+}
+
+// rings возвращает внешние кольца всех многоугольников объекта GeoJSON. Отверстия в
+// многоугольниках не учитываются.
+func (g *geoJSON) rings() ([][][2]float64, error) {
+	switch g.Type {
+	case "Feature":
+		if g.Geometry == nil {
+			return nil, errors.New("feature without geometry")
+		}
+		return g.Geometry.rings()
+	case "FeatureCollection":
+		var rings [][][2]float64
+		for _, feature := range g.Features {
+			found, err := feature.rings()
+			if err != nil {
+				return nil, err
+			}
+			rings = append(rings, found...)
+		}
+		return rings, nil
+	case "Polygon":
+		var polygon [][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &polygon); err != nil {
+			return nil, err
+		}
+		if len(polygon) == 0 {
+			return nil, nil
+		}
+		return polygon[:1], nil
+	case "MultiPolygon":
+		var polygons [][][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &polygons); err != nil {
+			return nil, err
+		}
+		rings := make([][][2]float64, 0, len(polygons))
+		for _, polygon := range polygons {
+			if len(polygon) > 0 {
+				rings = append(rings, polygon[0])
+			}
+		}
+		return rings, nil
+	default:
+		return nil, fmt.Errorf("unsupported GeoJSON type %q", g.Type)
+	}
+}
+
+// polygonFilter возвращает условие поиска вышек внутри многоугольников из файла GeoJSON.
+func polygonFilter(filename string) (bson.M, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var object geoJSON
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	rings, err := object.rings()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	var filters []bson.M
+	for _, ring := range rings {
+		if len(ring) < 3 {
+			return nil, fmt.Errorf("%s: polygon with %d points", filename, len(ring))
+		}
+		filters = append(filters, withinPolygon(ring))
+	}
+	switch len(filters) {
+	case 0:
+		return nil, fmt.Errorf("%s: no polygons", filename)
+	case 1:
+		return filters[0], nil
+	default:
+		return bson.M{"$or": filters}, nil
+	}
+}
+
+// writeCSV записывает вышки, удовлетворяющие условию filter, в формате OpenCellID и возвращает
+// количество записанных вышек. Заблокированные вышки пропускаются: в этом формате их нельзя
+// отметить.
+func writeCSV(ctx context.Context, coll *mongo.Collection, filter bson.M, out io.Writer) (count int, err error) {
+	w := csv.NewWriter(out)
+	if err := w.Write(lbs.CellHeader); err != nil {
+		return 0, err
+	}
+	cursor, err := coll.Find(ctx, bson.M{"$and": []bson.M{filter, {"blocked": bson.M{"$ne": true}}}},
+		options.Find().SetProjection(bson.M{"_id": 0}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(context.Background())
+	for cursor.Next(ctx) {
+		var cell lbs.Cell
+		if err := cursor.Decode(&cell); err != nil {
+			return count, err
+		}
+		if err := w.Write(cell.MarshalCSV()); err != nil {
+			return count, err
+		}
+		count++
+		if count%100000 == 0 {
+			fmt.Fprintf(os.Stderr, "\r* extracted %8d records ", count)
+		}
+	}
+	if count >= 100000 {
+		fmt.Fprintln(os.Stderr, "")
+	}
+	if err := cursor.Err(); err != nil {
+		return count, err
+	}
+	w.Flush()
+	return count, w.Error()
+}
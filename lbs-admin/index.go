@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// createIndex создает геопространственный индекс координат вышек (см. lbs.DB.CreateLocationIndex).
+func createIndex(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := commandFlags("index")
+	partitioned := fs.Bool("partitioned", false, "data for each country is stored in a separate collection")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	ldb, err := blockDB(ctx, db, *partitioned)
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+	log.Print("Creating location index...")
+	if err := ldb.CreateLocationIndex(ctx); err != nil {
+		return err
+	}
+	log.Print("Location index created")
+	return nil
+}
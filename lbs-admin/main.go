@@ -0,0 +1,297 @@
+// Программа lbs-admin предназначена для обслуживания хранилища LBS.
+//
+//	LBS database administration
+//	./lbs-admin [-mongo url] command [-params] [args]
+//
+//	Commands:
+//	  archive [-collection name] file.bson.gz
+//	    	save collection snapshot to compressed BSON file
+//	  restore [-collection name] file.bson.gz
+//	    	restore collection from compressed BSON snapshot
+//	  backup [-collection name] [-interval d] [-keep n] s3://bucket/prefix|gs://bucket/prefix|dir
+//	    	save collection snapshots to object storage with retention
+//	  estimate [-collection name] [-minsample n]
+//	    	estimate cells position and range from raw measurements
+//	  simulate [-collection name] [-requests n] [-towers n] [-radius m] [-seed n] [-signal-weighted] [-multilateration] [model params]
+//	    	measure algorithm error on synthesized requests
+//	  block [-reason text] [-partitioned] radio mcc mnc lac cell
+//	    	block cell: lookups skip it and imports keep it blocked
+//	  unblock [-partitioned] radio mcc mnc lac cell
+//	    	remove cell from blocklist
+//	  blocklist
+//	    	print blocked cells
+//	  sync [-collections list] [-partitioned] [-interval d] mongodb://standby/db
+//	    	mirror changed collections to a standby MongoDB cluster
+//	  accuracy [-partitioned]
+//	    	learn default cell ranges per radio and country
+//	  dedup [-collection name] [-radius m]
+//	    	link co-located cells of one site so lookups count them once
+//	  extract [-collection name] [-bbox minlon,minlat,maxlon,maxlat|-polygon file.geojson] [-format bson|csv] file
+//	    	save cells within a region to a portable snapshot
+//	  rebuild [-collection name]
+//	    	rebuild area centroids, network stats and area adjacency
+//	  migrate [-partitioned]
+//	    	convert records to the current schema version
+//	  index [-partitioned]
+//	    	create geospatial index for nearby and bounding box cell queries
+//
+// Команда archive сохраняет все документы коллекции (по умолчанию — lbs.CollectionName) в файл в
+// виде последовательности документов BSON, сжатой gzip (формат совпадает с форматом mongodump).
+// Команда restore восстанавливает коллекцию с указанным именем из такого файла: данные сначала
+// загружаются во временную коллекцию, которая затем атомарно переименовывается в целевую. Это
+// позволяет быстро откатить базу к предыдущему состоянию после неудачного обновления, не оставляя
+// читателей без данных во время восстановления.
+//
+// Команда backup сохраняет снимок коллекции в том же формате в Amazon S3 (s3://bucket/prefix),
+// Google Cloud Storage (gs://bucket/prefix) или в локальный каталог. Если задан параметр -interval,
+// то снимки сохраняются периодически, а в хранилище остаются только -keep последних снимков.
+// Параметры доступа к хранилищу берутся из стандартных настроек окружения AWS или Google Cloud.
+// Такие снимки можно восстановить командой restore, не прибегая к полному восстановлению MongoDB.
+//
+// Команда estimate вычисляет местоположение, радиус действия и количество измерений для каждой
+// вышки по исходным измерениям, импортированным с помощью lbs-import -type=measurement (см.
+// lbs.EstimateCell), и сохраняет результаты в коллекцию с данными о вышках. Вышки, для которых
+// набралось меньше -minsample измерений, не изменяются.
+//
+// Команда simulate проверяет точность вычисления координат без реальных треков устройств. Для
+// каждого из -requests запросов выбирается случайная вышка из хранилища и случайная точка в зоне
+// ее действия, затем подбираются вышки того же оператора, которые могут быть видны в этой точке,
+// а уровень их сигнала вычисляется по модели затухания с расстоянием (log-distance path loss) со
+// случайным отклонением. По синтезированному запросу вычисляются координаты, и выводится
+// статистика ошибок относительно исходной точки. Параметр -signal-weighted включает вычисление
+// координат с весами вышек по уровню сигнала (см. lbs.SignalWeighted), а -multilateration — по
+// расстояниям до вышек (см. lbs.Multilateration).
+//
+// Команды block, unblock и blocklist ведут список заблокированных вышек (см. lbs.BlockedCell):
+// заведомо ошибочных записей или перемещенного тестового оборудования. Запись о заблокированной
+// вышке остается в базе с пометкой и не используется при вычислении координат, а lbs-import
+// помечает ее заново при каждом импорте.
+//
+// Команда sync поддерживает копию данных на резервном сервере MongoDB (например, в другом
+// дата-центре). Хеши коллекций на основном сервере (команда dbHash) сравниваются с хешами,
+// сохраненными при прошлой синхронизации, и изменившиеся коллекции копируются целиком во
+// временную коллекцию, которая затем атомарно заменяет старую. С параметром -interval
+// синхронизация повторяется периодически, поэтому резервная копия отстает от основной не больше,
+// чем на этот интервал и время копирования.
+//
+// Команда accuracy заново вычисляет средний радиус действия вышек каждого типа радио в каждой
+// стране (см. lbs.DB.LearnAccuracy) и выводит результат. Эти значения используются вместо
+// отсутствующего или неправдоподобного радиуса в записях о вышках. lbs-import вычисляет их
+// автоматически после каждого полного импорта.
+//
+// Команда dedup находит записи о вышках одной зоны, стоящие на расстоянии не больше -radius
+// метров друг от друга, и связывает их в одну площадку (см. lbs.GroupSites): при вычислении
+// координат найденные вышки одной площадки учитываются как одна точка. Полный импорт lbs-import
+// переносит площадки в новую коллекцию, а для новых вышек из выгрузки команду нужно запустить
+// заново.
+//
+// Команда extract сохраняет записи о вышках внутри прямоугольника -bbox или многоугольника из
+// файла GeoJSON -polygon в отдельный файл, например, для сервера, который обслуживает только
+// один город или область. Файл в формате bson (сжатая последовательность BSON, как у archive)
+// загружается командой restore, а файл в формате csv (таблица вышек OpenCellID) — программой
+// lbs-import.
+//
+// Команда rebuild заново вычисляет по записям о вышках производные коллекции: центры и радиусы
+// зон (lbs.AreasCollectionName), количество вышек и зон в каждой сети (lbs.NetworksCollectionName)
+// и граф соседства зон с пересекающимися кругами (lbs.AdjacencyCollectionName). Ее нужно
+// запускать после ручного исправления данных. Команду можно выполнять на работающей базе:
+// результаты собираются во временных коллекциях, которые затем атомарно заменяют прежние, а ход
+// вычисления выводится в stderr.
+//
+// Команда migrate переводит записи о вышках и исходные измерения на текущую версию схемы данных
+// (см. lbs.DB.MigrateSchema). Начиная с версии 2 код зоны и идентификатор вышки вмещают TAC и NCI
+// сетей 5G NR, а в версии 3 координаты хранятся как точки GeoJSON вместо пар [долгота, широта];
+// старые записи читаются и без перехода, а после него все записи имеют один формат. Команду можно
+// выполнять на работающей базе и повторять.
+//
+// Команда index создает в коллекциях с данными о вышках геопространственный индекс координат (см.
+// lbs.DB.CreateLocationIndex), без которого не работают поиск вышек рядом с точкой и внутри
+// прямоугольника. Полный импорт lbs-import переносит индекс в новые коллекции, поэтому команду
+// достаточно выполнить один раз.
+//
+// Сигнал прерывания (Ctrl+C) или SIGTERM останавливает команду вместе с текущим запросом к
+// MongoDB, в том числе периодическое выполнение backup и sync. Команды restore, sync и rebuild
+// заменяют коллекции только после полной загрузки данных, поэтому прерывание оставляет старые
+// данные без изменений.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/geotrace/lbs/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+// command описывает команду lbs-admin.
+type command struct {
+	usage string                                                             // описание параметров
+	help  string                                                             // описание команды
+	run   func(ctx context.Context, db *mongo.Database, args []string) error // выполнение команды
+}
+
+// commands содержит список поддерживаемых команд. Список заполняется при инициализации, т.к.
+// команды сами обращаются к нему при выводе описания параметров.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"archive": {
+			usage: "[-collection name] file.bson.gz",
+			help:  "save collection snapshot to compressed BSON file",
+			run:   archive,
+		},
+		"restore": {
+			usage: "[-collection name] file.bson.gz",
+			help:  "restore collection from compressed BSON snapshot",
+			run:   restore,
+		},
+		"backup": {
+			usage: "[-collection name] [-interval d] [-keep n] s3://bucket/prefix|gs://bucket/prefix|dir",
+			help:  "save collection snapshots to object storage with retention",
+			run:   backup,
+		},
+		"estimate": {
+			usage: "[-collection name] [-minsample n]",
+			help:  "estimate cells position and range from raw measurements",
+			run:   estimate,
+		},
+		"simulate": {
+			usage: "[-collection name] [-requests n] [-towers n] [-radius m] [-seed n] [-signal-weighted] [-multilateration] [model params]",
+			help:  "measure algorithm error on synthesized requests",
+			run:   simulate,
+		},
+		"block": {
+			usage: "[-reason text] [-partitioned] radio mcc mnc lac cell",
+			help:  "block cell: lookups skip it and imports keep it blocked",
+			run:   block,
+		},
+		"unblock": {
+			usage: "[-partitioned] radio mcc mnc lac cell",
+			help:  "remove cell from blocklist",
+			run:   unblock,
+		},
+		"blocklist": {
+			usage: "",
+			help:  "print blocked cells",
+			run:   blocklist,
+		},
+		"sync": {
+			usage: "[-collections list] [-partitioned] [-interval d] mongodb://standby/db",
+			help:  "mirror changed collections to a standby MongoDB cluster",
+			run:   syncData,
+		},
+		"accuracy": {
+			usage: "[-partitioned]",
+			help:  "learn default cell ranges per radio and country",
+			run:   accuracy,
+		},
+		"dedup": {
+			usage: "[-collection name] [-radius m]",
+			help:  "link co-located cells of one site so lookups count them once",
+			run:   dedup,
+		},
+		"extract": {
+			usage: "[-collection name] [-bbox minlon,minlat,maxlon,maxlat|-polygon file.geojson] [-format bson|csv] file",
+			help:  "save cells within a region to a portable snapshot",
+			run:   extract,
+		},
+		"rebuild": {
+			usage: "[-collection name]",
+			help:  "rebuild area centroids, network stats and area adjacency",
+			run:   rebuild,
+		},
+		"migrate": {
+			usage: "[-partitioned]",
+			help:  "convert records to the current schema version",
+			run:   migrate,
+		},
+		"index": {
+			usage: "[-partitioned]",
+			help:  "create geospatial index for nearby and bounding box cell queries",
+			run:   createIndex,
+		},
+	}
+}
+
+// commandNames задает порядок вывода команд в описании.
+var commandNames = []string{"archive", "restore", "backup", "estimate", "simulate", "block", "unblock",
+	"blocklist", "sync", "accuracy", "dedup", "extract", "rebuild", "migrate", "index"}
+
+func main() {
+	log.SetOutput(os.Stdout)
+	log.SetFlags(log.Ltime)
+	mongourl := flag.String("mongo", "mongodb://localhost/geotrace", "mongoDB connection URL")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, "LBS database administration\n")
+		fmt.Fprintf(os.Stderr, "%s [-mongo url] command [-params] [args]\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(os.Stderr, "Commands:\n")
+		for _, name := range commandNames {
+			cmd := commands[name]
+			fmt.Fprintf(os.Stderr, "  %s %s\n    \t%s\n", name, cmd.usage, cmd.help)
+		}
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// прерывание останавливает выполнение команды вместе с текущими запросами к MongoDB
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Printf("Connecting to MongoDB %q...", *mongourl)
+	mdb, err := connect(ctx, *mongourl)
+	if err != nil {
+		log.Printf("Error connecting to MongoDB: %v", err)
+		os.Exit(1)
+	}
+	err = cmd.run(ctx, mdb, flag.Args()[1:])
+	mdb.Client().Disconnect(context.Background())
+	if err != nil {
+		log.Printf("Error: %v", err)
+		os.Exit(1)
+	}
+}
+
+// connect устанавливает соединение с сервером MongoDB по указанному URL и возвращает базу данных,
+// название которой указано в URL.
+func connect(ctx context.Context, url string) (*mongo.Database, error) {
+	cs, err := connstring.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(url).SetRegistry(lbs.Registry))
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+	return client.Database(cs.Database), nil
+}
+
+// commandFlags возвращает набор параметров для команды с описанием ее использования.
+func commandFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s %s %s\n", os.Args[0], name, commands[name].usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
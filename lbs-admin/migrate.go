@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/geotrace/lbs/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// migrate переводит хранилище на текущую версию схемы данных (см. lbs.DB.MigrateSchema).
+func migrate(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := commandFlags("migrate")
+	partitioned := fs.Bool("partitioned", false, "data for each country is stored in a separate collection")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	ldb, err := blockDB(ctx, db, *partitioned)
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+	schema, err := ldb.Schema(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Migrating schema from version %d to %d...", schema.Version, lbs.SchemaVersion)
+	if schema, err = ldb.MigrateSchema(ctx); err != nil {
+		return err
+	}
+	log.Printf("Schema version %d, %d values converted", schema.Version, schema.Modified)
+	return nil
+}
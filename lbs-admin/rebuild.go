@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/geotrace/lbs/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// areasIndex описывает уникальный индекс коллекций с данными о зонах.
+var areasIndex = mongo.IndexModel{
+	Keys: bson.D{
+		{Key: "radio", Value: 1},
+		{Key: "mcc", Value: 1},
+		{Key: "mnc", Value: 1},
+		{Key: "lac", Value: 1},
+	},
+	Options: options.Index().SetUnique(true),
+}
+
+// networksIndex описывает уникальный индекс коллекции со статистикой по сетям.
+var networksIndex = mongo.IndexModel{
+	Keys: bson.D{
+		{Key: "radio", Value: 1},
+		{Key: "mcc", Value: 1},
+		{Key: "mnc", Value: 1},
+	},
+	Options: options.Index().SetUnique(true),
+}
+
+// rebuild заново вычисляет производные коллекции по записям о вышках: центры зон, статистику по
+// сетям и граф соседства зон.
+func rebuild(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := commandFlags("rebuild")
+	name := fs.String("collection", lbs.CollectionName, "collection name")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	coll := db.Collection(*name)
+	// результаты собираются во временных коллекциях, поэтому читатели до конца пересчета
+	// пользуются прежними данными
+	targets := []string{lbs.AreasCollectionName, lbs.NetworksCollectionName, lbs.AdjacencyCollectionName}
+	tmp := make(map[string]*mongo.Collection, len(targets))
+	for _, target := range targets {
+		tmp[target] = db.Collection(target + "_rebuild")
+		if err := tmp[target].Drop(ctx); err != nil {
+			return err
+		}
+	}
+	estimated, err := coll.EstimatedDocumentCount(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Rebuilding areas, networks and adjacency from %q (about %d cells)...", *name, estimated)
+	var (
+		cells    []lbs.Cell         // вышки текущей зоны, кроме заблокированных
+		areas    []lbs.Area         // все вычисленные зоны
+		networks []lbs.NetworkStats // статистика по сетям в порядке сортировки
+		total    int
+	)
+	docs := make([]interface{}, 0, 1000)
+	// сохраняет зону, вышки которой накоплены в cells
+	save := func() error {
+		area, ok := lbs.AreaOf(cells)
+		cells = cells[:0]
+		if !ok {
+			return nil
+		}
+		areas = append(areas, area)
+		networks[len(networks)-1].Areas++
+		if docs = append(docs, area); len(docs) < cap(docs) {
+			return nil
+		}
+		err := insertAll(ctx, tmp[lbs.AreasCollectionName], docs)
+		docs = docs[:0]
+		return err
+	}
+	// вышки одной зоны идут подряд, т.к. отсортированы по ключу
+	cursor, err := coll.Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"_id": 0}).SetSort(bson.D{
+			{Key: "radio", Value: 1},
+			{Key: "mcc", Value: 1},
+			{Key: "mnc", Value: 1},
+			{Key: "lac", Value: 1},
+		}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+	var last lbs.Key
+	for cursor.Next(ctx) {
+		var cell lbs.Cell
+		if err := cursor.Decode(&cell); err != nil {
+			return err
+		}
+		if total == 0 || !sameArea(last, cell.Key) {
+			if err := save(); err != nil {
+				return err
+			}
+		}
+		if total == 0 || !sameNetwork(last, cell.Key) {
+			networks = append(networks, lbs.NetworkStats{
+				RadioType:         cell.RadioType,
+				MobileCountryCode: cell.MobileCountryCode,
+				MobileNetworkCode: cell.MobileNetworkCode,
+			})
+		}
+		last = cell.Key
+		total++
+		stats := &networks[len(networks)-1]
+		if cell.Updated.After(stats.Updated) {
+			stats.Updated = cell.Updated
+		}
+		if cell.Blocked {
+			stats.Blocked++
+		} else {
+			stats.Cells++
+			cells = append(cells, cell)
+		}
+		if total%10000 == 0 {
+			fmt.Fprintf(os.Stderr, "\r* processed %10d cells (%3.0f%%), %8d areas ", total,
+				100*float64(total)/float64(estimated+1), len(areas))
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if err := save(); err != nil {
+		return err
+	}
+	if err := insertAll(ctx, tmp[lbs.AreasCollectionName], docs); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "")
+
+	log.Printf("Linking %d areas...", len(areas))
+	adjacency := lbs.AdjacentAreas(areas)
+	docs = docs[:0]
+	for _, stats := range networks {
+		docs = append(docs, stats)
+	}
+	if err := insertAll(ctx, tmp[lbs.NetworksCollectionName], docs); err != nil {
+		return err
+	}
+	for i := 0; i < len(adjacency); i += 1000 {
+		docs = docs[:0]
+		for j := i; j < len(adjacency) && j < i+1000; j++ {
+			docs = append(docs, adjacency[j])
+		}
+		if err := insertAll(ctx, tmp[lbs.AdjacencyCollectionName], docs); err != nil {
+			return err
+		}
+	}
+	for target, index := range map[string]mongo.IndexModel{
+		lbs.AreasCollectionName:     areasIndex,
+		lbs.NetworksCollectionName:  networksIndex,
+		lbs.AdjacencyCollectionName: areasIndex,
+	} {
+		if _, err := tmp[target].Indexes().CreateOne(ctx, index); err != nil {
+			return err
+		}
+	}
+	for _, target := range targets {
+		if err := renameCollection(ctx, db, tmp[target].Name(), target); err != nil {
+			return err
+		}
+	}
+	log.Printf("Rebuilt from %d cells: %d networks, %d areas, %d areas with neighbors",
+		total, len(networks), len(areas), len(adjacency))
+	return nil
+}
+
+// sameNetwork возвращает true, если вышки относятся к одной сети: совпадают тип радио, код
+// страны и код оператора.
+func sameNetwork(a, b lbs.Key) bool {
+	a.LocationAreaCode, a.CellId = 0, 0
+	b.LocationAreaCode, b.CellId = 0, 0
+	return a == b
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/lbs/v2"
+	"github.com/geotrace/locator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// earthRadius задает радиус Земли в метрах.
+const earthRadius = 6378137.0
+
+// propagation описывает модель затухания сигнала с расстоянием (log-distance path loss):
+// уровень сигнала на расстоянии d равен ref - 10*exponent*log10(d/refDistance) плюс случайное
+// отклонение с нормальным распределением.
+type propagation struct {
+	ref         float64 // уровень сигнала на опорном расстоянии, дБм
+	refDistance float64 // опорное расстояние, м
+	exponent    float64 // показатель затухания
+	shadowing   float64 // стандартное отклонение случайной составляющей, дБ
+	floor       float64 // минимальный уровень сигнала, при котором вышка видна, дБм
+}
+
+// signal возвращает уровень сигнала вышки на указанном расстоянии и false, если сигнал слишком
+// слабый и вышка не видна.
+func (p propagation) signal(rnd *rand.Rand, dist float64) (int16, bool) {
+	if dist < p.refDistance {
+		dist = p.refDistance
+	}
+	rssi := p.ref - 10*p.exponent*math.Log10(dist/p.refDistance) + rnd.NormFloat64()*p.shadowing
+	if rssi < p.floor {
+		return 0, false
+	}
+	if rssi > -51 {
+		rssi = -51 // максимальное значение, которое сообщают устройства
+	}
+	return int16(math.Round(rssi)), true
+}
+
+// sampleCell возвращает случайную запись о вышке из коллекции.
+func sampleCell(ctx context.Context, coll *mongo.Collection) (cell lbs.Cell, err error) {
+	cursor, err := coll.Aggregate(ctx, []bson.M{
+		{"$sample": bson.M{"size": 1}},
+		{"$project": bson.M{"_id": 0}},
+	})
+	if err != nil {
+		return cell, err
+	}
+	defer cursor.Close(context.Background())
+	if !cursor.Next(ctx) {
+		if err := cursor.Err(); err != nil {
+			return cell, err
+		}
+		return cell, fmt.Errorf("collection %q is empty", coll.Name())
+	}
+	err = cursor.Decode(&cell)
+	return cell, err
+}
+
+// around возвращает случайную точку внутри круга с указанным центром и радиусом в метрах.
+func around(rnd *rand.Rand, center geo.Point, radius float64) geo.Point {
+	r := radius * math.Sqrt(rnd.Float64()) // равномерное распределение по площади
+	bearing := 2 * math.Pi * rnd.Float64()
+	lat := center.Latitude() + r*math.Cos(bearing)/earthRadius*180/math.Pi
+	lon := center.Longitude() + r*math.Sin(bearing)/
+		(earthRadius*math.Cos(center.Latitude()*math.Pi/180))*180/math.Pi
+	return geo.NewPoint(lon, lat)
+}
+
+// visible описывает вышку, видимую в синтезированном запросе.
+type visible struct {
+	lbs.Cell
+	signal int16
+}
+
+// simulate синтезирует запросы вокруг известных точек по данным из хранилища, вычисляет по ним
+// координаты и выводит статистику ошибок алгоритма.
+func simulate(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := commandFlags("simulate")
+	name := fs.String("collection", lbs.CollectionName, "collection name")
+	requests := fs.Int("requests", 1000, "number of synthesized requests")
+	towers := fs.Int("towers", 6, "max towers in request")
+	radius := fs.Float64("radius", 5000, "max distance to visible towers in meters")
+	seed := fs.Int64("seed", 0, "random seed (0 - use current time)")
+	weighted := fs.Bool("signal-weighted", false, "weight towers by signal strength (see lbs.SignalWeighted)")
+	multilateration := fs.Bool("multilateration", false, "locate by distances to towers (see lbs.Multilateration)")
+	model := propagation{refDistance: 100}
+	fs.Float64Var(&model.ref, "rssi", -50, "signal strength at 100 m in dBm")
+	fs.Float64Var(&model.exponent, "exponent", 2.7, "path loss exponent")
+	fs.Float64Var(&model.shadowing, "shadowing", 6, "signal strength deviation in dB")
+	fs.Float64Var(&model.floor, "floor", -113, "min visible signal strength in dBm")
+	fs.Parse(args)
+	if fs.NArg() != 0 || *requests <= 0 || *towers <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	rnd := rand.New(rand.NewSource(*seed))
+	opts := []lbs.Option{lbs.Collection(*name)}
+	if *weighted {
+		opts = append(opts, lbs.SignalWeighted())
+	}
+	if *multilateration {
+		opts = append(opts, lbs.Multilateration())
+	}
+	ldb, err := lbs.InitDB(ctx, db.Client(), db.Name(), opts...)
+	if err != nil {
+		return err
+	}
+	defer ldb.Close()
+	coll := db.Collection(*name)
+
+	log.Printf("Simulating %d requests from %q (seed %d)...", *requests, *name, *seed)
+	var (
+		dists     []float64 // ошибки определения координат, м
+		covered   int       // количество ответов, точка которых попала в круг точности
+		notFound  int       // количество запросов без ответа
+		towersSum int       // суммарное количество вышек в запросах
+	)
+	for i := 0; i < *requests; i++ {
+		// выбираем случайную вышку и точку в зоне ее действия
+		anchor, err := sampleCell(ctx, coll)
+		if err != nil {
+			return err
+		}
+		point := around(rnd, anchor.Location, math.Max(anchor.Accuracy, model.refDistance))
+		// находим вышки того же оператора, которые могут быть видны в этой точке
+		var cells []lbs.Cell
+		cursor, err := coll.Find(ctx, bson.M{
+			"radio": anchor.RadioType,
+			"mcc":   anchor.MobileCountryCode,
+			"mnc":   anchor.MobileNetworkCode,
+			"location": bson.M{"$geoWithin": bson.M{
+				"$centerSphere": []interface{}{[2]float64{point.Longitude(), point.Latitude()}, *radius / earthRadius},
+			}},
+		}, options.Find().SetProjection(bson.M{"_id": 0}).SetLimit(200))
+		if err == nil {
+			err = cursor.All(ctx, &cells)
+		}
+		if err != nil {
+			return err
+		}
+		seen := make([]visible, 0, len(cells))
+		for _, cell := range cells {
+			dist := lbs.Distance(point, cell.Location)
+			if cell.Accuracy > 0 && dist > cell.Accuracy && cell.Key != anchor.Key {
+				continue // точка вне зоны действия вышки
+			}
+			if signal, ok := model.signal(rnd, dist); ok || cell.Key == anchor.Key {
+				if !ok {
+					signal = int16(model.floor)
+				}
+				seen = append(seen, visible{Cell: cell, signal: signal})
+			}
+		}
+		if len(seen) == 0 {
+			seen = append(seen, visible{Cell: anchor, signal: int16(model.floor)})
+		}
+		sort.Slice(seen, func(i, j int) bool { return seen[i].signal > seen[j].signal })
+		if len(seen) > *towers {
+			seen = seen[:*towers]
+		}
+		req := lbs.Request{
+			Request: locator.Request{
+				RadioType:             anchor.RadioType,
+				HomeMobileCountryCode: anchor.MobileCountryCode,
+				HomeMobileNetworkCode: anchor.MobileNetworkCode,
+				CellTowers:            make([]*locator.CellTower, len(seen)),
+			},
+			// идентификаторы вышек 5G NR не помещаются в CellTower
+			Identities: make([]lbs.CellIdentity, len(seen)),
+		}
+		// модель вычисляет RSSI, а устройства для UMTS, LTE и NR сообщают RSCP и RSRP
+		offset := int16(lbs.RSSIOffset(anchor.RadioType))
+		for j, cell := range seen {
+			req.CellTowers[j] = &locator.CellTower{
+				MobileCountryCode: cell.MobileCountryCode,
+				MobileNetworkCode: cell.MobileNetworkCode,
+				LocationAreaCode:  uint16(cell.LocationAreaCode),
+				CellId:            uint32(cell.CellId),
+				SignalStrength:    cell.signal - offset,
+			}
+			req.Identities[j] = lbs.CellIdentity{
+				LocationAreaCode: cell.LocationAreaCode,
+				CellId:           cell.CellId,
+			}
+		}
+		towersSum += len(seen)
+		details, err := ldb.GetDetailed(ctx, req)
+		if errors.Is(err, lbs.ErrNotFound) {
+			notFound++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		resp := details.Response
+		dist := lbs.Distance(point, geo.NewPoint(resp.Location.Lng, resp.Location.Lat))
+		dists = append(dists, dist)
+		if dist <= resp.Accuracy {
+			covered++
+		}
+		if (i+1)%100 == 0 {
+			fmt.Fprintf(os.Stderr, "\r* simulated %8d requests ", i+1)
+		}
+	}
+	fmt.Fprintln(os.Stderr, "")
+	log.Printf("Requests: %d, not found: %d, avg towers: %.1f",
+		*requests, notFound, float64(towersSum)/float64(*requests))
+	if len(dists) == 0 {
+		return nil
+	}
+	sort.Float64s(dists)
+	var sum float64
+	for _, dist := range dists {
+		sum += dist
+	}
+	percentile := func(p float64) float64 {
+		return dists[int(p*float64(len(dists)-1))]
+	}
+	log.Printf("Error, m: mean %.0f, median %.0f, p90 %.0f, p95 %.0f, max %.0f",
+		sum/float64(len(dists)), percentile(0.5), percentile(0.9), percentile(0.95),
+		dists[len(dists)-1])
+	log.Printf("Within reported accuracy: %.1f%%", 100*float64(covered)/float64(len(dists)))
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"google.golang.org/api/iterator"
+)
+
+// snapshotStore описывает хранилище снимков базы данных.
+type snapshotStore interface {
+	// put сохраняет снимок с указанным именем.
+	put(ctx context.Context, name string, r io.Reader) error
+	// list возвращает отсортированный список имен сохраненных снимков, начинающихся с prefix.
+	list(ctx context.Context, prefix string) ([]string, error)
+	// remove удаляет снимок с указанным именем.
+	remove(ctx context.Context, name string) error
+}
+
+// openStore возвращает хранилище снимков по его адресу: s3://bucket/prefix для Amazon S3,
+// gs://bucket/prefix для Google Cloud Storage или путь к локальному каталогу.
+func openStore(ctx context.Context, location string) (snapshotStore, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	switch u.Scheme {
+	case "s3":
+		// параметры доступа берутся из стандартных переменных окружения и файлов настроек AWS
+		sess, err := session.NewSessionWithOptions(session.Options{
+			SharedConfigState: session.SharedConfigEnable,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &s3Store{
+			client:   s3.New(sess),
+			uploader: s3manager.NewUploader(sess),
+			bucket:   u.Host,
+			prefix:   prefix,
+		}, nil
+	case "gs":
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &gcsStore{
+			bucket: client.Bucket(u.Host),
+			prefix: prefix,
+		}, nil
+	case "", "file":
+		if err := os.MkdirAll(u.Path, 0755); err != nil {
+			return nil, err
+		}
+		return dirStore(u.Path), nil
+	default:
+		return nil, &url.Error{Op: "open store", URL: location, Err: errUnsupportedScheme}
+	}
+}
+
+// errUnsupportedScheme возвращается при указании неподдерживаемого типа хранилища.
+var errUnsupportedScheme = errors.New("unsupported storage scheme")
+
+// dirStore описывает хранилище снимков в локальном каталоге.
+type dirStore string
+
+func (d dirStore) put(ctx context.Context, name string, r io.Reader) error {
+	// записываем во временный файл, чтобы в каталоге никогда не было недописанных снимков
+	file, err := ioutil.TempFile(string(d), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, r); err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.Join(string(d), name))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+func (d dirStore) list(ctx context.Context, prefix string) ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(string(d), prefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range names {
+		names[i] = filepath.Base(name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (d dirStore) remove(ctx context.Context, name string) error {
+	return os.Remove(filepath.Join(string(d), name))
+}
+
+// s3Store описывает хранилище снимков в Amazon S3.
+type s3Store struct {
+	client   *s3.S3
+	uploader *s3manager.Uploader
+	bucket   string
+	prefix   string
+}
+
+func (s *s3Store) put(ctx context.Context, name string, r io.Reader) error {
+	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + name),
+		Body:   r,
+	})
+	return err
+}
+
+func (s *s3Store) list(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix + prefix),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range page.Contents {
+			names = append(names, strings.TrimPrefix(aws.StringValue(obj.Key), s.prefix))
+		}
+		return true
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+func (s *s3Store) remove(ctx context.Context, name string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + name),
+	})
+	return err
+}
+
+// gcsStore описывает хранилище снимков в Google Cloud Storage.
+type gcsStore struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+func (s *gcsStore) put(ctx context.Context, name string, r io.Reader) error {
+	w := s.bucket.Object(s.prefix + name).NewWriter(ctx)
+	w.ContentType = "application/gzip"
+	if _, err := io.Copy(w, r); err != nil {
+		w.CloseWithError(err)
+		return err
+	}
+	return w.Close()
+}
+
+func (s *gcsStore) list(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: s.prefix + prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, strings.TrimPrefix(attrs.Name, s.prefix))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *gcsStore) remove(ctx context.Context, name string) error {
+	return s.bucket.Object(s.prefix + name).Delete(ctx)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/geotrace/lbs/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// syncCollectionName описывает название коллекции на резервном сервере, в которой хранится
+// состояние синхронизации каждой коллекции.
+const syncCollectionName = "lbs_sync"
+
+// syncState описывает состояние синхронизации коллекции на резервном сервере.
+type syncState struct {
+	Name   string    `bson:"_id"`
+	Hash   string    `bson:"hash"`   // хеш данных коллекции на основном сервере (dbHash)
+	Count  int       `bson:"count"`  // количество скопированных документов
+	Synced time.Time `bson:"synced"` // время синхронизации
+}
+
+// syncData копирует коллекции с данными на резервный сервер MongoDB: однократно или
+// периодически. Коллекция копируется, только если ее данные изменились с прошлой синхронизации.
+func syncData(ctx context.Context, db *mongo.Database, args []string) error {
+	fs := commandFlags("sync")
+	names := fs.String("collections", strings.Join([]string{lbs.CollectionName, lbs.MetaCollectionName,
+		lbs.BlocklistCollectionName}, ","), "comma separated collections to mirror")
+	partitioned := fs.Bool("partitioned", false, "also mirror per-country collections from partitions table")
+	interval := fs.Duration("interval", 0, "sync interval (0 - sync once and exit)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	log.Printf("Connecting to standby MongoDB %q...", fs.Arg(0))
+	target, err := connect(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer target.Client().Disconnect(context.Background())
+	for {
+		err := syncOnce(ctx, db, target, strings.Split(*names, ","), *partitioned)
+		if *interval <= 0 {
+			return err
+		}
+		if err != nil {
+			log.Printf("Sync error: %v", err) // при периодической синхронизации не прерываемся
+		}
+		if err := sleep(ctx, *interval); err != nil {
+			return err
+		}
+	}
+}
+
+// syncOnce сравнивает хеши коллекций на основном сервере с хешами, сохраненными при прошлой
+// синхронизации, и копирует изменившиеся коллекции на резервный сервер.
+func syncOnce(ctx context.Context, source, target *mongo.Database, names []string, partitioned bool) error {
+	if partitioned {
+		var doc lbs.Partitions
+		err := source.Collection(lbs.MetaCollectionName).FindOne(ctx, bson.M{"_id": lbs.PartitionsID}).Decode(&doc)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		for _, name := range doc.Collections {
+			names = append(names, name)
+		}
+	}
+	names = uniqueNames(names)
+	var hashes struct {
+		Collections map[string]string `bson:"collections"`
+	}
+	err := source.RunCommand(ctx, bson.D{{Key: "dbHash", Value: 1}, {Key: "collections", Value: names}}).
+		Decode(&hashes)
+	if err != nil {
+		return err
+	}
+	states := target.Collection(syncCollectionName)
+	var synced, skipped int
+	for _, name := range names {
+		hash := hashes.Collections[name]
+		var state syncState
+		err := states.FindOne(ctx, bson.M{"_id": name}).Decode(&state)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		if err == nil && state.Hash == hash {
+			skipped++
+			continue // данные не изменились
+		}
+		log.Printf("Mirroring %q...", name)
+		count, err := mirrorCollection(ctx, source.Collection(name), target, name)
+		if err != nil {
+			return fmt.Errorf("mirror %q: %v", name, err)
+		}
+		_, err = states.ReplaceOne(ctx, bson.M{"_id": name},
+			syncState{Name: name, Hash: hash, Count: count, Synced: time.Now()}, options.Replace().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+		log.Printf("Mirrored %d records to %q", count, name)
+		synced++
+	}
+	log.Printf("Sync complete: %d collections copied, %d unchanged", synced, skipped)
+	return nil
+}
+
+// uniqueNames возвращает список названий коллекций без пустых значений и повторов.
+func uniqueNames(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		result = append(result, name)
+	}
+	return result
+}
+
+// mirrorCollection копирует все документы и индексы коллекции во временную коллекцию на
+// резервном сервере, которая затем атомарно заменяет коллекцию с указанным названием. Пока идет
+// копирование, резервный сервер продолжает отвечать по старым данным.
+func mirrorCollection(ctx context.Context, from *mongo.Collection, target *mongo.Database, name string) (count int, err error) {
+	tmp := target.Collection(name + "_sync")
+	if err := tmp.Drop(ctx); err != nil {
+		return 0, err
+	}
+	cursor, err := from.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(context.Background())
+	docs := make([]interface{}, 0, 1000)
+	for cursor.Next(ctx) {
+		docs = append(docs, append(bson.Raw(nil), cursor.Current...)) // буфер переиспользуется
+		if count++; count%1000 == 0 {
+			if err := insertAll(ctx, tmp, docs); err != nil {
+				return count, err
+			}
+			docs = docs[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return count, err
+	}
+	if count == 0 {
+		// временная коллекция не создана: просто удаляем данные на резервном сервере
+		return 0, target.Collection(name).Drop(ctx)
+	}
+	if err := insertAll(ctx, tmp, docs); err != nil {
+		return count, err
+	}
+	indexes, err := from.Indexes().List(ctx)
+	if err != nil {
+		return count, err
+	}
+	var specs []struct {
+		Name string `bson:"name"`
+		Key  bson.D `bson:"key"`
+		// параметры индекса, которые используются коллекциями с данными
+		Unique             bool   `bson:"unique"`
+		Sparse             bool   `bson:"sparse"`
+		ExpireAfterSeconds *int32 `bson:"expireAfterSeconds"`
+	}
+	if err := indexes.All(ctx, &specs); err != nil {
+		return count, err
+	}
+	for _, spec := range specs {
+		if spec.Name == "_id_" {
+			continue
+		}
+		opts := options.Index().SetName(spec.Name).SetUnique(spec.Unique).SetSparse(spec.Sparse)
+		if spec.ExpireAfterSeconds != nil {
+			opts.SetExpireAfterSeconds(*spec.ExpireAfterSeconds)
+		}
+		if _, err := tmp.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: spec.Key, Options: opts}); err != nil {
+			return count, err
+		}
+	}
+	return count, renameCollection(ctx, target, tmp.Name(), name)
+}
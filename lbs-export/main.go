@@ -0,0 +1,181 @@
+// Программа lbs-export сохраняет записи о вышках из хранилища LBS в файл CSV в формате OpenCellID.
+//
+//	LBS database export to OpenCellID CSV
+//	./lbs-export [-params] cells.csv[.gz]
+//	  -bbox minlon,minlat,maxlon,maxlat
+//	    	export only cells within bounding box minlon,minlat,maxlon,maxlat
+//	  -collection string
+//	    	collection name (default "lbs")
+//	  -mcc int
+//	    	export only cells with mobile country code (0 - all countries)
+//	  -mnc int
+//	    	export only cells with mobile network code (-1 - all operators) (default -1)
+//	  -mongo string
+//	    	mongoDB connection URL (default "mongodb://localhost/geotrace")
+//	  -partitioned
+//	    	data for each country is stored in a separate collection
+//	  -radio string
+//	    	export only cells with radio type (default - all radio types)
+//
+// Файл содержит те же колонки, что и выгрузка OpenCellID (см. lbs.CellHeader), поэтому его можно
+// загрузить программой lbs-import в другую базу или в ту же базу после ее очистки: так удобно
+// поделиться исправленными данными или сохранить их в переносимом виде. Файлы с расширением .gz
+// сжимаются, а вместо имени файла можно указать "-" для записи в стандартный вывод. Заблокированные
+// вышки не сохраняются (см. lbs.DB.Export).
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/geotrace/lbs/v2"
+)
+
+func main() {
+	log.SetOutput(os.Stderr) // стандартный вывод может быть занят данными
+	log.SetFlags(log.Ltime)
+	mongourl := flag.String("mongo", "mongodb://localhost/geotrace", "mongoDB connection URL")
+	name := flag.String("collection", lbs.CollectionName, "collection name")
+	partitioned := flag.Bool("partitioned", false, "data for each country is stored in a separate collection")
+	radio := flag.String("radio", "", "export only cells with radio type (default - all radio types)")
+	mcc := flag.Int("mcc", 0, "export only cells with mobile country code (0 - all countries)")
+	mnc := flag.Int("mnc", -1, "export only cells with mobile network code (-1 - all operators)")
+	bbox := flag.String("bbox", "", "export only cells within bounding box `minlon,minlat,maxlon,maxlat`")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, "LBS database export to OpenCellID CSV\n")
+		fmt.Fprintf(os.Stderr, "%s [-params] cells.csv[.gz]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var filter lbs.ExportFilter
+	if *radio != "" {
+		filter.RadioType = lbs.NormalizeRadio(*radio)
+	}
+	if *mcc < 0 || *mcc > 999 || *mnc < -1 || *mnc > 32767 {
+		log.Print("Bad -mcc or -mnc: 0-999 (CDMA SID for -mnc: 0-32767) expected")
+		os.Exit(2)
+	}
+	if *mcc > 0 {
+		code := uint16(*mcc)
+		filter.MobileCountryCode = &code
+	}
+	if *mnc >= 0 {
+		code := uint16(*mnc)
+		filter.MobileNetworkCode = &code
+	}
+	if *bbox != "" {
+		box, err := parseBox(*bbox)
+		if err != nil {
+			log.Printf("Bad -bbox: %v", err)
+			os.Exit(2)
+		}
+		filter.Box = &box
+	}
+
+	ctx := context.Background()
+	opts := []lbs.Option{lbs.Collection(*name)}
+	if *partitioned {
+		opts = append(opts, lbs.Partitioned())
+	}
+	log.Printf("Connecting to MongoDB %q...", *mongourl)
+	db, err := lbs.Dial(ctx, *mongourl, opts...)
+	if err != nil {
+		log.Printf("Error connecting to MongoDB: %v", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	w, err := createFile(flag.Arg(0))
+	if err != nil {
+		log.Printf("Error creating file: %v", err)
+		os.Exit(1)
+	}
+	log.Printf("Exporting %q to %q...", *name, flag.Arg(0))
+	count, err := db.Export(ctx, w, filter, func(count int) {
+		fmt.Fprintf(os.Stderr, "\r* exported %10d cells ", count)
+	})
+	if count >= 100000 {
+		fmt.Fprintln(os.Stderr, "")
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		log.Printf("Error exporting cells: %v", err)
+		os.Exit(1)
+	}
+	log.Printf("Exported %d cells", count)
+}
+
+// parseBox разбирает прямоугольник, заданный строкой minlon,minlat,maxlon,maxlat.
+func parseBox(value string) (box [4]float64, err error) {
+	fields := strings.Split(value, ",")
+	if len(fields) != 4 {
+		return box, fmt.Errorf("4 comma separated numbers expected in %q", value)
+	}
+	for i, field := range fields {
+		if box[i], err = strconv.ParseFloat(strings.TrimSpace(field), 64); err != nil {
+			return box, err
+		}
+	}
+	return box, nil
+}
+
+// createFile создает файл для записи. Файлы с расширением .gz сжимаются, а "-" означает
+// стандартный вывод. Данные буферизуются и записываются полностью только при вызове Close.
+func createFile(name string) (io.WriteCloser, error) {
+	file := os.Stdout
+	if name != "-" {
+		var err error
+		if file, err = os.Create(name); err != nil {
+			return nil, err
+		}
+	}
+	out := &outputFile{buf: bufio.NewWriter(file), file: file}
+	out.w = out.buf
+	if strings.HasSuffix(name, ".gz") {
+		out.gz = gzip.NewWriter(out.buf)
+		out.w = out.gz
+	}
+	return out, nil
+}
+
+// outputFile записывает данные в файл через буфер и, если нужно, сжимает их.
+type outputFile struct {
+	w    io.Writer     // буфер или сжатие
+	buf  *bufio.Writer // буфер записи в файл
+	gz   *gzip.Writer  // nil - без сжатия
+	file *os.File
+}
+
+func (f *outputFile) Write(data []byte) (int, error) {
+	return f.w.Write(data)
+}
+
+func (f *outputFile) Close() error {
+	if f.gz != nil {
+		if err := f.gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err := f.buf.Flush(); err != nil {
+		return err
+	}
+	if f.file == os.Stdout {
+		return nil
+	}
+	return f.file.Close()
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+
+	"github.com/geotrace/lbs/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// loadBlocklist возвращает список заблокированных вышек (см. lbs.BlockedCell). Записи о таких
+// вышках импортируются с пометкой blocked, чтобы обновление базы не возвращало их в работу.
+func loadBlocklist(ctx context.Context, db *mongo.Database) (map[lbs.Key]bool, error) {
+	var cells []lbs.BlockedCell
+	cursor, err := db.Collection(lbs.BlocklistCollectionName).Find(ctx, bson.M{})
+	if err == nil {
+		err = cursor.All(ctx, &cells)
+	}
+	if err != nil {
+		return nil, err
+	}
+	blocklist := make(map[lbs.Key]bool, len(cells))
+	for _, cell := range cells {
+		blocklist[cell.Key] = true
+	}
+	return blocklist, nil
+}
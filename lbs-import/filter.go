@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/geotrace/lbs/v2"
+	"github.com/geotrace/lbs/v2/importer"
+)
+
+// resolveCountryFilter возвращает значение фильтра -country для файла filename: "auto" заменяется
+// кодом страны из имени файла выгрузки по одной стране или, если его там нет или данные
+// загружаются по -source (download), пустым значением, как и "all": импортируются все страны.
+func resolveCountryFilter(value, filename string, download bool) string {
+	switch value {
+	case "auto":
+		if mcc, ok := importer.CountryFromFilename(filename); ok && !download {
+			return mcc
+		}
+		return ""
+	case "all":
+		return ""
+	}
+	return value
+}
+
+// parseCountryFilter разбирает список кодов стран (MCC) через запятую. Для пустого списка
+// возвращается пустой фильтр.
+func parseCountryFilter(value string) (map[uint16]bool, error) {
+	filter := make(map[uint16]bool)
+	for _, country := range strings.Split(value, ",") {
+		if country = strings.TrimSpace(country); country == "" {
+			continue
+		}
+		mcc, err := strconv.ParseUint(country, 10, 16)
+		if err != nil || mcc > 999 {
+			return nil, fmt.Errorf("%q: MCC list or \"all\" expected", country)
+		}
+		filter[uint16(mcc)] = true
+	}
+	return filter, nil
+}
+
+// supportedRadio возвращает true, если тип радио поддерживается хранилищем (см. lbs.RadioTypes):
+// записи с другими типами радио все равно не нашлись бы при поиске.
+func supportedRadio(radio string) bool {
+	for _, name := range lbs.RadioTypes {
+		if radio == name {
+			return true
+		}
+	}
+	return false
+}
+
+// networkFilter описывает фильтр по операторам: коды сетей (MNC), которые допускаются в любой
+// стране, и пары кодов страны и сети (MCC-MNC), которые допускаются только в своей стране. Код
+// сети без кода страны неоднозначен: один и тот же MNC в разных странах принадлежит разным
+// операторам.
+type networkFilter struct {
+	networks  map[uint16]bool    // коды сетей для любой страны
+	operators map[[2]uint16]bool // пары кодов страны и сети
+}
+
+// parseNetworkFilter разбирает список кодов сетей и пар кодов страны и сети через запятую,
+// например, "1,250-2".
+func parseNetworkFilter(value string) (networkFilter, error) {
+	filter := networkFilter{
+		networks:  make(map[uint16]bool),
+		operators: make(map[[2]uint16]bool),
+	}
+	for _, network := range strings.Split(value, ",") {
+		if network = strings.TrimSpace(network); network == "" {
+			continue
+		}
+		country, code, pair := strings.Cut(network, "-")
+		if !pair {
+			country, code = "", network
+		}
+		// для сетей CDMA вместо кода сети указывается номер системы (SID) до 32767
+		mnc, err := strconv.ParseUint(code, 10, 16)
+		if err != nil || mnc > 32767 {
+			return filter, fmt.Errorf("%q: MNC or MCC-MNC list expected", network)
+		}
+		if !pair {
+			filter.networks[uint16(mnc)] = true
+			continue
+		}
+		mcc, err := strconv.ParseUint(country, 10, 16)
+		if err != nil || mcc > 999 {
+			return filter, fmt.Errorf("%q: MNC or MCC-MNC list expected", network)
+		}
+		filter.operators[[2]uint16{uint16(mcc), uint16(mnc)}] = true
+	}
+	return filter, nil
+}
+
+// empty возвращает true, если фильтр не задан.
+func (f networkFilter) empty() bool {
+	return len(f.networks) == 0 && len(f.operators) == 0
+}
+
+// match возвращает true, если вышка оператора с указанными кодами страны и сети проходит фильтр.
+func (f networkFilter) match(mcc, mnc uint16) bool {
+	return f.empty() || f.networks[mnc] || f.operators[[2]uint16{mcc, mnc}]
+}
+
+// save сохраняет фильтр в сведениях о фильтрах импорта в отсортированном виде.
+func (f networkFilter) save(filters *lbs.ImportFilters) {
+	for mnc := range f.networks {
+		filters.Network = append(filters.Network, mnc)
+	}
+	sort.Slice(filters.Network, func(i, j int) bool { return filters.Network[i] < filters.Network[j] })
+	for operator := range f.operators {
+		filters.Operators = append(filters.Operators, fmt.Sprintf("%d-%d", operator[0], operator[1]))
+	}
+	sort.Strings(filters.Operators)
+}
+
+// cellFilter объединяет фильтры записей о вышках: по типу радио, стране, оператору, количеству
+// подтверждений, региону и времени последнего измерения. Пустые фильтры пропускают любые записи.
+type cellFilter struct {
+	radio      map[string]bool  // типы радио
+	country    map[uint16]bool  // коды стран
+	network    networkFilter    // операторы
+	minSamples int64            // минимальное количество подтверждений
+	area       *importer.Region // географический фильтр (nil - не задан)
+	seenAfter  time.Time        // минимальное время последнего измерения (см. -prune)
+}
+
+// matchRadio возвращает true, если тип радио из строки файла проходит фильтр. Тип радио
+// проверяется до разбора строки, чтобы не разбирать строки, которые все равно будут отброшены.
+func (f *cellFilter) matchRadio(radio string) bool {
+	return len(f.radio) == 0 || f.radio[lbs.NormalizeRadio(radio)]
+}
+
+// match возвращает true, если запись о вышке проходит остальные фильтры.
+func (f *cellFilter) match(cell lbs.Cell) bool {
+	switch {
+	case int64(cell.Samples) < f.minSamples:
+		return false // не импортируем данные с маленьким количеством подтверждений
+	case len(f.country) > 0 && !f.country[cell.MobileCountryCode]:
+		return false // игнорируем записи из других стран
+	case !f.network.match(cell.MobileCountryCode, cell.MobileNetworkCode):
+		return false // игнорируем записи других операторов
+	case f.area != nil && !f.area.Contains(cell.Location.Longitude(), cell.Location.Latitude()):
+		return false // игнорируем записи за пределами региона
+	case !f.seenAfter.IsZero() && stale(cell, f.seenAfter):
+		return false // игнорируем давно не подтверждавшиеся записи
+	}
+	return true
+}
+
+// stale возвращает true, если вышка не подтверждалась с момента after: так же, как lbs.PruneStale,
+// время последнего измерения заменяется временем первого, а записи без времени измерений
+// устаревшими не считаются.
+func stale(cell lbs.Cell, after time.Time) bool {
+	seen := cell.Updated
+	if seen.IsZero() {
+		seen = cell.Created
+	}
+	return !seen.IsZero() && seen.Before(after)
+}
+
+// recordStats учитывает типы радио и коды стран в прочитанных и импортированных записях. По ним
+// выводится список стран в импортированных данных, а если фильтры отбросили все записи, то и
+// список того, что было в файле: так сразу видно, что фильтр задан неверно.
+type recordStats struct {
+	radios    map[string]uint64 // количество прочитанных записей по типам радио
+	countries map[string]uint64 // количество прочитанных записей по кодам стран
+	imported  map[uint16]uint64 // количество импортированных записей по кодам стран
+}
+
+// newRecordStats возвращает пустую статистику записей.
+func newRecordStats() *recordStats {
+	return &recordStats{
+		radios:    make(map[string]uint64),
+		countries: make(map[string]uint64),
+		imported:  make(map[uint16]uint64),
+	}
+}
+
+// read учитывает прочитанную запись до применения фильтров.
+func (s *recordStats) read(radio, mcc string) {
+	s.radios[lbs.NormalizeRadio(radio)]++
+	s.countries[mcc]++
+}
+
+// add учитывает импортированную запись.
+func (s *recordStats) add(mcc uint16) {
+	s.imported[mcc]++
+}
+
+// importedCountries возвращает отсортированный список кодов стран импортированных записей.
+func (s *recordStats) importedCountries() []uint16 {
+	list := make([]uint16, 0, len(s.imported))
+	for mcc := range s.imported {
+		list = append(list, mcc)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// mergeCountries возвращает отсортированный список кодов стран из обоих списков без повторов.
+func mergeCountries(a, b []uint16) []uint16 {
+	seen := make(map[uint16]bool, len(a)+len(b))
+	list := make([]uint16, 0, len(a)+len(b))
+	for _, mcc := range append(append([]uint16(nil), a...), b...) {
+		if !seen[mcc] {
+			seen[mcc] = true
+			list = append(list, mcc)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// report выводит в журнал страны импортированных записей или, если ничего не импортировано, но
+// часть записей отброшена фильтрами, — предупреждение со списком типов радио и стран в файле.
+func (s *recordStats) report(info *lbs.ImportInfo) {
+	if len(s.imported) > 0 {
+		countries := s.importedCountries()
+		list := make([]string, len(countries))
+		for i, mcc := range countries {
+			list[i] = fmt.Sprintf("%d (%d)", mcc, s.imported[mcc])
+		}
+		logger.Info("Imported countries", "countries", strings.Join(list, ", "))
+		return
+	}
+	if info.Filtered == 0 {
+		return
+	}
+	logger.Warn("Filters excluded all records", "count", info.Filtered)
+	if len(s.radios) > 0 {
+		logger.Warn("File contains other radio types (set -radio)", "radio", topCounts(s.radios))
+	}
+	if len(s.countries) > 0 {
+		logger.Warn("File contains other countries (set -country or use -country=all)", "countries", topCounts(s.countries))
+	}
+}
+
+// maxReported ограничивает количество значений в предупреждении о фильтрах.
+const maxReported = 10
+
+// topCounts возвращает самые частые значения вместе с количеством записей в виде строки.
+func topCounts(counts map[string]uint64) string {
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if counts[values[i]] != counts[values[j]] {
+			return counts[values[i]] > counts[values[j]]
+		}
+		return values[i] < values[j]
+	})
+	list := make([]string, 0, maxReported+1)
+	for i, value := range values {
+		if i == maxReported {
+			list = append(list, fmt.Sprintf("and %d more", len(values)-maxReported))
+			break
+		}
+		list = append(list, fmt.Sprintf("%s (%d)", value, counts[value]))
+	}
+	return strings.Join(list, ", ")
+}
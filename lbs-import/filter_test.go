@@ -0,0 +1,151 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/lbs/v2"
+	"github.com/geotrace/lbs/v2/importer"
+)
+
+func TestResolveCountryFilter(t *testing.T) {
+	for _, test := range []struct {
+		value, filename string
+		download        bool
+		result          string
+	}{
+		{"auto", "250.csv.gz", false, "250"},
+		{"auto", "250.csv.gz", true, ""},
+		{"auto", "MLS-full-cell-export-2024-01-01T000000.csv.gz", false, ""},
+		{"auto", "cell_towers.csv.gz", false, ""},
+		{"all", "250.csv.gz", false, ""},
+		{"all", "cell_towers.csv.gz", false, ""},
+		{"", "250.csv.gz", false, ""},
+		{"262,310", "250.csv.gz", false, "262,310"},
+	} {
+		if result := resolveCountryFilter(test.value, test.filename, test.download); result != test.result {
+			t.Errorf("resolveCountryFilter(%q, %q, %t) = %q", test.value, test.filename, test.download, result)
+		}
+	}
+}
+
+func TestParseCountryFilter(t *testing.T) {
+	filter, err := parseCountryFilter(" 250, 262,,310 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filter) != 3 || !filter[250] || !filter[262] || !filter[310] {
+		t.Errorf("bad country filter: %v", filter)
+	}
+	if filter, err = parseCountryFilter(""); err != nil || len(filter) != 0 {
+		t.Errorf("empty country filter: %v, %v", filter, err)
+	}
+	for _, value := range []string{"all", "1000", "250,x", "-1"} {
+		if _, err := parseCountryFilter(value); err == nil {
+			t.Errorf("parseCountryFilter(%q): expected error", value)
+		}
+	}
+}
+
+func TestParseNetworkFilter(t *testing.T) {
+	filter, err := parseNetworkFilter(" 1, 250-2,,310-410, 32767")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		mcc, mnc uint16
+		match    bool
+	}{
+		{250, 1, true}, // код сети без страны допускается в любой стране
+		{262, 1, true},
+		{250, 2, true},  // пара кодов страны и сети
+		{262, 2, false}, // тот же код сети в другой стране
+		{310, 410, true},
+		{250, 410, false},
+		{490, 32767, true}, // номер системы CDMA
+		{250, 99, false},
+	} {
+		if match := filter.match(test.mcc, test.mnc); match != test.match {
+			t.Errorf("match(%d, %d) = %t", test.mcc, test.mnc, match)
+		}
+	}
+	var filters lbs.ImportFilters
+	filter.save(&filters)
+	if !reflect.DeepEqual(filters.Network, []uint16{1, 32767}) ||
+		!reflect.DeepEqual(filters.Operators, []string{"250-2", "310-410"}) {
+		t.Errorf("bad saved filters: %v, %v", filters.Network, filters.Operators)
+	}
+
+	if filter, err = parseNetworkFilter(""); err != nil || !filter.empty() || !filter.match(250, 1) {
+		t.Errorf("empty network filter: %v, %v", filter, err)
+	}
+	for _, value := range []string{"x", "-1", "250-", "250-x", "x-1", "1000-1", "32768", "250-2-3", "1;2"} {
+		if _, err := parseNetworkFilter(value); err == nil {
+			t.Errorf("parseNetworkFilter(%q): expected error", value)
+		}
+	}
+}
+
+func TestCellFilter(t *testing.T) {
+	network, err := parseNetworkFilter("250-1,2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	filter := &cellFilter{
+		radio:      map[string]bool{"gsm": true, "umts": true},
+		country:    map[uint16]bool{250: true, 262: true},
+		network:    network,
+		minSamples: 2,
+		area:       &importer.Region{Box: &[4]float64{30, 50, 40, 60}},
+		seenAfter:  seen,
+	}
+	for _, test := range []struct {
+		radio string
+		match bool
+	}{
+		{"GSM", true},
+		{" gsm ", true},
+		{"WCDMA", true}, // синоним umts
+		{"LTE", false},
+		{"", false},
+	} {
+		if match := filter.matchRadio(test.radio); match != test.match {
+			t.Errorf("matchRadio(%q) = %t", test.radio, match)
+		}
+	}
+	if !(&cellFilter{}).matchRadio("lte") || !(&cellFilter{}).match(lbs.Cell{}) {
+		t.Error("empty filter rejects records")
+	}
+
+	cell := func(mcc, mnc uint16, samples int, lon, lat float64, updated time.Time) lbs.Cell {
+		return lbs.Cell{
+			Key:     lbs.Key{RadioType: "gsm", MobileCountryCode: mcc, MobileNetworkCode: mnc},
+			Data:    lbs.Data{Location: geo.NewPoint(lon, lat)},
+			Samples: samples,
+			Updated: updated,
+		}
+	}
+	fresh := seen.AddDate(1, 0, 0)
+	for _, test := range []struct {
+		name  string
+		cell  lbs.Cell
+		match bool
+	}{
+		{"match", cell(250, 1, 5, 37.6, 55.7, fresh), true},
+		{"network without country", cell(262, 2, 5, 37.6, 55.7, fresh), true},
+		{"no update time", cell(250, 1, 5, 37.6, 55.7, time.Time{}), true},
+		{"few samples", cell(250, 1, 1, 37.6, 55.7, fresh), false},
+		{"other country", cell(255, 1, 5, 37.6, 55.7, fresh), false},
+		{"other network", cell(250, 3, 5, 37.6, 55.7, fresh), false},
+		{"operator of other country", cell(262, 1, 5, 37.6, 55.7, fresh), false},
+		{"outside region", cell(250, 1, 5, 20, 55.7, fresh), false},
+		{"stale", cell(250, 1, 5, 37.6, 55.7, seen.AddDate(-1, 0, 0)), false},
+	} {
+		if match := filter.match(test.cell); match != test.match {
+			t.Errorf("%s: match = %t", test.name, match)
+		}
+	}
+}
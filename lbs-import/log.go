@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// logger выводит сообщения программы. Сообщения содержат поля (например, количество записей или
+// название коллекции), поэтому журнал в формате JSON удобно разбирать системам сбора логов.
+var logger = slog.Default()
+
+// setupLogger настраивает вывод сообщений в w в формате text или json с минимальным уровнем
+// level (debug, info, warn или error).
+func setupLogger(w io.Writer, format, level string) error {
+	var min slog.Level
+	if err := min.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("bad log level %q: debug, info, warn or error expected", level)
+	}
+	opts := &slog.HandlerOptions{Level: min}
+	switch strings.ToLower(format) {
+	case "text":
+		logger = slog.New(slog.NewTextHandler(w, opts))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(w, opts))
+	default:
+		return fmt.Errorf("bad log format %q: text or json expected", format)
+	}
+	return nil
+}
@@ -1,21 +1,146 @@
 // Данная программа позволяет импортировать данные о координатах сотовых вышек, которые потом
 // используются для вычисления координат для LBS.
 //
-// 	Import LBS database data
-// 	./lbs-import [-params] datafile.csv
-// 	  -country string
-// 	    	filter for country (comma separated) (default "250")
-// 	  -minsample int
-// 	    	filter for min samples count
-// 	  -mongo string
-// 	    	mongoDB connection URL (default "mongodb://localhost/geotrace")
-// 	  -radio string
-// 	    	filter for radio (comma separated) (default "gsm")
+//	Import LBS database data
+//	./lbs-import [-params] datafile.csv|URL
+//	  -batch int
+//	    	records in one MongoDB bulk write (default 1000)
+//	  -bbox minlon,minlat,maxlon,maxlat
+//	    	filter for bounding box minlon,minlat,maxlon,maxlat
+//	  -country string
+//	    	filter for country (comma separated, "auto" - from file name, "all" - no filter) (default "auto")
+//	  -dataset-version string
+//	    	dataset version stored in import metadata (default - import date)
+//	  -errors file
+//	    	write rejected rows to CSV file
+//	  -force
+//	    	import file even if it was already imported
+//	  -format string
+//	    	output format: mongodb or snapshot (compact binary file for lbs.OpenSnapshot) (default "mongodb")
+//	  -geojson file
+//	    	filter for region: GeoJSON file with Polygon or MultiPolygon boundary
+//	  -log-format string
+//	    	log format: text or json (default "text")
+//	  -log-level string
+//	    	log level: debug, info, warn or error (default "info")
+//	  -max-errors int
+//	    	abort import if more than n rows are rejected (0 - no limit)
+//	  -max-lines int
+//	    	import no more than n data lines (0 - no limit)
+//	  -minsample int
+//	    	filter for min samples count
+//	  -mnc string
+//	    	filter for operator network code or mcc-mnc pair (comma separated, default - all operators)
+//	  -mode string
+//	    	import mode: replace, merge or diff (default - replace)
+//	  -mongo string
+//	    	mongoDB connection URL (default "mongodb://localhost/geotrace")
+//	  -output file
+//	    	snapshot file for -format=snapshot
+//	  -partitioned
+//	    	store data for each country in a separate collection
+//	  -prune years
+//	    	skip cells not updated for n years and remove them from DB (0 - keep all)
+//	  -radio string
+//	    	filter for radio: gsm, umts, lte, nr or cdma (comma separated) (default "gsm")
+//	  -resume
+//	    	continue interrupted import of the same file from the last checkpoint
+//	  -source string
+//	    	download latest dataset: opencellid or mozilla (instead of datafile)
+//	  -skip-lines int
+//	    	skip first n data lines
+//	  -strict
+//	    	abort import on the first malformed row
+//	  -token key
+//	    	OpenCellID API key for -source=opencellid
+//	  -type string
+//	    	data type: cell (aggregated cell table), measurement (raw measurements) or wifi (Wi-Fi access points) (default "cell")
+//	  -workers int
+//	    	parallel MongoDB bulk write workers (default 4)
+//
+// Кроме координат и радиуса действия для каждой вышки сохраняются количество подтверждений и время
+// первого и последнего измерения. Файл разбирается так же, как его записывает команда lbs-admin
+// extract -format csv (см. lbs.Cell), поэтому выгрузку можно загрузить обратно без потери полей.
 //
 // Т.к. импорт данных занимает некоторое время, в целях отладки можно указать фильтры, которые
 // будут применены при импорте данных. В этом случае база будет содержать только те данные, которые
 // подпадают под данный фильтр. В качестве фильтра можно указывать список типов радио-вышек и кодов
-// стран, разделенные запятой, а так же количество подтверждений данных.
+// стран, разделенные запятой, а так же количество подтверждений данных. Фильтр -mnc оставляет
+// только вышки указанных операторов: например, виртуальному оператору достаточно вышек сети, в
+// которой он работает (-country=250 -mnc=1). Один и тот же код сети в разных странах принадлежит
+// разным операторам, поэтому оператора можно указать и парой кодов страны и сети: -mnc=250-1,255-3
+// оставляет вышки только этих двух операторов, даже если импортируются все страны.
+//
+// Вышки CDMA (-radio=cdma) импортируются из тех же колонок: номер системы (SID) записан в колонке
+// net, номер сети (NID, допустим и нулевой) — в area, а номер базовой станции (BID) — в cell (см.
+// lbs.CDMAKey). Фильтр -mnc для них принимает номера систем до 32767.
+//
+// Фильтры -bbox и -geojson оставляют только вышки внутри прямоугольника или многоугольников
+// (Polygon, MultiPolygon, а также объекты Feature и FeatureCollection с ними) из файла GeoJSON:
+// так из полной выгрузки можно собрать компактную базу для одного города или региона. Вышки в
+// отверстиях многоугольников отбрасываются. Если заданы оба фильтра, то вышка должна попадать и в
+// прямоугольник, и в один из многоугольников.
+//
+// По умолчанию фильтр по стране определяется из имени файла: OpenCellID называет выгрузки по
+// отдельным странам по коду страны (например, 250.csv.gz). Если имя файла не содержит кода
+// страны, то импортируются данные по всем странам; то же самое явно задает -country=all. После
+// чтения файла выводится список стран в импортированных данных (он сохраняется и в метаданных
+// импорта), а если фильтры отбросили все записи — предупреждение со списком типов радио и стран,
+// которые есть в файле.
+//
+// Параметры -skip-lines и -max-lines позволяют импортировать только часть файла: например, для
+// тестирования или для разделения большого файла между несколькими параллельно запущенными
+// процессами импорта. Строка с заголовком CSV при этом не учитывается. При импорте части файла
+// старые данные из базы не удаляются.
+//
+// Строки с ошибками в данных пропускаются при импорте. Если указан параметр -errors, то такие
+// строки вместе с номером строки и причиной ошибки записываются в отдельный CSV-файл: это
+// позволяет проанализировать качество данных и сообщить об ошибках в OpenCellID. Код зоны и
+// идентификатор вышки могут занимать до 24 и 36 бит (TAC и NCI сетей 5G NR).
+//
+// Для проверенных файлов можно указать параметр -strict: в этом случае импорт прерывается на первой
+// же строке с ошибкой. Параметр -max-errors задает допустимое количество строк с ошибками, при
+// превышении которого импорт тоже прерывается. При полном импорте данные в базе в обоих случаях не
+// изменяются, а при обновлении уже сохраненные пакеты записей остаются в базе.
+//
+// Программа завершается с кодом 0 только после успешного импорта. Ошибки, прерванный или
+// отмененный импорт завершают ее с кодом 1, а неверные параметры — с кодом 2, поэтому скрипты и
+// задания cron могут отличить неудачный импорт от успешного.
+//
+// Контрольная сумма SHA-256 каждого успешно импортированного файла сохраняется в коллекции
+// метаданных. Повторный импорт того же файла (например, одного и того же обновления, запущенного
+// по расписанию дважды) пропускается, если не указан параметр -force. Вместе с контрольной суммой
+// сохраняются имя файла, версия набора данных (параметр -dataset-version), время импорта,
+// примененные фильтры и количество обработанных записей: по этим данным всегда можно определить,
+// какие данные находятся в базе и откуда они были получены.
+//
+// Параметр -partitioned включает режим, в котором данные по каждой стране хранятся в отдельной
+// коллекции (см. lbs.Partitioned). Индексы создаются для каждой такой коллекции, а таблица
+// распределения стран по коллекциям обновляется в конце импорта.
+//
+// Параметр -prune отбрасывает записи о вышках, которые не подтверждались указанное количество лет
+// (по времени последнего измерения, а если оно не задано — первого), и после импорта в режиме
+// merge или diff удаляет такие записи из базы (см. lbs.DB.PruneStale). Записи без времени
+// измерений сохраняются.
+//
+// После полного импорта заново вычисляются радиусы действия вышек по умолчанию для каждого типа
+// радио и страны (см. lbs.DB.LearnAccuracy).
+//
+// Вышки из списка заблокированных (см. lbs.BlockedCell) импортируются с пометкой blocked и не
+// используются при вычислении координат, поэтому обновление базы не возвращает их в работу.
+//
+// Кроме агрегированной таблицы сотовых вышек можно импортировать исходные измерения OpenCellID
+// (параметр -type=measurement). Измерения добавляются в отдельную коллекцию
+// lbs.ObservationsCollectionName и могут быть использованы для самостоятельного вычисления
+// координат вышек. Колонки файла с измерениями определяются по его заголовку.
+//
+// Данные о точках доступа Wi-Fi (параметр -type=wifi) импортируются в коллекцию
+// lbs.WifiCollectionName и используются при вычислении координат вместе с данными о вышках. Файл
+// должен содержать колонки mac (или bssid, key), lon и lat, а также может содержать колонки range
+// (radius) и samples. Групповые и локально администрируемые MAC-адреса отклоняются, а сети с
+// суффиксом _nomap и названиями мобильных точек доступа по умолчанию пропускаются. Выгрузка WiGLE
+// (WigleWifi-1.x) содержит отдельные измерения: они объединяются по MAC-адресу, а точки доступа,
+// замеченные в местах дальше 5 км друг от друга, пропускаются как мобильные.
 //
 // Данные в формате CSV можно загрузить с сервера http://opencellid.org/#action=database.downloadDatabase.
 // Для загрузки необходимо будет использовать API key, который необходимо будет получить.
@@ -23,230 +148,677 @@
 // Кроме этого, базу можно скачать с сервера https://location.services.mozilla.com/downloads —
 // эти данные несколько больше и актуальнее, чем предлагает OpenCellId.
 //
-// Если в имени файла есть строка `diff`, то программа только добавляет новые данные из файла. В
-// противном случае, база сначала очищается, а потом идет импорт новых данных.
+// Вместо имени файла можно указать адрес http:// или https://: файл импортируется по мере
+// загрузки, без сохранения на диск, а данные, сжатые gzip, распаковываются на лету. Параметр
+// -source подставляет адрес последней полной выгрузки Mozilla Location Service (mozilla) или
+// OpenCellID (opencellid, с ключом API из параметра -token). Для загружаемых файлов повторный
+// импорт определяется по адресу и заголовкам ETag, Last-Modified и Content-Length ответа сервера.
+//
+// Сжатые файлы распаковываются по мере чтения: файлы gzip (.gz) — и локальные, и загружаемые, а
+// архивы zip (.zip) с единственным файлом CSV — только локальные. Формат определяется по
+// расширению имени файла или по первым байтам данных.
+//
+// Записи о вышках сохраняются в базу по мере чтения файла пакетами по -batch записей в -workers
+// параллельных потоков, поэтому в памяти не накапливается весь файл целиком. При полном импорте
+// записи загружаются во временные коллекции, которые заменяют старые данные только после чтения
+// всего файла.
+//
+// Импорт можно отменить сигналом прерывания (Ctrl+C) или SIGTERM, пока файл читается: в этом
+// случае временные коллекции удаляются и данные в базе не изменяются, а запись об импорте
+// удаляется, поэтому тот же файл можно импортировать заново. Когда временные коллекции начинают
+// заменять старые данные, отмена уже не действует (повторный сигнал завершает программу сразу).
+// Сигнал SIGUSR1 приостанавливает импорт, а повторный SIGUSR1 — продолжает его. При обновлении
+// (merge и diff), импорте части файла и импорте исходных измерений (-type=measurement) уже сохраненные
+// пакеты при отмене или ошибке остаются в базе.
+//
+// Каждые миллион строк файла программа дожидается записи всех прочитанных записей и сохраняет
+// номер строки в записи об импорте. Если импорт прервался из-за ошибки или сбоя процесса (но не
+// был отменен сигналом), то запись об импорте и временные коллекции сохраняются, а запуск с тем
+// же файлом, режимом и фильтрами и параметром -resume продолжает импорт со следующей строки.
+//
+// Сообщения о ходе импорта выводятся в stdout в виде структурированного журнала (log/slog):
+// кроме текста сообщение содержит поля, например количество записей, название коллекции или
+// номер строки. Параметр -log-format=json выводит журнал в формате JSON для систем сбора логов, а
+// -log-level задает минимальный уровень сообщений (например, warn оставляет только
+// предупреждения об отклоненных строках и ошибки).
+//
+// Параметр -format=snapshot вместо импорта в MongoDB собирает записи о вышках, прошедшие фильтры,
+// в компактный двоичный снимок -output (см. lbs.SnapshotWriter), который lbs.OpenSnapshot
+// открывает мгновенно и без MongoDB: так данные для периферийных узлов готовятся одной командой.
+// Снимок записывается во временный файл, который заменяет -output только после успешной записи.
+// Метаданные импорта и список заблокированных вышек при этом не используются.
+//
+// Режим импорта задается параметром -mode. В режиме replace (по умолчанию) данные из файла
+// полностью заменяют старые; сохраняются только площадки вышек, найденные lbs-admin dedup. В
+// режиме merge записи из файла добавляются к данным в базе и
+// заменяют записи о тех же вышках, а в режиме diff запись о вышке заменяется, только если время
+// ее последнего измерения в файле не раньше, чем в базе (для этого нужен MongoDB 4.2 или новее).
+// Раньше режим обновления выбирался по строке `diff` в имени файла, поэтому такие файлы без
+// явного -mode не импортируются. Часть файла (-skip-lines, -max-lines) импортируется как merge
+// или diff.
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"flag"
 	"fmt"
 	"io"
-	"log"
 	"os"
-	"strconv"
+	"reflect"
+	"sort"
 	"strings"
+	"time"
 
-	"github.com/geotrace/geo"
-	"github.com/geotrace/lbs"
-	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	"github.com/geotrace/lbs/v2"
+	"github.com/geotrace/lbs/v2/importer"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
 )
 
+// checkpointLines задает, через сколько строк файла сохраняется состояние импорта для -resume.
+const checkpointLines = 1000000
+
 func main() {
-	log.SetOutput(os.Stdout)
-	log.SetFlags(log.Ltime)
+	os.Exit(run())
+}
+
+// run выполняет импорт и возвращает код завершения программы: 0 при успешном импорте, 1 при
+// ошибке или прерванном импорте и 2 при неверных параметрах. Отложенные функции (удаление
+// временных коллекций, запись отчета об ошибках) выполняются до выхода из программы.
+func run() int {
 	mongourl := flag.String("mongo", "mongodb://localhost/geotrace", "mongoDB connection URL")
-	radiofilter := flag.String("radio", "gsm", "filter for radio (comma separated)")
-	countryfilter := flag.String("country", "250", "filter for country (comma separated)")
+	radiofilter := flag.String("radio", "gsm", "filter for radio: gsm, umts, lte, nr or cdma (comma separated)")
+	countryfilter := flag.String("country", "auto", "filter for country (comma separated, \"auto\" - from file name, \"all\" - no filter)")
+	mncfilter := flag.String("mnc", "", "filter for operator network code or mcc-mnc pair (comma separated, default - all operators)")
 	minSamples := flag.Int64("minsample", 0, "filter for min samples count")
+	bboxfilter := flag.String("bbox", "", "filter for bounding box `minlon,minlat,maxlon,maxlat`")
+	geojsonfilter := flag.String("geojson", "", "filter for region: GeoJSON `file` with Polygon or MultiPolygon boundary")
+	prune := flag.Int("prune", 0, "skip cells not updated for n `years` and remove them from DB (0 - keep all)")
+	skipLines := flag.Uint64("skip-lines", 0, "skip first n data lines")
+	maxLines := flag.Uint64("max-lines", 0, "import no more than n data lines (0 - no limit)")
+	errorsFile := flag.String("errors", "", "write rejected rows to CSV `file`")
+	strict := flag.Bool("strict", false, "abort import on the first malformed row")
+	maxErrors := flag.Uint64("max-errors", 0, "abort import if more than n rows are rejected (0 - no limit)")
+	force := flag.Bool("force", false, "import file even if it was already imported")
+	resume := flag.Bool("resume", false, "continue interrupted import of the same file from the last checkpoint")
+	mode := flag.String("mode", "", "import mode: replace, merge or diff (default - replace)")
+	partitioned := flag.Bool("partitioned", false, "store data for each country in a separate collection")
+	dataType := flag.String("type", "cell", "data type: cell (aggregated cell table), measurement (raw measurements) or wifi (Wi-Fi access points)")
+	version := flag.String("dataset-version", "", "dataset version stored in import metadata (default - import date)")
+	workers := flag.Int("workers", 4, "parallel MongoDB bulk write workers")
+	batchSize := flag.Int("batch", 1000, "records in one MongoDB bulk write")
+	sourceFlag := flag.String("source", "", "download latest dataset: opencellid or mozilla (instead of datafile)")
+	token := flag.String("token", "", "OpenCellID API `key` for -source=opencellid")
+	format := flag.String("format", "mongodb", "output format: mongodb or snapshot (compact binary file for lbs.OpenSnapshot)")
+	output := flag.String("output", "", "snapshot `file` for -format=snapshot")
+	logFormat := flag.String("log-format", "text", "log format: text or json")
+	logLevel := flag.String("log-level", "info", "log level: debug, info, warn or error")
 	flag.Usage = func() {
 		fmt.Fprint(os.Stderr, "Import LBS database data\n")
-		fmt.Fprintf(os.Stderr, "%s [-params] datafile.csv\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "%s [-params] datafile.csv|URL\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
-	if flag.NArg() != 1 {
+	if (flag.NArg() != 1) == (*sourceFlag == "") || *workers < 1 || *batchSize < 1 {
 		flag.Usage()
-		return
+		return 2
+	}
+	if err := setupLogger(os.Stdout, *logFormat, *logLevel); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		return 2
 	}
 	filename := flag.Arg(0)
+	if *dataType != "cell" && *dataType != "measurement" && *dataType != "wifi" {
+		logger.Error("Unsupported data type", "type", *dataType)
+		return 2
+	}
+	switch *mode {
+	case "", "replace", "merge", "diff":
+	default:
+		logger.Error("Unsupported import mode: replace, merge or diff expected", "mode", *mode)
+		return 2
+	}
+	partial := *skipLines > 0 || *maxLines > 0
+	if partial && *mode == "replace" {
+		logger.Error("Partial import (-skip-lines, -max-lines) can't replace all data: use -mode=merge or -mode=diff")
+		return 2
+	}
+	// раньше режим определялся по имени файла, поэтому такие файлы требуют явного указания режима
+	if *mode == "" && !partial && strings.Contains(importer.SourceName(filename), "diff") {
+		logger.Error("File looks like an update: set -mode=diff or -mode=merge to update data "+
+			"or -mode=replace to replace all data", "file", importer.SourceName(filename))
+		return 2
+	}
+	if *mode == "" {
+		*mode = "replace"
+	}
+	if (*bboxfilter != "" || *geojsonfilter != "") && *dataType != "cell" {
+		logger.Error("Region filters (-bbox, -geojson) are supported only for cell import (-type=cell)")
+		return 2
+	}
+	if *prune < 0 || *prune > 0 && *dataType != "cell" {
+		logger.Error("Pruning stale cells (-prune) requires positive years and cell import (-type=cell)")
+		return 2
+	}
+	if *resume && (*force || *dataType != "cell") {
+		logger.Error("Resume is supported only for cell import (-type=cell) without -force")
+		return 2
+	}
+	switch *format {
+	case "mongodb":
+	case "snapshot":
+		if *output == "" || *dataType != "cell" || *resume {
+			logger.Error("Snapshot (-format=snapshot) requires -output file and cell import (-type=cell) without -resume")
+			return 2
+		}
+	default:
+		logger.Error("Unsupported output format: mongodb or snapshot expected", "format", *format)
+		return 2
+	}
 
-	mdi, err := mgo.ParseURL(*mongourl)
+	// определяем фильтр по стране из имени файла выгрузки
+	auto := *countryfilter == "auto"
+	*countryfilter = resolveCountryFilter(*countryfilter, importer.SourceName(filename), *sourceFlag != "")
+	switch {
+	case auto && *countryfilter != "":
+		logger.Info("Country filter from file name", "mcc", *countryfilter)
+	case auto:
+		logger.Info("No country code in file name: importing all countries (use -country to filter)")
+	}
+	if *sourceFlag != "" {
+		var err error
+		if filename, err = importer.SourceURL(*sourceFlag, *token, *countryfilter, time.Now()); err != nil {
+			logger.Error("Bad dataset source", "error", err)
+			return 2
+		}
+		logger.Info("Downloading", "url", importer.RedactURL(filename))
+	}
+
+	imported := &lbs.ImportInfo{
+		Source:  importer.RedactURL(filename),
+		Version: *version,
+		Mode:    *mode,
+	}
+	if partial {
+		imported.Mode = "partial"
+	}
+	if imported.Version == "" {
+		imported.Version = time.Now().UTC().Format("20060102T150405Z")
+	}
+
+	// разбираем фильтры и формируем соответствующие справочники
+	filterRadio := make(map[string]bool)
+	for _, radio := range strings.Split(*radiofilter, ",") {
+		if radio = lbs.NormalizeRadio(radio); radio == "" {
+			continue
+		}
+		if !supportedRadio(radio) {
+			logger.Error("Bad radio filter", "radio", radio, "expected", strings.Join(lbs.RadioTypes, ", "))
+			return 2
+		}
+		filterRadio[radio] = true
+	}
+	filterCountry, err := parseCountryFilter(*countryfilter)
 	if err != nil {
-		log.Printf("Error parse MongoDB URL: %v", err)
-		return
+		logger.Error("Bad country filter", "error", err)
+		return 2
 	}
-	// устанавливаем соединение с сервером MongoDB
-	log.Printf("Connecting to MongoDB %q...", *mongourl)
-	mdb, err := mgo.DialWithInfo(mdi)
+	filterNetwork, err := parseNetworkFilter(*mncfilter)
 	if err != nil {
-		log.Printf("Error connecting to MongoDB: %v", err)
-		return
+		logger.Error("Bad operator filter", "error", err)
+		return 2
+	}
+	var area *importer.Region // географический фильтр
+	if *bboxfilter != "" || *geojsonfilter != "" {
+		area = new(importer.Region)
+	}
+	if *bboxfilter != "" {
+		if area.Box, err = importer.ParseBox(*bboxfilter); err != nil {
+			logger.Error("Bad bounding box filter", "error", err)
+			return 2
+		}
+		imported.Filters.Box = area.Box[:]
+	}
+	if *geojsonfilter != "" {
+		if area.Polygons, err = importer.LoadPolygons(*geojsonfilter); err != nil {
+			logger.Error("Bad region filter", "error", err)
+			return 2
+		}
+		imported.Filters.Region = *geojsonfilter
+	}
+	for radio := range filterRadio {
+		imported.Filters.Radio = append(imported.Filters.Radio, radio)
+	}
+	for mcc := range filterCountry {
+		imported.Filters.Country = append(imported.Filters.Country, mcc)
+	}
+	filterNetwork.save(&imported.Filters)
+	// фильтры сортируются, чтобы их можно было сравнить с фильтрами прерванного импорта
+	sort.Strings(imported.Filters.Radio)
+	sort.Slice(imported.Filters.Country, func(i, j int) bool { return imported.Filters.Country[i] < imported.Filters.Country[j] })
+	imported.Filters.MinSamples = *minSamples
+	imported.Filters.Prune = *prune
+	imported.Filters.SkipLines = *skipLines
+	imported.Filters.MaxLines = *maxLines
+	if len(filterRadio) > 0 || len(filterCountry) > 0 || !filterNetwork.empty() {
+		logger.Info("Filters",
+			"country", strings.Join(strings.Split(*countryfilter, ","), ", "),
+			"mnc", strings.Join(strings.Split(*mncfilter, ","), ", "),
+			"radio", strings.Join(strings.Split(*radiofilter, ","), ", "))
+	}
+	if area != nil {
+		logger.Info("Region filters", "bbox", *bboxfilter, "region", *geojsonfilter, "polygons", len(area.Polygons))
+	}
+	filter := &cellFilter{
+		radio:      filterRadio,
+		country:    filterCountry,
+		network:    filterNetwork,
+		minSamples: *minSamples,
+		area:       area,
+	}
+	if *prune > 0 {
+		filter.seenAfter = time.Now().AddDate(-*prune, 0, 0)
+		logger.Info("Pruning stale cells", "before", filter.seenAfter.Format(time.DateOnly))
 	}
-	defer mdb.Close()
 
-	coll := mdb.DB(mdi.Database).C(lbs.CollectionName)
-	err = coll.EnsureIndex(mgo.Index{
-		Key:      []string{"radio", "mcc", "mnc", "lac", "cell"},
-		Unique:   true,
-		DropDups: true,
-	})
+	report, err := newErrorReport(*errorsFile)
 	if err != nil {
-		log.Printf("Error index in MongoDB: %v", err)
-		return
+		logger.Error("Error creating errors report file", "error", err)
+		return 1
+	}
+	defer func() {
+		if err := report.Close(); err != nil {
+			logger.Error("Error writing errors report file", "error", err)
+		}
+	}()
+	switch {
+	case *strict:
+		report.limit = 0
+	case *maxErrors > 0:
+		report.limit = int64(*maxErrors)
 	}
 
-	bulk := coll.Bulk()
-	bulk.Unordered()
+	// снимок собирается без MongoDB
+	if *format == "snapshot" {
+		im := newImportJob(context.Background())
+		watchSignals(im)
+		logger.Info("Reading data from CSV...", "file", importer.RedactURL(filename))
+		if err := importSnapshot(im, filename, *output, filter, report, *skipLines, *maxLines, imported); err != nil {
+			logger.Error("Error writing snapshot", "error", err)
+			return 1
+		}
+		return 0
+	}
 
-	// разбираем фильтры и формируем соответствующие справочники
-	var (
-		filterRadio   = make(map[string]bool)
-		filterCountry = make(map[uint16]bool)
-	)
-	for _, radio := range strings.Split(*radiofilter, ",") {
-		filterRadio[strings.ToLower(strings.TrimSpace(radio))] = true
+	cs, err := connstring.Parse(*mongourl)
+	if err != nil {
+		logger.Error("Error parse MongoDB URL", "error", err)
+		return 1
 	}
-	for _, country := range strings.Split(*countryfilter, ",") {
-		mcc, err := strconv.ParseUint(country, 10, 16)
+	// после начала записи данных о вышках отмена импорта уже не действует, поэтому запросы к
+	// MongoDB выполняются без отмены
+	ctx := context.Background()
+	// устанавливаем соединение с сервером MongoDB
+	logger.Info("Connecting to MongoDB...", "url", *mongourl)
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(*mongourl).SetRegistry(lbs.Registry))
+	if err != nil {
+		logger.Error("Error connecting to MongoDB", "error", err)
+		return 1
+	}
+	defer client.Disconnect(ctx)
+	mdb := client.Database(cs.Database)
+	// выводим сведения о сервере, чтобы сразу было видно, куда именно импортируются данные
+	db, err := lbs.InitDB(ctx, client, cs.Database, lbs.WithLogger(logger))
+	if err != nil {
+		logger.Error("Error connecting to MongoDB", "error", err)
+		return 1
+	}
+	server, err := db.Ping(ctx)
+	db.Close()
+	if err != nil {
+		logger.Error("Error connecting to MongoDB", "error", err)
+		return 1
+	}
+	logger.Info("Connected", "server", server.String())
+
+	// проверяем, что этот файл еще не импортировался: иначе одновременно запущенные
+	// процессы импорта могут применить одно и то же обновление дважды
+	logger.Info("Calculating checksum...", "file", importer.RedactURL(filename))
+	checksum, err := importer.SourceID(ctx, filename)
+	if err != nil {
+		logger.Error("Error reading file", "error", err)
+		return 1
+	}
+	imported.ID, imported.SHA256 = checksum, checksum
+	if partial {
+		imported.ID = fmt.Sprintf("%s:%d-%d", checksum, *skipLines, *maxLines)
+	}
+	blocklist, err := loadBlocklist(ctx, mdb)
+	if err != nil {
+		logger.Error("Error loading blocklist", "error", err)
+		return 1
+	}
+
+	meta := mdb.Collection(lbs.MetaCollectionName)
+	if *resume {
+		interrupted, err := findInterrupted(ctx, meta, imported.ID)
 		if err != nil {
-			continue
+			logger.Error("Error loading import metadata", "error", err)
+			return 1
+		}
+		if interrupted == nil {
+			logger.Error("No interrupted import to resume", "file", importer.RedactURL(filename), "sha256", checksum)
+			return 1
+		}
+		if interrupted.Mode != imported.Mode || !reflect.DeepEqual(interrupted.Filters, imported.Filters) {
+			logger.Error("Interrupted import used other mode or filters: run it again with the same parameters")
+			return 1
+		}
+		if *version != "" {
+			interrupted.Version = *version
+		}
+		*imported = *interrupted
+		logger.Info("Resuming import", "line", imported.Checkpoint)
+	} else {
+		claimed, err := claimImport(ctx, meta, imported, *force)
+		if err != nil {
+			logger.Error("Error saving import metadata", "error", err)
+			return 1
+		}
+		if !claimed {
+			if interrupted, err := findInterrupted(ctx, meta, imported.ID); err == nil && interrupted != nil {
+				logger.Error("Import was interrupted. Use -resume to continue or -force to start again",
+					"file", importer.RedactURL(filename), "line", interrupted.Checkpoint)
+				return 1
+			}
+			logger.Error("File already imported. Use -force to import again", "file", importer.RedactURL(filename), "sha256", checksum)
+			return 1
 		}
-		filterCountry[uint16(mcc)] = true
-	}
-	if len(filterRadio) > 0 || len(filterCountry) > 0 {
-		log.Printf("Filters country - %q, radio - %q",
-			strings.Join(strings.Split(*countryfilter, ","), ", "),
-			strings.Join(strings.Split(*radiofilter, ","), ", "))
 	}
+	// если импорт не завершился успешно, то удаляем запись о нем, кроме импорта, который можно
+	// продолжить с сохраненного места
+	var resumable bool
+	defer func() {
+		if imported.Status == lbs.ImportDone || resumable {
+			return
+		}
+		if err := releaseImport(ctx, meta, imported); err != nil {
+			logger.Error("Error deleting import metadata", "error", err)
+		}
+	}()
+
+	// импорт можно отменить или приостановить сигналами
+	im := newImportJob(ctx)
+	watchSignals(im)
 
-	log.Printf("Reading data from CSV %q...", filename)
-	file, err := os.Open(filename)
+	logger.Info("Reading data from CSV...", "file", importer.RedactURL(filename))
+	file, err := importer.Open(im.ctx, filename)
 	if err != nil {
-		log.Printf("Error opening CSV file: %v", err)
-		return
+		logger.Error("Error opening CSV file", "error", err)
+		return 1
 	}
 	defer file.Close()
+	// для загруженных данных сохраняем контрольную сумму того, что было фактически прочитано
+	complete := func() error {
+		if importer.IsURL(filename) {
+			imported.SHA256 = file.SHA256()
+		}
+		return completeImport(ctx, meta, imported)
+	}
+
+	report.count = imported.Rejected
 
-	var counter, lines uint64 // счетчики
+	stats := newRecordStats()
+
+	// точки доступа Wi-Fi хранятся в отдельной коллекции
+	if *dataType == "wifi" {
+		logger.Info("Importing Wi-Fi access points...")
+		err := importWifi(im, csv.NewReader(file), mdb.Collection(lbs.WifiCollectionName),
+			report, *minSamples, imported)
+		if err != nil {
+			logger.Error("Error importing Wi-Fi access points", "error", err)
+			return 1
+		}
+		logger.Info("Imported Wi-Fi access points", "count", imported.Imported)
+		if err := complete(); err != nil {
+			logger.Error("Error saving import metadata", "error", err)
+			return 1
+		}
+		return 0
+	}
+
+	// исходные измерения загружаются в отдельную коллекцию и обрабатываются по-другому
+	if *dataType == "measurement" {
+		imported.Mode = "observations"
+		err := importMeasurements(im, csv.NewReader(file), mdb.Collection(lbs.ObservationsCollectionName),
+			report, filterRadio, filterCountry, filterNetwork, stats, imported)
+		if err != nil {
+			logger.Error("Error importing measurements", "error", err)
+			return 1
+		}
+		logger.Info("Imported measurements", "count", imported.Imported)
+		if err := complete(); err != nil {
+			logger.Error("Error saving import metadata", "error", err)
+			return 1
+		}
+		return 0
+	}
+
+	// записи о вышках сохраняются пакетами в несколько потоков по мере чтения файла; при полном
+	// импорте — во временные коллекции, которые удаляются, если импорт не завершился и его нельзя
+	// продолжить
+	writer := newWriter(im.ctx, *workers)
+	collections := newTargets(mdb, *partitioned, imported.Mode == "replace", *mode == "diff", writer, *batchSize)
+	defer func() {
+		if imported.Status == lbs.ImportDone {
+			return
+		}
+		writer.close()
+		if imported.Checkpoint > 0 && im.ctx.Err() == nil {
+			resumable = true
+			logger.Info("Records up to checkpoint are saved. Use -resume to continue import", "line", imported.Checkpoint)
+			return
+		}
+		collections.discard(ctx)
+	}()
+	if *resume {
+		if err := collections.restore(ctx, imported.Countries); err != nil {
+			logger.Error("Error index in MongoDB", "error", err)
+			return 1
+		}
+	}
+
+	var lines, blocked uint64 // счетчики
+	counter, filtered := imported.Imported, imported.Filtered
+	// checkpoint дожидается записи всех прочитанных записей и сохраняет в метаданных импорта номер
+	// последней обработанной строки файла, с которой импорт можно продолжить (-resume)
+	checkpoint := func(line uint64) error {
+		if err := collections.sync(); err != nil {
+			return err
+		}
+		imported.Checkpoint = line
+		imported.Imported, imported.Filtered, imported.Rejected = counter, filtered, report.count
+		imported.Countries = mergeCountries(imported.Countries, stats.importedCountries())
+		return saveCheckpoint(ctx, meta, imported)
+	}
 	r := csv.NewReader(file)
 	for {
+		if report.exceeded() {
+			break
+		}
+		if line := lines - 1; lines > 1 && line > imported.Checkpoint && line%checkpointLines == 0 {
+			if err := checkpoint(line); err != nil {
+				fmt.Fprintln(os.Stderr, "")
+				logger.Error("Error saving import checkpoint", "error", err)
+				return 1
+			}
+		}
+		if err := im.wait(); err != nil {
+			fmt.Fprintln(os.Stderr, "")
+			if collections.replace {
+				logger.Warn("Import cancelled. Data in DB is not changed", "lines", imported.Lines)
+			} else {
+				logger.Warn("Import cancelled. Already saved records remain in DB", "lines", imported.Lines)
+			}
+			return 1
+		}
 		record, err := r.Read()
 		if err == io.EOF {
 			break
 		}
-		if err != nil {
-			log.Printf("Error parsing CSV file: %v", err)
-			return
+		badFields := false
+		if perr, ok := err.(*csv.ParseError); ok && perr.Err == csv.ErrFieldCount {
+			badFields = true // строка будет отклонена после проверки диапазона импорта
+		} else if err != nil {
+			logger.Error("Error parsing CSV file", "error", err)
+			return 1
 		}
 		lines++
 		if lines == 1 {
 			r.FieldsPerRecord = len(record) // устанавливаем количество полей
 			continue                        // пропускаем первую строку с заголовком в CSV-файле
 		}
-		fmt.Fprintf(os.Stderr, "\r* find %8d | skipped %8d records ", counter, lines-1-counter)
-
-		radio := strings.ToLower(record[0])
-		if len(filterRadio) > 0 && !filterRadio[radio] {
-			continue // игнорируем записи с неподдерживаемым типом радио
+		if lines-1 <= *skipLines {
+			continue // пропускаем строки до начала импортируемой части файла
 		}
-		samples, err := strconv.ParseInt(record[9], 10, 32)
-		if err != nil {
-			log.Printf("[%d] bad Samples: %s", lines, record[9])
-			continue
+		if lines-1 <= imported.Checkpoint {
+			continue // пропускаем строки, уже импортированные до прерывания
 		}
-		if samples < *minSamples {
-			continue // не импортируем данные с маленьким количеством подтверждений
+		if *maxLines > 0 && lines-1 > *skipLines+*maxLines {
+			break // импортируемая часть файла закончилась
 		}
-		mcc, err := strconv.ParseUint(record[1], 10, 16)
-		if err != nil {
-			log.Printf("[%d] bad MCC: %s", lines, record[1])
+		imported.Lines++
+		im.update(imported.Lines, counter)
+		fmt.Fprintf(os.Stderr, "\r* find %8d | skipped %8d records ", counter, imported.Lines-counter)
+		if badFields {
+			report.reject(lines, record, "bad fields count: %d", len(record))
 			continue
 		}
-		if len(filterCountry) > 0 && !filterCountry[uint16(mcc)] {
+
+		stats.read(record[0], record[1])
+		if !filter.matchRadio(record[0]) {
+			filtered++
 			continue // игнорируем записи с неподдерживаемым типом радио
 		}
-		mnc, err := strconv.ParseUint(record[2], 10, 16)
-		if err != nil {
-			log.Printf("[%d] bad MNC: %s", lines, record[2])
+		var doc lbs.Cell
+		if err := doc.UnmarshalCSV(record); err != nil {
+			report.reject(lines, record, "%v", err)
 			continue
 		}
-		area, err := strconv.ParseUint(record[3], 10, 16)
-		if err != nil {
-			log.Printf("[%d] bad Area: %s", lines, record[3])
+		if !filter.match(doc) {
+			filtered++
 			continue
 		}
-		cell, err := strconv.ParseUint(record[4], 10, 32)
-		if err != nil {
-			log.Printf("[%d] bad Cell: %s", lines, record[4])
+		key := doc.Key
+		if !key.Valid() {
+			report.reject(lines, record, "reserved Area or Cell: %d, %d", key.LocationAreaCode, key.CellId)
 			continue
 		}
-		lon, err := strconv.ParseFloat(record[6], 64)
+
+		target, err := collections.get(ctx, key.MobileCountryCode)
 		if err != nil {
-			log.Printf("[%d] bad longitude:", lines, record[6])
-			continue
+			logger.Error("Error index in MongoDB", "error", err)
+			return 1
 		}
-		lat, err := strconv.ParseFloat(record[7], 64)
-		if err != nil {
-			log.Printf("[%d] bad latitude:", lines, record[7])
-			continue
+		if doc.Blocked = blocklist[key]; doc.Blocked {
+			blocked++
 		}
-		distance, err := strconv.ParseFloat(record[8], 64)
-		if err != nil {
-			log.Printf("[%d] bad range:", lines, record[8])
-			continue
+		if err := collections.upsert(target, doc); err != nil {
+			fmt.Fprintln(os.Stderr, "")
+			logger.Error("MongoDB bulk write error", "error", err)
+			return 1
 		}
-		key := lbs.Key{
-			RadioType:         radio,
-			MobileCountryCode: uint16(mcc),
-			MobileNetworkCode: uint16(mnc),
-			LocationAreaCode:  uint16(area),
-			CellId:            uint32(cell),
-		}
-		data := lbs.Data{
-			Location: geo.NewPoint(lon, lat),
-			Accuracy: distance,
-		}
-
-		// created, err := strconv.ParseInt(record[11], 10, 64)
-		// if err != nil {
-		// 	log.Printf("[%d] bad Created: %s", lines, record[11])
-		// 	continue
-		// }
-		// updated, err := strconv.ParseInt(record[12], 10, 64)
-		// if err != nil {
-		// 	log.Printf("[%d] bad Updated: %s", lines, record[12])
-		// 	continue
-		// }
-
-		bulk.Upsert(key, bson.M{"$set": data})
+		stats.add(key.MobileCountryCode)
 		counter++
 	}
 	fmt.Fprintln(os.Stderr, "")
+	imported.Imported = counter
+	imported.Filtered = filtered
+	imported.Rejected = report.count
+	imported.Countries = mergeCountries(imported.Countries, stats.importedCountries())
+	stats.report(imported)
+	if report.count > 0 {
+		logger.Warn("Rejected records", "count", report.count)
+	}
+	if blocked > 0 {
+		logger.Info("Marked blocked records", "count", blocked)
+	}
+	if report.exceeded() {
+		logger.Error("Too many malformed records. Import aborted...")
+		return 1
+	}
 
 	if counter == 0 {
-		log.Println("No record for import. Exit...")
-		return
+		logger.Warn("No record for import. Exit...")
+		return 0
 	}
 
-	// если это не обновление, то подчищаем старые (не обновленные) данные
-	if !strings.Contains(filename, "diff") {
-		log.Println("Deleting old data...")
-		deleteResult, err := coll.RemoveAll(nil)
-		if err != nil {
-			log.Printf("MongoDB deleting old data error: %v", err)
-			return
-		}
-		if deleteResult.Removed > 0 {
-			log.Printf("Deleted %d records", deleteResult.Removed)
-		}
+	for _, name := range collections.names() {
+		logger.Info("Bulk importing to MongoDB...", "collection", name, "records", collections.list[name].count)
 	}
+	modified, _, err := collections.flush()
+	if err != nil {
+		logger.Error("MongoDB bulk write error", "error", err)
+		return 1
+	}
+	if modified > 0 {
+		logger.Info("Modified records", "count", modified)
+	}
+	imported.Modified += int(modified)
 
-	log.Printf("Bulk importing to MongoDB [%d records]...", counter)
-	bulkResult, err := bulk.Run()
+	// с этого момента данные в базе изменяются, поэтому отмена импорта больше не проверяется:
+	// при полном импорте временные коллекции заменяют старые данные целиком, и продолжить
+	// импорт после этого уже нельзя
+	imported.Checkpoint = 0
+	removed, err := collections.commit(ctx)
 	if err != nil {
-		log.Printf("MongoDB bulk insert error: %v", err)
-		return
+		logger.Error("MongoDB replacing old data error", "error", err)
+		return 1
 	}
-	if bulkResult.Modified > 0 {
-		log.Printf("Modified %d records", bulkResult.Modified)
+	imported.Removed = int(removed)
+	if err := collections.updatePartitions(ctx, meta); err != nil {
+		logger.Error("Error updating partitions metadata", "error", err)
+		return 1
 	}
 
-	total, err := coll.Count()
+	var opts []lbs.Option
+	if *partitioned {
+		opts = append(opts, lbs.Partitioned())
+	}
+	db, err = lbs.InitDB(ctx, client, cs.Database, opts...)
 	if err != nil {
-		log.Printf("MongoDB total counting error: %v", err)
-		return
+		logger.Error("Error connecting to MongoDB", "error", err)
+		return 1
+	}
+	if *prune > 0 && imported.Mode != "replace" {
+		// при полной замене устаревшие записи уже отброшены фильтром
+		removed, err := db.PruneStale(ctx, filter.seenAfter)
+		if err != nil {
+			logger.Error("Error removing stale cells", "error", err)
+			return 1
+		}
+		logger.Info("Removed stale cells", "count", removed)
+		imported.Removed += removed
+	}
+	imported.TotalCells = db.Records(ctx)
+	logger.Info("Total unique records in DB", "count", imported.TotalCells)
+	if !partial {
+		// радиусы по умолчанию зависят от всех данных страны, поэтому после импорта части
+		// файла их не пересчитываем
+		if defaults, err := db.LearnAccuracy(ctx); err != nil {
+			logger.Error("Error learning default ranges", "error", err)
+		} else {
+			logger.Info("Learned default ranges for radio types and countries", "count", len(defaults.Ranges))
+		}
+	}
+	db.Close()
+	if err := complete(); err != nil {
+		logger.Error("Error saving import metadata", "error", err)
+		return 1
 	}
-	log.Printf("Total unique records in DB: %d", total)
+	logger.Info("Dataset version", "version", imported.Version)
+	return 0
 }
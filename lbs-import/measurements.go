@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/lbs/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// measurementColumns описывает возможные названия колонок в файлах с исходными измерениями
+// OpenCellID. Названия отличаются в разных версиях выгрузки, поэтому колонки определяются по
+// заголовку файла.
+var measurementColumns = map[string][]string{
+	"radio":    {"radio", "act"},
+	"mcc":      {"mcc"},
+	"mnc":      {"mnc", "net"},
+	"lac":      {"lac", "area", "tac"},
+	"cell":     {"cellid", "cell", "cid"},
+	"lon":      {"lon"},
+	"lat":      {"lat"},
+	"signal":   {"signal"},
+	"measured": {"measured_at", "measured"},
+	"rating":   {"rating"},
+	"ta":       {"ta"},
+}
+
+// requiredColumns содержит список обязательных колонок в файле с измерениями.
+var requiredColumns = []string{"mcc", "mnc", "lac", "cell", "lon", "lat"}
+
+// measurementsBatch задает количество измерений, сохраняемых в базу за один запрос.
+const measurementsBatch = 1000
+
+// importMeasurements импортирует исходные измерения OpenCellID в коллекцию
+// lbs.ObservationsCollectionName. Измерения только добавляются к уже существующим, поэтому при
+// отмене импорта уже сохраненные пакеты измерений остаются в базе.
+func importMeasurements(im *importJob, r *csv.Reader, coll *mongo.Collection, report *errorReport,
+	filterRadio map[string]bool, filterCountry map[uint16]bool, filterNetwork networkFilter, stats *recordStats,
+	info *lbs.ImportInfo) error {
+	header, err := r.Read()
+	if err != nil {
+		return err
+	}
+	columns, err := columnsIndex(header, measurementColumns, requiredColumns)
+	if err != nil {
+		return err
+	}
+	r.FieldsPerRecord = len(header)
+	if _, err := coll.Indexes().CreateOne(im.ctx, mongo.IndexModel{Keys: cellKeys}); err != nil {
+		return err
+	}
+
+	docs := make([]interface{}, 0, measurementsBatch)
+	flush := func() error {
+		if len(docs) == 0 {
+			return nil
+		}
+		_, err := coll.InsertMany(im.ctx, docs)
+		docs = docs[:0]
+		return err
+	}
+	line := uint64(1) // номер строки с учетом заголовка
+	for !report.exceeded() {
+		if err := im.wait(); err != nil {
+			return err
+		}
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		badFields := false
+		if perr, ok := err.(*csv.ParseError); ok && perr.Err == csv.ErrFieldCount {
+			badFields = true
+		} else if err != nil {
+			return err
+		}
+		line++
+		if line-1 <= info.Filters.SkipLines {
+			continue
+		}
+		if info.Filters.MaxLines > 0 && line-1 > info.Filters.SkipLines+info.Filters.MaxLines {
+			break
+		}
+		info.Lines++
+		im.update(info.Lines, info.Imported)
+		fmt.Fprintf(os.Stderr, "\r* find %8d | skipped %8d records ", info.Imported, info.Lines-info.Imported)
+		if badFields {
+			report.reject(line, record, "bad fields count: %d", len(record))
+			continue
+		}
+
+		var radio string
+		if i, ok := columns["radio"]; ok {
+			radio = record[i]
+		}
+		stats.read(radio, record[columns["mcc"]])
+		var obs lbs.Observation
+		if i, ok := columns["radio"]; ok {
+			obs.RadioType = lbs.NormalizeRadio(record[i])
+			if len(filterRadio) > 0 && !filterRadio[obs.RadioType] {
+				info.Filtered++
+				continue
+			}
+		}
+		mcc, err := strconv.ParseUint(record[columns["mcc"]], 10, 16)
+		if err != nil {
+			report.reject(line, record, "bad MCC: %s", record[columns["mcc"]])
+			continue
+		}
+		if len(filterCountry) > 0 && !filterCountry[uint16(mcc)] {
+			info.Filtered++
+			continue
+		}
+		mnc, err := strconv.ParseUint(record[columns["mnc"]], 10, 16)
+		if err != nil {
+			report.reject(line, record, "bad MNC: %s", record[columns["mnc"]])
+			continue
+		}
+		if !filterNetwork.match(uint16(mcc), uint16(mnc)) {
+			info.Filtered++
+			continue
+		}
+		area, err := strconv.ParseUint(record[columns["lac"]], 10, lbs.AreaCodeBits)
+		if err != nil {
+			report.reject(line, record, "bad Area: %s", record[columns["lac"]])
+			continue
+		}
+		cell, err := strconv.ParseUint(record[columns["cell"]], 10, lbs.CellIdBits)
+		if err != nil {
+			report.reject(line, record, "bad Cell: %s", record[columns["cell"]])
+			continue
+		}
+		lon, err := strconv.ParseFloat(record[columns["lon"]], 64)
+		if err != nil || lon < -180 || lon > 180 {
+			report.reject(line, record, "bad longitude: %s", record[columns["lon"]])
+			continue
+		}
+		lat, err := strconv.ParseFloat(record[columns["lat"]], 64)
+		if err != nil || lat < -90 || lat > 90 {
+			report.reject(line, record, "bad latitude: %s", record[columns["lat"]])
+			continue
+		}
+		obs.MobileCountryCode = uint16(mcc)
+		obs.MobileNetworkCode = uint16(mnc)
+		obs.LocationAreaCode = uint32(area)
+		obs.CellId = cell
+		if !obs.Key.Valid() {
+			report.reject(line, record, "reserved Area or Cell: %d, %d", area, cell)
+			continue
+		}
+		obs.Location = geo.NewPoint(lon, lat)
+		// необязательные поля: при ошибке разбора просто не заполняем их
+		if i, ok := columns["signal"]; ok {
+			// в выгрузке OpenCellID уровень сигнала задан в дБм или ASU
+			signal, _ := strconv.Atoi(record[i])
+			obs.Signal, _ = lbs.SignalDBm(obs.RadioType, signal)
+		}
+		if i, ok := columns["ta"]; ok {
+			obs.TimingAdvance, _ = strconv.Atoi(record[i])
+		}
+		if i, ok := columns["rating"]; ok {
+			obs.Accuracy, _ = strconv.ParseFloat(record[i], 64)
+		}
+		if i, ok := columns["measured"]; ok {
+			obs.Measured = parseTime(record[i])
+		}
+
+		docs = append(docs, obs)
+		stats.add(obs.MobileCountryCode)
+		info.Imported++
+		if len(docs) >= measurementsBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Fprintln(os.Stderr, "")
+	info.Rejected = report.count
+	info.Countries = stats.importedCountries()
+	stats.report(info)
+	if report.count > 0 {
+		logger.Warn("Rejected records", "count", report.count)
+	}
+	if report.exceeded() {
+		return errors.New("too many malformed records")
+	}
+	return flush()
+}
+
+// parseTime разбирает время измерения, заданное в виде Unix time или в формате RFC 3339.
+func parseTime(value string) time.Time {
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(sec, 0).UTC()
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t
+	}
+	return time.Time{}
+}
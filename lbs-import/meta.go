@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/geotrace/lbs/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// claimImport создает в коллекции метаданных запись о начале импорта файла. Если такая запись
+// уже существует (файл уже импортирован или импортируется в данный момент другим процессом), то
+// возвращается false. При force запись создается в любом случае.
+func claimImport(ctx context.Context, coll *mongo.Collection, info *lbs.ImportInfo, force bool) (bool, error) {
+	info.Type = "import"
+	info.Status = lbs.ImportRunning
+	info.Started = time.Now()
+	if force {
+		_, err := coll.ReplaceOne(ctx, bson.M{"_id": info.ID}, info, options.Replace().SetUpsert(true))
+		return err == nil, err
+	}
+	_, err := coll.InsertOne(ctx, info)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// completeImport помечает импорт файла как успешно завершенный и сохраняет итоговые сведения об
+// импорте.
+func completeImport(ctx context.Context, coll *mongo.Collection, info *lbs.ImportInfo) error {
+	info.Status = lbs.ImportDone
+	info.Finished = time.Now()
+	_, err := coll.ReplaceOne(ctx, bson.M{"_id": info.ID}, info)
+	return err
+}
+
+// findInterrupted возвращает запись о незавершенном импорте с идентификатором id, для которого
+// сохранено место продолжения (checkpoint). Если такого импорта нет, то возвращается nil.
+func findInterrupted(ctx context.Context, coll *mongo.Collection, id string) (*lbs.ImportInfo, error) {
+	info := new(lbs.ImportInfo)
+	err := coll.FindOne(ctx, bson.M{
+		"_id":        id,
+		"status":     lbs.ImportRunning,
+		"checkpoint": bson.M{"$gt": 0},
+	}).Decode(info)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// saveCheckpoint сохраняет промежуточные сведения о выполняемом импорте вместе с местом, с
+// которого его можно продолжить.
+func saveCheckpoint(ctx context.Context, coll *mongo.Collection, info *lbs.ImportInfo) error {
+	_, err := coll.ReplaceOne(ctx, bson.M{"_id": info.ID, "status": lbs.ImportRunning}, info)
+	return err
+}
+
+// releaseImport удаляет запись о незавершенном импорте файла, чтобы его можно было импортировать
+// повторно.
+func releaseImport(ctx context.Context, coll *mongo.Collection, info *lbs.ImportInfo) error {
+	_, err := coll.DeleteOne(ctx, bson.M{"_id": info.ID, "status": lbs.ImportRunning})
+	return err
+}
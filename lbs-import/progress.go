@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// importJob управляет ходом импорта: позволяет из другого потока приостановить, продолжить или
+// отменить импорт и узнать, сколько строк уже обработано. Циклы импорта вызывают wait перед
+// обработкой каждой строки.
+type importJob struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	resumed  *sync.Cond // сигнал о продолжении или отмене импорта
+	paused   bool       // импорт приостановлен
+	lines    uint64     // количество обработанных строк
+	imported uint64     // количество импортированных записей
+}
+
+// progress описывает состояние импорта.
+type progress struct {
+	Lines    uint64 // обработано строк
+	Imported uint64 // импортировано записей
+	Paused   bool   // импорт приостановлен
+}
+
+// newImportJob возвращает объект управления импортом, который отменяется вместе с ctx.
+func newImportJob(ctx context.Context) *importJob {
+	im := new(importJob)
+	im.ctx, im.cancel = context.WithCancel(ctx)
+	im.resumed = sync.NewCond(&im.mu)
+	go func() {
+		<-im.ctx.Done()
+		im.mu.Lock()
+		im.resumed.Broadcast() // будим приостановленный импорт, чтобы он завершился
+		im.mu.Unlock()
+	}()
+	return im
+}
+
+// Cancel отменяет импорт. Импорт останавливается перед обработкой следующей строки, а данные в
+// базе остаются без изменений.
+func (im *importJob) Cancel() {
+	im.cancel()
+}
+
+// Pause приостанавливает импорт перед обработкой следующей строки.
+func (im *importJob) Pause() {
+	im.mu.Lock()
+	im.paused = true
+	im.mu.Unlock()
+}
+
+// Resume продолжает приостановленный импорт.
+func (im *importJob) Resume() {
+	im.mu.Lock()
+	im.paused = false
+	im.resumed.Broadcast()
+	im.mu.Unlock()
+}
+
+// Progress возвращает текущее состояние импорта.
+func (im *importJob) Progress() progress {
+	im.mu.Lock()
+	paused := im.paused
+	im.mu.Unlock()
+	return progress{
+		Lines:    atomic.LoadUint64(&im.lines),
+		Imported: atomic.LoadUint64(&im.imported),
+		Paused:   paused,
+	}
+}
+
+// wait ожидает продолжения приостановленного импорта и возвращает ошибку, если импорт отменен.
+func (im *importJob) wait() error {
+	im.mu.Lock()
+	for im.paused && im.ctx.Err() == nil {
+		im.resumed.Wait()
+	}
+	im.mu.Unlock()
+	return im.ctx.Err()
+}
+
+// update сохраняет количество обработанных строк и импортированных записей.
+func (im *importJob) update(lines, imported uint64) {
+	atomic.StoreUint64(&im.lines, lines)
+	atomic.StoreUint64(&im.imported, imported)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// errorReport описывает отчет об отклоненных при импорте строках. Если имя файла отчета не
+// задано, то ошибки только выводятся в лог.
+type errorReport struct {
+	file  *os.File    // файл с отчетом
+	w     *csv.Writer // запись отчета в формате CSV
+	count uint64      // количество отклоненных строк
+	limit int64       // допустимое количество отклоненных строк (отрицательное - без ограничений)
+}
+
+// newErrorReport создает файл отчета об ошибках. Первой строкой в файл записывается заголовок.
+func newErrorReport(filename string) (*errorReport, error) {
+	report := &errorReport{limit: -1}
+	if filename == "" {
+		return report, nil
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	report.file = file
+	report.w = csv.NewWriter(file)
+	if err := report.w.Write([]string{"line", "reason", "record"}); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return report, nil
+}
+
+// reject выводит в лог и записывает в отчет информацию об отклоненной строке: номер строки,
+// причину и исходные данные.
+func (r *errorReport) reject(line uint64, record []string, format string, args ...interface{}) {
+	r.count++
+	reason := fmt.Sprintf(format, args...)
+	logger.Warn("Rejected record", "line", line, "reason", reason)
+	if r.w == nil {
+		return
+	}
+	row := make([]string, 0, len(record)+2)
+	row = append(row, strconv.FormatUint(line, 10), reason)
+	row = append(row, record...)
+	r.w.Write(row)
+}
+
+// exceeded возвращает true, если количество отклоненных строк превысило допустимое.
+func (r *errorReport) exceeded() bool {
+	return r.limit >= 0 && r.count > uint64(r.limit)
+}
+
+// Close записывает оставшиеся данные и закрывает файл отчета.
+func (r *errorReport) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	r.w.Flush()
+	if err := r.w.Error(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
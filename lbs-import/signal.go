@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchSignals отменяет импорт по сигналу прерывания (Ctrl+C) или SIGTERM. Повторный сигнал
+// завершает программу сразу, как и без обработки сигналов.
+func watchSignals(im *importJob) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		signal.Stop(c)
+		logger.Info("Cancelling import...")
+		im.Cancel()
+	}()
+	watchPause(im)
+}
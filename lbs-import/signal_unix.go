@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchPause приостанавливает и продолжает импорт по сигналу SIGUSR1.
+func watchPause(im *importJob) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	go func() {
+		for range c {
+			if progress := im.Progress(); progress.Paused {
+				im.Resume()
+				logger.Info("Import resumed")
+			} else {
+				im.Pause()
+				logger.Info("Import paused", "lines", progress.Lines, "records", progress.Imported)
+			}
+		}
+	}()
+}
@@ -0,0 +1,4 @@
+package main
+
+// watchPause ничего не делает: в Windows нет сигнала, которым можно приостановить импорт.
+func watchPause(im *importJob) {}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/geotrace/lbs/v2"
+	"github.com/geotrace/lbs/v2/importer"
+)
+
+// importSnapshot собирает из файла CSV записи о вышках, прошедшие фильтр, в снимок output (см.
+// lbs.SnapshotWriter и lbs.OpenSnapshot). MongoDB при этом не используется. Снимок записывается
+// во временный файл рядом с output и заменяет его только после успешной записи, поэтому сервер,
+// открывший старый снимок, продолжает работать с ним до перезапуска.
+func importSnapshot(im *importJob, filename, output string, filter *cellFilter, report *errorReport,
+	skipLines, maxLines uint64, info *lbs.ImportInfo) error {
+	file, err := importer.Open(im.ctx, filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stats := newRecordStats()
+	snapshot := lbs.NewSnapshotWriter()
+	r := csv.NewReader(file)
+	var lines uint64
+	for !report.exceeded() {
+		if err := im.wait(); err != nil {
+			fmt.Fprintln(os.Stderr, "")
+			return err
+		}
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		badFields := false
+		if perr, ok := err.(*csv.ParseError); ok && perr.Err == csv.ErrFieldCount {
+			badFields = true
+		} else if err != nil {
+			return err
+		}
+		lines++
+		if lines == 1 {
+			r.FieldsPerRecord = len(record) // устанавливаем количество полей
+			continue                        // пропускаем первую строку с заголовком в CSV-файле
+		}
+		if lines-1 <= skipLines {
+			continue
+		}
+		if maxLines > 0 && lines-1 > skipLines+maxLines {
+			break
+		}
+		info.Lines++
+		im.update(info.Lines, info.Imported)
+		fmt.Fprintf(os.Stderr, "\r* find %8d | skipped %8d records ", info.Imported, info.Lines-info.Imported)
+		if badFields {
+			report.reject(lines, record, "bad fields count: %d", len(record))
+			continue
+		}
+		stats.read(record[0], record[1])
+		if !filter.matchRadio(record[0]) {
+			info.Filtered++
+			continue
+		}
+		var cell lbs.Cell
+		if err := cell.UnmarshalCSV(record); err != nil {
+			report.reject(lines, record, "%v", err)
+			continue
+		}
+		if !filter.match(cell) {
+			info.Filtered++
+			continue
+		}
+		if err := snapshot.Add(cell); err != nil {
+			report.reject(lines, record, "%v", err)
+			continue
+		}
+		stats.add(cell.MobileCountryCode)
+		info.Imported++
+	}
+	fmt.Fprintln(os.Stderr, "")
+	info.Rejected = report.count
+	info.Countries = stats.importedCountries()
+	stats.report(info)
+	if report.count > 0 {
+		logger.Warn("Rejected records", "count", report.count)
+	}
+	if report.exceeded() {
+		return errors.New("too many malformed records")
+	}
+	if info.Imported == 0 {
+		return errors.New("no record for snapshot")
+	}
+
+	logger.Info("Writing snapshot...", "file", output, "records", info.Imported)
+	tmp, err := os.CreateTemp(filepath.Dir(output), filepath.Base(output)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // после переименования ничего не удаляет
+	size, err := snapshot.WriteTo(tmp)
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), output); err != nil {
+		return err
+	}
+	logger.Info("Snapshot written", "file", output, "bytes", size)
+	return nil
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/geotrace/lbs/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// cellKeys описывает поля ключа вышки в порядке их следования в индексах.
+var cellKeys = bson.D{
+	{Key: "radio", Value: 1},
+	{Key: "mcc", Value: 1},
+	{Key: "mnc", Value: 1},
+	{Key: "lac", Value: 1},
+	{Key: "cell", Value: 1},
+}
+
+// cellsIndex описывает уникальный индекс коллекции с данными о сотовых вышках.
+var cellsIndex = mongo.IndexModel{
+	Keys:    cellKeys,
+	Options: options.Index().SetUnique(true),
+}
+
+// target описывает коллекцию, в которую импортируются данные, и накопленный для нее пакет
+// изменений.
+type target struct {
+	name   string             // название коллекции с данными
+	coll   *mongo.Collection  // коллекция, в которую записываются изменения
+	models []mongo.WriteModel // накопленный пакет изменений
+	count  uint64             // количество записей для импорта
+}
+
+// writeBatch описывает пакет изменений одной коллекции.
+type writeBatch struct {
+	coll   *mongo.Collection
+	models []mongo.WriteModel
+}
+
+// writer сохраняет пакеты изменений в несколько потоков: разбор файла продолжается, пока
+// предыдущие пакеты записываются в базу, и в памяти не накапливается весь файл целиком.
+type writer struct {
+	batches  chan writeBatch
+	wg       sync.WaitGroup
+	pending  sync.WaitGroup // переданные, но еще не записанные пакеты
+	once     sync.Once
+	mu       sync.Mutex
+	err      error // первая ошибка записи
+	modified int64 // количество измененных записей
+	upserted int64 // количество созданных записей
+}
+
+// newWriter запускает workers потоков записи пакетов изменений. Запись прерывается вместе с ctx.
+func newWriter(ctx context.Context, workers int) *writer {
+	w := &writer{batches: make(chan writeBatch, workers)}
+	w.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer w.wg.Done()
+			for batch := range w.batches {
+				if w.failed() != nil {
+					w.pending.Done()
+					continue // после ошибки оставшиеся пакеты только вычитываются
+				}
+				result, err := batch.coll.BulkWrite(ctx, batch.models, options.BulkWrite().SetOrdered(false))
+				w.mu.Lock()
+				if err != nil && w.err == nil {
+					w.err = err
+				}
+				if result != nil {
+					w.modified += result.ModifiedCount
+					w.upserted += result.UpsertedCount
+				}
+				w.mu.Unlock()
+				w.pending.Done()
+			}
+		}()
+	}
+	return w
+}
+
+// failed возвращает первую ошибку записи.
+func (w *writer) failed() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// write передает пакет изменений на запись, ожидая освобождения одного из потоков, и возвращает
+// ошибку, если запись одного из предыдущих пакетов не удалась.
+func (w *writer) write(coll *mongo.Collection, models []mongo.WriteModel) error {
+	if err := w.failed(); err != nil {
+		return err
+	}
+	w.pending.Add(1)
+	w.batches <- writeBatch{coll: coll, models: models}
+	return nil
+}
+
+// wait дожидается записи всех переданных пакетов, не останавливая потоки записи, и возвращает
+// первую ошибку записи.
+func (w *writer) wait() error {
+	w.pending.Wait()
+	return w.failed()
+}
+
+// close дожидается записи всех переданных пакетов и возвращает первую ошибку записи. Повторный
+// вызов только возвращает ошибку.
+func (w *writer) close() error {
+	w.once.Do(func() { close(w.batches) })
+	w.wg.Wait()
+	return w.failed()
+}
+
+// targets описывает набор коллекций, в которые импортируются данные. Если включен режим хранения
+// данных по странам в отдельных коллекциях, то для каждой страны используется своя коллекция,
+// иначе все данные импортируются в одну коллекцию lbs.CollectionName.
+//
+// При полном импорте (replace) данные записываются во временные коллекции, которые заменяют
+// старые только в конце импорта (см. commit), а при обновлении (merge и diff) — сразу в коллекции
+// с данными.
+type targets struct {
+	db          *mongo.Database    // база данных
+	partitioned bool               // данные по странам хранятся в отдельных коллекциях
+	replace     bool               // данные загружаются во временные коллекции
+	newer       bool               // обновляются только записи старше импортируемых (diff)
+	restoring   bool               // временные коллекции прерванного импорта не пересоздаются
+	writer      *writer            // запись пакетов изменений
+	batch       int                // количество записей в одном пакете изменений
+	list        map[string]*target // коллекции по названию
+	countries   map[uint16]string  // название коллекции по коду страны
+}
+
+// newTargets возвращает новый набор коллекций для импорта данных, изменения в которые
+// записываются через w пакетами по batch записей.
+func newTargets(db *mongo.Database, partitioned, replace, newer bool, w *writer, batch int) *targets {
+	return &targets{
+		db:          db,
+		partitioned: partitioned,
+		replace:     replace,
+		newer:       newer,
+		writer:      w,
+		batch:       batch,
+		list:        make(map[string]*target),
+		countries:   make(map[uint16]string),
+	}
+}
+
+// get возвращает коллекцию для импорта данных указанной страны. При первом обращении к коллекции
+// для нее создается индекс, а при полном импорте — пустая временная коллекция.
+func (t *targets) get(ctx context.Context, mcc uint16) (*target, error) {
+	name := lbs.CollectionName
+	if t.partitioned {
+		name = lbs.PartitionCollection(mcc)
+		t.countries[mcc] = name
+	}
+	if item, ok := t.list[name]; ok {
+		return item, nil
+	}
+	coll := t.db.Collection(name)
+	if t.replace {
+		coll = t.db.Collection(name + "_import")
+	}
+	if t.replace && !t.restoring {
+		if err := coll.Drop(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := coll.Indexes().CreateOne(ctx, cellsIndex); err != nil {
+		return nil, err
+	}
+	item := &target{name: name, coll: coll}
+	t.list[name] = item
+	return item, nil
+}
+
+// upsert добавляет обновление или создание записи о вышке в пакет изменений коллекции и передает
+// заполненный пакет на запись.
+func (t *targets) upsert(item *target, cell lbs.Cell) error {
+	// дисперсия координат относится к прежнему среднему положению и вместе с ним заменяется
+	// значением из файла (в выгрузках ее нет)
+	var update interface{} = bson.M{"$set": cell, "$unset": bson.M{"variance": ""}}
+	if t.newer && !cell.Updated.IsZero() {
+		// запись, измеренная позже, чем запись из файла (например, вычисленная lbs-admin
+		// estimate), не изменяется; требуется MongoDB 4.2 или новее
+		newer := bson.M{"$gt": []interface{}{"$updated", cell.Updated}}
+		update = []bson.M{
+			{"$set": bson.M{"variance": bson.M{"$cond": []interface{}{newer, "$variance", "$$REMOVE"}}}},
+			{"$replaceWith": bson.M{"$cond": []interface{}{
+				newer,
+				"$$ROOT",
+				bson.M{"$mergeObjects": []interface{}{"$$ROOT", bson.M{"$literal": cell}}},
+			}}},
+		}
+	}
+	item.models = append(item.models, mongo.NewUpdateOneModel().SetFilter(cell.Key).
+		SetUpdate(update).SetUpsert(true))
+	item.count++
+	if len(item.models) < t.batch {
+		return nil
+	}
+	models := item.models
+	item.models = make([]mongo.WriteModel, 0, t.batch)
+	return t.writer.write(item.coll, models)
+}
+
+// restore подключает временные коллекции прерванного полного импорта с данными указанных стран,
+// чтобы продолжить импорт в них, не удаляя уже загруженные записи. Временные коллекции других
+// стран при первом обращении к ним, как обычно, создаются заново.
+func (t *targets) restore(ctx context.Context, countries []uint16) error {
+	t.restoring = true
+	defer func() { t.restoring = false }()
+	for _, mcc := range countries {
+		if _, err := t.get(ctx, mcc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sync передает на запись неполные пакеты изменений всех коллекций и дожидается записи всех
+// пакетов, после чего все прочитанные записи сохранены в базе.
+func (t *targets) sync() error {
+	for _, name := range t.names() {
+		item := t.list[name]
+		if len(item.models) == 0 {
+			continue
+		}
+		if err := t.writer.write(item.coll, item.models); err != nil {
+			return err
+		}
+		item.models = make([]mongo.WriteModel, 0, t.batch)
+	}
+	return t.writer.wait()
+}
+
+// flush передает на запись неполные пакеты изменений всех коллекций и дожидается окончания
+// записи. Возвращает количество измененных и созданных записей.
+func (t *targets) flush() (modified, upserted int64, err error) {
+	for _, name := range t.names() {
+		item := t.list[name]
+		if len(item.models) == 0 {
+			continue
+		}
+		if err := t.writer.write(item.coll, item.models); err != nil {
+			break
+		}
+		item.models = nil
+	}
+	err = t.writer.close()
+	return t.writer.modified, t.writer.upserted, err
+}
+
+// commit при полном импорте заменяет коллекции с данными временными коллекциями. Перед заменой
+// во временных коллекциях создаются все индексы старых коллекций (например, созданные
+// администратором для выборок по координатам), чтобы серверы сразу после замены не перешли на
+// полный перебор коллекции, и переносятся площадки вышек (см. copySites). Возвращает количество
+// записей в замененных коллекциях.
+func (t *targets) commit(ctx context.Context) (removed int64, err error) {
+	if !t.replace {
+		return 0, nil
+	}
+	for _, name := range t.names() {
+		item := t.list[name]
+		if err := copyIndexes(ctx, t.db.Collection(name), item.coll); err != nil {
+			return removed, err
+		}
+		if err := copySites(ctx, t.db.Collection(name), item.coll); err != nil {
+			return removed, err
+		}
+		count, err := t.db.Collection(name).EstimatedDocumentCount(ctx)
+		if err != nil {
+			return removed, err
+		}
+		if err := t.db.Client().Database("admin").RunCommand(ctx, bson.D{
+			{Key: "renameCollection", Value: t.db.Name() + "." + item.coll.Name()},
+			{Key: "to", Value: t.db.Name() + "." + name},
+			{Key: "dropTarget", Value: true},
+		}).Err(); err != nil {
+			return removed, err
+		}
+		if count > 0 {
+			logger.Info("Replaced records", "collection", name, "count", count)
+		}
+		removed += count
+	}
+	return removed, nil
+}
+
+// copyIndexes создает в коллекции to индексы коллекции from, которых в ней еще нет. Если
+// коллекции from нет, то ничего не делается. Индексы создаются командой createIndexes по полному
+// описанию из listIndexes (см. indexSpec), поэтому после замены коллекции сохраняются все их
+// параметры, в том числе partialFilterExpression и collation.
+func copyIndexes(ctx context.Context, from, to *mongo.Collection) error {
+	cursor, err := from.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	var specs []bson.D
+	if err := cursor.All(ctx, &specs); err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		name, _ := specValue(spec, "name").(string)
+		keys, _ := specValue(spec, "key").(bson.D)
+		// индекс ключа вышки уже создан в get, возможно под другим именем
+		if name == "_id_" || equalKeys(keys, cellKeys) {
+			continue
+		}
+		err := to.Database().RunCommand(ctx, bson.D{
+			{Key: "createIndexes", Value: to.Name()},
+			{Key: "indexes", Value: bson.A{indexSpec(spec)}},
+		}).Err()
+		if err != nil {
+			// геопространственный индекс не создается, если в файле есть вышки с неправильными
+			// координатами: это не повод отменять импорт
+			if ctx.Err() == nil && geoIndex(keys) {
+				logger.Warn("Index not created", "index", name, "collection", to.Name(), "error", err)
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// copySites переносит в коллекцию to поле site записей коллекции from с теми же ключами: площадки
+// вышек, найденные lbs-admin dedup, в выгрузках не указываются и без переноса терялись бы при
+// каждом полном импорте. Записи, которых в коллекции to нет, не создаются. Требуется MongoDB 4.2
+// или новее.
+func copySites(ctx context.Context, from, to *mongo.Collection) error {
+	project := bson.M{"_id": 0, "site": 1}
+	on := make(bson.A, 0, len(cellKeys))
+	for _, key := range cellKeys {
+		project[key.Key] = 1
+		on = append(on, key.Key)
+	}
+	cursor, err := from.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"site": bson.M{"$gt": 0}}},
+		bson.M{"$project": project},
+		bson.M{"$merge": bson.M{"into": to.Name(), "on": on, "whenMatched": "merge", "whenNotMatched": "discard"}},
+	})
+	if err != nil {
+		return err
+	}
+	return cursor.Close(ctx)
+}
+
+// indexSpec возвращает описание индекса из listIndexes для команды createIndexes: без версии
+// индекса v, которую сервер выбирает сам, и пространства имен ns исходной коллекции.
+func indexSpec(spec bson.D) bson.D {
+	index := make(bson.D, 0, len(spec))
+	for _, elem := range spec {
+		if elem.Key != "v" && elem.Key != "ns" {
+			index = append(index, elem)
+		}
+	}
+	return index
+}
+
+// specValue возвращает значение поля key описания индекса или nil, если поля нет.
+func specValue(spec bson.D, key string) interface{} {
+	for _, elem := range spec {
+		if elem.Key == key {
+			return elem.Value
+		}
+	}
+	return nil
+}
+
+// geoIndex сообщает, является ли индекс с указанными полями геопространственным.
+func geoIndex(keys bson.D) bool {
+	for _, key := range keys {
+		if key.Value == "2dsphere" || key.Value == "2d" {
+			return true
+		}
+	}
+	return false
+}
+
+// equalKeys сообщает, совпадают ли поля индексов a и b (без учета направления).
+func equalKeys(a, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key {
+			return false
+		}
+	}
+	return true
+}
+
+// discard удаляет временные коллекции незавершенного полного импорта.
+func (t *targets) discard(ctx context.Context) {
+	if !t.replace {
+		return
+	}
+	for _, item := range t.list {
+		if err := item.coll.Drop(ctx); err != nil {
+			logger.Error("Error dropping collection", "collection", item.coll.Name(), "error", err)
+		}
+	}
+}
+
+// names возвращает отсортированный список названий коллекций, в которые импортируются данные.
+func (t *targets) names() []string {
+	names := make([]string, 0, len(t.list))
+	for name := range t.list {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// updatePartitions добавляет в таблицу распределения данных по коллекциям информацию о
+// коллекциях, в которые были импортированы данные. Все изменения вносятся одним обновлением
+// документа, поэтому читатели видят либо старую, либо новую таблицу целиком.
+func (t *targets) updatePartitions(ctx context.Context, meta *mongo.Collection) error {
+	if !t.partitioned || len(t.countries) == 0 {
+		return nil
+	}
+	update := bson.M{"updated": time.Now()}
+	for mcc, name := range t.countries {
+		update["collections."+strconv.FormatUint(uint64(mcc), 10)] = name
+	}
+	_, err := meta.UpdateOne(ctx, bson.M{"_id": lbs.PartitionsID}, bson.M{"$set": update},
+		options.Update().SetUpsert(true))
+	return err
+}
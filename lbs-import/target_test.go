@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/geotrace/lbs/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestIndexSpec(t *testing.T) {
+	// описание индекса в том виде, в котором его возвращает listIndexes
+	data, err := bson.Marshal(bson.D{
+		{Key: "v", Value: 2},
+		{Key: "key", Value: bson.D{{Key: "radio", Value: 1}, {Key: "updated", Value: -1}}},
+		{Key: "name", Value: "radio_updated"},
+		{Key: "ns", Value: "geotrace.lbs"},
+		{Key: "partialFilterExpression", Value: bson.D{{Key: "blocked", Value: true}}},
+		{Key: "collation", Value: bson.D{{Key: "locale", Value: "en"}, {Key: "strength", Value: 2}}},
+		{Key: "expireAfterSeconds", Value: 3600},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec bson.D
+	if err := bson.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+	index := indexSpec(spec)
+	var names []string
+	for _, elem := range index {
+		names = append(names, elem.Key)
+	}
+	if want := []string{"key", "name", "partialFilterExpression", "collation", "expireAfterSeconds"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("bad index fields: %v", names)
+	}
+	if name, _ := specValue(spec, "name").(string); name != "radio_updated" {
+		t.Errorf("bad index name: %q", name)
+	}
+	keys, ok := specValue(spec, "key").(bson.D)
+	if !ok || !equalKeys(keys, bson.D{{Key: "radio"}, {Key: "updated"}}) {
+		t.Errorf("bad index keys: %#v", specValue(spec, "key"))
+	}
+	if specValue(spec, "weights") != nil {
+		t.Error("missing field found")
+	}
+}
+
+func TestCopySites(t *testing.T) {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost/geotrace").
+		SetServerSelectionTimeout(time.Second).SetRegistry(lbs.Registry))
+	if err == nil {
+		err = client.Ping(ctx, nil)
+	}
+	if err != nil {
+		log.Println("Error connecting to MongoDB:", err)
+		return
+	}
+	defer client.Disconnect(ctx)
+	mdb := client.Database("geotrace")
+	from, to := mdb.Collection("lbs_test_sites"), mdb.Collection("lbs_test_sites_import")
+	defer from.Drop(ctx)
+	defer to.Drop(ctx)
+	key := func(id uint64) lbs.Key {
+		return lbs.Key{RadioType: "gsm", MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 7743, CellId: id}
+	}
+	old := []interface{}{
+		lbs.Cell{Key: key(1), Samples: 10},
+		lbs.Cell{Key: key(2), Samples: 5, Site: 1},
+		lbs.Cell{Key: key(3), Samples: 5, Site: 1},
+	}
+	if _, err := from.InsertMany(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := to.Indexes().CreateOne(ctx, cellsIndex); err != nil {
+		t.Fatal(err)
+	}
+	// вышки 3 в новой выгрузке нет, а вышка 4 — новая
+	imported := []interface{}{
+		lbs.Cell{Key: key(1), Samples: 12},
+		lbs.Cell{Key: key(2), Samples: 7},
+		lbs.Cell{Key: key(4), Samples: 1},
+	}
+	if _, err := to.InsertMany(ctx, imported); err != nil {
+		t.Fatal(err)
+	}
+	if err := copySites(ctx, from, to); err != nil {
+		t.Fatal(err)
+	}
+	var cells []lbs.Cell
+	cursor, err := to.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"cell": 1}))
+	if err == nil {
+		err = cursor.All(ctx, &cells)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cells) != 3 {
+		t.Fatalf("bad cells count: %d", len(cells))
+	}
+	for i, site := range []uint64{0, 1, 0} {
+		if cells[i].Site != site {
+			t.Errorf("cell %d: bad site %d", cells[i].CellId, cells[i].Site)
+		}
+	}
+	if cells[1].Samples != 7 {
+		t.Errorf("imported data replaced: %+v", cells[1])
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/lbs/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// wifiColumns описывает возможные названия колонок в файлах с данными о точках доступа Wi-Fi.
+// Колонки ssid, type и time есть в выгрузках WiGLE.
+var wifiColumns = map[string][]string{
+	"mac":     {"mac", "bssid", "key"},
+	"lon":     {"lon", "currentlongitude"},
+	"lat":     {"lat", "currentlatitude"},
+	"range":   {"range", "radius", "accuracy"},
+	"samples": {"samples"},
+	"ssid":    {"ssid"},
+	"type":    {"type"},
+	"time":    {"firstseen"},
+}
+
+// requiredWifiColumns содержит список обязательных колонок в файле с точками доступа Wi-Fi.
+var requiredWifiColumns = []string{"mac", "lon", "lat"}
+
+// wiglePrefix начинает строку с описанием программы, которая предшествует заголовку в выгрузках
+// WiGLE: WigleWifi-1.4,appRelease=...,model=...
+const wiglePrefix = "WigleWifi-"
+
+// wigleTime задает формат времени измерения в выгрузках WiGLE.
+const wigleTime = "2006-01-02 15:04:05"
+
+// maxWifiSpread задает максимальный радиус, в котором должны лежать все измерения неподвижной точки
+// доступа, м: точки доступа, замеченные в разных местах дальше друг от друга, — мобильные (в
+// транспорте, раздающие интернет телефоны) и не имеют постоянного местоположения.
+const maxWifiSpread = 5000
+
+// mobileSSIDs содержит части названий сетей (в нижнем регистре), по которым распознаются мобильные
+// точки доступа: телефоны и модемы, раздающие интернет, с названиями по умолчанию.
+var mobileSSIDs = []string{"androidap", "iphone", "ipad", "hotspot", "mifi"}
+
+// excludedSSID возвращает, нужно ли пропустить точку доступа с названием сети ssid: владелец
+// запретил использовать ее для определения местоположения (суффикс _nomap или _optout), или это
+// мобильная точка доступа (см. mobileSSIDs).
+func excludedSSID(ssid string) bool {
+	ssid = strings.ToLower(strings.TrimSpace(ssid))
+	if strings.HasSuffix(ssid, "_nomap") || strings.Contains(ssid, "_optout") {
+		return true
+	}
+	for _, name := range mobileSSIDs {
+		if strings.Contains(ssid, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// importWifi импортирует данные о точках доступа Wi-Fi в коллекцию lbs.WifiCollectionName.
+// Записи о точках доступа с тем же MAC-адресом заменяются. Если файл не является обновлением, то
+// данные загружаются во временную коллекцию, которая в конце атомарно заменяет старую: при ошибке
+// или отмене импорта старые данные остаются без изменений.
+//
+// Выгрузка WiGLE распознается по строке перед заголовком и содержит отдельные измерения, а не
+// записи о точках доступа: измерения одной точки доступа объединяются в памяти (см.
+// lbs.AccessPoint.Add) и сохраняются после чтения всего файла, а точки доступа с измерениями дальше
+// maxWifiSpread друг от друга пропускаются как мобильные. Строки с Bluetooth-устройствами и вышками
+// из той же выгрузки пропускаются.
+func importWifi(im *importJob, r *csv.Reader, coll *mongo.Collection, report *errorReport, minSamples int64,
+	info *lbs.ImportInfo) error {
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return err
+	}
+	var observed map[string]lbs.AccessPoint // точки доступа из выгрузки WiGLE
+	if len(header) > 0 && strings.HasPrefix(header[0], wiglePrefix) {
+		observed = make(map[string]lbs.AccessPoint)
+		if header, err = r.Read(); err != nil {
+			return err
+		}
+	}
+	columns, err := columnsIndex(header, wifiColumns, requiredWifiColumns)
+	if err != nil {
+		return err
+	}
+	r.FieldsPerRecord = len(header)
+	target := coll
+	if info.Mode == "replace" {
+		coll = coll.Database().Collection(coll.Name() + "_import")
+		if err := coll.Drop(im.ctx); err != nil {
+			return err
+		}
+	}
+
+	models := make([]mongo.WriteModel, 0, measurementsBatch)
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		_, err := coll.BulkWrite(im.ctx, models)
+		models = models[:0]
+		return err
+	}
+	line := uint64(1) // номер строки с учетом заголовка
+	for !report.exceeded() {
+		if err := im.wait(); err != nil {
+			return err
+		}
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		badFields := false
+		if perr, ok := err.(*csv.ParseError); ok && perr.Err == csv.ErrFieldCount {
+			badFields = true
+		} else if err != nil {
+			return err
+		}
+		line++
+		if line-1 <= info.Filters.SkipLines {
+			continue
+		}
+		if info.Filters.MaxLines > 0 && line-1 > info.Filters.SkipLines+info.Filters.MaxLines {
+			break
+		}
+		info.Lines++
+		im.update(info.Lines, info.Imported)
+		fmt.Fprintf(os.Stderr, "\r* find %8d | skipped %8d records ", info.Imported, info.Lines-info.Imported)
+		if badFields {
+			report.reject(line, record, "bad fields count: %d", len(record))
+			continue
+		}
+
+		if i, ok := columns["type"]; ok && !strings.EqualFold(record[i], "wifi") {
+			info.Filtered++
+			continue
+		}
+		if i, ok := columns["ssid"]; ok && excludedSSID(record[i]) {
+			info.Filtered++
+			continue
+		}
+		var point lbs.AccessPoint
+		mac, ok := lbs.NormalizeMAC(record[columns["mac"]])
+		if !ok {
+			report.reject(line, record, "bad or local MAC address: %s", record[columns["mac"]])
+			continue
+		}
+		point.MAC = mac
+		lon, err := strconv.ParseFloat(record[columns["lon"]], 64)
+		if err != nil || lon < -180 || lon > 180 {
+			report.reject(line, record, "bad longitude: %s", record[columns["lon"]])
+			continue
+		}
+		lat, err := strconv.ParseFloat(record[columns["lat"]], 64)
+		if err != nil || lat < -90 || lat > 90 {
+			report.reject(line, record, "bad latitude: %s", record[columns["lat"]])
+			continue
+		}
+		point.Location = geo.NewPoint(lon, lat)
+		if observed != nil {
+			var measured time.Time
+			if i, ok := columns["time"]; ok {
+				measured, _ = time.Parse(wigleTime, record[i])
+			}
+			aggregated := observed[mac]
+			aggregated.MAC = mac
+			observed[mac] = aggregated.Add(point.Location, measured)
+			continue
+		}
+		if i, ok := columns["range"]; ok {
+			point.Accuracy, _ = strconv.ParseFloat(record[i], 64)
+		}
+		if i, ok := columns["samples"]; ok {
+			point.Samples, _ = strconv.Atoi(record[i])
+		}
+		if int64(point.Samples) < minSamples {
+			info.Filtered++
+			continue
+		}
+
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": point.MAC}).
+			SetReplacement(point).SetUpsert(true))
+		info.Imported++
+		if len(models) >= measurementsBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Fprintln(os.Stderr, "")
+	info.Rejected = report.count
+	if report.count > 0 {
+		logger.Warn("Rejected records", "count", report.count)
+	}
+	if report.exceeded() {
+		return errors.New("too many malformed records")
+	}
+	macs := make([]string, 0, len(observed))
+	for mac := range observed {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+	for _, mac := range macs {
+		point := observed[mac]
+		if point.Accuracy > maxWifiSpread || int64(point.Samples) < minSamples {
+			info.Filtered++
+			continue
+		}
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": point.MAC}).
+			SetReplacement(point).SetUpsert(true))
+		info.Imported++
+		if len(models) >= measurementsBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if coll == target {
+		return nil
+	}
+	if err := copyIndexes(im.ctx, target, coll); err != nil {
+		return err
+	}
+	db := coll.Database()
+	return db.Client().Database("admin").RunCommand(context.Background(), bson.D{
+		{Key: "renameCollection", Value: db.Name() + "." + coll.Name()},
+		{Key: "to", Value: db.Name() + "." + target.Name()},
+		{Key: "dropTarget", Value: true},
+	}).Err()
+}
+
+// columnsIndex возвращает номера колонок файла по их назначению. Каждой колонке может
+// соответствовать несколько возможных названий в заголовке.
+func columnsIndex(header []string, names map[string][]string, required []string) (map[string]int, error) {
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	columns := make(map[string]int, len(names))
+	for column, names := range names {
+		for _, name := range names {
+			if i, ok := index[name]; ok {
+				columns[column] = i
+				break
+			}
+		}
+	}
+	for _, column := range required {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("column %q not found", column)
+		}
+	}
+	return columns, nil
+}
@@ -0,0 +1,279 @@
+// Программа lbs-replay повторяет журнал запросов на вычисление координат для двух наборов данных
+// и сравнивает результаты.
+//
+//	LBS requests replay and datasets comparison
+//	./lbs-replay [-params] requests.json[.gz]
+//	  -candidate string
+//	    	candidate dataset collection name
+//	  -candidate-mongo string
+//	    	candidate mongoDB connection URL (default: same as -mongo)
+//	  -collection string
+//	    	base dataset collection name (default "lbs")
+//	  -max-changed float
+//	    	max fraction of changed fixes (0 - unlimited)
+//	  -max-lost float
+//	    	max fraction of lost fixes (0 - unlimited)
+//	  -max-p90 float
+//	    	max 90th percentile of fix shift in meters (0 - unlimited)
+//	  -mongo string
+//	    	mongoDB connection URL (default "mongodb://localhost/geotrace")
+//	  -threshold float
+//	    	min fix shift in meters counted as change (default 10)
+//
+// Журнал запросов — файл, каждая строка которого содержит запрос в формате JSON, совпадающем с
+// форматом Mozilla Location Service (locator.Request). Файлы с расширением .gz распаковываются
+// автоматически, а вместо имени файла можно указать "-" для чтения со стандартного ввода.
+//
+// Каждый запрос выполняется для базового набора данных (-mongo и -collection) и для проверяемого
+// (-candidate-mongo и -candidate). Программа выводит, для скольких запросов координаты
+// изменились больше, чем на -threshold метров, насколько они сместились, а также сколько
+// запросов перестали или начали находиться. Если заданы ограничения -max-changed, -max-lost или
+// -max-p90 и они превышены, то программа завершается с кодом 1: это позволяет не допустить
+// перевода на новый набор данных, ухудшающий результаты.
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/lbs/v2"
+	"github.com/geotrace/locator"
+)
+
+func main() {
+	log.SetOutput(os.Stdout)
+	log.SetFlags(log.Ltime)
+	mongourl := flag.String("mongo", "mongodb://localhost/geotrace", "mongoDB connection URL")
+	name := flag.String("collection", lbs.CollectionName, "base dataset collection name")
+	candidateURL := flag.String("candidate-mongo", "", "candidate mongoDB connection URL (default: same as -mongo)")
+	candidateName := flag.String("candidate", "", "candidate dataset collection name")
+	threshold := flag.Float64("threshold", 10, "min fix shift in meters counted as change")
+	maxChanged := flag.Float64("max-changed", 0, "max fraction of changed fixes (0 - unlimited)")
+	maxLost := flag.Float64("max-lost", 0, "max fraction of lost fixes (0 - unlimited)")
+	maxP90 := flag.Float64("max-p90", 0, "max 90th percentile of fix shift in meters (0 - unlimited)")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, "LBS requests replay and datasets comparison\n")
+		fmt.Fprintf(os.Stderr, "%s [-params] requests.json[.gz]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *candidateURL == "" {
+		*candidateURL = *mongourl
+	}
+	if *candidateName == "" {
+		*candidateName = *name
+	}
+	if *candidateURL == *mongourl && *candidateName == *name {
+		fmt.Fprint(os.Stderr, "Candidate dataset is the same as base\n")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	log.Printf("Connecting to base MongoDB %q...", *mongourl)
+	base, err := lbs.Dial(ctx, *mongourl, lbs.Collection(*name))
+	if err != nil {
+		log.Printf("Error connecting to MongoDB: %v", err)
+		os.Exit(1)
+	}
+	defer base.Close()
+	log.Printf("Connecting to candidate MongoDB %q...", *candidateURL)
+	candidate, err := lbs.Dial(ctx, *candidateURL, lbs.Collection(*candidateName))
+	if err != nil {
+		log.Printf("Error connecting to MongoDB: %v", err)
+		os.Exit(1)
+	}
+	defer candidate.Close()
+
+	r, err := openLog(flag.Arg(0))
+	if err != nil {
+		log.Printf("Error opening requests log: %v", err)
+		os.Exit(1)
+	}
+	defer r.Close()
+
+	log.Printf("Replaying %q: %q vs %q...", flag.Arg(0), *name, *candidateName)
+	var stats comparison
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	var line int
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var req lbs.Request
+		if err := json.Unmarshal([]byte(text), &req); err != nil {
+			log.Printf("Line %d: bad request: %v", line, err)
+			stats.bad++
+			continue
+		}
+		respA, err := locate(ctx, base, req)
+		if err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(1)
+		}
+		respB, err := locate(ctx, candidate, req)
+		if err != nil {
+			log.Printf("Error: %v", err)
+			os.Exit(1)
+		}
+		stats.add(respA, respB, *threshold)
+		if stats.total%1000 == 0 {
+			fmt.Fprintf(os.Stderr, "\r* replayed %8d requests ", stats.total)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error reading requests log: %v", err)
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stderr, "")
+	stats.report()
+
+	failed := false
+	if *maxChanged > 0 && stats.fraction(stats.changed) > *maxChanged {
+		log.Printf("FAIL: changed fixes %.2f%% > %.2f%%", 100*stats.fraction(stats.changed), 100**maxChanged)
+		failed = true
+	}
+	if *maxLost > 0 && stats.fraction(stats.lost) > *maxLost {
+		log.Printf("FAIL: lost fixes %.2f%% > %.2f%%", 100*stats.fraction(stats.lost), 100**maxLost)
+		failed = true
+	}
+	if *maxP90 > 0 && stats.percentile(0.9) > *maxP90 {
+		log.Printf("FAIL: 90th percentile of fix shift %.0f m > %.0f m", stats.percentile(0.9), *maxP90)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// openLog открывает журнал запросов. Файлы с расширением .gz распаковываются, а "-" означает
+// стандартный ввод.
+func openLog(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return os.Stdin, nil
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(name, ".gz") {
+		return file, nil
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &gzipFile{Reader: gz, file: file}, nil
+}
+
+// gzipFile закрывает вместе с распаковщиком и сам файл.
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (f *gzipFile) Close() error {
+	f.Reader.Close()
+	return f.file.Close()
+}
+
+// locate вычисляет координаты по запросу. Если вышки не найдены или запрос пустой, то
+// возвращает nil без ошибки.
+func locate(ctx context.Context, db *lbs.DB, req lbs.Request) (*locator.Response, error) {
+	details, err := db.GetDetailed(ctx, req)
+	switch {
+	case err == nil:
+		return details.Response, nil
+	case errors.Is(err, lbs.ErrNotFound), err == lbs.ErrEmptyRequest:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// comparison накапливает статистику сравнения результатов для двух наборов данных.
+type comparison struct {
+	total    int       // количество запросов
+	bad      int       // количество строк с ошибками
+	both     int       // координаты определены в обоих наборах
+	neither  int       // координаты не определены ни в одном наборе
+	lost     int       // координаты определены только в базовом наборе
+	gained   int       // координаты определены только в проверяемом наборе
+	changed  int       // координаты сместились больше порога
+	shifts   []float64 // смещения координат, м
+	accuracy float64   // суммарное изменение точности, м
+}
+
+// add учитывает результаты одного запроса.
+func (c *comparison) add(a, b *locator.Response, threshold float64) {
+	c.total++
+	switch {
+	case a == nil && b == nil:
+		c.neither++
+	case b == nil:
+		c.lost++
+	case a == nil:
+		c.gained++
+	default:
+		c.both++
+		shift := lbs.Distance(geo.NewPoint(a.Location.Lng, a.Location.Lat),
+			geo.NewPoint(b.Location.Lng, b.Location.Lat))
+		c.shifts = append(c.shifts, shift)
+		if shift > threshold {
+			c.changed++
+		}
+		c.accuracy += b.Accuracy - a.Accuracy
+	}
+}
+
+// fraction возвращает долю от общего количества запросов.
+func (c *comparison) fraction(n int) float64 {
+	if c.total == 0 {
+		return 0
+	}
+	return float64(n) / float64(c.total)
+}
+
+// percentile возвращает процентиль смещения координат.
+func (c *comparison) percentile(p float64) float64 {
+	if len(c.shifts) == 0 {
+		return 0
+	}
+	if !sort.Float64sAreSorted(c.shifts) {
+		sort.Float64s(c.shifts)
+	}
+	return c.shifts[int(p*float64(len(c.shifts)-1))]
+}
+
+// report выводит результаты сравнения.
+func (c *comparison) report() {
+	log.Printf("Requests: %d (bad lines: %d)", c.total, c.bad)
+	log.Printf("Found in both: %d, neither: %d, lost: %d (%.2f%%), gained: %d (%.2f%%)",
+		c.both, c.neither, c.lost, 100*c.fraction(c.lost), c.gained, 100*c.fraction(c.gained))
+	log.Printf("Changed fixes: %d (%.2f%%)", c.changed, 100*c.fraction(c.changed))
+	if len(c.shifts) == 0 {
+		return
+	}
+	log.Printf("Fix shift, m: median %.0f, p90 %.0f, p95 %.0f, max %.0f",
+		c.percentile(0.5), c.percentile(0.9), c.percentile(0.95), c.percentile(1))
+	log.Printf("Mean accuracy change: %+.0f m", c.accuracy/float64(c.both))
+}
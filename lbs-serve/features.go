@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// feature описывает подсистему сервера, которую можно включить или выключить параметром
+// -features без пересборки программы.
+type feature struct {
+	name        string // название в параметре -features
+	description string // описание для журнала
+	enabled     bool   // подсистема включена по умолчанию
+}
+
+// features содержит все подсистемы, которыми управляет параметр -features.
+var features = []feature{
+	{"admin", "administrative API /admin", true},
+	{"cells", "cells search API /api/cells", true},
+	{"geosubmit", "measurements submission API /v2/geosubmit", false},
+	{"ipf", "IP address fallback with -geoip", true},
+	{"lacf", "location area center fallback", false},
+	{"wifi", "Wi-Fi access points in requests", true},
+}
+
+// featureSet описывает подсистемы, включенные при запуске сервера.
+type featureSet map[string]bool
+
+// parseFeatures разбирает значение параметра -features: список подсистем через запятую, которые
+// нужно включить, а с префиксом "-" — выключить. Подсистемы, не упомянутые в списке, сохраняют
+// состояние по умолчанию.
+func parseFeatures(list string) (featureSet, error) {
+	set := make(featureSet, len(features))
+	for _, f := range features {
+		set[f.name] = f.enabled
+	}
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name == "" {
+			continue
+		}
+		enabled := !strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(strings.TrimPrefix(name, "-"), "+")
+		if _, ok := set[name]; !ok {
+			return nil, fmt.Errorf("unknown feature %q (available: %s)", name, featureNames())
+		}
+		set[name] = enabled
+	}
+	return set, nil
+}
+
+// featureNames возвращает названия всех подсистем через запятую.
+func featureNames() string {
+	names := make([]string, len(features))
+	for i, f := range features {
+		names[i] = f.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// String возвращает включенные и выключенные подсистемы для журнала.
+func (s featureSet) String() string {
+	var on, off []string
+	for _, f := range features {
+		if s[f.name] {
+			on = append(on, f.name)
+		} else {
+			off = append(off, f.name+" ("+f.description+")")
+		}
+	}
+	if len(on) == 0 {
+		on = append(on, "none")
+	}
+	result := "enabled: " + strings.Join(on, ", ")
+	if len(off) > 0 {
+		result += "; disabled: " + strings.Join(off, ", ")
+	}
+	return result
+}
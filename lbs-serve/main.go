@@ -0,0 +1,346 @@
+// Программа lbs-serve предоставляет доступ к хранилищу LBS по HTTP.
+//
+//	LBS database HTTP server
+//	./lbs-serve [-params]
+//	  -addr string
+//	    	HTTP server address (default ":8080")
+//	  -batch-size int
+//	    	max requests in one batch (default 100)
+//	  -batch-window duration
+//	    	coalesce requests arriving within this window into one batch (0 - disabled)
+//	  -cache-size int
+//	    	max cells in memory cache (0 - disabled)
+//	  -cache-ttl duration
+//	    	cells cache TTL (default 1h0m0s)
+//	  -country-fallback
+//	    	return country center by mobile country code if nothing is found
+//	  -daily-limit int
+//	    	default daily requests limit per API key (0 - unlimited)
+//	  -features string
+//	    	subsystems to enable, or disable with "-" prefix (comma separated): admin, cells, geosubmit, ipf, lacf, wifi
+//	  -fuzzy-cells int
+//	    	locate by nearest cells of the same area if no request cell is found (0 - disabled)
+//	  -geoip file
+//	    	MaxMind GeoIP2 or GeoLite2 City file to locate clients by IP address if nothing is found
+//	  -keys file
+//	    	allowed API keys file with optional daily limits (default - any key)
+//	  -max-accuracy float
+//	    	max response accuracy in meters (0 - unlimited)
+//	  -max-pool-size uint
+//	    	max connections to each MongoDB server (0 - from URL)
+//	  -max-towers int
+//	    	max towers used from one request (0 - unlimited) (default 32)
+//	  -min-accuracy float
+//	    	min response accuracy in meters
+//	  -mongo string
+//	    	mongoDB connection URL (default "mongodb://localhost/geotrace")
+//	  -multilateration
+//	    	locate by distances to towers estimated from signal strength and timing advance
+//	  -outlier-factor float
+//	    	reject cells farther than factor * median distance from the others (0 - disabled)
+//	  -query-concurrency int
+//	    	max parallel MongoDB queries for one request (0 - unlimited) (default 4)
+//	  -query-timeout duration
+//	    	max MongoDB query time for one request (0 - unlimited) (default 10s)
+//	  -radio-fallback string
+//	    	radio types tried in order for cells without radio type (comma separated, default - gsm)
+//	  -read-preference string
+//	    	MongoDB replica set members to read from: primary, primaryPreferred, secondary, secondaryPreferred or nearest (default - from URL)
+//	  -redis URL
+//	    	redis URL for shared cells cache (overrides -cache-size)
+//	  -result-cache-size int
+//	    	max resolved positions in memory cache (0 - disabled)
+//	  -result-cache-ttl duration
+//	    	resolved positions cache TTL (default 5m0s)
+//	  -signal-weighted
+//	    	weight towers by signal strength and timing advance
+//	  -stale-age duration
+//	    	lower weight of cells not updated for this duration (0 - disabled)
+//	  -stale-weight float
+//	    	weight factor of stale cells (0 - not used)
+//	  -store-results
+//	    	store resolved positions in MongoDB shared by all servers
+//	  -ui
+//	    	serve web UI with a map of cells
+//
+// Сервер поддерживает следующие запросы:
+//
+//	POST /v1/geolocate?key=...
+//	    	вычисление координат в формате Mozilla Location Service
+//	POST /v1/country?key=...
+//	    	определение страны по коду страны вышек в формате Mozilla Location Service
+//	POST /v2/geosubmit?key=...
+//	    	измерения устройств в формате Mozilla Location Service (если включена подсистема geosubmit)
+//	GET /api/cells?radio=gsm&mcc=250&mnc=2&lac=7743&cell=22517&limit=100
+//	    	поиск записей о сотовых вышках (любые параметры можно не указывать)
+//	GET /readyz
+//	    	проверка доступности хранилища
+//	GET /debug/vars
+//	    	метрики сервера, включая гистограммы времени выполнения запросов
+//	GET /metrics
+//	    	те же метрики в формате Prometheus
+//	POST /debug/locate
+//	    	подробный результат вычисления координат для запроса в формате JSON
+//	GET /debug/locate
+//	    	страница, отображающая найденные вышки, их веса и вычисленные координаты на карте
+//	GET /admin/usage?key=...&days=7
+//	    	количество запросов по ключам API за последние дни
+//	GET /admin/import
+//	    	сведения о последнем успешном импорте данных: источник, версия, фильтры и количество записей
+//
+// Запрос /v1/geolocate совместим с Mozilla Location Service: существующие клиенты MLS могут
+// использовать сервер, указав его адрес вместо https://location.services.mozilla.com. Если
+// координаты вычислить не удалось, возвращается ответ 404 с ошибкой notFound, а на запрос, который
+// не удалось разобрать, — ответ 400 с ошибкой parseError.
+//
+// Запросы на вычисление координат учитываются по ключу API, переданному в параметре key. Если
+// задан параметр -keys, то принимаются только ключи, перечисленные в файле: в каждой строке
+// файла указывается ключ и, через пробел, дневное ограничение количества запросов (если
+// ограничение не указано, то используется -daily-limit). Запросы сверх ограничения отклоняются с
+// ошибкой dailyLimitExceeded. Пути /debug и /admin не предназначены для внешних клиентов и должны
+// быть закрыты на уровне прокси.
+//
+// Параметры -cache-size и -cache-ttl включают кеширование записей о вышках в памяти, а -redis —
+// в общем для нескольких серверов Redis. Изменения, внесенные импортом или
+// lbs-admin, видны после устаревания записей в кеше.
+//
+// Параметр -result-cache-size включает кеширование вычисленных координат для одного и того же
+// набора вышек и точек доступа на время -result-cache-ttl (см. lbs.WithResultCache): повторяющиеся
+// запросы неподвижных трекеров не обращаются к MongoDB. Параметр -store-results дополнительно
+// сохраняет результаты в MongoDB, чтобы ими пользовались все серверы.
+//
+// Запросы к MongoDB прерываются, если клиент закрыл соединение, не дождавшись ответа, или если
+// они выполняются дольше -query-timeout. Вышки разных типов радио и операторов из одного запроса
+// ищутся параллельно, не более -query-concurrency запросов одновременно.
+//
+// Параметр -read-preference=secondaryPreferred распределяет запросы чтения по вторичным серверам
+// набора реплик MongoDB (см. lbs.ReadPreference), а -max-pool-size ограничивает количество
+// соединений с каждым сервером (см. lbs.MaxPoolSize). Значения флагов переопределяют параметры
+// readPreference и maxPoolSize из URL -mongo.
+//
+// Параметр -signal-weighted включает вычисление координат с весами вышек, зависящими от уровня
+// сигнала и времени задержки из запроса (см. lbs.SignalWeighted). Параметр -multilateration
+// включает вычисление координат по этим расстояниям методом наименьших квадратов, если найдено
+// не меньше трех вышек (см. lbs.Multilateration); иначе координаты вычисляются как обычно.
+//
+// Параметр -radio-fallback задает типы радио, под которыми по порядку ищутся вышки, если тип
+// радио не указан ни для запроса, ни для вышки (см. lbs.RadioFallback), например, lte,umts,gsm.
+//
+// Параметр -outlier-factor включает отбрасывание вышек, удаленных от остальных (см.
+// lbs.RejectOutliers; рекомендуемое значение — 3), а -min-accuracy и -max-accuracy ограничивают
+// радиус точности ответа (см. lbs.MinAccuracy и lbs.MaxAccuracy).
+//
+// Параметр -stale-age включает учет возраста записей: вес вышек, не подтверждавшихся дольше
+// указанного времени (например, 26280h — три года), умножается на -stale-weight, а при нулевом
+// -stale-weight такие вышки не используются (см. lbs.StaleCells).
+//
+// Параметр -fuzzy-cells включает приблизительный поиск после перенумерации вышек: если ни одна
+// вышка из запроса не найдена, то координаты вычисляются по указанному количеству вышек той же
+// зоны с ближайшими идентификаторами, а радиус точности увеличивается (см. lbs.FuzzyAreaLookup;
+// рекомендуемое значение — 3).
+//
+// Параметр -country-fallback включает последний вариант поиска: если не найдено ничего, то
+// возвращается центр страны по коду страны вышки с радиусом точности, покрывающим всю страну (см.
+// lbs.CountryFallback).
+//
+// Параметр -geoip включает приблизительный поиск по IP-адресу клиента (первому адресу из заголовка
+// X-Forwarded-For или адресу соединения) по базе MaxMind GeoIP2 или GeoLite2 City: если по вышкам и
+// точкам доступа Wi-Fi координаты вычислить не удалось, то возвращаются координаты города (см.
+// lbs.IPFallback). Этот вариант проверяется раньше -country-fallback.
+//
+// Параметр -features включает и выключает подсистемы сервера при запуске: например,
+// -features=-wifi,-admin отключает использование точек доступа Wi-Fi (см. lbs.IgnoreWifi) и пути
+// /admin. Подсистемы, не упомянутые в списке, остаются в состоянии по умолчанию; в журнал при
+// запуске выводится состояние всех подсистем. Подсистема ipf позволяет временно отключить поиск
+// по IP-адресу, не убирая -geoip, а подсистема lacf включает для всех запросов /v1/geolocate и
+// /debug/locate возврат центра зоны, если ни одна вышка из запроса не найдена (см.
+// lbs.GetOptions.AreaFallback); клиент может отказаться от него флагом "lacf": false. Подсистемы
+// geosubmit и lacf по умолчанию выключены.
+//
+// Запрос /v2/geosubmit принимает измерения устройств с координатами GPS в формате Mozilla
+// Location Service и уточняет по ним записи о вышках и точках доступа Wi-Fi (см. lbs.DB.Submit).
+// Запросы учитываются по ключу API так же, как запросы на вычисление координат.
+//
+// Если указан параметр -ui, то по адресу / доступен веб-интерфейс с картой, на которой можно
+// найти вышки по MCC/MNC/LAC/CID и посмотреть сведения о них. Файлы интерфейса встроены в
+// программу; библиотека Leaflet и картографическая подложка загружаются браузером из интернета.
+//
+// Обработчики запросов находятся в пакете github.com/geotrace/lbs/v2/server (см. server.New).
+package main
+
+import (
+	"context"
+	"expvar"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/geotrace/lbs/v2"
+	"github.com/geotrace/lbs/v2/geoip"
+	"github.com/geotrace/lbs/v2/promcollector"
+	"github.com/geotrace/lbs/v2/rediscache"
+	"github.com/geotrace/lbs/v2/server"
+	"github.com/go-redis/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func main() {
+	log.SetOutput(os.Stdout)
+	log.SetFlags(log.Ltime)
+	mongourl := flag.String("mongo", "mongodb://localhost/geotrace", "mongoDB connection URL")
+	addr := flag.String("addr", ":8080", "HTTP server address")
+	maxTowers := flag.Int("max-towers", lbs.DefaultMaxTowers, "max towers used from one request (0 - unlimited)")
+	keysFile := flag.String("keys", "", "allowed API keys `file` with optional daily limits (default - any key)")
+	dailyLimit := flag.Int("daily-limit", 0, "default daily requests limit per API key (0 - unlimited)")
+	batchWindow := flag.Duration("batch-window", 0, "coalesce requests arriving within this window into one batch (0 - disabled)")
+	batchSize := flag.Int("batch-size", 100, "max requests in one batch")
+	cacheSize := flag.Int("cache-size", 0, "max cells in memory cache (0 - disabled)")
+	cacheTTL := flag.Duration("cache-ttl", time.Hour, "cells cache TTL")
+	radioFallback := flag.String("radio-fallback", "", "radio types tried in order for cells without radio type (comma separated, default - gsm)")
+	resultCacheSize := flag.Int("result-cache-size", 0, "max resolved positions in memory cache (0 - disabled)")
+	resultCacheTTL := flag.Duration("result-cache-ttl", 5*time.Minute, "resolved positions cache TTL")
+	storeResults := flag.Bool("store-results", false, "store resolved positions in MongoDB shared by all servers")
+	redisURL := flag.String("redis", "", "redis `URL` for shared cells cache (overrides -cache-size)")
+	queryConcurrency := flag.Int("query-concurrency", lbs.DefaultQueryConcurrency, "max parallel MongoDB queries for one request (0 - unlimited)")
+	queryTimeout := flag.Duration("query-timeout", 10*time.Second, "max MongoDB query time for one request (0 - unlimited)")
+	readPreference := flag.String("read-preference", "", "MongoDB replica set members to read from: primary, primaryPreferred, secondary, secondaryPreferred or nearest (default - from URL)")
+	maxPoolSize := flag.Uint64("max-pool-size", 0, "max connections to each MongoDB server (0 - from URL)")
+	signalWeighted := flag.Bool("signal-weighted", false, "weight towers by signal strength and timing advance")
+	minAccuracy := flag.Float64("min-accuracy", 0, "min response accuracy in meters")
+	maxAccuracy := flag.Float64("max-accuracy", 0, "max response accuracy in meters (0 - unlimited)")
+	outlierFactor := flag.Float64("outlier-factor", 0, "reject cells farther than factor * median distance from the others (0 - disabled)")
+	multilateration := flag.Bool("multilateration", false, "locate by distances to towers estimated from signal strength and timing advance")
+	staleAge := flag.Duration("stale-age", 0, "lower weight of cells not updated for this duration (0 - disabled)")
+	staleWeight := flag.Float64("stale-weight", 0, "weight factor of stale cells (0 - not used)")
+	fuzzyCells := flag.Int("fuzzy-cells", 0, "locate by nearest cells of the same area if no request cell is found (0 - disabled)")
+	countryFallback := flag.Bool("country-fallback", false, "return country center by mobile country code if nothing is found")
+	geoIPFile := flag.String("geoip", "", "MaxMind GeoIP2 or GeoLite2 City `file` to locate clients by IP address if nothing is found")
+	ui := flag.Bool("ui", false, "serve web UI with a map of cells")
+	featureList := flag.String("features", "", "subsystems to enable, or disable with \"-\" prefix (comma separated): "+featureNames())
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, "LBS database HTTP server\n")
+		fmt.Fprintf(os.Stderr, "%s [-params]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	enabled, err := parseFeatures(*featureList)
+	if err != nil {
+		log.Printf("Bad -features: %v", err)
+		os.Exit(2)
+	}
+	log.Printf("Features %s", enabled)
+
+	serverOpts := server.Options{
+		DailyLimit:   *dailyLimit,
+		BatchWindow:  *batchWindow,
+		BatchSize:    *batchSize,
+		AreaFallback: enabled["lacf"],
+		Admin:        enabled["admin"],
+		Cells:        enabled["cells"],
+		Geosubmit:    enabled["geosubmit"],
+		UI:           *ui,
+	}
+	if *keysFile != "" {
+		if serverOpts.Keys, err = server.LoadKeys(*keysFile); err != nil {
+			log.Printf("Error loading API keys: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	opts := []lbs.Option{lbs.HealthCheck(10 * time.Second), lbs.MaxTowers(*maxTowers),
+		lbs.QueryTimeout(*queryTimeout), lbs.QueryConcurrency(*queryConcurrency),
+		lbs.MinAccuracy(*minAccuracy), lbs.MaxAccuracy(*maxAccuracy),
+		lbs.RejectOutliers(*outlierFactor), lbs.FuzzyAreaLookup(*fuzzyCells), lbs.WithLogger(slog.Default())}
+	if *signalWeighted {
+		opts = append(opts, lbs.SignalWeighted())
+	}
+	if *multilateration {
+		opts = append(opts, lbs.Multilateration())
+	}
+	if *staleAge > 0 {
+		opts = append(opts, lbs.StaleCells(*staleAge, *staleWeight))
+	}
+	if *countryFallback {
+		opts = append(opts, lbs.CountryFallback())
+	}
+	if *readPreference != "" {
+		mode, err := readpref.ModeFromString(*readPreference)
+		if err != nil {
+			log.Printf("Bad -read-preference: %v", err)
+			os.Exit(2)
+		}
+		opts = append(opts, lbs.ReadPreference(mode))
+	}
+	if *maxPoolSize > 0 {
+		opts = append(opts, lbs.MaxPoolSize(*maxPoolSize))
+	}
+	if *geoIPFile != "" && enabled["ipf"] {
+		reader, err := geoip.Open(*geoIPFile)
+		if err != nil {
+			log.Printf("Error opening GeoIP database: %v", err)
+			os.Exit(1)
+		}
+		defer reader.Close()
+		opts = append(opts, lbs.IPFallback(reader))
+	}
+	if !enabled["wifi"] {
+		opts = append(opts, lbs.IgnoreWifi())
+	}
+	if *radioFallback != "" {
+		opts = append(opts, lbs.RadioFallback(strings.Split(*radioFallback, ",")...))
+	}
+	if *redisURL != "" {
+		redisOpts, err := redis.ParseURL(*redisURL)
+		if err != nil {
+			log.Printf("Bad Redis URL: %v", err)
+			os.Exit(2)
+		}
+		client := redis.NewClient(redisOpts)
+		defer client.Close()
+		opts = append(opts, lbs.WithCache(rediscache.New(client), *cacheTTL))
+	} else if *cacheSize > 0 {
+		opts = append(opts, lbs.WithCache(lbs.NewLRUCache(*cacheSize), *cacheTTL))
+	}
+	if *resultCacheSize > 0 {
+		opts = append(opts, lbs.WithResultCache(lbs.NewLRUCache(*resultCacheSize), *resultCacheTTL))
+	} else if *storeResults {
+		opts = append(opts, lbs.WithResultCache(nil, *resultCacheTTL))
+	}
+	if *storeResults {
+		opts = append(opts, lbs.StoreResults())
+	}
+
+	log.Printf("Connecting to MongoDB %q...", *mongourl)
+	db, err := lbs.Dial(context.Background(), *mongourl, opts...)
+	if err != nil {
+		log.Printf("Error connecting to MongoDB: %v", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	expvar.Publish("lbs", db.Metrics())
+	prometheus.MustRegister(promcollector.New(db.Metrics(), "lbs"))
+
+	mux := server.New(db, serverOpts)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/metrics", promhttp.Handler())
+
+	log.Printf("Listening on %q...", *addr)
+	srv := &http.Server{
+		Addr:         *addr,
+		Handler:      mux,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	if err := srv.ListenAndServe(); err != nil {
+		log.Printf("HTTP server error: %v", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,92 @@
+package lbs
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/geotrace/geo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// Registry задает правила преобразования в BSON, по которым координаты (geo.Point) сохраняются в
+// MongoDB как точка GeoJSON {type: "Point", coordinates: [долгота, широта]}. Читаются координаты
+// в обоих форматах: и точки GeoJSON, и пары [долгота, широта] (legacy coordinate pair) из записей,
+// сохраненных до версии схемы 3 (см. MigrateSchema). DB использует эти правила во всех запросах
+// независимо от параметров клиента, а программам, которые работают с коллекциями напрямую, их
+// нужно указать в параметрах клиента:
+//
+//	client, err := mongo.Connect(ctx, options.Client().ApplyURI(url).SetRegistry(lbs.Registry))
+var Registry = newRegistry()
+
+// pointType описывает тип координат, для которого регистрируются правила преобразования.
+var pointType = reflect.TypeOf(geo.Point{})
+
+// newRegistry возвращает правила преобразования по умолчанию, дополненные правилами для
+// координат.
+func newRegistry() *bsoncodec.Registry {
+	registry := bson.NewRegistry()
+	registry.RegisterTypeEncoder(pointType, bsoncodec.ValueEncoderFunc(encodePoint))
+	registry.RegisterTypeDecoder(pointType, bsoncodec.ValueDecoderFunc(decodePoint))
+	return registry
+}
+
+// geoJSONPoint описывает точку GeoJSON в хранилище.
+type geoJSONPoint struct {
+	Type        string    `bson:"type"`
+	Coordinates []float64 `bson:"coordinates"`
+}
+
+// encodePoint сохраняет координаты как точку GeoJSON.
+func encodePoint(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	if !val.IsValid() || val.Type() != pointType {
+		return bsoncodec.ValueEncoderError{Name: "encodePoint", Types: []reflect.Type{pointType}, Received: val}
+	}
+	point := val.Interface().(geo.Point)
+	kind, data, err := bson.MarshalValue(geoJSONPoint{
+		Type:        "Point",
+		Coordinates: []float64{point.Longitude(), point.Latitude()},
+	})
+	if err != nil {
+		return err
+	}
+	return bsonrw.Copier{}.CopyValueFromBytes(vw, kind, data)
+}
+
+// errBadLocation возвращается при чтении координат в неизвестном формате.
+var errBadLocation = errors.New("lbs: bad location: GeoJSON point or [lon, lat] pair expected")
+
+// decodePoint читает координаты из точки GeoJSON или пары [долгота, широта].
+func decodePoint(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	if !val.CanSet() || val.Type() != pointType {
+		return bsoncodec.ValueDecoderError{Name: "decodePoint", Types: []reflect.Type{pointType}, Received: val}
+	}
+	kind, data, err := bsonrw.Copier{}.CopyValueToBytes(vr)
+	if err != nil {
+		return err
+	}
+	raw := bson.RawValue{Type: kind, Value: data}
+	var coordinates []float64
+	switch kind {
+	case bsontype.Null, bsontype.Undefined:
+		val.Set(reflect.Zero(pointType))
+		return nil
+	case bsontype.Array:
+		err = raw.Unmarshal(&coordinates)
+	case bsontype.EmbeddedDocument:
+		var point geoJSONPoint
+		if err = raw.Unmarshal(&point); err == nil && point.Type != "Point" {
+			return errBadLocation
+		}
+		coordinates = point.Coordinates
+	default:
+		return errBadLocation
+	}
+	if err != nil || len(coordinates) < 2 {
+		return errBadLocation
+	}
+	val.Set(reflect.ValueOf(geo.NewPoint(coordinates[0], coordinates[1])))
+	return nil
+}
@@ -0,0 +1,68 @@
+package lbs
+
+import (
+	"testing"
+
+	"github.com/geotrace/geo"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRegistry(t *testing.T) {
+	data, err := bson.MarshalWithRegistry(Registry, Data{Location: geo.NewPoint(37.6, 55.7), Accuracy: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored struct {
+		Location struct {
+			Type        string    `bson:"type"`
+			Coordinates []float64 `bson:"coordinates"`
+		} `bson:"location"`
+	}
+	if err := bson.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Location.Type != "Point" || len(stored.Location.Coordinates) != 2 ||
+		stored.Location.Coordinates[0] != 37.6 || stored.Location.Coordinates[1] != 55.7 {
+		t.Errorf("bad stored location: %+v", stored.Location)
+	}
+	for name, doc := range map[string]bson.M{
+		"geojson": {"location": bson.M{"type": "Point", "coordinates": bson.A{37.6, 55.7}}},
+		"legacy":  {"location": bson.A{37.6, 55.7}},
+		"int":     {"location": bson.A{int32(37), int64(55)}},
+	} {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cell Data
+		if err := bson.UnmarshalWithRegistry(Registry, data, &cell); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if lon, lat := cell.Location.Longitude(), cell.Location.Latitude(); lon < 37 || lon > 37.6 || lat < 55 || lat > 55.7 {
+			t.Errorf("%s: bad location: %v", name, cell.Location)
+		}
+	}
+	var empty Data
+	if data, err = bson.Marshal(bson.M{"location": nil}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bson.UnmarshalWithRegistry(Registry, data, &empty); err != nil || empty.Location != (geo.Point{}) {
+		t.Errorf("bad null location: %v, %v", empty.Location, err)
+	}
+	for name, location := range map[string]interface{}{
+		"short":   bson.A{37.6},
+		"line":    bson.M{"type": "LineString", "coordinates": bson.A{bson.A{37.6, 55.7}}},
+		"string":  "37.6,55.7",
+		"strings": bson.A{"37.6", "55.7"},
+	} {
+		data, err := bson.Marshal(bson.M{"location": location})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cell Data
+		if err := bson.UnmarshalWithRegistry(Registry, data, &cell); err == nil {
+			t.Errorf("%s: bad location accepted: %v", name, cell.Location)
+		}
+	}
+}
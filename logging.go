@@ -0,0 +1,57 @@
+package lbs
+
+import (
+	"context"
+	"log/slog"
+)
+
+// WithLogger задает журнал, в который DB выводит сообщения о событиях, не влияющих на результат
+// вызова: пропадании и восстановлении связи с сервером MongoDB, ошибках сохранения измерений и
+// результатов (уровень Warn), а также о каждом вычислении координат с количеством запрошенных и
+// найденных вышек (уровень Debug). Сообщения содержат поля, например mcc, mnc и cells, поэтому
+// приложение может разбирать их и настраивать вывод с помощью своего обработчика slog. По
+// умолчанию сообщения не выводятся.
+func WithLogger(logger *slog.Logger) Option {
+	return func(db *DB) {
+		db.logger = logger
+	}
+}
+
+// log возвращает журнал DB или журнал, отбрасывающий все сообщения, если WithLogger не задан.
+func (db *DB) log() *slog.Logger {
+	if db.logger == nil {
+		return discardLogger
+	}
+	return db.logger
+}
+
+// discardLogger отбрасывает все сообщения.
+var discardLogger = slog.New(discardHandler{})
+
+// discardHandler описывает обработчик slog, не принимающий сообщений ни одного уровня.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// logLookup выводит в журнал результат вычисления координат для запроса с вышками keys.
+func (db *DB) logLookup(ctx context.Context, keys []Key, details *Details, err error) {
+	logger := db.log()
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	attrs := []any{"cells", len(keys)}
+	if len(keys) > 0 {
+		attrs = append(attrs, "mcc", keys[0].MobileCountryCode, "mnc", keys[0].MobileNetworkCode)
+	}
+	if err != nil {
+		logger.DebugContext(ctx, "Position not found", append(attrs, "error", err)...)
+		return
+	}
+	logger.DebugContext(ctx, "Position found", append(attrs,
+		"matched", details.CellCounts.Matched,
+		"wifi", details.WifiCounts.Matched,
+		"accuracy", details.Response.Accuracy)...)
+}
@@ -0,0 +1,117 @@
+package lbs
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Статус импорта данных.
+const (
+	ImportRunning = "running" // импорт выполняется
+	ImportDone    = "done"    // данные успешно импортированы
+)
+
+// ImportInfo описывает сведения об импорте данных, которые сохраняются в коллекции метаданных
+// MetaCollectionName: откуда и когда были загружены данные, с какими фильтрами и сколько записей
+// было обработано.
+//
+// В качестве идентификатора используется контрольная сумма импортированного файла, поэтому для
+// одного и того же файла может существовать только одна запись.
+type ImportInfo struct {
+	ID         string        `bson:"_id" json:"id"`
+	Type       string        `bson:"type" json:"-"`                          // всегда "import"
+	Source     string        `bson:"source" json:"source"`                   // имя файла или URL
+	SHA256     string        `bson:"sha256" json:"sha256"`                   // контрольная сумма файла
+	Version    string        `bson:"version" json:"version"`                 // версия набора данных
+	Mode       string        `bson:"mode" json:"mode"`                       // replace, merge, diff, partial или observations
+	Status     string        `bson:"status" json:"status"`                   // статус импорта
+	Started    time.Time     `bson:"started" json:"started"`                 // время начала импорта
+	Finished   time.Time     `bson:"finished,omitempty" json:"finished"`     // время завершения
+	Filters    ImportFilters `bson:"filters" json:"filters"`                 // примененные фильтры
+	Countries  []uint16      `bson:"countries,omitempty" json:"countries"`   // коды стран в данных
+	Lines      uint64        `bson:"lines" json:"lines"`                     // прочитано строк данных
+	Imported   uint64        `bson:"imported" json:"imported"`               // импортировано записей
+	Filtered   uint64        `bson:"filtered" json:"filtered"`               // отброшено фильтрами
+	Rejected   uint64        `bson:"rejected" json:"rejected"`               // отклонено из-за ошибок
+	Modified   int           `bson:"modified" json:"modified"`               // изменено записей в базе
+	Removed    int           `bson:"removed,omitempty" json:"removed"`       // удалено старых записей
+	TotalCells int           `bson:"total,omitempty" json:"total,omitempty"` // записей после импорта
+	Checkpoint uint64        `bson:"checkpoint,omitempty" json:"-"`          // сохранено до строки
+}
+
+// ImportFilters описывает фильтры, примененные при импорте данных.
+type ImportFilters struct {
+	Radio      []string  `bson:"radio,omitempty" json:"radio,omitempty"`           // типы радио
+	Country    []uint16  `bson:"country,omitempty" json:"country,omitempty"`       // коды стран
+	Network    []uint16  `bson:"mnc,omitempty" json:"mnc,omitempty"`               // коды операторов
+	Operators  []string  `bson:"operators,omitempty" json:"operators,omitempty"`   // пары MCC-MNC
+	MinSamples int64     `bson:"minsamples,omitempty" json:"minsamples,omitempty"` // подтверждений
+	Prune      int       `bson:"prune,omitempty" json:"prune,omitempty"`           // лет без подтверждений
+	SkipLines  uint64    `bson:"skip,omitempty" json:"skip,omitempty"`             // пропущено строк
+	MaxLines   uint64    `bson:"max,omitempty" json:"max,omitempty"`               // ограничение строк
+	Box        []float64 `bson:"bbox,omitempty" json:"bbox,omitempty"`             // прямоугольник
+	Region     string    `bson:"region,omitempty" json:"region,omitempty"`         // файл GeoJSON
+}
+
+// DatasetInfo описывает сведения о наборе данных, который используется хранилищем.
+type DatasetInfo struct {
+	Version  string    `json:"version,omitempty"`  // версия набора данных
+	Source   string    `json:"source,omitempty"`   // источник последнего импорта
+	Mode     string    `json:"mode,omitempty"`     // режим последнего импорта
+	Imported time.Time `json:"imported,omitempty"` // время завершения последнего импорта
+	Records  int       `json:"records"`            // количество записей в хранилище
+}
+
+// ImportInfo возвращает полные сведения о последнем успешном импорте данных о вышках: источник,
+// контрольную сумму, версию набора данных, время, примененные фильтры и количество записей. По
+// ним можно определить, по какому набору данных сейчас вычисляются координаты. Импорт исходных
+// измерений (observations) данные о вышках не заменяет и поэтому не учитывается. Если сведений об
+// импорте нет (например, данные были загружены старой версией lbs-import), то возвращается nil
+// без ошибки.
+func (db *DB) ImportInfo(ctx context.Context) (*ImportInfo, error) {
+	mdb, err := db.database()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	var last ImportInfo
+	err = mdb.Collection(MetaCollectionName).
+		FindOne(ctx, bson.M{"type": "import", "status": ImportDone, "mode": bson.M{"$ne": "observations"}},
+			options.FindOne().SetSort(bson.M{"finished": -1})).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &last, nil
+}
+
+// DatasetInfo возвращает краткие сведения о последнем успешном импорте данных (см. ImportInfo) и
+// текущее количество записей в хранилище. Если сведений об импорте нет, то заполняется только
+// количество записей.
+func (db *DB) DatasetInfo(ctx context.Context) (*DatasetInfo, error) {
+	last, err := db.ImportInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if last == nil {
+		last = new(ImportInfo)
+	}
+	total, err := db.count(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &DatasetInfo{
+		Version:  last.Version,
+		Source:   last.Source,
+		Mode:     last.Mode,
+		Imported: last.Finished,
+		Records:  total,
+	}, nil
+}
@@ -0,0 +1,253 @@
+package lbs
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets описывает границы интервалов гистограммы времени выполнения, используемые
+// по умолчанию.
+var DefaultLatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// Histogram описывает гистограмму времени выполнения с фиксированными границами интервалов.
+// Безопасна для использования из нескольких потоков.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []time.Duration // верхние границы интервалов
+	counts []uint64        // количество значений в каждом интервале (последний — свыше всех границ)
+	count  uint64          // общее количество значений
+	sum    time.Duration   // суммарное время
+}
+
+// NewHistogram возвращает новую гистограмму с указанными границами интервалов. Границы должны
+// быть отсортированы по возрастанию. Если границы не указаны, то используются
+// DefaultLatencyBuckets.
+func NewHistogram(bounds ...time.Duration) *Histogram {
+	if len(bounds) == 0 {
+		bounds = DefaultLatencyBuckets
+	}
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe добавляет в гистограмму время выполнения.
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+	h.mu.Lock()
+	h.counts[i]++
+	h.count++
+	h.sum += d
+	h.mu.Unlock()
+}
+
+// Since добавляет в гистограмму время, прошедшее с указанного момента.
+func (h *Histogram) Since(start time.Time) {
+	h.Observe(time.Since(start))
+}
+
+// HistogramSnapshot описывает состояние гистограммы на момент запроса.
+type HistogramSnapshot struct {
+	Bounds []time.Duration `json:"bounds"` // верхние границы интервалов
+	Counts []uint64        `json:"counts"` // количество значений в интервалах (не накопительное)
+	Count  uint64          `json:"count"`  // общее количество значений
+	Sum    time.Duration   `json:"sum"`    // суммарное время
+}
+
+// Snapshot возвращает копию текущего состояния гистограммы.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	snapshot := HistogramSnapshot{
+		Bounds: h.bounds,
+		Counts: append([]uint64(nil), h.counts...),
+		Count:  h.count,
+		Sum:    h.sum,
+	}
+	h.mu.Unlock()
+	return snapshot
+}
+
+// String возвращает представление гистограммы в формате JSON. Это позволяет использовать
+// гистограмму в качестве expvar.Var.
+func (h *Histogram) String() string {
+	data, _ := json.Marshal(h.Snapshot())
+	return string(data)
+}
+
+// Counter описывает счетчик событий. Безопасен для использования из нескольких потоков.
+type Counter struct {
+	n uint64
+}
+
+// Add увеличивает значение счетчика.
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.n, n)
+}
+
+// Value возвращает текущее значение счетчика.
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.n)
+}
+
+// DefaultCountBuckets описывает границы интервалов гистограммы количества найденных вышек,
+// используемые по умолчанию.
+var DefaultCountBuckets = []int{0, 1, 2, 3, 5, 10, 20}
+
+// CountHistogram описывает гистограмму количества (например, найденных в запросе вышек) с
+// фиксированными границами интервалов. Безопасна для использования из нескольких потоков.
+type CountHistogram struct {
+	mu     sync.Mutex
+	bounds []int    // верхние границы интервалов
+	counts []uint64 // количество значений в каждом интервале (последний — свыше всех границ)
+	count  uint64   // общее количество значений
+	sum    uint64   // сумма значений
+}
+
+// NewCountHistogram возвращает новую гистограмму количества с указанными границами интервалов.
+// Границы должны быть отсортированы по возрастанию. Если границы не указаны, то используются
+// DefaultCountBuckets.
+func NewCountHistogram(bounds ...int) *CountHistogram {
+	if len(bounds) == 0 {
+		bounds = DefaultCountBuckets
+	}
+	return &CountHistogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe добавляет в гистограмму значение.
+func (h *CountHistogram) Observe(n int) {
+	i := 0
+	for i < len(h.bounds) && n > h.bounds[i] {
+		i++
+	}
+	h.mu.Lock()
+	h.counts[i]++
+	h.count++
+	h.sum += uint64(n)
+	h.mu.Unlock()
+}
+
+// CountSnapshot описывает состояние гистограммы количества на момент запроса.
+type CountSnapshot struct {
+	Bounds []int    `json:"bounds"` // верхние границы интервалов
+	Counts []uint64 `json:"counts"` // количество значений в интервалах (не накопительное)
+	Count  uint64   `json:"count"`  // общее количество значений
+	Sum    uint64   `json:"sum"`    // сумма значений
+}
+
+// Snapshot возвращает копию текущего состояния гистограммы.
+func (h *CountHistogram) Snapshot() CountSnapshot {
+	h.mu.Lock()
+	snapshot := CountSnapshot{
+		Bounds: h.bounds,
+		Counts: append([]uint64(nil), h.counts...),
+		Count:  h.count,
+		Sum:    h.sum,
+	}
+	h.mu.Unlock()
+	return snapshot
+}
+
+// Metrics содержит гистограммы времени выполнения отдельных этапов обработки запроса и счетчики
+// результатов вычисления координат. Гистограммы позволяют определить, на что именно тратится
+// время при высокой нагрузке, а счетчики — насколько хорошо база покрывает запросы клиентов: доля
+// запросов без результата и доля найденных вышек от запрошенных.
+//
+// Metrics реализует интерфейс expvar.Var, поэтому данные можно опубликовать вместе с остальными
+// метриками приложения:
+//
+//	expvar.Publish("lbs", db.Metrics())
+type Metrics struct {
+	// счетчики идут первыми: атомарные операции с ними требуют выравнивания по 64 битам и на
+	// 32-битных платформах
+	Lookups        Counter // запросы на вычисление координат
+	NotFound       Counter // запросы, для которых координаты не найдены (см. ErrNotFound)
+	Failed         Counter // запросы, завершившиеся другой ошибкой
+	CellsRequested Counter // вышки в запросах
+	CellsMatched   Counter // вышки из запросов, найденные в хранилище
+	WifiRequested  Counter // точки доступа Wi-Fi в запросах
+	WifiMatched    Counter // точки доступа из запросов, найденные в хранилище
+
+	Query     *Histogram      // формирование запроса
+	Mongo     *Histogram      // выполнение запроса к MongoDB (или к Storage, см. OpenStorage)
+	Algorithm *Histogram      // вычисление координат
+	Matched   *CountHistogram // количество найденных вышек в запросе
+}
+
+// newMetrics возвращает инициализированный набор гистограмм.
+func newMetrics() *Metrics {
+	return &Metrics{
+		Query:     NewHistogram(),
+		Mongo:     NewHistogram(),
+		Algorithm: NewHistogram(),
+		Matched:   NewCountHistogram(),
+	}
+}
+
+// lookup учитывает результат вычисления координат для запроса с requested вышками.
+func (m *Metrics) lookup(requested int, details *Details, err error) {
+	m.Lookups.Add(1)
+	var notFound *NotFoundError
+	switch {
+	case err == nil:
+		m.CellsRequested.Add(uint64(details.CellCounts.Requested))
+		m.CellsMatched.Add(uint64(details.CellCounts.Matched))
+		m.WifiRequested.Add(uint64(details.WifiCounts.Requested))
+		m.WifiMatched.Add(uint64(details.WifiCounts.Matched))
+		m.Matched.Observe(details.CellCounts.Matched)
+	case errors.As(err, &notFound):
+		m.NotFound.Add(1)
+		matched := requested - len(notFound.Missing)
+		if matched < 0 {
+			matched = 0
+		}
+		m.CellsRequested.Add(uint64(requested))
+		m.CellsMatched.Add(uint64(matched))
+		m.Matched.Observe(matched)
+	case errors.Is(err, ErrNotFound), err == ErrEmptyRequest:
+		m.NotFound.Add(1)
+		m.Matched.Observe(0)
+	default:
+		m.Failed.Add(1)
+	}
+}
+
+// String возвращает представление всех гистограмм в формате JSON.
+func (m *Metrics) String() string {
+	data, _ := json.Marshal(map[string]interface{}{
+		"query":          m.Query.Snapshot(),
+		"mongo":          m.Mongo.Snapshot(),
+		"algorithm":      m.Algorithm.Snapshot(),
+		"lookups":        m.Lookups.Value(),
+		"notFound":       m.NotFound.Value(),
+		"failed":         m.Failed.Value(),
+		"cellsRequested": m.CellsRequested.Value(),
+		"cellsMatched":   m.CellsMatched.Value(),
+		"wifiRequested":  m.WifiRequested.Value(),
+		"wifiMatched":    m.WifiMatched.Value(),
+		"matched":        m.Matched.Snapshot(),
+	})
+	return string(data)
+}
@@ -0,0 +1,65 @@
+package lbs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(time.Millisecond, 10*time.Millisecond)
+	h.Observe(500 * time.Microsecond)
+	h.Observe(time.Millisecond)
+	h.Observe(5 * time.Millisecond)
+	h.Observe(time.Second)
+	s := h.Snapshot()
+	if s.Count != 4 {
+		t.Errorf("bad count: %d", s.Count)
+	}
+	want := []uint64{2, 1, 1}
+	for i, count := range want {
+		if s.Counts[i] != count {
+			t.Errorf("bad bucket %d: %d", i, s.Counts[i])
+		}
+	}
+	if s.Sum != time.Second+6500*time.Microsecond {
+		t.Errorf("bad sum: %v", s.Sum)
+	}
+}
+
+func TestCountHistogram(t *testing.T) {
+	h := NewCountHistogram(0, 1, 3)
+	for _, n := range []int{0, 1, 2, 3, 7} {
+		h.Observe(n)
+	}
+	s := h.Snapshot()
+	if s.Count != 5 || s.Sum != 13 {
+		t.Errorf("bad count or sum: %d, %d", s.Count, s.Sum)
+	}
+	want := []uint64{1, 1, 2, 1}
+	for i, count := range want {
+		if s.Counts[i] != count {
+			t.Errorf("bad bucket %d: %d", i, s.Counts[i])
+		}
+	}
+}
+
+func TestMetricsLookup(t *testing.T) {
+	m := newMetrics()
+	m.lookup(3, &Details{
+		CellCounts: Counts{Requested: 3, Matched: 2, Used: 2},
+		WifiCounts: Counts{Requested: 4, Matched: 1},
+	}, nil)
+	m.lookup(2, nil, &NotFoundError{Missing: []Key{{CellId: 1}}})
+	m.lookup(0, nil, ErrEmptyRequest)
+	m.lookup(1, nil, ErrUnavailable)
+	if m.Lookups.Value() != 4 || m.NotFound.Value() != 2 || m.Failed.Value() != 1 {
+		t.Errorf("bad lookup counters: %v", m)
+	}
+	if m.CellsRequested.Value() != 5 || m.CellsMatched.Value() != 3 ||
+		m.WifiRequested.Value() != 4 || m.WifiMatched.Value() != 1 {
+		t.Errorf("bad match counters: %v", m)
+	}
+	if s := m.Matched.Snapshot(); s.Count != 3 || s.Sum != 3 {
+		t.Errorf("bad matched histogram: %+v", s)
+	}
+}
@@ -0,0 +1,99 @@
+package lbs
+
+import (
+	"math"
+
+	"github.com/geotrace/locator"
+)
+
+// Multilateration включает вычисление координат по вышкам методом наименьших квадратов:
+// ищется точка, расстояния от которой до найденных вышек лучше всего совпадают с расстояниями,
+// оцененными по времени задержки или уровню сигнала из запроса (см. SignalWeighted), а для вышек
+// без измерений — с радиусом их действия. Чем меньше оцененное расстояние, тем точнее оно
+// измерено и тем больше вес вышки. Если найдено меньше трех площадок (см. GroupSites), вышки
+// стоят на одной линии или решение выходит за пределы зоны действия вышек, то координаты
+// вычисляются как среднее положение вышек.
+//
+// По умолчанию координаты всегда вычисляются как среднее положение найденных вышек.
+func Multilateration() Option {
+	return func(db *DB) {
+		db.leastSquares = true
+	}
+}
+
+// Параметры решения методом Гаусса-Ньютона.
+const (
+	multilaterationSites      = 3   // минимальное количество площадок
+	multilaterationIterations = 20  // максимальное количество итераций
+	multilaterationPrecision  = 0.1 // изменение координат, при котором решение найдено, м
+)
+
+// multilaterate возвращает координаты, вычисленные по расстояниям до вышек, оцененным по
+// измерениям towers для ключей keys, или false, если вычислить их нельзя. Начальным приближением
+// служит точка lat, lon. Вычисления ведутся на плоскости, касательной к поверхности Земли в
+// начальной точке: на расстояниях действия сотовых вышек ее погрешность пренебрежимо мала.
+func multilaterate(lat, lon float64, cells []Cell, keys []Key, towers []*locator.CellTower) (float64, float64, bool) {
+	sites := make(map[Key]bool, len(cells))
+	for _, cell := range cells {
+		sites[cell.SiteKey()] = true
+	}
+	if len(sites) < multilaterationSites {
+		return lat, lon, false
+	}
+	measured := measuredTowers(keys, towers)
+	// координаты вышек на плоскости в метрах от начальной точки
+	scaleY := earthRadius * math.Pi / 180
+	scaleX := scaleY * math.Cos(lat*math.Pi/180)
+	xs, ys := make([]float64, len(cells)), make([]float64, len(cells))
+	ranges, weights := make([]float64, len(cells)), make([]float64, len(cells))
+	for i, cell := range cells {
+		xs[i] = (cell.Location.Longitude() - lon) * scaleX
+		ys[i] = (cell.Location.Latitude() - lat) * scaleY
+		dist := towerDistance(cell.RadioType, measured[cell.Key])
+		if dist <= 0 || dist > cell.Accuracy {
+			dist = cell.Accuracy
+		}
+		ranges[i] = math.Max(dist, minAccuracy)
+		weights[i] = 1 / (ranges[i] * ranges[i])
+	}
+	var x, y float64
+	for iteration := 0; iteration < multilaterationIterations; iteration++ {
+		// нормальные уравнения (JᵀWJ)Δ = -JᵀWr для невязок r = |p - pᵢ| - dᵢ
+		var a11, a12, a22, b1, b2 float64
+		for i := range xs {
+			dx, dy := x-xs[i], y-ys[i]
+			d := math.Hypot(dx, dy)
+			if d < minAccuracy {
+				d = minAccuracy // в точке вышки направление градиента не определено
+			}
+			jx, jy := dx/d, dy/d
+			r := math.Hypot(dx, dy) - ranges[i]
+			w := weights[i]
+			a11 += w * jx * jx
+			a12 += w * jx * jy
+			a22 += w * jy * jy
+			b1 -= w * jx * r
+			b2 -= w * jy * r
+		}
+		det := a11*a22 - a12*a12
+		if det <= 1e-9*(a11+a22)*(a11+a22) {
+			return lat, lon, false // вышки на одной линии: решение неоднозначно
+		}
+		stepX, stepY := (b1*a22-b2*a12)/det, (b2*a11-b1*a12)/det
+		x, y = x+stepX, y+stepY
+		if math.Hypot(stepX, stepY) < multilaterationPrecision {
+			break
+		}
+	}
+	resultLat, resultLon := lat+y/scaleY, lon+x/scaleX
+	if math.IsNaN(resultLat) || math.IsNaN(resultLon) {
+		return lat, lon, false
+	}
+	// решение за пределами зоны действия какой-либо из вышек противоречит тому, что она видна
+	for i, cell := range cells {
+		if math.Hypot(x-xs[i], y-ys[i]) > cell.Accuracy {
+			return lat, lon, false
+		}
+	}
+	return resultLat, resultLon, true
+}
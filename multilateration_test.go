@@ -0,0 +1,61 @@
+package lbs
+
+import (
+	"math"
+	"testing"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/locator"
+)
+
+func TestMultilateration(t *testing.T) {
+	cell := func(id uint64, lon, lat float64) Cell {
+		return Cell{Key: Key{RadioType: "lte", MobileCountryCode: 250, LocationAreaCode: 1, CellId: id},
+			Data: Data{Location: geo.NewPoint(lon, lat), Accuracy: 5000}}
+	}
+	cells := []Cell{cell(1, 37.60, 55.75), cell(2, 37.64, 55.75), cell(3, 37.62, 55.77)}
+	device := geo.NewPoint(37.605, 55.752)
+	keys := make([]Key, len(cells))
+	towers := make([]*locator.CellTower, len(cells))
+	for i, cell := range cells {
+		keys[i] = cell.Key
+		ta := math.Round(Distance(device, cell.Location)/lteTimingAdvance - 0.5)
+		towers[i] = &locator.CellTower{TimingAdvance: uint8(ta)}
+	}
+	db := newDB("test", []Option{Multilateration()})
+
+	details, err := db.locate(keys, towers, append([]Cell(nil), cells...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := geo.NewPoint(details.Response.Location.Lng, details.Response.Location.Lat)
+	if dist := Distance(result, device); dist > 100 {
+		t.Errorf("result too far from device: %.0f m", dist)
+	}
+	centroid, err := newDB("test", nil).locate(keys, towers, append([]Cell(nil), cells...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mean := geo.NewPoint(centroid.Response.Location.Lng, centroid.Response.Location.Lat)
+	if Distance(result, device) >= Distance(mean, device) {
+		t.Error("multilateration is not better than centroid")
+	}
+
+	// по двум вышкам координаты вычисляются как среднее положение
+	two, err := db.locate(keys[:2], towers[:2], append([]Cell(nil), cells[:2]...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lat, lon := two.Response.Location.Lat, two.Response.Location.Lng; math.Abs(lat-55.75) > 1e-9 ||
+		math.Abs(lon-37.62) > 1e-9 {
+		t.Errorf("no fallback to centroid: %f, %f", lat, lon)
+	}
+
+	// вышки одной площадки считаются одной точкой
+	sited := append([]Cell(nil), cells...)
+	sited[2] = cell(4, 37.6001, 55.75)
+	sited[2].Site = 1
+	if _, _, ok := multilaterate(55.75, 37.61, sited, keys, towers); ok {
+		t.Error("multilateration by two sites")
+	}
+}
@@ -0,0 +1,180 @@
+package lbs
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/geotrace/geo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// locationIndex описывает геопространственный индекс координат вышек. Координаты хранятся в виде
+// точки GeoJSON (см. Registry), а записи в формате пары [долгота, широта] из предыдущих версий
+// преобразует MigrateSchema. Индекс 2dsphere понимает оба формата, поэтому до миграции поиск тоже
+// работает.
+var locationIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: "location", Value: "2dsphere"}},
+	Options: options.Index().SetName("location_2dsphere"),
+}
+
+// CreateLocationIndex создает во всех коллекциях с данными о вышках геопространственный индекс
+// 2dsphere по координатам, который нужен для CellsNear и CellsInBounds. Повторное создание индекса
+// ничего не меняет, а lbs-import переносит его в новые коллекции при полном импорте. MongoDB не
+// создаст индекс, если в коллекции есть записи с координатами вне допустимого диапазона.
+func (db *DB) CreateLocationIndex(ctx context.Context) error {
+	mdb, err := db.database()
+	if err != nil {
+		return err
+	}
+	for _, name := range db.collections() {
+		if _, err := mdb.Collection(name).Indexes().CreateOne(ctx, locationIndex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CellsNear возвращает не более limit записей о вышках на расстоянии не больше radius метров от
+// точки point в порядке удаления от нее. Если limit не задан, то возвращается не более 100 записей.
+// Заблокированные вышки не возвращаются. Для поиска нужен индекс, созданный CreateLocationIndex.
+func (db *DB) CellsNear(ctx context.Context, point geo.Point, radius float64, limit int) ([]Cell, error) {
+	if !(radius > 0) || !validPoint(point) {
+		return nil, errors.New("lbs: bad search area")
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	mdb, err := db.database()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	query := bson.M{
+		"location": bson.M{"$nearSphere": bson.M{
+			"$geometry":    bson.M{"type": "Point", "coordinates": bson.A{point.Longitude(), point.Latitude()}},
+			"$maxDistance": radius,
+		}},
+		"blocked": notBlocked,
+	}
+	find := options.Find().SetProjection(bson.M{"_id": 0}).SetLimit(int64(limit))
+	var cells []Cell
+	for _, name := range db.collections() {
+		var found []Cell
+		if err := findAll(ctx, mdb.Collection(name), query, &found, find); err != nil {
+			return nil, err
+		}
+		cells = append(cells, found...)
+	}
+	return nearest(cells, point, limit), nil
+}
+
+// nearest упорядочивает вышки по удалению от точки и возвращает не более limit ближайших. Вышки из
+// разных коллекций найдены отдельными запросами, поэтому их нужно упорядочить заново.
+func nearest(cells []Cell, point geo.Point, limit int) []Cell {
+	sort.SliceStable(cells, func(i, j int) bool {
+		return Distance(cells[i].Location, point) < Distance(cells[j].Location, point)
+	})
+	if len(cells) > limit {
+		cells = cells[:limit]
+	}
+	return cells
+}
+
+// CellsInBounds возвращает не более limit записей о вышках внутри прямоугольника minlon, minlat,
+// maxlon, maxlat, например, для отображения покрытия на карте. Если limit не задан, то
+// возвращается не более 100 записей. Заблокированные вышки не возвращаются. Ширина прямоугольника
+// не может превышать 180 градусов. Для поиска нужен индекс, созданный CreateLocationIndex.
+func (db *DB) CellsInBounds(ctx context.Context, box [4]float64, limit int) ([]Cell, error) {
+	if err := checkBox(box); err != nil {
+		return nil, err
+	}
+	if box[2]-box[0] > 180 {
+		return nil, errors.New("lbs: bounding box is too wide")
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	mdb, err := db.database()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	query := withinBox(box)
+	query["blocked"] = notBlocked
+	cells := make([]Cell, 0, limit)
+	for _, name := range db.collections() {
+		var found []Cell
+		find := options.Find().SetProjection(bson.M{"_id": 0}).SetLimit(int64(limit - len(cells)))
+		if err := findAll(ctx, mdb.Collection(name), query, &found, find); err != nil {
+			return nil, err
+		}
+		if cells = append(cells, found...); len(cells) >= limit {
+			break
+		}
+	}
+	return cells, nil
+}
+
+// boxRing возвращает кольцо многоугольника GeoJSON для прямоугольника. Стороны многоугольника на
+// сфере — дуги больших кругов, поэтому северная и южная стороны разбиваются на отрезки не длиннее
+// градуса долготы, чтобы они почти совпадали с параллелями.
+func boxRing(box [4]float64) [][2]float64 {
+	steps := int(math.Ceil(box[2] - box[0]))
+	ring := make([][2]float64, 0, 2*steps+3)
+	for i := 0; i <= steps; i++ {
+		ring = append(ring, [2]float64{box[0] + (box[2]-box[0])*float64(i)/float64(steps), box[1]})
+	}
+	for i := steps; i >= 0; i-- {
+		ring = append(ring, [2]float64{box[0] + (box[2]-box[0])*float64(i)/float64(steps), box[3]})
+	}
+	return append(ring, ring[0])
+}
+
+// BoxRings возвращает кольца многоугольников GeoJSON, которые вместе покрывают прямоугольник
+// minlon, minlat, maxlon, maxlat (см. boxRing). Прямоугольник шире 180 градусов делится пополам:
+// MongoDB не принимает многоугольники больше полусферы.
+func BoxRings(box [4]float64) [][][2]float64 {
+	if box[2]-box[0] <= 180 {
+		return [][][2]float64{boxRing(box)}
+	}
+	middle := (box[0] + box[2]) / 2
+	return [][][2]float64{
+		boxRing([4]float64{box[0], box[1], middle, box[3]}),
+		boxRing([4]float64{middle, box[1], box[2], box[3]}),
+	}
+}
+
+// withinBox возвращает условие поиска записей с координатами внутри прямоугольника.
+func withinBox(box [4]float64) bson.M {
+	var filters []bson.M
+	for _, ring := range BoxRings(box) {
+		filters = append(filters, bson.M{"location": bson.M{"$geoWithin": bson.M{
+			"$geometry": bson.M{"type": "Polygon", "coordinates": [][][2]float64{ring}},
+		}}})
+	}
+	if len(filters) == 1 {
+		return filters[0]
+	}
+	return bson.M{"$or": filters}
+}
+
+// checkBox проверяет прямоугольник minlon, minlat, maxlon, maxlat.
+func checkBox(box [4]float64) error {
+	if box[0] >= box[2] || box[1] >= box[3] ||
+		box[0] < -180 || box[2] > 180 || box[1] < -90 || box[3] > 90 {
+		return errors.New("lbs: bad bounding box")
+	}
+	return nil
+}
+
+// validPoint сообщает, находятся ли координаты точки в допустимом диапазоне.
+func validPoint(point geo.Point) bool {
+	lon, lat := point.Longitude(), point.Latitude()
+	return lon >= -180 && lon <= 180 && lat >= -90 && lat <= 90
+}
@@ -0,0 +1,70 @@
+package lbs
+
+import (
+	"math"
+	"testing"
+
+	"github.com/geotrace/geo"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNearest(t *testing.T) {
+	point := geo.NewPoint(37.6, 55.7)
+	cells := []Cell{
+		{Key: Key{CellId: 1}, Data: Data{Location: geo.NewPoint(37.9, 55.7)}},
+		{Key: Key{CellId: 2}, Data: Data{Location: geo.NewPoint(37.61, 55.7)}},
+		{Key: Key{CellId: 3}, Data: Data{Location: geo.NewPoint(37.7, 55.7)}},
+	}
+	found := nearest(cells, point, 2)
+	if len(found) != 2 || found[0].CellId != 2 || found[1].CellId != 3 {
+		t.Errorf("bad nearest cells: %v", found)
+	}
+}
+
+func TestBoxRing(t *testing.T) {
+	box := [4]float64{37.3, 55.5, 39.9, 56.0}
+	ring := boxRing(box)
+	if ring[0] != ring[len(ring)-1] {
+		t.Fatalf("ring not closed: %v", ring)
+	}
+	// стороны разбиты на отрезки не длиннее градуса
+	if len(ring) != 2*(3+1)+1 {
+		t.Errorf("bad ring size: %d", len(ring))
+	}
+	for i := 1; i < len(ring); i++ {
+		a, b := ring[i-1], ring[i]
+		if a[0]-b[0] > 1 || b[0]-a[0] > 1 {
+			t.Errorf("ring segment too long: %v - %v", a, b)
+		}
+		for _, p := range [][2]float64{a, b} {
+			if p[0] < box[0] || p[0] > box[2] || p[1] < box[1] || p[1] > box[3] {
+				t.Errorf("point outside box: %v", p)
+			}
+		}
+	}
+	if err := checkBox([4]float64{37.9, 55.5, 37.3, 56.0}); err == nil {
+		t.Error("bad box accepted")
+	}
+}
+
+func TestBoxRings(t *testing.T) {
+	if rings := BoxRings([4]float64{37.3, 55.5, 39.9, 56.0}); len(rings) != 1 {
+		t.Errorf("bad rings count: %d", len(rings))
+	}
+	rings := BoxRings([4]float64{-180, -60, 180, 60})
+	if len(rings) != 2 {
+		t.Fatalf("bad rings count: %d", len(rings))
+	}
+	for _, ring := range rings {
+		var minlon, maxlon float64 = 180, -180
+		for _, p := range ring {
+			minlon, maxlon = math.Min(minlon, p[0]), math.Max(maxlon, p[0])
+		}
+		if maxlon-minlon > 180 {
+			t.Errorf("ring too wide: %v - %v", minlon, maxlon)
+		}
+	}
+	if query := withinBox([4]float64{-180, -60, 180, 60}); len(query["$or"].([]bson.M)) != 2 {
+		t.Errorf("bad query: %v", query)
+	}
+}
@@ -0,0 +1,23 @@
+package lbs
+
+import (
+	"time"
+
+	"github.com/geotrace/geo"
+)
+
+// ObservationsCollectionName описывает название коллекции с исходными измерениями: сведениями о
+// сотовых вышках, видимых в точках с известными координатами.
+var ObservationsCollectionName = "lbs_observations"
+
+// Observation описывает единичное измерение: сотовую вышку, видимую устройством в точке с
+// известными (например, полученными от GPS) координатами. Из таких измерений можно самостоятельно
+// вычислить местоположение и радиус действия вышки, не полагаясь на агрегированные данные.
+type Observation struct {
+	Key           `bson:",inline"`
+	Location      geo.Point `bson:"location"`           // координаты точки измерения
+	Accuracy      float64   `bson:"accuracy,omitempty"` // точность координат, м
+	Signal        int       `bson:"signal,omitempty"`   // уровень сигнала, dBm (см. SignalDBm)
+	TimingAdvance int       `bson:"ta,omitempty"`       // timing advance
+	Measured      time.Time `bson:"measured,omitempty"` // время измерения
+}
@@ -0,0 +1,303 @@
+// Package offline реализует хранилища записей о вышках (storage.Storage), которые работают без
+// MongoDB: таблицу в памяти, загруженную из файла CSV в формате OpenCellID (OpenCSV, ReadCSV), и
+// компактный двоичный снимок, отображаемый в память (SnapshotWriter, OpenSnapshot). Хранилища
+// подключаются к lbs.DB через lbs.OpenStorage или сразу открываются функциями lbs.OpenCSV и
+// lbs.OpenSnapshot.
+//
+// Например, на периферийных узлах с данными одного региона, подготовленными командой lbs-export:
+//
+//	table, err := offline.OpenSnapshot("region.snapshot")
+//	if err != nil {
+//		return err
+//	}
+//	db := lbs.OpenStorage(table, lbs.FuzzyAreaLookup(lbs.DefaultFuzzyCells))
+//	defer db.Close()
+package offline
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/lbs/v2/storage"
+)
+
+// OpenCSV загружает записи о вышках из файла CSV в формате OpenCellID (см. storage.CellHeader) в
+// память и возвращает хранилище, по которому lbs.DB вычисляет координаты без MongoDB (см.
+// lbs.OpenStorage и lbs.OpenCSV). Файл с расширением .gz распаковывается при чтении.
+func OpenCSV(path string) (storage.Storage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	table, err := loadCSV(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return table, nil
+}
+
+// ReadCSV загружает записи о вышках в формате OpenCellID из r в память так же, как OpenCSV.
+// Записи хранятся в упорядоченном по ключу массиве компактных структур (около 48 байт на вышку),
+// координаты — в целых единицах 10⁻⁷ градуса (около сантиметра). Если вышка встречается несколько
+// раз, то используется последняя запись, а записи с зарезервированными значениями идентификаторов
+// (см. storage.Key.Valid) и координатами вне допустимого диапазона пропускаются. Данные доступны
+// только для чтения.
+func ReadCSV(r io.Reader) (storage.Storage, error) {
+	table, err := loadCSV(r)
+	if err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// fixedScale задает количество единиц координат memoryCell в одном градусе.
+const fixedScale = 1e7
+
+// memoryCell описывает компактную запись о вышке в memoryTable.
+type memoryCell struct {
+	cell     uint64
+	lac      uint32
+	mcc, mnc uint16
+	radio    uint8 // номер типа радио в memoryTable.radios
+	lon, lat int32 // координаты в единицах 1/fixedScale градуса
+	accuracy float32
+	samples  int32
+	created  uint32 // секунды Unix (0 - не задано)
+	updated  uint32 // секунды Unix (0 - не задано)
+}
+
+// memoryTable хранит записи о вышках в массиве, упорядоченном по типу радио, коду страны,
+// оператора, зоны и идентификатору вышки: запись находится двоичным поиском, а вышки одной зоны с
+// соседними идентификаторами расположены рядом.
+type memoryTable struct {
+	radios []string         // типы радио записей
+	index  map[string]uint8 // номера типов радио по названиям
+	rows   []memoryCell     // записи, упорядоченные по ключу
+}
+
+// loadCSV загружает записи о вышках из CSV в формате OpenCellID. Строка заголовка необязательна.
+func loadCSV(r io.Reader) (*memoryTable, error) {
+	in := csv.NewReader(r)
+	in.FieldsPerRecord = -1
+	in.ReuseRecord = true
+	table := new(memoryTable)
+	for line := 1; ; line++ {
+		record, err := in.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && len(record) > 0 && record[0] == storage.CellHeader[0] {
+			continue
+		}
+		var cell storage.Cell
+		if err := cell.UnmarshalCSV(record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if !cell.Key.Valid() || !validPoint(cell.Location) {
+			continue
+		}
+		if err := table.add(cell); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	table.sort()
+	return table, nil
+}
+
+// add добавляет запись о вышке в конец таблицы. После добавления всех записей таблицу нужно
+// упорядочить вызовом sort.
+func (t *memoryTable) add(cell storage.Cell) error {
+	radio, ok := t.index[cell.RadioType]
+	if !ok {
+		if len(t.radios) > 255 {
+			return errors.New("lbs: too many radio types")
+		}
+		if t.index == nil {
+			t.index = make(map[string]uint8)
+		}
+		radio = uint8(len(t.radios))
+		t.index[cell.RadioType] = radio
+		t.radios = append(t.radios, cell.RadioType)
+	}
+	lon, lat := cell.Location.Longitude(), cell.Location.Latitude()
+	if !validPoint(cell.Location) {
+		return fmt.Errorf("lbs: bad location: %g, %g", lon, lat)
+	}
+	t.rows = append(t.rows, memoryCell{
+		cell:     cell.CellId,
+		lac:      cell.LocationAreaCode,
+		mcc:      cell.MobileCountryCode,
+		mnc:      cell.MobileNetworkCode,
+		radio:    radio,
+		lon:      int32(math.Round(lon * fixedScale)),
+		lat:      int32(math.Round(lat * fixedScale)),
+		accuracy: float32(cell.Accuracy),
+		samples:  int32(cell.Samples),
+		created:  unixSeconds(cell.Created),
+		updated:  unixSeconds(cell.Updated),
+	})
+	return nil
+}
+
+// sort упорядочивает записи таблицы по ключу и удаляет повторы: из записей об одной вышке
+// остается добавленная последней.
+func (t *memoryTable) sort() {
+	// при сортировке сохраняется порядок добавления, поэтому из повторов остается последняя
+	sort.SliceStable(t.rows, func(i, j int) bool {
+		return t.rows[i].less(&t.rows[j])
+	})
+	rows := t.rows[:0]
+	for i := range t.rows {
+		if i+1 < len(t.rows) && t.rows[i].sameKey(&t.rows[i+1]) {
+			continue
+		}
+		rows = append(rows, t.rows[i])
+	}
+	t.rows = append([]memoryCell(nil), rows...) // освобождаем память, занятую повторами
+}
+
+// unixSeconds возвращает время в секундах Unix или 0 для нулевого времени.
+func unixSeconds(t time.Time) uint32 {
+	if t.IsZero() || t.Unix() <= 0 {
+		return 0
+	}
+	return uint32(t.Unix())
+}
+
+// less сообщает, предшествует ли ключ записи ключу другой записи.
+func (c *memoryCell) less(other *memoryCell) bool {
+	switch {
+	case c.radio != other.radio:
+		return c.radio < other.radio
+	case c.mcc != other.mcc:
+		return c.mcc < other.mcc
+	case c.mnc != other.mnc:
+		return c.mnc < other.mnc
+	case c.lac != other.lac:
+		return c.lac < other.lac
+	}
+	return c.cell < other.cell
+}
+
+// sameKey сообщает, совпадает ли ключ записи с ключом другой записи.
+func (c *memoryCell) sameKey(other *memoryCell) bool {
+	return c.sameArea(other) && c.cell == other.cell
+}
+
+// sameArea сообщает, относятся ли записи к одной зоне одного оператора с одним типом радио.
+func (c *memoryCell) sameArea(other *memoryCell) bool {
+	return c.radio == other.radio && c.mcc == other.mcc && c.mnc == other.mnc && c.lac == other.lac
+}
+
+// search возвращает запись с ключом key для поиска и позицию первой записи, не меньшей ее. Если
+// типа радио ключа нет в таблице, то возвращается false.
+func (t *memoryTable) search(key storage.Key) (probe memoryCell, i int, ok bool) {
+	for radio, name := range t.radios {
+		if name == key.RadioType {
+			probe = memoryCell{
+				cell:  key.CellId,
+				lac:   key.LocationAreaCode,
+				mcc:   key.MobileCountryCode,
+				mnc:   key.MobileNetworkCode,
+				radio: uint8(radio),
+			}
+			i = sort.Search(len(t.rows), func(i int) bool { return !t.rows[i].less(&probe) })
+			return probe, i, true
+		}
+	}
+	return probe, 0, false
+}
+
+// cell возвращает запись о вышке в виде Cell.
+func (t *memoryTable) cell(c *memoryCell) storage.Cell {
+	cell := storage.Cell{
+		Key: storage.Key{
+			RadioType:         t.radios[c.radio],
+			MobileCountryCode: c.mcc,
+			MobileNetworkCode: c.mnc,
+			LocationAreaCode:  c.lac,
+			CellId:            c.cell,
+		},
+		Data: storage.Data{
+			Location: geo.NewPoint(float64(c.lon)/fixedScale, float64(c.lat)/fixedScale),
+			Accuracy: float64(c.accuracy),
+		},
+		Samples: int(c.samples),
+	}
+	if c.created != 0 {
+		cell.Created = time.Unix(int64(c.created), 0).UTC()
+	}
+	if c.updated != 0 {
+		cell.Updated = time.Unix(int64(c.updated), 0).UTC()
+	}
+	return cell
+}
+
+// Cells возвращает записи о вышках с указанными ключами.
+func (t *memoryTable) Cells(ctx context.Context, keys []storage.Key) ([]storage.Cell, error) {
+	cells := make([]storage.Cell, 0, len(keys))
+	for _, key := range keys {
+		if probe, i, ok := t.search(key); ok && i < len(t.rows) && t.rows[i].sameKey(&probe) {
+			cells = append(cells, t.cell(&t.rows[i]))
+		}
+	}
+	return cells, nil
+}
+
+// Neighbors возвращает не более n вышек зоны ключа key с идентификаторами, ближайшими к
+// key.CellId: они лежат в массиве по обе стороны от позиции ключа.
+func (t *memoryTable) Neighbors(ctx context.Context, key storage.Key, n int) ([]storage.Cell, error) {
+	probe, i, ok := t.search(key)
+	if !ok {
+		return nil, nil
+	}
+	var above, below []storage.Cell
+	for j := i; j < len(t.rows) && len(above) < n && t.rows[j].sameArea(&probe); j++ {
+		if t.rows[j].cell != key.CellId {
+			above = append(above, t.cell(&t.rows[j]))
+		}
+	}
+	for j := i - 1; j >= 0 && len(below) < n && t.rows[j].sameArea(&probe); j-- {
+		below = append(below, t.cell(&t.rows[j]))
+	}
+	return storage.NearestCells(key.CellId, above, below, n), nil
+}
+
+// Count возвращает количество записей о вышках.
+func (t *memoryTable) Count(ctx context.Context) (int, error) {
+	return len(t.rows), nil
+}
+
+// Close ничего не делает: память таблицы освобождает сборщик мусора.
+func (t *memoryTable) Close() error {
+	return nil
+}
+
+// validPoint сообщает, находятся ли координаты точки в допустимом диапазоне.
+func validPoint(point geo.Point) bool {
+	lon, lat := point.Longitude(), point.Latitude()
+	return lon >= -180 && lon <= 180 && lat >= -90 && lat <= 90
+}
@@ -0,0 +1,103 @@
+package offline
+
+import (
+	"compress/gzip"
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/lbs/v2/storage"
+)
+
+const memoryCSV = `radio,mcc,net,area,cell,unit,lon,lat,range,samples,changeable,created,updated,averageSignal
+GSM,250,2,7743,22517,,37.6,55.7,1000,10,1,1500000000,1600000000,0
+GSM,250,2,7743,22518,,37.7,55.8,1000,5,1,,,0
+GSM,250,2,7743,22530,,37.9,55.9,1000,5,1,,,0
+LTE,250,2,7743,26216458,,37.5,55.6,500,3,1,,,0
+GSM,250,2,7743,22518,,37.61,55.71,800,6,1,,,0
+GSM,250,2,0,1,,10,10,1000,1,1,,,0
+GSM,250,2,7743,22519,,37.6,155.7,1000,1,1,,,0
+`
+
+func TestLoadCSV(t *testing.T) {
+	table, err := loadCSV(strings.NewReader(memoryCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if len(table.rows) != 4 {
+		t.Fatalf("bad records count: %d", len(table.rows))
+	}
+	keys := []storage.Key{
+		{RadioType: "gsm", MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22518},
+		{RadioType: "lte", MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 26216458},
+		{RadioType: "gsm", MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22519},
+		{RadioType: "umts", MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517},
+	}
+	cells, err := table.Cells(ctx, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cells) != 2 || cells[0].Key != keys[0] || cells[1].Key != keys[1] {
+		t.Fatalf("bad cells: %+v", cells)
+	}
+	if cells[0].Accuracy != 800 || cells[0].Samples != 6 {
+		t.Errorf("duplicate record not replaced: %+v", cells[0])
+	}
+	if !near(cells[0].Location, geo.NewPoint(37.61, 55.71), 1e-6) {
+		t.Errorf("location stored with error: %v", cells[0].Location)
+	}
+	neighbors, err := table.Neighbors(ctx, keys[2], 2)
+	if err != nil || len(neighbors) != 2 || neighbors[0].CellId != 22518 || neighbors[1].CellId != 22517 {
+		t.Errorf("bad neighbors: %+v", neighbors)
+	}
+	cells, _ = table.Cells(ctx, keys[3:])
+	if neighbors, _ = table.Neighbors(ctx, keys[3], 3); len(cells) != 0 || len(neighbors) != 0 {
+		t.Errorf("cells found for unknown radio type")
+	}
+	if _, err := loadCSV(strings.NewReader("GSM,250,x,1,1,,0,0,0,0\n")); err == nil ||
+		!strings.HasPrefix(err.Error(), "line 1:") {
+		t.Errorf("bad record error: %v", err)
+	}
+}
+
+func TestOpenCSV(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cells.csv.gz")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(file)
+	gz.Write([]byte(memoryCSV))
+	gz.Close()
+	file.Close()
+
+	table, err := OpenCSV(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+	ctx := context.Background()
+	if count, err := table.Count(ctx); err != nil || count != 4 {
+		t.Errorf("bad records count: %d, %v", count, err)
+	}
+	key := storage.Key{RadioType: "gsm", MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517}
+	if cells, err := table.Cells(ctx, []storage.Key{key}); err != nil || len(cells) != 1 || cells[0].Samples != 10 {
+		t.Errorf("bad cells: %+v, %v", cells, err)
+	}
+	if _, err := OpenCSV(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("missing file opened")
+	}
+	if _, err := ReadCSV(strings.NewReader("GSM,250,x,1,1,,0,0,0,0\n")); err == nil {
+		t.Error("bad record accepted")
+	}
+}
+
+// near сообщает, отличаются ли координаты точек не больше чем на eps градусов.
+func near(a, b geo.Point, eps float64) bool {
+	return math.Abs(a.Longitude()-b.Longitude()) <= eps && math.Abs(a.Latitude()-b.Latitude()) <= eps
+}
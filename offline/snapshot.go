@@ -0,0 +1,497 @@
+package offline
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/lbs/v2/storage"
+)
+
+// Снимок (см. SnapshotWriter и OpenSnapshot) хранит записи о вышках в порядке ключей, как
+// memoryTable, и состоит из заголовка, индекса блоков и самих блоков. Все числа в заголовке и
+// индексе записываются в порядке little-endian.
+//
+// Заголовок: snapshotMagic, количество записей (uint64), количество блоков (uint32), количество
+// типов радио (uint8) и названия типов радио (длина uint8 и байты названия).
+//
+// Индекс: для каждого блока ключ его первой записи и смещение блока от начала данных блоков —
+// записи фиксированного размера snapshotEntrySize, поэтому блок находится двоичным поиском прямо в
+// отображенном в память файле без разбора индекса при открытии.
+//
+// Блок содержит до snapshotBlockRecords записей, закодированных числами переменной длины
+// (varint) относительно предыдущей записи блока: ключ — приращением идентификатора вышки (или
+// полным ключом, если сменилась зона), координаты — приращением в целых единицах 10⁻⁶ градуса,
+// время создания — приращением в сутках, а время последнего измерения — разностью с временем
+// создания. Радиус действия записывается в целых метрах. В среднем запись занимает 12–16 байт.
+const (
+	snapshotMagic        = "LBSSNAP1"
+	snapshotEntrySize    = 32  // cell uint64, offset uint64, lac uint32, mcc uint16, mnc uint16, radio uint8, 7 байт выравнивания
+	snapshotBlockRecords = 64  // записей в блоке
+	snapshotScale        = 1e6 // единиц координат в одном градусе
+	secondsPerDay        = 24 * 60 * 60
+)
+
+// ErrBadSnapshot возвращается при открытии или чтении поврежденного файла снимка.
+var ErrBadSnapshot = errors.New("lbs: bad snapshot")
+
+// SnapshotWriter собирает записи о вышках и записывает их в компактный двоичный снимок, который
+// открывает OpenSnapshot. Записи добавляются в любом порядке и хранятся в памяти до записи снимка
+// (около 48 байт на вышку), поэтому для снимка полной выгрузки OpenCellID нужно несколько
+// гигабайт памяти. Если вышка добавлена несколько раз, то в снимок попадает последняя запись.
+//
+// Программа lbs-import с параметром -format=snapshot собирает снимок из файла CSV с теми же
+// фильтрами, что и при импорте в MongoDB.
+type SnapshotWriter struct {
+	table memoryTable
+}
+
+// NewSnapshotWriter возвращает пустой SnapshotWriter.
+func NewSnapshotWriter() *SnapshotWriter {
+	return new(SnapshotWriter)
+}
+
+// Add добавляет запись о вышке. Записи с зарезервированными значениями идентификаторов (см.
+// storage.Key.Valid) и координатами вне допустимого диапазона не добавляются и возвращают ошибку.
+// Поля Blocked, Site и Variance в снимке не сохраняются, поэтому заблокированные вышки нужно
+// пропускать до добавления.
+func (w *SnapshotWriter) Add(cell storage.Cell) error {
+	if !cell.Key.Valid() {
+		return fmt.Errorf("lbs: reserved area or cell: %d, %d", cell.LocationAreaCode, cell.CellId)
+	}
+	return w.table.add(cell)
+}
+
+// Len возвращает количество добавленных записей, включая повторы.
+func (w *SnapshotWriter) Len() int {
+	return len(w.table.rows)
+}
+
+// WriteTo записывает снимок со всеми добавленными записями в out и возвращает количество
+// записанных байт.
+func (w *SnapshotWriter) WriteTo(out io.Writer) (int64, error) {
+	t := &w.table
+	t.sort()
+	blocks := (len(t.rows) + snapshotBlockRecords - 1) / snapshotBlockRecords
+	header := append([]byte(snapshotMagic), make([]byte, 13)...)
+	binary.LittleEndian.PutUint64(header[8:], uint64(len(t.rows)))
+	binary.LittleEndian.PutUint32(header[16:], uint32(blocks))
+	header[20] = uint8(len(t.radios))
+	for _, radio := range t.radios {
+		header = append(append(header, uint8(len(radio))), radio...)
+	}
+	// блоки кодируются заранее: смещения блоков нужны для индекса, который записывается перед ними
+	index := make([]byte, blocks*snapshotEntrySize)
+	var data []byte
+	for b := 0; b < blocks; b++ {
+		first := &t.rows[b*snapshotBlockRecords]
+		entry := index[b*snapshotEntrySize:]
+		binary.LittleEndian.PutUint64(entry[0:], first.cell)
+		binary.LittleEndian.PutUint64(entry[8:], uint64(len(data)))
+		binary.LittleEndian.PutUint32(entry[16:], first.lac)
+		binary.LittleEndian.PutUint16(entry[20:], first.mcc)
+		binary.LittleEndian.PutUint16(entry[22:], first.mnc)
+		entry[24] = first.radio
+		end := (b + 1) * snapshotBlockRecords
+		if end > len(t.rows) {
+			end = len(t.rows)
+		}
+		data = appendBlock(data, t.rows[b*snapshotBlockRecords:end])
+	}
+	bw := bufio.NewWriter(out)
+	var written int64
+	for _, part := range [][]byte{header, index, data} {
+		n, err := bw.Write(part)
+		if written += int64(n); err != nil {
+			return written, err
+		}
+	}
+	return written, bw.Flush()
+}
+
+// snapshotRecord описывает запись блока снимка в том виде, в котором она кодируется.
+type snapshotRecord struct {
+	radio    uint8
+	mcc, mnc uint16
+	lac      uint32
+	cell     uint64
+	lon, lat int64 // координаты в единицах 1/snapshotScale градуса
+	accuracy uint64
+	samples  uint64
+	created  int64 // сутки Unix + 1 (0 - не задано)
+	updated  int64 // сутки Unix + 1 (0 - не задано)
+}
+
+// snapshotDays возвращает сутки Unix + 1 для времени в секундах Unix или 0 для незаданного
+// времени.
+func snapshotDays(seconds uint32) int64 {
+	if seconds == 0 {
+		return 0
+	}
+	return int64(seconds)/secondsPerDay + 1
+}
+
+// appendBlock добавляет к data закодированный блок записей.
+func appendBlock(data []byte, rows []memoryCell) []byte {
+	var prev snapshotRecord
+	for i := range rows {
+		c := &rows[i]
+		r := snapshotRecord{
+			radio:    c.radio,
+			mcc:      c.mcc,
+			mnc:      c.mnc,
+			lac:      c.lac,
+			cell:     c.cell,
+			lon:      int64(math.Round(float64(c.lon) * snapshotScale / fixedScale)),
+			lat:      int64(math.Round(float64(c.lat) * snapshotScale / fixedScale)),
+			accuracy: uint64(math.Round(math.Max(float64(c.accuracy), 0))),
+			samples:  uint64(math.Max(float64(c.samples), 0)),
+			created:  snapshotDays(c.created),
+			updated:  snapshotDays(c.updated),
+		}
+		if i > 0 && r.radio == prev.radio && r.mcc == prev.mcc && r.mnc == prev.mnc && r.lac == prev.lac {
+			data = binary.AppendUvarint(data, (r.cell-prev.cell)<<1)
+		} else {
+			data = binary.AppendUvarint(data, 1)
+			data = append(data, r.radio)
+			data = binary.AppendUvarint(data, uint64(r.mcc))
+			data = binary.AppendUvarint(data, uint64(r.mnc))
+			data = binary.AppendUvarint(data, uint64(r.lac))
+			data = binary.AppendUvarint(data, r.cell)
+		}
+		data = binary.AppendVarint(data, r.lon-prev.lon)
+		data = binary.AppendVarint(data, r.lat-prev.lat)
+		data = binary.AppendUvarint(data, r.accuracy)
+		data = binary.AppendUvarint(data, r.samples)
+		data = binary.AppendVarint(data, r.created-prev.created)
+		data = binary.AppendVarint(data, r.updated-r.created)
+		prev = r
+	}
+	return data
+}
+
+// OpenSnapshot открывает снимок, записанный SnapshotWriter (например, командой lbs-import
+// -format=snapshot), и возвращает хранилище, по которому lbs.DB вычисляет координаты без MongoDB
+// так же, как по OpenCSV (см. lbs.OpenSnapshot). Файл отображается в память (mmap) только для
+// чтения, поэтому открывается мгновенно при любом размере: разбирается только заголовок, а блоки
+// записей читаются с диска и декодируются при поиске, оставаясь в кеше страниц операционной
+// системы. Снимок всех вышек OpenCellID занимает несколько сотен мегабайт; координаты в нем
+// хранятся с точностью 10⁻⁶ градуса (около 10 см), радиус действия — в метрах, а время создания и
+// последнего измерения — с точностью до суток. В системах без mmap файл читается в память
+// целиком.
+//
+// Файл освобождается вызовом Close.
+func OpenSnapshot(path string) (storage.Storage, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	table, err := parseSnapshot(data)
+	if err != nil {
+		unmap()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	table.unmap = unmap
+	return table, nil
+}
+
+// snapshotTable описывает открытый снимок.
+type snapshotTable struct {
+	mu      sync.RWMutex // защищает data от освобождения во время поиска
+	data    []byte       // содержимое файла (nil - снимок закрыт)
+	unmap   func() error // освобождает содержимое файла
+	radios  []string     // типы радио записей
+	records int          // количество записей
+	blocks  int          // количество блоков
+	index   int          // смещение индекса блоков
+	body    int          // смещение данных блоков
+}
+
+// parseSnapshot разбирает заголовок снимка и проверяет размер индекса.
+func parseSnapshot(data []byte) (*snapshotTable, error) {
+	if len(data) < 21 || string(data[:8]) != snapshotMagic {
+		return nil, ErrBadSnapshot
+	}
+	t := &snapshotTable{
+		data:    data,
+		records: int(binary.LittleEndian.Uint64(data[8:])),
+		blocks:  int(binary.LittleEndian.Uint32(data[16:])),
+	}
+	pos := 21
+	for i := 0; i < int(data[20]); i++ {
+		if pos >= len(data) || pos+1+int(data[pos]) > len(data) {
+			return nil, ErrBadSnapshot
+		}
+		t.radios = append(t.radios, string(data[pos+1:pos+1+int(data[pos])]))
+		pos += 1 + int(data[pos])
+	}
+	t.index, t.body = pos, pos+t.blocks*snapshotEntrySize
+	if t.body > len(data) || t.records < 0 || t.records > t.blocks*snapshotBlockRecords ||
+		t.records <= (t.blocks-1)*snapshotBlockRecords {
+		return nil, ErrBadSnapshot
+	}
+	return t, nil
+}
+
+// entry возвращает ключ первой записи блока b и смещение блока в data.
+func (t *snapshotTable) entry(b int) (first snapshotRecord, offset int) {
+	e := t.data[t.index+b*snapshotEntrySize:]
+	first = snapshotRecord{
+		cell:  binary.LittleEndian.Uint64(e[0:]),
+		lac:   binary.LittleEndian.Uint32(e[16:]),
+		mcc:   binary.LittleEndian.Uint16(e[20:]),
+		mnc:   binary.LittleEndian.Uint16(e[22:]),
+		radio: e[24],
+	}
+	return first, t.body + int(binary.LittleEndian.Uint64(e[8:]))
+}
+
+// less сообщает, предшествует ли ключ записи ключу другой записи.
+func (r *snapshotRecord) less(other *snapshotRecord) bool {
+	switch {
+	case r.radio != other.radio:
+		return r.radio < other.radio
+	case r.mcc != other.mcc:
+		return r.mcc < other.mcc
+	case r.mnc != other.mnc:
+		return r.mnc < other.mnc
+	case r.lac != other.lac:
+		return r.lac < other.lac
+	}
+	return r.cell < other.cell
+}
+
+// sameArea сообщает, относятся ли записи к одной зоне одного оператора с одним типом радио.
+func (r *snapshotRecord) sameArea(other *snapshotRecord) bool {
+	return r.radio == other.radio && r.mcc == other.mcc && r.mnc == other.mnc && r.lac == other.lac
+}
+
+// block декодирует записи блока b.
+func (t *snapshotTable) block(b int) ([]snapshotRecord, error) {
+	_, pos := t.entry(b)
+	n := snapshotBlockRecords
+	if b == t.blocks-1 {
+		n = t.records - b*snapshotBlockRecords
+	}
+	if pos > len(t.data) {
+		return nil, ErrBadSnapshot
+	}
+	buf := t.data[pos:]
+	failed := false
+	uvarint := func() uint64 {
+		v, size := binary.Uvarint(buf)
+		if size <= 0 {
+			failed, buf = true, nil
+			return 0
+		}
+		buf = buf[size:]
+		return v
+	}
+	varint := func() int64 {
+		v, size := binary.Varint(buf)
+		if size <= 0 {
+			failed, buf = true, nil
+			return 0
+		}
+		buf = buf[size:]
+		return v
+	}
+	records := make([]snapshotRecord, n)
+	var prev snapshotRecord
+	for i := range records {
+		r := &records[i]
+		if head := uvarint(); head&1 == 0 {
+			*r = snapshotRecord{radio: prev.radio, mcc: prev.mcc, mnc: prev.mnc, lac: prev.lac,
+				cell: prev.cell + head>>1}
+		} else if len(buf) > 0 {
+			r.radio, buf = buf[0], buf[1:]
+			r.mcc, r.mnc = uint16(uvarint()), uint16(uvarint())
+			r.lac, r.cell = uint32(uvarint()), uvarint()
+		} else {
+			failed = true
+		}
+		r.lon, r.lat = prev.lon+varint(), prev.lat+varint()
+		r.accuracy, r.samples = uvarint(), uvarint()
+		r.created = prev.created + varint()
+		r.updated = r.created + varint()
+		if failed || int(r.radio) >= len(t.radios) {
+			return nil, ErrBadSnapshot
+		}
+		prev = *r
+	}
+	return records, nil
+}
+
+// find возвращает номер блока, в котором должна находиться запись с ключом probe.
+func (t *snapshotTable) find(probe *snapshotRecord) int {
+	b := sort.Search(t.blocks, func(b int) bool {
+		first, _ := t.entry(b)
+		return probe.less(&first)
+	})
+	if b > 0 {
+		b--
+	}
+	return b
+}
+
+// probe возвращает запись с ключом key для поиска. Если типа радио ключа нет в снимке, то
+// возвращается false.
+func (t *snapshotTable) probe(key storage.Key) (snapshotRecord, bool) {
+	for radio, name := range t.radios {
+		if name == key.RadioType {
+			return snapshotRecord{
+				radio: uint8(radio),
+				mcc:   key.MobileCountryCode,
+				mnc:   key.MobileNetworkCode,
+				lac:   key.LocationAreaCode,
+				cell:  key.CellId,
+			}, true
+		}
+	}
+	return snapshotRecord{}, false
+}
+
+// cell возвращает запись снимка в виде Cell.
+func (t *snapshotTable) cell(r *snapshotRecord) storage.Cell {
+	cell := storage.Cell{
+		Key: storage.Key{
+			RadioType:         t.radios[r.radio],
+			MobileCountryCode: r.mcc,
+			MobileNetworkCode: r.mnc,
+			LocationAreaCode:  r.lac,
+			CellId:            r.cell,
+		},
+		Data: storage.Data{
+			Location: geo.NewPoint(float64(r.lon)/snapshotScale, float64(r.lat)/snapshotScale),
+			Accuracy: float64(r.accuracy),
+		},
+		Samples: int(r.samples),
+	}
+	if r.created > 0 {
+		cell.Created = time.Unix((r.created-1)*secondsPerDay, 0).UTC()
+	}
+	if r.updated > 0 {
+		cell.Updated = time.Unix((r.updated-1)*secondsPerDay, 0).UTC()
+	}
+	return cell
+}
+
+// Cells возвращает записи о вышках с указанными ключами. Если снимок закрыт, то возвращается
+// ошибка ErrClosed.
+func (t *snapshotTable) Cells(ctx context.Context, keys []storage.Key) ([]storage.Cell, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.data == nil {
+		return nil, storage.ErrClosed
+	}
+	cells := make([]storage.Cell, 0, len(keys))
+	for _, key := range keys {
+		probe, ok := t.probe(key)
+		if !ok || t.blocks == 0 {
+			continue
+		}
+		records, err := t.block(t.find(&probe))
+		if err != nil {
+			return nil, err
+		}
+		for i := range records {
+			if records[i].sameArea(&probe) && records[i].cell == probe.cell {
+				cells = append(cells, t.cell(&records[i]))
+				break
+			}
+		}
+	}
+	return cells, nil
+}
+
+// Neighbors возвращает не более n вышек зоны ключа key с идентификаторами, ближайшими к
+// key.CellId. Соседние вышки могут находиться и в соседних блоках.
+func (t *snapshotTable) Neighbors(ctx context.Context, key storage.Key, n int) ([]storage.Cell, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.data == nil {
+		return nil, storage.ErrClosed
+	}
+	probe, ok := t.probe(key)
+	if !ok || t.blocks == 0 {
+		return nil, nil
+	}
+	var above, below []storage.Cell
+	// вышки с большими идентификаторами — от позиции ключа до конца зоны
+	for b := t.find(&probe); b < t.blocks && len(above) < n; b++ {
+		records, err := t.block(b)
+		if err != nil {
+			return nil, err
+		}
+		done := false
+		for i := range records {
+			if !probe.less(&records[i]) {
+				continue
+			}
+			if !records[i].sameArea(&probe) || len(above) == n {
+				done = true
+				break
+			}
+			above = append(above, t.cell(&records[i]))
+		}
+		if done {
+			break
+		}
+	}
+	// вышки с меньшими идентификаторами — от позиции ключа к началу зоны
+	for b := t.find(&probe); b >= 0 && len(below) < n; b-- {
+		records, err := t.block(b)
+		if err != nil {
+			return nil, err
+		}
+		done := false
+		for i := len(records) - 1; i >= 0; i-- {
+			if !records[i].less(&probe) {
+				continue
+			}
+			if !records[i].sameArea(&probe) || len(below) == n {
+				done = true
+				break
+			}
+			below = append(below, t.cell(&records[i]))
+		}
+		if done {
+			break
+		}
+	}
+	return storage.NearestCells(key.CellId, above, below, n), nil
+}
+
+// Count возвращает количество записей о вышках.
+func (t *snapshotTable) Count(ctx context.Context) (int, error) {
+	return t.records, nil
+}
+
+// Close освобождает отображенный в память файл. Поиск в закрытом снимке возвращает ErrClosed.
+func (t *snapshotTable) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.data == nil {
+		return nil
+	}
+	t.data = nil
+	return t.unmap()
+}
+
+// readFile читает файл в память целиком для систем без mmap.
+func readFile(path string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package offline
+
+// mapFile читает файл в память целиком: в этой системе файл не отображается в память.
+func mapFile(path string) ([]byte, func() error, error) {
+	return readFile(path)
+}
@@ -0,0 +1,130 @@
+package offline
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/lbs/v2/storage"
+)
+
+func TestSnapshot(t *testing.T) {
+	source, err := loadCSV(strings.NewReader(memoryCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewSnapshotWriter()
+	var keys []storage.Key
+	for i := range source.rows {
+		cell := source.cell(&source.rows[i])
+		keys = append(keys, cell.Key)
+		if err := w.Add(cell); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// несколько блоков и смена зоны внутри блока
+	for id := uint64(1); id <= 3*snapshotBlockRecords; id++ {
+		cell := storage.Cell{
+			Key:  storage.Key{RadioType: "lte", MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 10, CellId: id * 10},
+			Data: storage.Data{Location: geo.NewPoint(37+float64(id)/1000, 55), Accuracy: 500.4},
+		}
+		if id > 2*snapshotBlockRecords {
+			cell.LocationAreaCode = 11
+		}
+		if err := w.Add(cell); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Add(storage.Cell{Key: storage.Key{RadioType: "gsm", MobileCountryCode: 250}}); err == nil {
+		t.Error("reserved key added")
+	}
+	filename := filepath.Join(t.TempDir(), "cells.snapshot")
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	table, err := OpenSnapshot(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+	ctx := context.Background()
+	if count, err := table.Count(ctx); err != nil || count != 4+3*snapshotBlockRecords {
+		t.Errorf("bad records count: %d, %v", count, err)
+	}
+
+	found := must(table.Cells(ctx, keys))
+	if len(found) != len(keys) {
+		t.Fatalf("bad cells count: %d", len(found))
+	}
+	for i, cell := range found {
+		want := source.cell(&source.rows[i])
+		if cell.Key != want.Key || cell.Accuracy != want.Accuracy || cell.Samples != want.Samples ||
+			!near(cell.Location, want.Location, 2e-6) {
+			t.Errorf("bad cell: %+v, want %+v", cell, want)
+		}
+		if want.Created.Sub(cell.Created) >= 24*time.Hour || want.Updated.Sub(cell.Updated) >= 24*time.Hour {
+			t.Errorf("bad times: %v, %v", cell.Created, cell.Updated)
+		}
+	}
+	lte := storage.Key{RadioType: "lte", MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 10}
+	for _, id := range []uint64{10, 640, 650, 1280} {
+		lte.CellId = id
+		if cells := must(table.Cells(ctx, []storage.Key{lte})); len(cells) != 1 || cells[0].Accuracy != 500 {
+			t.Errorf("cell %d not found: %+v", id, cells)
+		}
+	}
+	lte.CellId = 1285
+	if cells := must(table.Cells(ctx, []storage.Key{lte})); len(cells) != 0 {
+		t.Errorf("missing cell found: %+v", cells)
+	}
+	// соседи на границе блоков и зоны
+	for _, test := range []struct {
+		id   uint64
+		n    int
+		want []uint64
+	}{
+		{645, 2, []uint64{650, 640}},
+		{1285, 3, []uint64{1280, 1270, 1260}},
+		{5, 2, []uint64{10, 20}},
+	} {
+		lte.CellId = test.id
+		cells := must(table.Neighbors(ctx, lte, test.n))
+		if len(cells) != len(test.want) {
+			t.Errorf("bad neighbors of %d: %+v", test.id, cells)
+			continue
+		}
+		for i, cell := range cells {
+			if cell.CellId != test.want[i] || cell.LocationAreaCode != 10 {
+				t.Errorf("bad neighbors of %d: %+v", test.id, cells)
+			}
+		}
+	}
+	table.Close()
+	if _, err := table.Cells(ctx, keys); err != storage.ErrClosed {
+		t.Errorf("closed snapshot returned bad error: %v", err)
+	}
+
+	if err := os.WriteFile(filename, buf.Bytes()[:30], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenSnapshot(filename); err == nil {
+		t.Error("truncated snapshot opened")
+	}
+}
+
+// must возвращает найденные вышки, если поиск завершился без ошибки, или nil.
+func must(cells []storage.Cell, err error) []storage.Cell {
+	if err != nil {
+		return nil
+	}
+	return cells
+}
@@ -0,0 +1,153 @@
+package lbs
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Option описывает дополнительный параметр DB, задаваемый при инициализации.
+type Option func(*DB)
+
+// OwnClient передает DB владение клиентом MongoDB, переданным в InitDB: клиент будет отключен при
+// вызове Close. Для DB, созданного с помощью Dial, клиент всегда принадлежит DB.
+func OwnClient() Option {
+	return func(db *DB) {
+		db.owned = true
+	}
+}
+
+// DialTimeout задает максимальное время установки соединения с сервером MongoDB. Используется
+// только при инициализации с помощью Dial. По умолчанию используется значение 10 секунд.
+func DialTimeout(timeout time.Duration) Option {
+	return func(db *DB) {
+		db.dialTimeout = timeout
+	}
+}
+
+// SocketTimeout задает максимальное время ожидания ответа на запрос от сервера MongoDB. Если
+// сервер не ответил за это время, то запрос завершается с ошибкой. Используется только при
+// инициализации с помощью Dial: для клиента, переданного в InitDB, таймауты задаются при его
+// создании.
+func SocketTimeout(timeout time.Duration) Option {
+	return func(db *DB) {
+		db.socketTimeout = timeout
+	}
+}
+
+// SyncTimeout задает максимальное время ожидания доступного сервера MongoDB при выполнении
+// запроса. Без этого ограничения первый же запрос после пропадания связи с сервером может
+// "зависнуть" на продолжительное время. Используется только при инициализации с помощью Dial.
+func SyncTimeout(timeout time.Duration) Option {
+	return func(db *DB) {
+		db.syncTimeout = timeout
+	}
+}
+
+// QueryTimeout ограничивает время выполнения каждого запроса к MongoDB, если контекст, переданный
+// при вызове, не ограничен по времени. Если контекст отменяется раньше, то запрос прерывается
+// сразу. На вычисление радиусов по умолчанию в LearnAccuracy, которое обрабатывает все записи
+// хранилища, ограничение не распространяется. Значение 0 (по умолчанию) снимает ограничение.
+func QueryTimeout(timeout time.Duration) Option {
+	return func(db *DB) {
+		db.queryTimeout = timeout
+	}
+}
+
+// ReadPreference задает, на каких серверах набора реплик MongoDB выполняются запросы чтения,
+// например, readpref.SecondaryPreferredMode распределяет вычисление координат по вторичным
+// серверам и разгружает основной. Вторичные серверы могут отставать от основного, поэтому
+// только что импортированные вышки становятся видны не сразу. Запись всегда выполняется на
+// основном сервере. В отличие от остальных параметров соединения, применяется и к клиенту,
+// переданному в InitDB. По умолчанию используется параметр клиента (основной сервер).
+func ReadPreference(mode readpref.Mode) Option {
+	return func(db *DB) {
+		db.readMode = mode
+	}
+}
+
+// MaxPoolSize ограничивает количество одновременно открытых соединений с каждым сервером MongoDB.
+// Запросы сверх этого количества ждут освобождения соединения, поэтому при большом значении
+// QueryConcurrency и нагруженном сервере пул стоит увеличить. Используется только при
+// инициализации с помощью Dial. Значение 0 (по умолчанию) оставляет значение из URL или
+// драйвера (100 соединений).
+func MaxPoolSize(n uint64) Option {
+	return func(db *DB) {
+		db.maxPoolSize = n
+	}
+}
+
+// RetryReads включает или отключает однократный повтор запроса чтения, прерванного сетевой ошибкой
+// или сменой основного сервера набора реплик. Используется только при инициализации с помощью
+// Dial. По умолчанию используется значение из URL или драйвера (повтор включен).
+func RetryReads(enabled bool) Option {
+	return func(db *DB) {
+		db.retryReads = &enabled
+	}
+}
+
+// RetryWrites включает или отключает однократный повтор записи, прерванной сетевой ошибкой или
+// сменой основного сервера набора реплик. Используется только при инициализации с помощью Dial.
+// По умолчанию используется значение из URL или драйвера (повтор включен).
+func RetryWrites(enabled bool) Option {
+	return func(db *DB) {
+		db.retryWrites = &enabled
+	}
+}
+
+// Collection задает название коллекции с данными о вышках вместо CollectionName. Позволяет
+// работать в одной программе с несколькими наборами данных, например, сравнивать их между собой.
+// В режиме Partitioned не используется.
+func Collection(name string) Option {
+	return func(db *DB) {
+		db.collectionName = name
+	}
+}
+
+// DefaultMaxTowers задает максимальное количество вышек в одном запросе, используемое по
+// умолчанию.
+const DefaultMaxTowers = 32
+
+// MaxTowers ограничивает количество вышек в одном запросе: если их больше, то используются только
+// вышки с самым сильным сигналом. Так же ограничивается и количество точек доступа Wi-Fi. Это
+// ограничивает размер запроса к MongoDB и защищает сервер от злоупотреблений. Значение 0 снимает
+// ограничение. По умолчанию используется DefaultMaxTowers.
+func MaxTowers(n int) Option {
+	return func(db *DB) {
+		db.maxTowers = n
+	}
+}
+
+// MinAccuracy задает минимальный радиус точности ответа в метрах: более точные координаты по
+// данным о вышках и точках доступа получить нельзя, поэтому меньший радиус только вводит клиента
+// в заблуждение. Значение 0 (по умолчанию) снимает ограничение.
+func MinAccuracy(meters float64) Option {
+	return func(db *DB) {
+		db.accuracyMin = meters
+	}
+}
+
+// MaxAccuracy задает максимальный радиус точности ответа в метрах. Радиус точности покрывает зоны
+// действия всех использованных вышек, поэтому одна устаревшая запись о далекой вышке может
+// увеличить его до сотен километров; такой ответ бесполезен, и большой радиус заменяется
+// значением meters. Значение 0 (по умолчанию) снимает ограничение. Отбросить такие вышки при
+// вычислении координат позволяет RejectOutliers.
+func MaxAccuracy(meters float64) Option {
+	return func(db *DB) {
+		db.accuracyMax = meters
+	}
+}
+
+// DefaultQueryConcurrency задает количество одновременных запросов к MongoDB при обработке одного
+// запроса, используемое по умолчанию.
+const DefaultQueryConcurrency = 4
+
+// QueryConcurrency ограничивает количество одновременных запросов к MongoDB при обработке одного
+// запроса. Вышки с разными типами радио и из разных сетей ищутся отдельными запросами; если
+// выполнять их параллельно, то время ответа на сложный запрос почти не отличается от простого.
+// Значение 0 снимает ограничение. По умолчанию используется DefaultQueryConcurrency.
+func QueryConcurrency(n int) Option {
+	return func(db *DB) {
+		db.queryWorkers = n
+	}
+}
@@ -0,0 +1,59 @@
+package lbs
+
+import "sort"
+
+// DefaultOutlierFactor задает рекомендуемый порог отбрасывания далеких вышек для RejectOutliers:
+// так же отбрасываются выбросы при вычислении местоположения вышек по измерениям (см.
+// EstimateCell).
+const DefaultOutlierFactor = estimateOutlierFactor
+
+// outlierMinCells задает минимальное количество найденных вышек, при котором можно отличить
+// выброс от остальных: из двух вышек нельзя сказать, какая из них неверна.
+const outlierMinCells = 3
+
+// RejectOutliers включает отбрасывание вышек, которые находятся от медианы координат найденных
+// вышек в factor раз дальше, чем медиана расстояний до нее, и при этом дальше радиуса своего
+// действия: чаще всего это устаревшие записи о переставленных вышках или ошибки в выгрузке. Такие
+// вышки не используются при вычислении координат и перечисляются в Details.Rejected.
+// Отбрасывание применяется, только если найдено не меньше трех вышек. Значение 0 (по умолчанию)
+// отключает отбрасывание; рекомендуемое значение — DefaultOutlierFactor.
+func RejectOutliers(factor float64) Option {
+	return func(db *DB) {
+		db.outlierFactor = factor
+	}
+}
+
+// rejectOutliers разделяет найденные вышки на используемые при вычислении координат и
+// отброшенные как выбросы (см. RejectOutliers). Порядок используемых вышек сохраняется.
+func rejectOutliers(cells []Cell, factor float64) (used []Cell, rejected []Key) {
+	if factor <= 0 || len(cells) < outlierMinCells {
+		return cells, nil
+	}
+	// медиана координат, в отличие от среднего, не смещается к далекой вышке
+	lats, lons := make([]float64, len(cells)), make([]float64, len(cells))
+	for i, cell := range cells {
+		lats[i], lons[i] = cell.Location.Latitude(), cell.Location.Longitude()
+	}
+	lat, lon := median(lats), median(lons)
+	dists := make([]float64, len(cells))
+	for i, cell := range cells {
+		dists[i] = distance(lat, lon, cell.Location.Latitude(), cell.Location.Longitude())
+	}
+	limit := factor * median(dists)
+	used = make([]Cell, 0, len(cells))
+	for i, cell := range cells {
+		if dists[i] > limit && dists[i] > cell.Accuracy {
+			rejected = append(rejected, cell.Key)
+			continue
+		}
+		used = append(used, cell)
+	}
+	return used, rejected
+}
+
+// median возвращает медиану значений, не изменяя их порядок.
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return sorted[len(sorted)/2]
+}
@@ -0,0 +1,71 @@
+package lbs
+
+import (
+	"testing"
+
+	"github.com/geotrace/geo"
+)
+
+func TestRejectOutliers(t *testing.T) {
+	cell := func(id uint64, lon, lat float64) Cell {
+		return Cell{Key: Key{RadioType: "gsm", MobileCountryCode: 250, LocationAreaCode: 1, CellId: id},
+			Data: Data{Location: geo.NewPoint(lon, lat), Accuracy: 2000}}
+	}
+	cells := []Cell{
+		cell(1, 37.60, 55.75),
+		cell(2, 37.62, 55.75),
+		cell(3, 30.30, 59.95), // устаревшая запись: вышка в другом городе
+		cell(4, 37.61, 55.76),
+	}
+	keys := []Key{cells[0].Key, cells[1].Key, cells[2].Key, cells[3].Key}
+
+	found, err := newDB("test", nil).locate(keys, nil, append([]Cell(nil), cells...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Response.Accuracy < 100000 || len(found.Rejected) != 0 {
+		t.Fatalf("outlier used by default: %+v", found.Response)
+	}
+
+	db := newDB("test", []Option{RejectOutliers(DefaultOutlierFactor)})
+	details, err := db.locate(keys, nil, append([]Cell(nil), cells...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(details.Rejected) != 1 || details.Rejected[0] != cells[2].Key || len(details.Cells) != 3 {
+		t.Errorf("bad rejected cells: %v", details.Rejected)
+	}
+	if details.Response.Accuracy > 5000 {
+		t.Errorf("bad accuracy: %.0f", details.Response.Accuracy)
+	}
+	if len(details.Missing) != 0 || details.CellCounts != (Counts{4, 4, 3}) {
+		t.Errorf("bad counts: %+v, missing %v", details.CellCounts, details.Missing)
+	}
+
+	// из двух вышек выброс не определить
+	if used, rejected := rejectOutliers(cells[1:3], DefaultOutlierFactor); len(used) != 2 || rejected != nil {
+		t.Errorf("cell rejected from two: %v", rejected)
+	}
+}
+
+func TestAccuracyLimits(t *testing.T) {
+	cells := []Cell{
+		{Key: Key{CellId: 1}, Data: Data{Location: geo.NewPoint(37.60, 55.75), Accuracy: 2000}},
+		{Key: Key{CellId: 2}, Data: Data{Location: geo.NewPoint(30.30, 59.95), Accuracy: 2000}},
+	}
+	db := newDB("test", []Option{MaxAccuracy(50000)})
+	details, err := db.locate(nil, nil, append([]Cell(nil), cells...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Response.Accuracy != 50000 {
+		t.Errorf("accuracy not clamped to max: %.0f", details.Response.Accuracy)
+	}
+	db = newDB("test", []Option{MinAccuracy(5000)})
+	if details, err = db.locate(nil, nil, cells[:1], nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if details.Response.Accuracy != 5000 {
+		t.Errorf("accuracy not clamped to min: %.0f", details.Response.Accuracy)
+	}
+}
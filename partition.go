@@ -0,0 +1,130 @@
+package lbs
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PartitionsID задает идентификатор документа с таблицей распределения данных по коллекциям в
+// коллекции метаданных MetaCollectionName.
+const PartitionsID = "partitions"
+
+// Partitions описывает распределение данных по коллекциям в зависимости от кода страны. Данные по
+// каждой стране (или группе стран) хранятся в отдельной коллекции: это позволяет держать индексы
+// небольшими и быстро удалять данные по стране целиком.
+type Partitions struct {
+	ID          string            `bson:"_id"`
+	Collections map[string]string `bson:"collections"` // название коллекции по коду страны (MCC)
+	Updated     time.Time         `bson:"updated"`     // время последнего изменения
+}
+
+// PartitionCollection возвращает название коллекции, которое используется по умолчанию для данных
+// указанной страны, если она не указана в таблице распределения.
+func PartitionCollection(mcc uint16) string {
+	return CollectionName + "_" + strconv.FormatUint(uint64(mcc), 10)
+}
+
+// Partitioned включает режим, при котором данные по каждой стране хранятся в отдельной коллекции.
+// Таблица распределения загружается из коллекции метаданных при инициализации и может быть
+// обновлена вызовом LoadPartitions. Для стран, не указанных в таблице, используется коллекция с
+// названием PartitionCollection.
+func Partitioned() Option {
+	return func(db *DB) {
+		db.partitions = new(partitionTable)
+	}
+}
+
+// partitionTable содержит загруженную таблицу распределения данных по коллекциям.
+type partitionTable struct {
+	mu          sync.RWMutex
+	collections map[uint16]string // название коллекции по коду страны
+}
+
+// collection возвращает название коллекции для указанного кода страны.
+func (t *partitionTable) collection(mcc uint16) string {
+	t.mu.RLock()
+	name, ok := t.collections[mcc]
+	t.mu.RUnlock()
+	if !ok {
+		name = PartitionCollection(mcc)
+	}
+	return name
+}
+
+// names возвращает отсортированный список названий всех коллекций из таблицы без повторов.
+func (t *partitionTable) names() []string {
+	t.mu.RLock()
+	unique := make(map[string]bool, len(t.collections))
+	for _, name := range t.collections {
+		unique[name] = true
+	}
+	t.mu.RUnlock()
+	names := make([]string, 0, len(unique))
+	for name := range unique {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadPartitions загружает таблицу распределения данных по коллекциям из коллекции метаданных.
+// Если режим хранения данных в отдельных коллекциях не включен, то ничего не делает.
+func (db *DB) LoadPartitions(ctx context.Context) error {
+	if db.partitions == nil {
+		return nil
+	}
+	var doc Partitions
+	if err := db.findMeta(ctx, PartitionsID, &doc); err != nil {
+		return err
+	}
+	collections := make(map[uint16]string, len(doc.Collections))
+	for country, name := range doc.Collections {
+		mcc, err := strconv.ParseUint(country, 10, 16)
+		if err != nil {
+			continue
+		}
+		collections[uint16(mcc)] = name
+	}
+	db.partitions.mu.Lock()
+	db.partitions.collections = collections
+	db.partitions.mu.Unlock()
+	return nil
+}
+
+// findMeta загружает документ с указанным идентификатором из коллекции метаданных. Если документа
+// нет, то doc не изменяется и ошибка не возвращается.
+func (db *DB) findMeta(ctx context.Context, id string, doc interface{}) error {
+	mdb, err := db.database()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	err = mdb.Collection(MetaCollectionName).FindOne(ctx, bson.M{"_id": id}).Decode(doc)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	return err
+}
+
+// collection возвращает название коллекции, в которой хранятся данные для указанной страны.
+func (db *DB) collection(mcc uint16) string {
+	if db.partitions == nil {
+		return db.collectionName
+	}
+	return db.partitions.collection(mcc)
+}
+
+// collections возвращает названия всех коллекций с данными.
+func (db *DB) collections() []string {
+	if db.partitions == nil {
+		return []string{db.collectionName}
+	}
+	return db.partitions.names()
+}
@@ -0,0 +1,98 @@
+// Package promcollector публикует метрики lbs.DB (см. lbs.Metrics) для Prometheus: количество
+// запросов на вычисление координат и запросов без результата, долю найденных вышек и точек
+// доступа, количество найденных вышек в запросе и время выполнения запросов к MongoDB.
+//
+//	prometheus.MustRegister(promcollector.New(db.Metrics(), "lbs"))
+//	http.Handle("/metrics", promhttp.Handler())
+package promcollector
+
+import (
+	"github.com/geotrace/lbs/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector реализует prometheus.Collector для метрик lbs.DB. Значения читаются из lbs.Metrics в
+// момент сбора, поэтому сам DB не зависит от библиотеки Prometheus.
+type Collector struct {
+	metrics *lbs.Metrics
+
+	lookups        *prometheus.Desc
+	notFound       *prometheus.Desc
+	failed         *prometheus.Desc
+	cellsRequested *prometheus.Desc
+	cellsMatched   *prometheus.Desc
+	wifiRequested  *prometheus.Desc
+	wifiMatched    *prometheus.Desc
+	matched        *prometheus.Desc
+	duration       *prometheus.Desc
+}
+
+// New возвращает сборщик метрик с указанным префиксом названий (например, "lbs").
+func New(metrics *lbs.Metrics, namespace string) *Collector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, nil)
+	}
+	return &Collector{
+		metrics:        metrics,
+		lookups:        desc("lookups_total", "Geolocation requests."),
+		notFound:       desc("lookups_not_found_total", "Geolocation requests without a position found."),
+		failed:         desc("lookups_failed_total", "Geolocation requests failed with other errors."),
+		cellsRequested: desc("cells_requested_total", "Cells in geolocation requests."),
+		cellsMatched:   desc("cells_matched_total", "Cells from geolocation requests found in the database."),
+		wifiRequested:  desc("wifi_requested_total", "Wi-Fi access points in geolocation requests."),
+		wifiMatched:    desc("wifi_matched_total", "Wi-Fi access points from geolocation requests found in the database."),
+		matched:        desc("cells_matched_per_request", "Cells found in the database per geolocation request."),
+		duration:       desc("stage_duration_seconds", "Geolocation request stages duration.", "stage"),
+	}
+}
+
+// Describe передает описания всех метрик.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{c.lookups, c.notFound, c.failed, c.cellsRequested,
+		c.cellsMatched, c.wifiRequested, c.wifiMatched, c.matched, c.duration} {
+		ch <- desc
+	}
+}
+
+// Collect передает текущие значения метрик.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	m := c.metrics
+	for desc, counter := range map[*prometheus.Desc]*lbs.Counter{
+		c.lookups:        &m.Lookups,
+		c.notFound:       &m.NotFound,
+		c.failed:         &m.Failed,
+		c.cellsRequested: &m.CellsRequested,
+		c.cellsMatched:   &m.CellsMatched,
+		c.wifiRequested:  &m.WifiRequested,
+		c.wifiMatched:    &m.WifiMatched,
+	} {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(counter.Value()))
+	}
+	matched := m.Matched.Snapshot()
+	buckets := make(map[float64]uint64, len(matched.Bounds))
+	var total uint64
+	for i, bound := range matched.Bounds {
+		total += matched.Counts[i]
+		buckets[float64(bound)] = total
+	}
+	ch <- prometheus.MustNewConstHistogram(c.matched, matched.Count, float64(matched.Sum), buckets)
+	for stage, h := range map[string]*lbs.Histogram{
+		"query":     m.Query,
+		"mongo":     m.Mongo,
+		"algorithm": m.Algorithm,
+	} {
+		ch <- durationHistogram(c.duration, h.Snapshot(), stage)
+	}
+}
+
+// durationHistogram преобразует гистограмму времени выполнения в гистограмму Prometheus с
+// накопительными интервалами в секундах.
+func durationHistogram(desc *prometheus.Desc, s lbs.HistogramSnapshot, labels ...string) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(s.Bounds))
+	var total uint64
+	for i, bound := range s.Bounds {
+		total += s.Counts[i]
+		buckets[bound.Seconds()] = total
+	}
+	return prometheus.MustNewConstHistogram(desc, s.Count, s.Sum.Seconds(), buckets, labels...)
+}
@@ -0,0 +1,87 @@
+package promcollector
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geotrace/lbs/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const expected = `
+# HELP lbs_lookups_total Geolocation requests.
+# TYPE lbs_lookups_total counter
+lbs_lookups_total 4
+# HELP lbs_lookups_not_found_total Geolocation requests without a position found.
+# TYPE lbs_lookups_not_found_total counter
+lbs_lookups_not_found_total 1
+# HELP lbs_lookups_failed_total Geolocation requests failed with other errors.
+# TYPE lbs_lookups_failed_total counter
+lbs_lookups_failed_total 0
+# HELP lbs_cells_requested_total Cells in geolocation requests.
+# TYPE lbs_cells_requested_total counter
+lbs_cells_requested_total 15
+# HELP lbs_cells_matched_total Cells from geolocation requests found in the database.
+# TYPE lbs_cells_matched_total counter
+lbs_cells_matched_total 13
+# HELP lbs_wifi_requested_total Wi-Fi access points in geolocation requests.
+# TYPE lbs_wifi_requested_total counter
+lbs_wifi_requested_total 6
+# HELP lbs_wifi_matched_total Wi-Fi access points from geolocation requests found in the database.
+# TYPE lbs_wifi_matched_total counter
+lbs_wifi_matched_total 2
+# HELP lbs_cells_matched_per_request Cells found in the database per geolocation request.
+# TYPE lbs_cells_matched_per_request histogram
+lbs_cells_matched_per_request_bucket{le="0"} 1
+lbs_cells_matched_per_request_bucket{le="1"} 2
+lbs_cells_matched_per_request_bucket{le="5"} 3
+lbs_cells_matched_per_request_bucket{le="+Inf"} 4
+lbs_cells_matched_per_request_sum 13
+lbs_cells_matched_per_request_count 4
+# HELP lbs_stage_duration_seconds Geolocation request stages duration.
+# TYPE lbs_stage_duration_seconds histogram
+lbs_stage_duration_seconds_bucket{stage="algorithm",le="0.001"} 0
+lbs_stage_duration_seconds_bucket{stage="algorithm",le="1"} 0
+lbs_stage_duration_seconds_bucket{stage="algorithm",le="+Inf"} 0
+lbs_stage_duration_seconds_sum{stage="algorithm"} 0
+lbs_stage_duration_seconds_count{stage="algorithm"} 0
+lbs_stage_duration_seconds_bucket{stage="mongo",le="0.001"} 0
+lbs_stage_duration_seconds_bucket{stage="mongo",le="1"} 0
+lbs_stage_duration_seconds_bucket{stage="mongo",le="+Inf"} 1
+lbs_stage_duration_seconds_sum{stage="mongo"} 2
+lbs_stage_duration_seconds_count{stage="mongo"} 1
+lbs_stage_duration_seconds_bucket{stage="query",le="0.001"} 1
+lbs_stage_duration_seconds_bucket{stage="query",le="1"} 2
+lbs_stage_duration_seconds_bucket{stage="query",le="+Inf"} 2
+lbs_stage_duration_seconds_sum{stage="query"} 0.0025
+lbs_stage_duration_seconds_count{stage="query"} 2
+`
+
+func TestCollector(t *testing.T) {
+	metrics := &lbs.Metrics{
+		Query:     lbs.NewHistogram(time.Millisecond, time.Second),
+		Mongo:     lbs.NewHistogram(time.Millisecond, time.Second),
+		Algorithm: lbs.NewHistogram(time.Millisecond, time.Second),
+		Matched:   lbs.NewCountHistogram(0, 1, 5),
+	}
+	metrics.Lookups.Add(4)
+	metrics.NotFound.Add(1)
+	metrics.CellsRequested.Add(15)
+	metrics.CellsMatched.Add(13)
+	metrics.WifiRequested.Add(6)
+	metrics.WifiMatched.Add(2)
+	for _, n := range []int{0, 1, 3, 9} { // последнее значение больше всех границ
+		metrics.Matched.Observe(n)
+	}
+	metrics.Query.Observe(500 * time.Microsecond)
+	metrics.Query.Observe(2 * time.Millisecond)
+	metrics.Mongo.Observe(2 * time.Second)
+
+	if err := testutil.CollectAndCompare(New(metrics, "lbs"), strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	if count := testutil.CollectAndCount(New(metrics, "lbs")); count != 11 {
+		t.Errorf("bad metrics count: %d", count)
+	}
+}
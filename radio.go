@@ -0,0 +1,121 @@
+package lbs
+
+import (
+	"github.com/geotrace/lbs/v2/storage"
+	"github.com/geotrace/locator"
+)
+
+// RadioAliases задает другие названия типов радио (см. storage.RadioAliases). Это тот же
+// словарь, поэтому дополнить его можно через любой из пакетов.
+var RadioAliases = storage.RadioAliases
+
+// NormalizeRadio приводит тип радио к виду, в котором он хранится в базе (см.
+// storage.NormalizeRadio).
+func NormalizeRadio(radio string) string {
+	return storage.NormalizeRadio(radio)
+}
+
+// RadioFallback задает типы радио, под которыми вышка ищется в хранилище, если тип радио не
+// указан ни для запроса, ни для самой вышки. Современные устройства редко работают только в сети
+// GSM, поэтому вместо DefaultRadioType вышка ищется под всеми перечисленными типами, и
+// используется первый по порядку тип, под которым она найдена:
+//
+//	db, err := lbs.Dial(url, lbs.RadioFallback("lte", "umts", "gsm"))
+//
+// Все варианты ищутся одновременно (см. QueryConcurrency), поэтому перебор почти не увеличивает
+// время ответа. Ненайденная вышка отмечается в Details.Missing с первым типом радио из списка. По
+// умолчанию используется DefaultRadioType.
+func RadioFallback(radios ...string) Option {
+	return func(db *DB) {
+		db.radioFallback = nil
+		for _, radio := range radios {
+			db.radioFallback = append(db.radioFallback, NormalizeRadio(radio))
+		}
+	}
+}
+
+// requestCandidates возвращает ключи вышек из запроса, лучшие измерения для них и для каждой
+// вышки — ключи, под которыми ее нужно искать в хранилище, в порядке предпочтения. Без
+// RadioFallback и для вышек с известным типом радио такой ключ один.
+func (db *DB) requestCandidates(req Request) ([]Key, []*locator.CellTower, [][]Key) {
+	if req.RadioType == "" && req.Options != nil {
+		req.RadioType = req.Options.RadioType
+	}
+	req.RadioType = NormalizeRadio(req.RadioType)
+	fallback := db.radioFallbacks(req.Options)
+	if len(fallback) == 0 || req.RadioType != "" {
+		keys, towers := requestTowers(req, db.maxTowers)
+		candidates := make([][]Key, len(keys))
+		for i, key := range keys {
+			candidates[i] = []Key{key}
+		}
+		return keys, towers, candidates
+	}
+	// ключи вышек без типа радио получают пустой тип и заменяются вариантами из списка
+	keys, towers := towerKeys(req, "", db.maxTowers)
+	candidates := make([][]Key, len(keys))
+	for i, key := range keys {
+		if key.RadioType != "" {
+			candidates[i] = []Key{key}
+			continue
+		}
+		seen := make(map[string]bool, len(fallback))
+		for _, radio := range fallback {
+			if radio = towerRadio(radio, key.CellId); seen[radio] {
+				continue
+			}
+			seen[radio] = true
+			key.RadioType = radio
+			candidates[i] = append(candidates[i], key)
+		}
+		keys[i] = candidates[i][0]
+	}
+	return keys, towers, candidates
+}
+
+// allCandidates возвращает все ключи для поиска вышек без повторов.
+func allCandidates(candidates [][]Key) []Key {
+	all := make([]Key, 0, len(candidates))
+	seen := make(map[Key]bool, len(candidates))
+	for _, list := range candidates {
+		for _, key := range list {
+			if !seen[key] {
+				seen[key] = true
+				all = append(all, key)
+			}
+		}
+	}
+	return all
+}
+
+// resolveCandidates выбирает для каждой вышки первый ключ из candidates, под которым она найдена
+// в index, и возвращает выбранные ключи вместе с найденными записями. Для ненайденных вышек
+// возвращается первый ключ из списка. Каждая запись возвращается один раз, даже если под ее
+// ключом найдено несколько вышек из запроса.
+func resolveCandidates(candidates [][]Key, index map[Key]Cell) (keys []Key, cells []Cell) {
+	keys = make([]Key, len(candidates))
+	used := make(map[Key]bool, len(candidates))
+	for i, list := range candidates {
+		keys[i] = list[0]
+		for _, key := range list {
+			if cell, ok := index[key]; ok {
+				keys[i] = key
+				if !used[key] {
+					used[key] = true
+					cells = append(cells, cell)
+				}
+				break
+			}
+		}
+	}
+	return keys, cells
+}
+
+// cellIndex возвращает записи о вышках по их ключам.
+func cellIndex(cells []Cell) map[Key]Cell {
+	index := make(map[Key]Cell, len(cells))
+	for _, cell := range cells {
+		index[cell.Key] = cell
+	}
+	return index
+}
@@ -0,0 +1,75 @@
+package lbs
+
+import (
+	"testing"
+
+	"github.com/geotrace/locator"
+)
+
+func TestRadioFallback(t *testing.T) {
+	db := newDB("test", []Option{RadioFallback("LTE", "umts", "gsm")})
+	req := Request{
+		Request: locator.Request{CellTowers: []*locator.CellTower{
+			{MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 1, CellId: 10},
+			{MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 1, CellId: 20},
+			{MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 1, CellId: 0x10000},
+			{MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 1, CellId: 30},
+		}},
+		RadioTypes: []string{"", "", "", "gsm"},
+	}
+	keys, towers, candidates := db.requestCandidates(req)
+	if len(keys) != 4 || len(towers) != 4 || len(candidates) != 4 {
+		t.Fatalf("bad candidates: %v", candidates)
+	}
+	if len(candidates[0]) != 3 || candidates[0][0].RadioType != "lte" || candidates[0][2].RadioType != "gsm" {
+		t.Errorf("bad fallback order: %v", candidates[0])
+	}
+	// вышка GSM не может иметь идентификатор длиннее 16 бит
+	if len(candidates[2]) != 2 || candidates[2][1].RadioType != "umts" {
+		t.Errorf("bad long cell id candidates: %v", candidates[2])
+	}
+	if len(candidates[3]) != 1 || candidates[3][0].RadioType != "gsm" {
+		t.Errorf("explicit radio replaced: %v", candidates[3])
+	}
+	if keys[0].RadioType != "lte" {
+		t.Errorf("bad primary key: %v", keys[0])
+	}
+	if all := allCandidates(candidates); len(all) != 3+3+2+1 {
+		t.Errorf("bad all candidates: %v", all)
+	}
+
+	umts, gsm := candidates[0][1], candidates[1][2]
+	keys, cells := resolveCandidates(candidates, cellIndex([]Cell{{Key: umts}, {Key: gsm}}))
+	if keys[0] != umts || keys[1] != gsm || keys[2] != candidates[2][0] || len(cells) != 2 {
+		t.Errorf("bad resolved keys: %v, cells %v", keys, cells)
+	}
+
+	// тип радио запроса отменяет перебор
+	req.RadioType = "gsm"
+	if _, _, candidates := db.requestCandidates(req); len(candidates[0]) != 1 {
+		t.Errorf("fallback with request radio: %v", candidates[0])
+	}
+}
+
+func TestNormalizeRadio(t *testing.T) {
+	for radio, want := range map[string]string{"GSM": "gsm", "WCDMA": "umts", " wcdma": "umts", "UMTS": "umts", "": ""} {
+		if got := NormalizeRadio(radio); got != want {
+			t.Errorf("NormalizeRadio(%q) = %q, want %q", radio, got, want)
+		}
+	}
+	keys := requestKeys(Request{Request: locator.Request{
+		RadioType:  "WCDMA",
+		CellTowers: []*locator.CellTower{{MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 1, CellId: 10}},
+	}}, 0)
+	if len(keys) != 1 || keys[0].RadioType != "umts" {
+		t.Errorf("request radio type not normalized: %+v", keys)
+	}
+	var cell Cell
+	if err := cell.UnmarshalCSV([]string{"WCDMA", "250", "1", "1", "10", "", "37.6", "55.7", "1000", "1"}); err != nil ||
+		cell.RadioType != "umts" {
+		t.Errorf("imported radio type not normalized: %q, %v", cell.RadioType, err)
+	}
+	if err := (Request{Request: locator.Request{RadioType: "WCDMA"}}).Validate(); err != nil {
+		t.Errorf("radio alias rejected: %v", err)
+	}
+}
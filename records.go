@@ -0,0 +1,124 @@
+package lbs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrBadGroupField возвращается, если запрошена группировка по неподдерживаемому полю.
+var ErrBadGroupField = errors.New("lbs: unsupported group field")
+
+// groupFields содержит список полей, по которым поддерживается группировка записей.
+var groupFields = map[string]bool{
+	"radio": true,
+	"mcc":   true,
+	"mnc":   true,
+	"lac":   true,
+}
+
+// defaultRecordsCacheTTL задает время, в течение которого результаты RecordsBy используются
+// повторно без запроса к MongoDB.
+const defaultRecordsCacheTTL = 10 * time.Second
+
+// RecordsCacheTTL задает время хранения результатов RecordsBy. Нулевое значение отключает
+// кеширование.
+func RecordsCacheTTL(ttl time.Duration) Option {
+	return func(db *DB) {
+		db.records.ttl = ttl
+	}
+}
+
+// recordsCache хранит результаты группировки записей хранилища в течение короткого времени:
+// панели мониторинга запрашивают эти данные каждые несколько секунд, а агрегация по всей
+// коллекции — достаточно дорогая операция.
+type recordsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration           // время хранения результатов
+	entries map[string]recordsEntry // результаты группировки по названию поля
+}
+
+// recordsEntry описывает сохраненный результат группировки.
+type recordsEntry struct {
+	counts  map[string]int // количество записей по значениям поля
+	expires time.Time      // время устаревания результата
+}
+
+// get возвращает копию сохраненного результата группировки по полю, если к моменту now он еще не
+// устарел.
+func (c *recordsCache) get(field string, now time.Time) (map[string]int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[field]
+	if !ok || now.After(entry.expires) {
+		return nil, false
+	}
+	return copyCounts(entry.counts), true
+}
+
+// set сохраняет результат группировки по полю, полученный в момент now.
+func (c *recordsCache) set(field string, counts map[string]int, now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]recordsEntry)
+	}
+	c.entries[field] = recordsEntry{
+		counts:  copyCounts(counts),
+		expires: now.Add(c.ttl),
+	}
+	c.mu.Unlock()
+}
+
+// copyCounts возвращает копию результата группировки, чтобы изменения, сделанные вызывающей
+// стороной, не затрагивали кеш.
+func copyCounts(counts map[string]int) map[string]int {
+	result := make(map[string]int, len(counts))
+	for value, count := range counts {
+		result[value] = count
+	}
+	return result
+}
+
+// RecordsBy возвращает количество записей в хранилище LBS, сгруппированных по значению
+// указанного поля: "radio", "mcc", "mnc" или "lac". Результат кешируется на время, заданное
+// RecordsCacheTTL (по умолчанию 10 секунд).
+func (db *DB) RecordsBy(ctx context.Context, field string) (map[string]int, error) {
+	if !groupFields[field] {
+		return nil, ErrBadGroupField
+	}
+	if counts, ok := db.records.get(field, db.clock.Now()); ok {
+		return counts, nil
+	}
+	var result []struct {
+		Value interface{} `bson:"_id"`
+		Count int         `bson:"count"`
+	}
+	pipeline := []bson.M{
+		{"$group": bson.M{"_id": "$" + field, "count": bson.M{"$sum": 1}}},
+	}
+	counts := make(map[string]int)
+	mdb, err := db.database()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	// при хранении данных в отдельных коллекциях суммируем результаты по всем коллекциям
+	for _, name := range db.collections() {
+		if err := aggregateAll(ctx, mdb.Collection(name), pipeline, &result); err != nil {
+			return nil, err
+		}
+		for _, item := range result {
+			counts[fmt.Sprint(item.Value)] += item.Count
+		}
+	}
+	db.records.set(field, counts, db.clock.Now())
+	return counts, nil
+}
@@ -0,0 +1,43 @@
+package lbs
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock описывает часы, время которых меняется только вызовом add.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) add(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func TestRecordsCache(t *testing.T) {
+	clock := &testClock{now: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)}
+	db := newDB("test", []Option{WithClock(clock), RecordsCacheTTL(10 * time.Second)})
+	db.records.set("mcc", map[string]int{"250": 10}, db.clock.Now())
+	counts, ok := db.records.get("mcc", db.clock.Now())
+	if !ok || counts["250"] != 10 {
+		t.Fatalf("cached counts not found: %v", counts)
+	}
+	counts["250"] = 0
+	if counts, _ := db.records.get("mcc", db.clock.Now()); counts["250"] != 10 {
+		t.Error("cached counts changed by caller")
+	}
+	clock.add(11 * time.Second)
+	if _, ok := db.records.get("mcc", db.clock.Now()); ok {
+		t.Error("expired counts returned")
+	}
+}
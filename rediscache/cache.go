@@ -0,0 +1,68 @@
+// Package rediscache реализует кеш записей о вышках lbs.Cache поверх Redis. Позволяет нескольким
+// экземплярам сервера использовать общий кеш. Записи о вышках одного запроса читаются одной
+// командой MGET и сохраняются одним конвейером команд (см. lbs.BatchCache), поэтому запрос к
+// кешу занимает одно обращение к Redis независимо от количества вышек.
+//
+//	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+//	db, err := lbs.Dial(ctx, url, lbs.WithCache(rediscache.New(client), time.Hour))
+package rediscache
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Cache описывает кеш в Redis.
+type Cache struct {
+	client *redis.Client
+}
+
+// New возвращает новый кеш, использующий указанный клиент Redis.
+func New(client *redis.Client) *Cache {
+	return &Cache{client: client}
+}
+
+// Get возвращает значение из кеша. Ошибки Redis считаются отсутствием значения.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	value, err := c.client.Get(key).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// Set сохраняет значение в кеш на время ttl.
+func (c *Cache) Set(key string, value []byte, ttl time.Duration) {
+	c.client.Set(key, value, ttl)
+}
+
+// GetMulti возвращает найденные в кеше значения по ключу. При ошибке Redis возвращается пустой
+// результат.
+func (c *Cache) GetMulti(keys []string) map[string][]byte {
+	values, err := c.client.MGet(keys...).Result()
+	if err != nil {
+		return map[string][]byte{}
+	}
+	found := make(map[string][]byte, len(values))
+	for i, value := range values {
+		if s, ok := value.(string); ok && i < len(keys) {
+			found[keys[i]] = []byte(s)
+		}
+	}
+	return found
+}
+
+// SetMulti сохраняет значения в кеш на время ttl одним конвейером команд.
+func (c *Cache) SetMulti(values map[string][]byte, ttl time.Duration) {
+	pipe := c.client.Pipeline()
+	for key, value := range values {
+		pipe.Set(key, value, ttl)
+	}
+	pipe.Exec()
+}
+
+// Delete удаляет значение из кеша.
+func (c *Cache) Delete(key string) {
+	c.client.Del(key)
+}
@@ -0,0 +1,93 @@
+package rediscache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/geotrace/lbs/v2"
+	"github.com/go-redis/redis"
+)
+
+var (
+	_ lbs.Cache      = (*Cache)(nil)
+	_ lbs.BatchCache = (*Cache)(nil)
+)
+
+// newCache возвращает кеш поверх сервера miniredis.
+func newCache(t *testing.T) (*Cache, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return New(client), server
+}
+
+func TestCache(t *testing.T) {
+	cache, server := newCache(t)
+	binary := []byte{0, 1, 0xfe, 0xff, '\r', '\n', 0}
+	cache.Set("a", binary, time.Minute)
+	cache.Set("b", []byte{}, time.Minute)
+	cache.Set("c", []byte("3"), 0) // без ограничения времени хранения
+	if value, ok := cache.Get("a"); !ok || !bytes.Equal(value, binary) {
+		t.Errorf("bad value: %q, %v", value, ok)
+	}
+	if value, ok := cache.Get("b"); !ok || len(value) != 0 {
+		t.Errorf("empty value not cached: %q, %v", value, ok)
+	}
+	if _, ok := cache.Get("missing"); ok {
+		t.Error("missing value returned")
+	}
+	if ttl := server.TTL("a"); ttl != time.Minute {
+		t.Errorf("bad TTL: %v", ttl)
+	}
+	cache.Delete("b")
+	if _, ok := cache.Get("b"); ok {
+		t.Error("deleted value returned")
+	}
+	server.FastForward(time.Minute)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("expired value returned")
+	}
+	if value, ok := cache.Get("c"); !ok || string(value) != "3" {
+		t.Errorf("value without TTL expired: %q, %v", value, ok)
+	}
+}
+
+func TestCacheMulti(t *testing.T) {
+	cache, server := newCache(t)
+	binary := []byte{0, 1, 0xfe, 0xff}
+	cache.SetMulti(map[string][]byte{"a": binary, "b": []byte{}, "c": []byte("3")}, time.Minute)
+	if ttl := server.TTL("c"); ttl != time.Minute {
+		t.Errorf("bad TTL: %v", ttl)
+	}
+	found := cache.GetMulti([]string{"a", "missing", "b", "c"})
+	if len(found) != 3 || !bytes.Equal(found["a"], binary) || found["b"] == nil || len(found["b"]) != 0 ||
+		string(found["c"]) != "3" {
+		t.Errorf("bad values: %q", found)
+	}
+	if _, ok := found["missing"]; ok {
+		t.Error("missing value returned")
+	}
+	server.FastForward(time.Minute)
+	if found = cache.GetMulti([]string{"a", "b", "c"}); len(found) != 0 {
+		t.Errorf("expired values returned: %q", found)
+	}
+}
+
+func TestCacheUnavailable(t *testing.T) {
+	cache, server := newCache(t)
+	cache.Set("a", []byte("1"), time.Minute)
+	server.Close()
+	// ошибки Redis считаются отсутствием значений
+	if _, ok := cache.Get("a"); ok {
+		t.Error("value returned from closed server")
+	}
+	if found := cache.GetMulti([]string{"a"}); found == nil || len(found) != 0 {
+		t.Errorf("bad values from closed server: %q", found)
+	}
+	cache.Set("b", []byte("2"), time.Minute)
+	cache.SetMulti(map[string][]byte{"c": []byte("3")}, time.Minute)
+	cache.Delete("a")
+}
@@ -0,0 +1,168 @@
+package lbs
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/geotrace/locator"
+)
+
+// Request описывает запрос на вычисление координат с дополнительными сведениями, которые не
+// поддерживаются locator.Request.
+//
+// Google Geolocation API позволяет указать тип радио для каждой вышки отдельно: телефоны
+// сообщают в одном запросе соседние вышки GSM и LTE. Такие типы радио передаются в RadioTypes в
+// том же порядке, что и вышки в CellTowers; если тип для вышки не указан, то используется тип
+// радио запроса. При разборе запроса в формате JSON поле radioType каждой вышки заполняет
+// RadioTypes автоматически.
+//
+// Идентификаторы вышек 5G NR не помещаются в поля locator.CellTower: TAC занимает 24 бита, а NCI
+// — 36 бит. Они передаются в Identities в том же порядке, что и вышки; при разборе запроса в
+// формате JSON туда попадают поля locationAreaCode и cellId, не поместившиеся в CellTower, и
+// поле newRadioCellId из Google Geolocation API. Вышка с newRadioCellId без указанного типа радио
+// считается вышкой nr.
+//
+// Вышки CDMA передаются с номером системы в mobileNetworkCode, номером сети в locationAreaCode и
+// номером базовой станции в cellId (см. CDMAKey). Клиенты, получающие эти номера от Android
+// (CdmaCellLocation), могут передать их и в полях systemId, networkId и basestationId: такая вышка
+// без указанного типа радио считается вышкой cdma.
+//
+// Options задает параметры вычисления координат для этого запроса вместо параметров DB (см.
+// GetOptions), а IP — адрес клиента для приблизительного поиска (см. IPFallback). Mozilla Location
+// Service позволяет клиенту запретить приблизительный поиск в поле fallbacks запроса: значения
+// "lacf": false и "ipf": false (а также "considerIp": false из Google Geolocation API) при разборе
+// запроса в формате JSON устанавливают GetOptions.NoAreaFallback и GetOptions.NoIPFallback.
+type Request struct {
+	locator.Request
+	RadioTypes []string       `json:"-"` // тип радио для каждой вышки из CellTowers
+	Identities []CellIdentity `json:"-"` // идентификаторы для вышек из CellTowers, если они шире полей CellTower
+	Options    *GetOptions    `json:"-"` // параметры вычисления координат (nil - параметры DB)
+	IP         net.IP         `json:"-"` // IP-адрес клиента (nil - неизвестен)
+}
+
+// CellIdentity описывает идентификаторы вышки, которые не помещаются в locator.CellTower. Нулевое
+// значение поля означает, что используется значение из CellTower.
+type CellIdentity struct {
+	LocationAreaCode uint32 // код зоны (TAC)
+	CellId           uint64 // идентификатор вышки (NCI)
+}
+
+// UnmarshalJSON разбирает запрос в формате JSON вместе с типом радио и идентификаторами каждой
+// вышки.
+func (r *Request) UnmarshalJSON(data []byte) error {
+	var towers struct {
+		CellTowers []struct {
+			RadioType        string `json:"radioType"`
+			LocationAreaCode uint32 `json:"locationAreaCode"`
+			CellId           uint64 `json:"cellId"`
+			NewRadioCellId   uint64 `json:"newRadioCellId"`
+			SystemId         uint16 `json:"systemId"`
+			NetworkId        uint16 `json:"networkId"`
+			BasestationId    uint16 `json:"basestationId"`
+		} `json:"cellTowers"`
+		ConsiderIp *bool `json:"considerIp"`
+		Fallbacks  struct {
+			LAC *bool `json:"lacf"`
+			IP  *bool `json:"ipf"`
+		} `json:"fallbacks"`
+	}
+	if err := json.Unmarshal(data, &towers); err != nil {
+		return err
+	}
+	// идентификаторы, не поместившиеся в CellTower, разбираются отдельно
+	if err := json.Unmarshal(data, &r.Request); err != nil && !wideIdentityError(err) {
+		return err
+	}
+	r.RadioTypes, r.Identities = nil, nil
+	for i, cell := range towers.CellTowers {
+		radio := cell.RadioType
+		id := CellIdentity{CellId: cell.NewRadioCellId}
+		if id.CellId != 0 && radio == "" {
+			radio = "nr"
+		}
+		if cell.BasestationId != 0 && i < len(r.CellTowers) && r.CellTowers[i] != nil {
+			tower := r.CellTowers[i]
+			tower.MobileNetworkCode = cell.SystemId
+			tower.LocationAreaCode = cell.NetworkId
+			tower.CellId = uint32(cell.BasestationId)
+			if radio == "" {
+				radio = "cdma"
+			}
+		}
+		if cell.LocationAreaCode > maxTowerAreaCode {
+			id.LocationAreaCode = cell.LocationAreaCode
+		}
+		if id.CellId == 0 && cell.CellId > maxTowerCellId {
+			id.CellId = cell.CellId
+		}
+		if radio != "" {
+			if r.RadioTypes == nil {
+				r.RadioTypes = make([]string, len(towers.CellTowers))
+			}
+			r.RadioTypes[i] = radio
+		}
+		if id != (CellIdentity{}) {
+			if r.Identities == nil {
+				r.Identities = make([]CellIdentity, len(towers.CellTowers))
+			}
+			r.Identities[i] = id
+		}
+	}
+	disabled := func(flag *bool) bool { return flag != nil && !*flag }
+	noArea := disabled(towers.Fallbacks.LAC)
+	noIP := disabled(towers.Fallbacks.IP) || disabled(towers.ConsiderIp)
+	if noArea || noIP {
+		var opts GetOptions
+		if r.Options != nil {
+			opts = *r.Options
+		}
+		opts.NoAreaFallback, opts.NoIPFallback = noArea, noIP
+		r.Options = &opts
+	}
+	return nil
+}
+
+// Максимальные значения идентификаторов, которые помещаются в поля locator.CellTower.
+const (
+	maxTowerAreaCode = 0xFFFF
+	maxTowerCellId   = 0xFFFFFFFF
+)
+
+// wideIdentityError возвращает true, если ошибка разбора JSON вызвана только тем, что
+// идентификатор вышки не поместился в поле locator.CellTower.
+func wideIdentityError(err error) bool {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) || !strings.HasPrefix(typeErr.Value, "number") ||
+		!strings.HasPrefix(typeErr.Field, "cellTowers.") {
+		return false
+	}
+	// в зависимости от версии Go путь к полю может содержать номер вышки
+	return strings.HasSuffix(typeErr.Field, ".locationAreaCode") || strings.HasSuffix(typeErr.Field, ".cellId")
+}
+
+// radio возвращает тип радио для вышки с указанным номером в запросе. Если тип для вышки не
+// указан, то возвращается пустая строка.
+func (r Request) radio(i int) string {
+	if i >= len(r.RadioTypes) {
+		return ""
+	}
+	return NormalizeRadio(r.RadioTypes[i])
+}
+
+// identity возвращает код зоны и идентификатор вышки с указанным номером в запросе с учетом
+// Identities.
+func (r Request) identity(i int) (lac uint32, cellId uint64) {
+	cell := r.CellTowers[i]
+	lac, cellId = uint32(cell.LocationAreaCode), uint64(cell.CellId)
+	if i < len(r.Identities) {
+		if id := r.Identities[i]; id.LocationAreaCode != 0 {
+			lac = id.LocationAreaCode
+		}
+		if id := r.Identities[i]; id.CellId != 0 {
+			cellId = id.CellId
+		}
+	}
+	return lac, cellId
+}
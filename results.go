@@ -0,0 +1,152 @@
+package lbs
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ResultsCollectionName описывает название коллекции с сохраненными результатами вычисления
+// координат (см. StoreResults).
+var ResultsCollectionName = "lbs_results"
+
+// resultsIndex описывает TTL-индекс коллекции с результатами: записи удаляются после наступления
+// времени, указанного в поле expires.
+var resultsIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: "expires", Value: 1}},
+	Options: options.Index().SetExpireAfterSeconds(0).SetBackground(true),
+}
+
+// storedResult описывает результат вычисления координат в коллекции ResultsCollectionName.
+type storedResult struct {
+	Key     string    `bson:"_id"`
+	Value   []byte    `bson:"value"`   // Details в формате JSON
+	Expires time.Time `bson:"expires"` // время удаления записи
+}
+
+// WithResultCache включает кеширование результатов вычисления координат на время ttl. Ключом
+// кеша служит набор вышек и точек доступа Wi-Fi из запроса без учета их порядка, поэтому
+// повторяющиеся запросы неподвижных трекеров не обращаются к MongoDB, пока результат не устареет:
+//
+//	db, err := lbs.Dial(ctx, url, lbs.WithResultCache(lbs.NewLRUCache(10000), 5*time.Minute))
+//
+// Уровень сигнала и время задержки в ключ не входят: для того же набора вышек в течение ttl
+// возвращается ранее вычисленный результат, даже если заданы SignalWeighted или Multilateration.
+// Изменения хранилища, в том числе Block и Unblock, становятся видны после устаревания
+// результатов. Ненайденные координаты не кешируются. Значение cache может быть nil, если
+// результаты хранятся только в MongoDB (см. StoreResults).
+func WithResultCache(cache Cache, ttl time.Duration) Option {
+	return func(db *DB) {
+		db.results = cache
+		db.resultsTTL = ttl
+	}
+}
+
+// StoreResults дополнительно сохраняет результаты вычисления координат в коллекции
+// ResultsCollectionName на время, заданное в WithResultCache, чтобы ими могли пользоваться
+// несколько экземпляров сервера и чтобы они переживали перезапуск. Результаты сначала ищутся в
+// кеше WithResultCache, затем в коллекции; найденные в коллекции результаты попадают в кеш.
+// Устаревшие записи удаляются MongoDB автоматически с помощью TTL-индекса.
+func StoreResults() Option {
+	return func(db *DB) {
+		db.resultsStored = true
+	}
+}
+
+// resultKey возвращает ключ кеша результата для ключей вышек и MAC-адресов точек доступа из
+// запроса. Ключи сортируются, поэтому порядок вышек в запросе не влияет на результат.
+func (db *DB) resultKey(keys []Key, macs []string) string {
+	ids := make([]string, 0, len(keys)+len(macs))
+	for _, key := range keys {
+		ids = append(ids, fmt.Sprintf("%s:%d:%d:%d:%d", key.RadioType, key.MobileCountryCode,
+			key.MobileNetworkCode, key.LocationAreaCode, key.CellId))
+	}
+	sort.Strings(ids)
+	wifi := append([]string(nil), macs...)
+	sort.Strings(wifi)
+	hash := sha1.New()
+	for _, id := range append(ids, wifi...) {
+		hash.Write([]byte(id))
+		hash.Write([]byte{0})
+	}
+	return fmt.Sprintf("lbs:%s.%s:result:%x", db.name, db.collectionName, hash.Sum(nil))
+}
+
+// cachedResult возвращает сохраненный результат вычисления координат. Ошибки кеша и MongoDB
+// считаются отсутствием результата.
+func (db *DB) cachedResult(ctx context.Context, key string) (*Details, bool) {
+	if db.results != nil {
+		if value, ok := db.results.Get(key); ok {
+			var details Details
+			if err := json.Unmarshal(value, &details); err == nil {
+				return &details, true
+			}
+		}
+	}
+	if !db.resultsStored {
+		return nil, false
+	}
+	mdb, err := db.database()
+	if err != nil {
+		return nil, false
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	now := db.clock.Now()
+	var result storedResult
+	// TTL-индекс удаляет записи с задержкой, поэтому срок проверяется и в запросе
+	err = mdb.Collection(ResultsCollectionName).FindOne(ctx,
+		bson.M{"_id": key, "expires": bson.M{"$gt": now}}).Decode(&result)
+	if err != nil {
+		return nil, false
+	}
+	var details Details
+	if err = json.Unmarshal(result.Value, &details); err != nil {
+		return nil, false
+	}
+	if db.results != nil {
+		db.results.Set(key, result.Value, result.Expires.Sub(now))
+	}
+	return &details, true
+}
+
+// cacheResult сохраняет результат вычисления координат в кеш и, если задан StoreResults, в
+// MongoDB. Ошибки сохранения не мешают вернуть результат и поэтому игнорируются.
+func (db *DB) cacheResult(ctx context.Context, key string, details *Details) {
+	value, err := json.Marshal(details)
+	if err != nil {
+		return
+	}
+	if db.results != nil {
+		db.results.Set(key, value, db.resultsTTL)
+	}
+	if !db.resultsStored {
+		return
+	}
+	mdb, err := db.database()
+	if err != nil {
+		return
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	coll := mdb.Collection(ResultsCollectionName)
+	if atomic.LoadInt32(&db.resultsIndexed) == 0 {
+		if _, err = coll.Indexes().CreateOne(ctx, resultsIndex); err != nil {
+			return
+		}
+		atomic.StoreInt32(&db.resultsIndexed, 1)
+	}
+	if _, err = coll.ReplaceOne(ctx, bson.M{"_id": key},
+		storedResult{Key: key, Value: value, Expires: db.clock.Now().Add(db.resultsTTL)},
+		options.Replace().SetUpsert(true)); err != nil {
+		db.log().WarnContext(ctx, "Result not stored", "error", err)
+	}
+}
@@ -0,0 +1,51 @@
+package lbs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/geotrace/locator"
+)
+
+func TestResultKey(t *testing.T) {
+	db := newDB("test", nil)
+	a := Key{RadioType: "gsm", MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 1, CellId: 10}
+	b := Key{RadioType: "gsm", MobileCountryCode: 250, MobileNetworkCode: 1, LocationAreaCode: 1, CellId: 20}
+	if db.resultKey([]Key{a, b}, nil) != db.resultKey([]Key{b, a}, nil) {
+		t.Error("result key depends on cells order")
+	}
+	if db.resultKey([]Key{a, b}, nil) == db.resultKey([]Key{a}, nil) {
+		t.Error("same result key for different cells")
+	}
+	if db.resultKey([]Key{a}, []string{"01:02:03:04:05:06"}) == db.resultKey([]Key{a}, nil) {
+		t.Error("result key ignores wifi")
+	}
+	if newDB("other", nil).resultKey([]Key{a}, nil) == db.resultKey([]Key{a}, nil) {
+		t.Error("same result key for different databases")
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	cache := NewLRUCache(10)
+	db := newDB("test", []Option{WithResultCache(cache, time.Minute)})
+	key := db.resultKey([]Key{{RadioType: "gsm", CellId: 1}}, nil)
+	if _, ok := db.cachedResult(context.Background(), key); ok {
+		t.Fatal("result in empty cache")
+	}
+	details := &Details{
+		Response:   &locator.Response{Location: locator.Point{Lat: 55.75, Lng: 37.61}, Accuracy: 1500},
+		CellCounts: Counts{1, 1, 1},
+	}
+	db.cacheResult(context.Background(), key, details)
+	cached, ok := db.cachedResult(context.Background(), key)
+	if !ok {
+		t.Fatal("result not cached")
+	}
+	if *cached.Response != *details.Response || cached.CellCounts != details.CellCounts {
+		t.Errorf("bad cached result: %+v", cached.Response)
+	}
+	if cache.Len() != 1 {
+		t.Errorf("bad cache size: %d", cache.Len())
+	}
+}
@@ -0,0 +1,82 @@
+package lbs
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SchemaID задает идентификатор документа с версией схемы данных в коллекции метаданных
+// MetaCollectionName.
+const SchemaID = "schema"
+
+// SchemaVersion задает текущую версию схемы данных. В версии 2 код зоны и идентификатор вышки
+// расширены до AreaCodeBits и CellIdBits и хранятся как 64-битные целые; в версии 1 они хранились
+// как 32-битные. В версии 3 координаты хранятся как точка GeoJSON (см. Registry); в предыдущих
+// версиях они хранились как пара [долгота, широта]. База без документа SchemaID имеет версию 1.
+const SchemaVersion = 3
+
+// SchemaInfo описывает версию схемы данных хранилища.
+type SchemaInfo struct {
+	ID       string `bson:"_id" json:"-"`
+	Version  int    `bson:"version" json:"version"`   // версия схемы
+	Modified int    `bson:"modified" json:"modified"` // значений, измененных при последнем переходе
+}
+
+// Schema возвращает версию схемы данных хранилища.
+func (db *DB) Schema(ctx context.Context) (*SchemaInfo, error) {
+	doc := &SchemaInfo{ID: SchemaID, Version: 1}
+	if err := db.findMeta(ctx, SchemaID, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// MigrateSchema переводит записи о вышках и исходные измерения на текущую версию схемы данных и
+// сохраняет ее в коллекции метаданных. Для поиска переход не обязателен: MongoDB сравнивает числа
+// разных типов по значению, индекс 2dsphere понимает координаты в обоих форматах, а DB читает оба
+// формата, поэтому старые записи находятся и без него. Но после перехода поля lac, cell и site во
+// всех записях имеют один тип, а координаты в записях о вышках, точках доступа Wi-Fi и зонах и в
+// исходных измерениях хранятся как точки GeoJSON, на что могут рассчитывать внешние программы,
+// читающие базу напрямую. Переход можно выполнять на работающей базе и повторять: уже переведенные
+// записи не изменяются. Требуется MongoDB 4.2 или новее.
+//
+// Ключи списка заблокированных вышек служат идентификаторами документов и не изменяются: они
+// тоже сравниваются по значению.
+func (db *DB) MigrateSchema(ctx context.Context) (*SchemaInfo, error) {
+	mdb, err := db.database()
+	if err != nil {
+		return nil, err
+	}
+	doc := &SchemaInfo{ID: SchemaID, Version: SchemaVersion}
+	for _, name := range append(db.collections(), ObservationsCollectionName) {
+		coll := mdb.Collection(name)
+		for _, field := range []string{"lac", "cell", "site"} {
+			result, err := coll.UpdateMany(ctx, bson.M{field: bson.M{"$type": "int"}}, []bson.M{
+				{"$set": bson.M{field: bson.M{"$toLong": "$" + field}}},
+			})
+			if err != nil {
+				return nil, err
+			}
+			doc.Modified += int(result.ModifiedCount)
+		}
+	}
+	names := append(db.collections(), ObservationsCollectionName, WifiCollectionName,
+		AreasCollectionName, NetworksCollectionName)
+	for _, name := range names {
+		result, err := mdb.Collection(name).UpdateMany(ctx, bson.M{"location": bson.M{"$type": "array"}}, []bson.M{
+			{"$set": bson.M{"location": bson.D{{Key: "type", Value: "Point"}, {Key: "coordinates", Value: "$location"}}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		doc.Modified += int(result.ModifiedCount)
+	}
+	_, err = mdb.Collection(MetaCollectionName).ReplaceOne(ctx, bson.M{"_id": SchemaID}, doc,
+		options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
@@ -0,0 +1,105 @@
+package lbs
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Filter описывает условия поиска записей о сотовых вышках. Незаданные (nil или пустые) поля в
+// поиске не участвуют.
+type Filter struct {
+	RadioType         string  // тип радио
+	MobileCountryCode *uint16 // код страны
+	MobileNetworkCode *uint16 // код оператора
+	LocationAreaCode  *uint32 // код зоны
+	CellId            *uint64 // идентификатор вышки
+}
+
+// query возвращает запрос к MongoDB, соответствующий фильтру.
+func (f Filter) query() bson.M {
+	query := bson.M{}
+	if f.RadioType != "" {
+		query["radio"] = f.RadioType
+	}
+	if f.MobileCountryCode != nil {
+		query["mcc"] = *f.MobileCountryCode
+	}
+	if f.MobileNetworkCode != nil {
+		query["mnc"] = *f.MobileNetworkCode
+	}
+	if f.LocationAreaCode != nil {
+		query["lac"] = *f.LocationAreaCode
+	}
+	if f.CellId != nil {
+		query["cell"] = *f.CellId
+	}
+	return query
+}
+
+// defaultSearchLimit задает количество записей, возвращаемых Search, если ограничение не указано.
+const defaultSearchLimit = 100
+
+// Search возвращает не более limit записей о сотовых вышках, удовлетворяющих фильтру. Если limit
+// не задан, то возвращается не более 100 записей. В отличие от вычисления координат, поиск
+// возвращает и заблокированные вышки, отмечая их флагом Blocked.
+func (db *DB) Search(ctx context.Context, filter Filter, limit int) ([]Cell, error) {
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	names := db.collections()
+	if filter.MobileCountryCode != nil {
+		names = []string{db.collection(*filter.MobileCountryCode)}
+	}
+	cells := make([]Cell, 0, limit)
+	mdb, err := db.database()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	query := filter.query()
+	for _, name := range names {
+		var found []Cell
+		find := options.Find().SetProjection(bson.M{"_id": 0}).SetLimit(int64(limit - len(cells)))
+		if err := findAll(ctx, mdb.Collection(name), query, &found, find); err != nil {
+			return nil, err
+		}
+		if cells = append(cells, found...); len(cells) >= limit {
+			break
+		}
+	}
+	return cells, nil
+}
+
+// TowersByOperator возвращает записи о вышках оператора с кодом страны mcc и кодом оператора mnc,
+// например, для проверки импортированных данных или статистики покрытия оператора. Записи
+// упорядочены по ключу вышки и разбиты на страницы по limit записей (по умолчанию 100); page
+// задает номер страницы, начиная с нуля. Пустой список означает, что страниц больше нет. Как и
+// Search, метод возвращает и заблокированные вышки, отмечая их флагом Blocked.
+func (db *DB) TowersByOperator(ctx context.Context, mcc, mnc uint16, limit, page int) ([]Cell, error) {
+	if page < 0 {
+		return nil, errors.New("lbs: bad page number")
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	mdb, err := db.database()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	cells := make([]Cell, 0, limit)
+	find := options.Find().SetProjection(bson.M{"_id": 0}).
+		SetSort(bson.D{{Key: "radio", Value: 1}, {Key: "mcc", Value: 1}, {Key: "mnc", Value: 1},
+			{Key: "lac", Value: 1}, {Key: "cell", Value: 1}}).
+		SetSkip(int64(page) * int64(limit)).SetLimit(int64(limit))
+	query := Filter{MobileCountryCode: &mcc, MobileNetworkCode: &mnc}.query()
+	if err := findAll(ctx, mdb.Collection(db.collection(mcc)), query, &cells, find); err != nil {
+		return nil, err
+	}
+	return cells, nil
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/geotrace/lbs/v2"
+)
+
+// batcher объединяет запросы на вычисление координат, поступившие в течение короткого окна, в
+// один вызов lbs.DB.GetBatch. При массовом подключении устройств (например, всего автопарка
+// сразу после начала смены) это заметно снижает количество запросов к MongoDB ценой небольшой
+// задержки ответа.
+type batcher struct {
+	db       *lbs.DB
+	window   time.Duration // время ожидания других запросов после первого
+	size     int           // максимальное количество запросов в пакете
+	requests chan *pendingRequest
+}
+
+// pendingRequest описывает запрос, ожидающий выполнения в составе пакета.
+type pendingRequest struct {
+	req  lbs.Request
+	done chan batchResult
+}
+
+// batchResult описывает результат выполнения запроса из пакета.
+type batchResult struct {
+	details *lbs.Details
+	err     error
+}
+
+// newBatcher возвращает новый объединитель запросов и запускает его.
+func newBatcher(db *lbs.DB, window time.Duration, size int) *batcher {
+	b := &batcher{
+		db:       db,
+		window:   window,
+		size:     size,
+		requests: make(chan *pendingRequest, size),
+	}
+	go b.loop()
+	return b
+}
+
+// loop собирает запросы в пакеты: пакет закрывается по истечении окна после первого запроса или
+// при достижении максимального размера и выполняется отдельно, пока собирается следующий.
+func (b *batcher) loop() {
+	for first := range b.requests {
+		batch := []*pendingRequest{first}
+		timer := time.NewTimer(b.window)
+	collect:
+		for len(batch) < b.size {
+			select {
+			case pending := <-b.requests:
+				batch = append(batch, pending)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+		go b.run(batch)
+	}
+}
+
+// run выполняет пакет запросов и передает результаты ожидающим их обработчикам. Пакет общий для
+// нескольких клиентов, поэтому отключение одного из них не прерывает запрос к MongoDB: его время
+// ограничивается только параметром lbs.QueryTimeout.
+func (b *batcher) run(batch []*pendingRequest) {
+	reqs := make([]lbs.Request, len(batch))
+	for i, pending := range batch {
+		reqs[i] = pending.req
+	}
+	results, errs := b.db.GetBatch(context.Background(), reqs)
+	for i, pending := range batch {
+		pending.done <- batchResult{details: results[i], err: errs[i]}
+	}
+}
+
+// get вычисляет координаты по запросу в составе ближайшего пакета.
+func (b *batcher) get(ctx context.Context, req lbs.Request) (*lbs.Details, error) {
+	pending := &pendingRequest{req: req, done: make(chan batchResult, 1)}
+	select {
+	case b.requests <- pending:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case result := <-pending.done:
+		return result.details, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
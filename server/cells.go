@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/geotrace/lbs/v2"
+)
+
+// cellsHandler обрабатывает запросы на поиск записей о сотовых вышках.
+type cellsHandler struct {
+	db *lbs.DB
+}
+
+func (h *cellsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, limit, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cells, err := h.db.Search(r.Context(), filter, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, cells)
+}
+
+// parseFilter возвращает фильтр поиска записей о вышках, заданный параметрами запроса.
+func parseFilter(r *http.Request) (filter lbs.Filter, limit int, err error) {
+	query := r.URL.Query()
+	filter.RadioType = query.Get("radio")
+	parse := func(name string, bits int) (uint64, bool, error) {
+		value := query.Get(name)
+		if value == "" {
+			return 0, false, nil
+		}
+		n, err := strconv.ParseUint(value, 10, bits)
+		if err != nil {
+			return 0, false, fmt.Errorf("bad %s: %q", name, value)
+		}
+		return n, true, nil
+	}
+	var (
+		n  uint64
+		ok bool
+	)
+	if n, ok, err = parse("mcc", 16); err != nil {
+		return
+	} else if ok {
+		mcc := uint16(n)
+		filter.MobileCountryCode = &mcc
+	}
+	if n, ok, err = parse("mnc", 16); err != nil {
+		return
+	} else if ok {
+		mnc := uint16(n)
+		filter.MobileNetworkCode = &mnc
+	}
+	if n, ok, err = parse("lac", lbs.AreaCodeBits); err != nil {
+		return
+	} else if ok {
+		lac := uint32(n)
+		filter.LocationAreaCode = &lac
+	}
+	if n, ok, err = parse("cell", lbs.CellIdBits); err != nil {
+		return
+	} else if ok {
+		cell := n
+		filter.CellId = &cell
+	}
+	if n, ok, err = parse("limit", 16); err != nil {
+		return
+	} else if ok {
+		limit = int(n)
+	}
+	return filter, limit, nil
+}
+
+// writeJSON отправляет ответ в формате JSON.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/geotrace/lbs/v2"
+)
+
+// countryHandler обрабатывает запросы на определение страны в формате Mozilla Location Service
+// (POST /v1/country): тело запроса такое же, как у /v1/geolocate, а ответ содержит только код и
+// название страны (см. lbs.DB.Region). Запрос должен быть предварительно разобран и проверен с
+// помощью validateRequest.
+type countryHandler struct {
+	db *lbs.DB
+}
+
+// countryResponse описывает ответ на запрос /v1/country.
+type countryResponse struct {
+	Code string `json:"country_code"` // код страны ISO 3166-1 alpha-2
+	Name string `json:"country_name"` // название страны на английском языке
+}
+
+func (h *countryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "methodNotAllowed", "method not allowed", nil)
+		return
+	}
+	country, err := h.db.Region(r.Context(), requestFrom(r.Context()))
+	switch {
+	case err == nil:
+		writeJSON(w, countryResponse{Code: country.Code, Name: country.Name})
+	case err == lbs.ErrEmptyRequest, errors.Is(err, lbs.ErrNotFound):
+		writeError(w, http.StatusNotFound, "notFound", "Not found", nil)
+	case err == lbs.ErrUnavailable:
+		writeError(w, http.StatusServiceUnavailable, "backendError", err.Error(), nil)
+	default:
+		writeError(w, http.StatusInternalServerError, "backendError", err.Error(), nil)
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/geotrace/lbs/v2"
+)
+
+// geolocateHandler обрабатывает запросы на вычисление координат в формате Mozilla Location
+// Service (POST /v1/geolocate): возвращает только координаты и точность, поэтому существующие
+// клиенты MLS могут обращаться к серверу без изменений. Запрос должен быть предварительно
+// разобран и проверен с помощью validateRequest.
+type geolocateHandler struct {
+	locate *locateHandler
+}
+
+func (h *geolocateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "methodNotAllowed", "method not allowed", nil)
+		return
+	}
+	details, err := h.locate.locate(r)
+	switch {
+	case err == nil:
+		writeJSON(w, details.Response)
+	case err == lbs.ErrEmptyRequest, errors.Is(err, lbs.ErrNotFound):
+		writeError(w, http.StatusNotFound, "notFound", "Not found", nil)
+	case err == lbs.ErrUnavailable:
+		writeError(w, http.StatusServiceUnavailable, "backendError", err.Error(), nil)
+	default:
+		writeError(w, http.StatusInternalServerError, "backendError", err.Error(), nil)
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/geotrace/lbs/v2"
+	"github.com/geotrace/locator"
+)
+
+const geolocateCSV = `radio,mcc,net,area,cell,unit,lon,lat,range,samples,changeable,created,updated,averageSignal
+GSM,250,2,7743,22517,,37.6,55.7,1000,10,1,1500000000,1600000000,0
+`
+
+func TestGeolocate(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "cells.csv")
+	if err := os.WriteFile(name, []byte(geolocateCSV), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := lbs.OpenCSV(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	handler := validateRequest(&geolocateHandler{locate: &locateHandler{db: db}})
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, "/v1/geolocate", strings.NewReader(body)))
+		return w
+	}
+
+	w := serve("POST", `{"radioType": "gsm", "cellTowers": [{"mobileCountryCode": 250, "mobileNetworkCode": 2,
+		"locationAreaCode": 7743, "cellId": 22517}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bad status: %d %s", w.Code, w.Body)
+	}
+	var resp locator.Response
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Location.Lat < 55.69 || resp.Location.Lat > 55.71 || resp.Location.Lng < 37.59 ||
+		resp.Location.Lng > 37.61 || resp.Accuracy <= 0 {
+		t.Errorf("bad response: %+v", resp)
+	}
+
+	for _, body := range []string{
+		`{"radioType": "gsm", "cellTowers": [{"mobileCountryCode": 250, "mobileNetworkCode": 2,
+			"locationAreaCode": 7743, "cellId": 99999}]}`,
+		`{}`,
+	} {
+		w = serve("POST", body)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("bad status for unknown cell: %d", w.Code)
+			continue
+		}
+		if resp := decodeError(t, w); len(resp.Error.Errors) != 1 || resp.Error.Errors[0].Reason != "notFound" {
+			t.Errorf("bad not found error: %+v", resp)
+		}
+	}
+
+	// с подсистемой lacf для неизвестной вышки известной зоны возвращается центр зоны
+	locate := &locateHandler{db: db, areaFallback: true}
+	handler = validateRequest(&geolocateHandler{locate: locate})
+	unknown := `{"radioType": "gsm", "cellTowers": [{"mobileCountryCode": 250, "mobileNetworkCode": 2,
+		"locationAreaCode": 7743, "cellId": 22518}]%s}`
+	if w = serve("POST", fmt.Sprintf(unknown, "")); w.Code != http.StatusOK {
+		t.Errorf("area fallback: bad status %d %s", w.Code, w.Body)
+	}
+	if w = serve("POST", fmt.Sprintf(unknown, `, "fallbacks": {"lacf": false}`)); w.Code != http.StatusNotFound {
+		t.Errorf("area fallback disabled by client: bad status %d", w.Code)
+	}
+
+	for _, method := range []string{"GET", "PUT", "DELETE"} {
+		w = serve(method, "")
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST" {
+			t.Errorf("%s: bad status %d", method, w.Code)
+			continue
+		}
+		if resp := decodeError(t, w); resp.Error.Errors[0].Reason != "methodNotAllowed" {
+			t.Errorf("%s: bad error: %+v", method, resp)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/lbs/v2"
+)
+
+// maxSubmitSize ограничивает размер тела запроса geosubmit: в одном запросе устройство может
+// отправить много накопленных измерений.
+const maxSubmitSize = 8 << 20
+
+// geosubmitHandler принимает измерения устройств в формате Mozilla Location Service
+// (POST /v2/geosubmit) и уточняет по ним хранилище (см. lbs.DB.Submit).
+type geosubmitHandler struct {
+	db *lbs.DB
+}
+
+// submitItem описывает координаты устройства в одном измерении запроса geosubmit. Видимые вышки и
+// точки доступа того же измерения разбираются отдельно в lbs.Request.
+type submitItem struct {
+	Timestamp int64 `json:"timestamp"` // время измерения, мс с начала эпохи Unix
+	Position  *struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+		Accuracy  float64 `json:"accuracy"`
+	} `json:"position"`
+}
+
+func (h *geosubmitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "methodNotAllowed", "method not allowed", nil)
+		return
+	}
+	submissions, err := parseSubmissions(io.LimitReader(r.Body, maxSubmitSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "parseError", err.Error(), nil)
+		return
+	}
+	switch _, err := h.db.Submit(r.Context(), submissions); {
+	case err == nil:
+		writeJSON(w, struct{}{})
+	case err == lbs.ErrBadSubmission:
+		writeError(w, http.StatusBadRequest, "parseError", err.Error(), nil)
+	case err == lbs.ErrUnavailable:
+		writeError(w, http.StatusServiceUnavailable, "backendError", err.Error(), nil)
+	default:
+		writeError(w, http.StatusInternalServerError, "backendError", err.Error(), nil)
+	}
+}
+
+// parseSubmissions разбирает тело запроса geosubmit. Измерения без координат пропускаются.
+func parseSubmissions(r io.Reader) ([]lbs.Submission, error) {
+	var body struct {
+		Items []json.RawMessage `json:"items"`
+	}
+	if err := json.NewDecoder(r).Decode(&body); err != nil {
+		return nil, err
+	}
+	if len(body.Items) == 0 {
+		return nil, errors.New("no items")
+	}
+	submissions := make([]lbs.Submission, 0, len(body.Items))
+	for _, data := range body.Items {
+		var item submitItem
+		if err := json.Unmarshal(data, &item); err != nil {
+			return nil, err
+		}
+		if item.Position == nil {
+			continue
+		}
+		submission := lbs.Submission{
+			Location: geo.NewPoint(item.Position.Longitude, item.Position.Latitude),
+			Accuracy: item.Position.Accuracy,
+		}
+		if err := json.Unmarshal(data, &submission.Request); err != nil {
+			return nil, err
+		}
+		if item.Timestamp > 0 {
+			submission.Measured = time.Unix(0, item.Timestamp*int64(time.Millisecond))
+		}
+		submissions = append(submissions, submission)
+	}
+	return submissions, nil
+}
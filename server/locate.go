@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/geotrace/lbs/v2"
+)
+
+// maxRequestSize ограничивает размер тела запроса на вычисление координат.
+const maxRequestSize = 1 << 20
+
+// locateHandler обрабатывает отладочные запросы на вычисление координат. На запрос POST с
+// описанием видимых вышек в формате JSON возвращает подробный результат вычисления, а на запрос
+// GET — страницу, отображающую этот результат на карте. Запрос POST должен быть предварительно
+// разобран и проверен с помощью validateRequest.
+type locateHandler struct {
+	db           *lbs.DB
+	batch        *batcher // объединение запросов в пакеты (nil - без объединения)
+	areaFallback bool     // возвращать центр зоны для ненайденных вышек (см. lbs.GetOptions.AreaFallback)
+}
+
+// locate вычисляет координаты по запросу напрямую или в составе пакета.
+func (h *locateHandler) locate(r *http.Request) (*lbs.Details, error) {
+	req := requestFrom(r.Context())
+	if h.areaFallback {
+		// клиент по-прежнему может отключить поиск по зоне флагом "lacf": false
+		var opts lbs.GetOptions
+		if req.Options != nil {
+			opts = *req.Options
+		}
+		opts.AreaFallback = true
+		req.Options = &opts
+	}
+	if h.batch != nil {
+		return h.batch.get(r.Context(), req)
+	}
+	return h.db.GetDetailed(r.Context(), req)
+}
+
+func (h *locateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		page, err := uiFiles.ReadFile("ui/locate.html")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+	case "POST":
+		details, err := h.locate(r)
+		var notFound *lbs.NotFoundError
+		switch {
+		case err == nil:
+			writeJSON(w, details)
+		case err == lbs.ErrEmptyRequest:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.As(err, &notFound):
+			// ненайденные вышки помогают понять, чего не хватает в хранилище
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(notFound)
+		case errors.Is(err, lbs.ErrNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err == lbs.ErrUnavailable:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,71 @@
+// Package server реализует HTTP API для вычисления координат по базе LBS, совместимое с Mozilla
+// Location Service: запросы /v1/geolocate, /v1/country и /v2/geosubmit, учет запросов по ключам
+// API, объединение запросов в пакеты, поиск записей о вышках, пути /admin и веб-интерфейс с картой.
+// Обработчики создаются функцией New, а программа lbs-serve связывает их с параметрами командной
+// строки, метриками и подключением к MongoDB:
+//
+//	db, err := lbs.Dial(ctx, "mongodb://localhost/geotrace")
+//	if err != nil {
+//		return err
+//	}
+//	defer db.Close()
+//	http.ListenAndServe(":8080", server.New(db, server.Options{Cells: true}))
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/geotrace/lbs/v2"
+)
+
+// Options задает параметры и подсистемы сервера. Нулевое значение принимает любой ключ API без
+// ограничений, не объединяет запросы и включает только вычисление координат и /readyz.
+type Options struct {
+	Keys         map[string]int // допустимые ключи API и их дневные ограничения (см. LoadKeys; nil - любой ключ)
+	DailyLimit   int            // ограничение для ключей без собственного ограничения (0 - без ограничений)
+	BatchWindow  time.Duration  // окно объединения запросов в пакет (0 - не объединять)
+	BatchSize    int            // максимальное количество запросов в пакете
+	AreaFallback bool           // центр зоны, если ни одна вышка не найдена (см. lbs.GetOptions.AreaFallback)
+	Admin        bool           // пути /admin/usage и /admin/import
+	Cells        bool           // поиск записей о вышках /api/cells
+	Geosubmit    bool           // измерения устройств /v2/geosubmit
+	UI           bool           // веб-интерфейс с картой по адресу /
+}
+
+// New возвращает обработчик запросов к db с путями, перечисленными в описании программы
+// lbs-serve. Пути /debug/vars и /metrics программа добавляет сама, поэтому возвращается
+// http.ServeMux: к нему можно подключить и другие обработчики.
+func New(db *lbs.DB, opts Options) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !db.Healthy() {
+			http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	keys := &apiKeys{limits: opts.Keys, defaultLimit: opts.DailyLimit}
+	locate := &locateHandler{db: db, areaFallback: opts.AreaFallback}
+	if opts.BatchWindow > 0 && opts.BatchSize > 1 {
+		locate.batch = newBatcher(db, opts.BatchWindow, opts.BatchSize)
+	}
+	mux.Handle("/v1/geolocate", accountUsage(db, keys, validateRequest(&geolocateHandler{locate: locate})))
+	mux.Handle("/v1/country", accountUsage(db, keys, validateRequest(&countryHandler{db: db})))
+	mux.Handle("/debug/locate", accountUsage(db, keys, validateRequest(locate)))
+	if opts.Admin {
+		mux.Handle("/admin/usage", &usageHandler{db: db})
+		mux.Handle("/admin/import", &importHandler{db: db})
+	}
+	if opts.Cells {
+		mux.Handle("/api/cells", &cellsHandler{db: db})
+	}
+	if opts.Geosubmit {
+		mux.Handle("/v2/geosubmit", accountUsage(db, keys, &geosubmitHandler{db: db}))
+	}
+	if opts.UI {
+		mux.Handle("/", uiHandler())
+	}
+	return mux
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// uiFiles содержит файлы веб-интерфейса.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// uiHandler возвращает обработчик, отдающий файлы веб-интерфейса.
+func uiHandler() http.Handler {
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err) // каталог встроен в программу и всегда существует
+	}
+	return http.FileServer(http.FS(files))
+}
@@ -0,0 +1,96 @@
+// Веб-интерфейс для просмотра записей о сотовых вышках на карте.
+(function () {
+  'use strict';
+
+  var map = L.map('map').setView([55.75, 37.62], 10);
+  L.tileLayer('https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png', {
+    maxZoom: 19,
+    attribution: '&copy; <a href="https://www.openstreetmap.org/copyright">OpenStreetMap</a>'
+  }).addTo(map);
+  var layer = L.featureGroup().addTo(map);
+
+  var form = document.getElementById('search');
+  var status = document.getElementById('status');
+  var tbody = document.querySelector('#cells tbody');
+
+  // details возвращает HTML с описанием записи о вышке.
+  function details(cell) {
+    var rows = [
+      ['Радио', cell.radio],
+      ['MCC', cell.mcc],
+      ['MNC', cell.mnc],
+      ['LAC', cell.lac],
+      ['CID', cell.cell],
+      ['Широта', cell.location[1].toFixed(6)],
+      ['Долгота', cell.location[0].toFixed(6)],
+      ['Радиус, м', Math.round(cell.range)]
+    ];
+    if (cell.samples) {
+      rows.push(['Измерений', cell.samples]);
+    }
+    if (cell.blocked) {
+      rows.push(['Заблокирована', 'да']);
+    }
+    return '<table>' + rows.map(function (row) {
+      return '<tr><th>' + row[0] + '</th><td>' + row[1] + '</td></tr>';
+    }).join('') + '</table>';
+  }
+
+  // show отображает найденные вышки на карте и в таблице.
+  function show(cells) {
+    layer.clearLayers();
+    tbody.innerHTML = '';
+    cells.forEach(function (cell) {
+      var latlng = [cell.location[1], cell.location[0]];
+      var circle = L.circle(latlng, { radius: cell.range, weight: 1, fillOpacity: 0.1 });
+      var marker = L.circleMarker(latlng, { radius: 4 }).bindPopup(details(cell));
+      layer.addLayer(circle).addLayer(marker);
+
+      var tr = document.createElement('tr');
+      [cell.radio, cell.mcc, cell.mnc, cell.lac, cell.cell].forEach(function (value) {
+        var td = document.createElement('td');
+        td.textContent = value;
+        tr.appendChild(td);
+      });
+      tr.addEventListener('click', function () {
+        var selected = tbody.querySelector('.selected');
+        if (selected) {
+          selected.classList.remove('selected');
+        }
+        tr.classList.add('selected');
+        map.setView(latlng, Math.max(map.getZoom(), 14));
+        marker.openPopup();
+      });
+      tbody.appendChild(tr);
+    });
+    if (cells.length > 0) {
+      map.fitBounds(layer.getBounds(), { maxZoom: 15 });
+    }
+  }
+
+  form.addEventListener('submit', function (event) {
+    event.preventDefault();
+    var params = new URLSearchParams();
+    new FormData(form).forEach(function (value, name) {
+      if (value !== '') {
+        params.append(name, value);
+      }
+    });
+    status.textContent = 'Поиск...';
+    fetch('api/cells?' + params.toString())
+      .then(function (response) {
+        if (!response.ok) {
+          return response.text().then(function (text) { throw new Error(text); });
+        }
+        return response.json();
+      })
+      .then(function (cells) {
+        cells = cells || [];
+        status.textContent = 'Найдено: ' + cells.length;
+        show(cells);
+      })
+      .catch(function (err) {
+        status.textContent = 'Ошибка: ' + err.message;
+      });
+  });
+})();
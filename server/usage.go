@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/geotrace/lbs/v2"
+)
+
+// apiKeys описывает допустимые ключи API и их дневные ограничения на количество запросов.
+type apiKeys struct {
+	limits       map[string]int // ограничения по ключам (nil - допустим любой ключ)
+	defaultLimit int            // ограничение для ключей без собственного ограничения (0 - без ограничений)
+}
+
+// LoadKeys загружает список ключей API для Options.Keys из файла. Каждая строка файла содержит
+// ключ и, необязательно, дневное ограничение количества запросов через пробел; пустые строки и
+// строки, начинающиеся с #, пропускаются. Для ключа без ограничения возвращается -1: к нему
+// применяется Options.DailyLimit.
+func LoadKeys(filename string) (map[string]int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	limits := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	var line int
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch len(fields) {
+		case 1:
+			limits[fields[0]] = -1 // используется ограничение по умолчанию
+		case 2:
+			limit, err := strconv.Atoi(fields[1])
+			if err != nil || limit < 0 {
+				return nil, fmt.Errorf("%s:%d: bad limit %q", filename, line, fields[1])
+			}
+			limits[fields[0]] = limit
+		default:
+			return nil, fmt.Errorf("%s:%d: bad line", filename, line)
+		}
+	}
+	return limits, scanner.Err()
+}
+
+// limit возвращает дневное ограничение для ключа и false, если ключ не допустим.
+func (k *apiKeys) limit(key string) (int, bool) {
+	if k.limits == nil {
+		return k.defaultLimit, true
+	}
+	limit, ok := k.limits[key]
+	if limit < 0 {
+		limit = k.defaultLimit
+	}
+	return limit, ok
+}
+
+// usageCounter считает запросы по ключам API за текущий день (см. lbs.DB.CountUsage).
+type usageCounter interface {
+	CountUsage(ctx context.Context, apiKey string) (int, error)
+}
+
+// accountUsage возвращает обработчик, который учитывает запросы POST по ключам API (параметр
+// key в URL) и отклоняет запросы с недопустимым ключом или сверх дневного ограничения. Ошибка
+// учета не мешает выполнению запроса.
+func accountUsage(usage usageCounter, keys *apiKeys, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := r.URL.Query().Get("key")
+		limit, ok := keys.limit(key)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "keyInvalid", "missing or invalid API key", nil)
+			return
+		}
+		count, err := usage.CountUsage(r.Context(), key)
+		if err != nil {
+			log.Printf("Error counting usage for key %q: %v", key, err)
+		} else if limit > 0 && count > limit {
+			writeError(w, http.StatusForbidden, "dailyLimitExceeded", "daily request limit exceeded", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// usageHandler возвращает статистику использования по ключам API:
+//
+//	GET /admin/usage?key=...&days=7
+type usageHandler struct {
+	db *lbs.DB
+}
+
+func (h *usageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	days := 1
+	if value := query.Get("days"); value != "" {
+		var err error
+		if days, err = strconv.Atoi(value); err != nil || days < 1 {
+			http.Error(w, fmt.Sprintf("bad days: %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+	stats, err := h.db.UsageStats(r.Context(), query.Get("key"), days)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if stats == nil {
+		stats = []lbs.Usage{}
+	}
+	writeJSON(w, stats)
+}
+
+// importHandler возвращает сведения о последнем успешном импорте данных, по которым сервер
+// вычисляет координаты.
+//
+//	GET /admin/import
+type importHandler struct {
+	db *lbs.DB
+}
+
+func (h *importHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	info, err := h.db.ImportInfo(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if info == nil {
+		http.Error(w, "no import metadata", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, info)
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadKeys(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "keys")
+	data := "# ключи API\n\nalpha\nbeta 1000\n  gamma   0  \n#delta 5\n"
+	if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	limits, err := LoadKeys(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"alpha": -1, "beta": 1000, "gamma": 0}; !reflect.DeepEqual(limits, want) {
+		t.Errorf("bad limits: %v", limits)
+	}
+
+	for _, data := range []string{"alpha -5\n", "alpha many\n", "alpha 10 20\n", "beta 1\nalpha 1.5\n"} {
+		if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadKeys(name); err == nil {
+			t.Errorf("%q: expected error", data)
+		}
+	}
+	if _, err := LoadKeys(filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing file: expected error")
+	}
+}
+
+func TestKeysLimit(t *testing.T) {
+	keys := &apiKeys{defaultLimit: 100}
+	if limit, ok := keys.limit("any"); !ok || limit != 100 {
+		t.Errorf("any key: %d, %t", limit, ok)
+	}
+	keys.limits = map[string]int{"alpha": -1, "beta": 1000, "gamma": 0}
+	for _, test := range []struct {
+		key   string
+		limit int
+		ok    bool
+	}{
+		{"alpha", 100, true}, // ограничение по умолчанию
+		{"beta", 1000, true},
+		{"gamma", 0, true}, // без ограничений
+		{"delta", 0, false},
+		{"", 0, false},
+	} {
+		if limit, ok := keys.limit(test.key); limit != test.limit || ok != test.ok {
+			t.Errorf("limit(%q) = %d, %t", test.key, limit, ok)
+		}
+	}
+}
+
+// testUsage считает запросы в памяти вместо MongoDB.
+type testUsage struct {
+	counts map[string]int
+	err    error
+}
+
+func (u *testUsage) CountUsage(ctx context.Context, apiKey string) (int, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+	u.counts[apiKey]++
+	return u.counts[apiKey], nil
+}
+
+func TestAccountUsage(t *testing.T) {
+	usage := &testUsage{counts: make(map[string]int)}
+	keys := &apiKeys{limits: map[string]int{"alpha": -1, "beta": 0}, defaultLimit: 2}
+	handler := accountUsage(usage, keys, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(method, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, "/v1/geolocate?key="+key, nil))
+		return w
+	}
+	reason := func(w *httptest.ResponseRecorder) string {
+		if resp := decodeError(t, w); len(resp.Error.Errors) == 1 {
+			return resp.Error.Errors[0].Reason
+		}
+		return ""
+	}
+
+	for i, code := range []int{http.StatusOK, http.StatusOK, http.StatusForbidden, http.StatusForbidden} {
+		w := serve("POST", "alpha")
+		if w.Code != code {
+			t.Fatalf("request %d: bad status %d", i+1, w.Code)
+		}
+		if code == http.StatusForbidden && reason(w) != "dailyLimitExceeded" {
+			t.Errorf("request %d: bad error", i+1)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if w := serve("POST", "beta"); w.Code != http.StatusOK {
+			t.Fatalf("unlimited key rejected: %d", w.Code)
+		}
+	}
+	for _, key := range []string{"delta", ""} {
+		if w := serve("POST", key); w.Code != http.StatusBadRequest || reason(w) != "keyInvalid" {
+			t.Errorf("key %q: bad status %d", key, w.Code)
+		}
+	}
+	if usage.counts["delta"] != 0 || usage.counts["alpha"] != 4 {
+		t.Errorf("bad usage counts: %v", usage.counts)
+	}
+	// запросы GET не учитываются
+	if w := serve("GET", "delta"); w.Code != http.StatusOK || usage.counts["delta"] != 0 {
+		t.Errorf("GET request accounted: %d", w.Code)
+	}
+	// ошибка учета не мешает выполнению запроса
+	usage.err = errors.New("database unavailable")
+	if w := serve("POST", "alpha"); w.Code != http.StatusOK {
+		t.Errorf("request rejected on usage error: %d", w.Code)
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/geotrace/lbs/v2"
+)
+
+// requestContextKey используется для передачи разобранного запроса обработчику через контекст.
+type requestContextKey struct{}
+
+// validateRequest возвращает обработчик, который разбирает тело запроса POST с описанием видимых
+// вышек, проверяет его с помощью lbs.Request.Validate и передает обработчику next только
+// корректные запросы. На некорректные запросы возвращается ответ 400 со списком ошибочных полей.
+// Разобранный запрос доступен обработчику через requestFrom.
+func validateRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var req lbs.Request
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "parseError", err.Error(), nil)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			fields, _ := err.(lbs.ValidationError)
+			writeError(w, http.StatusBadRequest, "invalid", "invalid request", fields)
+			return
+		}
+		req.IP = clientIP(r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestContextKey{}, req)))
+	})
+}
+
+// clientIP возвращает IP-адрес клиента для lbs.IPFallback: первый адрес из заголовка
+// X-Forwarded-For, если сервер работает за прокси, или адрес соединения.
+func clientIP(r *http.Request) net.IP {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// requestFrom возвращает запрос, разобранный validateRequest.
+func requestFrom(ctx context.Context) lbs.Request {
+	req, _ := ctx.Value(requestContextKey{}).(lbs.Request)
+	return req
+}
+
+// apiError описывает ответ с ошибкой в формате Google Geolocation API.
+type apiError struct {
+	Error struct {
+		Errors  []apiErrorItem `json:"errors"`
+		Code    int            `json:"code"`
+		Message string         `json:"message"`
+	} `json:"error"`
+}
+
+// apiErrorItem описывает одну ошибку в ответе. Для ошибок в значениях полей запроса Location
+// содержит путь к ошибочному полю.
+type apiErrorItem struct {
+	Domain   string `json:"domain"`
+	Reason   string `json:"reason"`
+	Message  string `json:"message"`
+	Location string `json:"location,omitempty"`
+}
+
+// writeError отправляет ответ с ошибкой. Если указаны ошибочные поля, то для каждого из них в
+// ответ добавляется отдельная ошибка.
+func writeError(w http.ResponseWriter, code int, reason, message string, fields lbs.ValidationError) {
+	var resp apiError
+	resp.Error.Code = code
+	resp.Error.Message = message
+	if len(fields) == 0 {
+		resp.Error.Errors = []apiErrorItem{{Domain: "geolocation", Reason: reason, Message: message}}
+	}
+	for _, field := range fields {
+		resp.Error.Errors = append(resp.Error.Errors, apiErrorItem{
+			Domain:   "geolocation",
+			Reason:   reason,
+			Message:  field.Message,
+			Location: field.Field,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
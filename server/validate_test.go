@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/geotrace/lbs/v2"
+)
+
+// decodeError разбирает ответ с ошибкой.
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) apiError {
+	t.Helper()
+	var resp apiError
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("bad error response: %v", err)
+	}
+	if resp.Error.Code != w.Code {
+		t.Errorf("error code %d in response %d", resp.Error.Code, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("bad content type: %s", ct)
+	}
+	return resp
+}
+
+func TestValidateRequest(t *testing.T) {
+	var (
+		called bool
+		got    lbs.Request
+	)
+	handler := validateRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called, got = true, requestFrom(r.Context())
+	}))
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		called, got = false, lbs.Request{}
+		r := httptest.NewRequest(method, "/v1/geolocate", strings.NewReader(body))
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("POST", `{"radioType": "gsm", "cellTowers": [{"mobileCountryCode": 250, "mobileNetworkCode": 2,
+		"locationAreaCode": 7743, "cellId": 22517}]}`)
+	if !called || w.Code != http.StatusOK {
+		t.Fatalf("valid request rejected: %d %s", w.Code, w.Body)
+	}
+	if len(got.CellTowers) != 1 || got.CellTowers[0].CellId != 22517 || !got.IP.Equal(net.ParseIP("203.0.113.7")) {
+		t.Errorf("bad parsed request: %+v", got)
+	}
+
+	w = serve("POST", `{"cellTowers": [`)
+	if called || w.Code != http.StatusBadRequest {
+		t.Fatalf("bad JSON accepted: %d", w.Code)
+	}
+	if resp := decodeError(t, w); len(resp.Error.Errors) != 1 || resp.Error.Errors[0].Reason != "parseError" ||
+		resp.Error.Errors[0].Location != "" {
+		t.Errorf("bad parse error: %+v", resp)
+	}
+
+	w = serve("POST", `{"radioType": "5g", "cellTowers": [{"mobileCountryCode": 250, "mobileNetworkCode": 2,
+		"locationAreaCode": 7743, "cellId": 22517}, {"mobileCountryCode": 2500, "mobileNetworkCode": 2,
+		"locationAreaCode": 7743, "cellId": 22518}]}`)
+	if called || w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid request accepted: %d", w.Code)
+	}
+	resp := decodeError(t, w)
+	locations := make(map[string]bool)
+	for _, item := range resp.Error.Errors {
+		if item.Reason != "invalid" || item.Domain != "geolocation" || item.Message == "" {
+			t.Errorf("bad error item: %+v", item)
+		}
+		locations[item.Location] = true
+	}
+	if len(locations) != 2 || !locations["radioType"] || !locations["cellTowers[1].mobileCountryCode"] {
+		t.Errorf("bad error locations: %+v", resp.Error.Errors)
+	}
+
+	// запросы с другими методами проверяет сам обработчик
+	if w = serve("GET", ""); !called || w.Code != http.StatusOK {
+		t.Errorf("GET request not passed: %d", w.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	for _, test := range []struct {
+		remote, forwarded string
+		ip                string
+	}{
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		{"[2001:db8::1]:443", "", "2001:db8::1"},
+		{"192.0.2.1", "", "192.0.2.1"},
+		{"192.0.2.1:1234", "203.0.113.7", "203.0.113.7"},
+		{"192.0.2.1:1234", " 203.0.113.7 , 10.0.0.1, 10.0.0.2", "203.0.113.7"},
+		{"192.0.2.1:1234", "2001:db8::2, 10.0.0.1", "2001:db8::2"},
+		{"192.0.2.1:1234", "unknown, 10.0.0.1", "192.0.2.1"},
+	} {
+		r := httptest.NewRequest("POST", "/v1/geolocate", nil)
+		r.RemoteAddr = test.remote
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if ip := clientIP(r); !ip.Equal(net.ParseIP(test.ip)) {
+			t.Errorf("clientIP(%q, %q) = %v", test.remote, test.forwarded, ip)
+		}
+	}
+	r := httptest.NewRequest("POST", "/v1/geolocate", nil)
+	if r.RemoteAddr = "bad"; clientIP(r) != nil {
+		t.Error("bad remote address parsed")
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeError(w, http.StatusServiceUnavailable, "backendError", "database unavailable", nil)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("bad status: %d", w.Code)
+	}
+	resp := decodeError(t, w)
+	if resp.Error.Message != "database unavailable" || len(resp.Error.Errors) != 1 ||
+		resp.Error.Errors[0] != (apiErrorItem{Domain: "geolocation", Reason: "backendError", Message: "database unavailable"}) {
+		t.Errorf("bad error response: %+v", resp)
+	}
+
+	w = httptest.NewRecorder()
+	writeError(w, http.StatusBadRequest, "invalid", "invalid request", lbs.ValidationError{
+		{Field: "cellTowers[0].cellId", Message: "out of range: 4294967295"},
+		{Field: "wifiAccessPoints[1].macAddress", Message: "bad MAC address"},
+	})
+	resp = decodeError(t, w)
+	if resp.Error.Message != "invalid request" || len(resp.Error.Errors) != 2 ||
+		resp.Error.Errors[0].Location != "cellTowers[0].cellId" ||
+		resp.Error.Errors[0].Message != "out of range: 4294967295" ||
+		resp.Error.Errors[1].Location != "wifiAccessPoints[1].macAddress" {
+		t.Errorf("bad field errors: %+v", resp)
+	}
+}
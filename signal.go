@@ -0,0 +1,70 @@
+package lbs
+
+// Устройства сообщают уровень сигнала вышки в разных единицах: одни — в дБм, другие — в ASU
+// (Arbitrary Strength Unit), как его возвращает Android. Кроме того, для разных типов радио
+// измеряется разная величина: для GSM и CDMA — полная мощность принятого сигнала (RSSI), для UMTS —
+// мощность пилотного канала (RSCP), а для LTE и NR — мощность одного опорного ресурсного элемента
+// (RSRP, в NR — SS-RSRP). RSCP и RSRP заметно меньше RSSI того же сигнала, поэтому перед сравнением
+// уровней сигнала разных вышек и оценкой расстояния по модели затухания они приводятся к единой
+// шкале RSSI в дБм.
+
+// Допустимые значения уровня сигнала в ASU.
+const (
+	maxASU     = 31 // GSM, UMTS, CDMA: dBm = 2*ASU - 113
+	maxLTEASU  = 97 // LTE, NR: dBm = ASU - 140
+	unknownASU = 99 // уровень сигнала неизвестен
+)
+
+// Типичная разница между RSSI и величиной, которую измеряет устройство, дБ.
+const (
+	umtsRSCPOffset = 10 // на пилотный канал приходится около 10% мощности соты
+	lteRSRPOffset  = 27 // 10*log10(12*50): 50 ресурсных блоков по 12 поднесущих (10 МГц)
+)
+
+// SignalDBm возвращает уровень сигнала вышки с указанным типом радио в дБм. Отрицательные
+// значения считаются заданными в дБм и возвращаются без изменений, а положительные — в ASU. Если
+// уровень сигнала не указан, неизвестен или не соответствует шкале ASU для этого типа радио, то
+// возвращается false.
+func SignalDBm(radio string, signal int) (int, bool) {
+	switch {
+	case signal < 0:
+		return signal, true
+	case signal == 0 || signal == unknownASU:
+		return 0, false
+	}
+	switch radio {
+	case "gsm", "umts", "wcdma", "cdma":
+		if signal <= maxASU {
+			return 2*signal - 113, true
+		}
+	case "lte", "nr":
+		if signal <= maxLTEASU {
+			return signal - 140, true
+		}
+	}
+	return 0, false
+}
+
+// SignalRSSI возвращает уровень сигнала вышки, приведенный к RSSI в дБм (см. SignalDBm и
+// RSSIOffset), или false, если уровень сигнала неизвестен.
+func SignalRSSI(radio string, signal int) (float64, bool) {
+	dbm, ok := SignalDBm(radio, signal)
+	if !ok {
+		return 0, false
+	}
+	return float64(dbm) + RSSIOffset(radio), true
+}
+
+// RSSIOffset возвращает типичную разницу в дБ между RSSI и уровнем сигнала, который сообщают
+// устройства для указанного типа радио: для UMTS это RSCP, а для LTE и NR — RSRP. Для остальных типов
+// радио устройства сообщают RSSI, и разница равна нулю.
+func RSSIOffset(radio string) float64 {
+	switch radio {
+	case "umts", "wcdma":
+		return umtsRSCPOffset
+	case "lte", "nr":
+		// полосы NR шире, но с большим шагом поднесущих, поэтому разница близка к LTE
+		return lteRSRPOffset
+	}
+	return 0
+}
@@ -0,0 +1,52 @@
+package lbs
+
+import (
+	"math"
+	"testing"
+
+	"github.com/geotrace/locator"
+)
+
+func TestSignalDBm(t *testing.T) {
+	for _, test := range []struct {
+		radio  string
+		signal int
+		dbm    int
+		ok     bool
+	}{
+		{"gsm", -85, -85, true},
+		{"gsm", 0, 0, false},
+		{"gsm", 14, -85, true},
+		{"gsm", 31, -51, true},
+		{"gsm", 32, 0, false},
+		{"gsm", 99, 0, false},
+		{"umts", 10, -93, true},
+		{"lte", 40, -100, true},
+		{"lte", 97, -43, true},
+		{"lte", 98, 0, false},
+		{"tetra", 10, 0, false},
+	} {
+		dbm, ok := SignalDBm(test.radio, test.signal)
+		if dbm != test.dbm || ok != test.ok {
+			t.Errorf("SignalDBm(%q, %d) = %d, %v; want %d, %v", test.radio, test.signal,
+				dbm, ok, test.dbm, test.ok)
+		}
+	}
+}
+
+func TestSignalRSSI(t *testing.T) {
+	gsm, _ := SignalRSSI("gsm", -80)
+	lte, _ := SignalRSSI("lte", 33) // RSRP -107 дБм
+	if gsm != -80 || lte != -80 {
+		t.Errorf("bad RSSI: gsm %.0f, lte %.0f", gsm, lte)
+	}
+	if _, ok := SignalRSSI("lte", 0); ok {
+		t.Error("unknown signal normalized")
+	}
+	// одинаковый сигнал в ASU и дБм дает одинаковое расстояние
+	asu := towerDistance("lte", &locator.CellTower{SignalStrength: 40})
+	dbm := towerDistance("lte", &locator.CellTower{SignalStrength: -100})
+	if math.Abs(asu-dbm) > 0.01 {
+		t.Errorf("ASU distance %.0f differs from dBm distance %.0f", asu, dbm)
+	}
+}
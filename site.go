@@ -0,0 +1,93 @@
+package lbs
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultSiteRadius задает расстояние в метрах, на котором записи о вышках одной зоны считаются
+// одной площадкой (см. GroupSites).
+const DefaultSiteRadius = 50.0
+
+// siteAccuracyShare задает долю радиуса действия вышки, на которую могут отстоять друг от друга
+// записи одной площадки: у вышек с маленьким радиусом действия соседние площадки стоят ближе.
+const siteAccuracyShare = 0.1
+
+// colocated возвращает true, если записи a и b описывают одну площадку: расстояние между ними
+// не превышает radius и десятой доли меньшего из известных радиусов действия.
+func colocated(a, b Cell, radius float64) bool {
+	for _, accuracy := range []float64{a.Accuracy, b.Accuracy} {
+		if accuracy > 0 {
+			radius = math.Min(radius, accuracy*siteAccuracyShare)
+		}
+	}
+	return Distance(a.Location, b.Location) <= radius
+}
+
+// GroupSites объединяет записи о вышках одной зоны (тип радио, код страны и оператора, LAC),
+// которые находятся на расстоянии не больше radius метров друг от друга и с учетом радиуса их
+// действия (см. DefaultSiteRadius): в выгрузках одна площадка часто встречается под несколькими
+// идентификаторами, например, по одному на сектор. Основной записью площадки становится запись с
+// наибольшим количеством подтверждений, а в поле Site всех записей площадки, кроме основной,
+// записывается ее идентификатор; у отдельно стоящих вышек поле Site очищается. Заблокированные
+// записи не объединяются. Возвращает количество записей, у которых изменилось поле Site.
+//
+// Get и GetDetailed учитывают найденные вышки одной площадки как одну точку, чтобы площадка с
+// несколькими секторами не перетягивала координаты на себя.
+func GroupSites(cells []Cell, radius float64) (changed int) {
+	order := make([]int, len(cells))
+	for i := range order {
+		order[i] = i
+	}
+	// основными становятся записи с наибольшим количеством подтверждений, затем с меньшим CID
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := cells[order[i]], cells[order[j]]
+		if a.Samples != b.Samples {
+			return a.Samples > b.Samples
+		}
+		return a.CellId < b.CellId
+	})
+	sites := make([]uint64, len(cells))
+	grouped := make([]bool, len(cells))
+	for n, i := range order {
+		if grouped[i] || cells[i].Blocked {
+			continue
+		}
+		grouped[i] = true
+		for _, j := range order[n+1:] {
+			if grouped[j] || cells[j].Blocked || cells[j].Key.Area() != cells[i].Key.Area() ||
+				!colocated(cells[i], cells[j], radius) {
+				continue
+			}
+			grouped[j] = true
+			sites[j] = cells[i].CellId
+		}
+	}
+	for i := range cells {
+		if cells[i].Site != sites[i] {
+			cells[i].Site = sites[i]
+			changed++
+		}
+	}
+	return changed
+}
+
+// siteWeights делит вес каждой вышки на количество найденных вышек той же площадки, чтобы
+// площадка в целом имела тот же вес, что и отдельная вышка. Сумма весов остается равной единице.
+func siteWeights(points []weightedPoint, cells []Cell) {
+	count := make(map[Key]int, len(cells))
+	for _, cell := range cells {
+		count[cell.SiteKey()]++
+	}
+	if len(count) == len(cells) {
+		return // все вышки с разных площадок
+	}
+	var total float64
+	for i, cell := range cells {
+		points[i].weight /= float64(count[cell.SiteKey()])
+		total += points[i].weight
+	}
+	for i := range points {
+		points[i].weight /= total
+	}
+}
@@ -0,0 +1,50 @@
+package lbs
+
+import (
+	"math"
+	"testing"
+
+	"github.com/geotrace/geo"
+)
+
+func TestGroupSites(t *testing.T) {
+	cell := func(id uint64, lon float64, samples int) Cell {
+		return Cell{Key: Key{RadioType: "gsm", MobileCountryCode: 250, LocationAreaCode: 1, CellId: id},
+			Data: Data{Location: geo.NewPoint(lon, 55.75), Accuracy: 3000}, Samples: samples}
+	}
+	cells := []Cell{
+		cell(1, 37.6000, 10),
+		cell(2, 37.6003, 50), // около 19 м от первой
+		cell(3, 37.6500, 10),
+		cell(4, 37.6001, 5),
+	}
+	cells[3].LocationAreaCode = 2 // другая зона
+	if changed := GroupSites(cells, DefaultSiteRadius); changed != 1 {
+		t.Errorf("bad changed count: %d", changed)
+	}
+	if cells[0].Site != 2 || cells[1].Site != 0 || cells[2].Site != 0 || cells[3].Site != 0 {
+		t.Errorf("bad sites: %+v", cells)
+	}
+	if changed := GroupSites(cells, DefaultSiteRadius); changed != 0 {
+		t.Errorf("repeated grouping changed %d cells", changed)
+	}
+	// у вышек с маленьким радиусом действия соседние площадки стоят ближе
+	cells[0].Accuracy, cells[1].Accuracy = 100, 100
+	if GroupSites(cells, DefaultSiteRadius); cells[0].Site != 0 {
+		t.Errorf("small cells grouped: %+v", cells[0])
+	}
+}
+
+func TestSiteWeights(t *testing.T) {
+	cells := []Cell{
+		{Key: Key{CellId: 1}},
+		{Key: Key{CellId: 2}, Site: 1},
+		{Key: Key{CellId: 3}},
+	}
+	points := []weightedPoint{{weight: 1.0 / 3}, {weight: 1.0 / 3}, {weight: 1.0 / 3}}
+	siteWeights(points, cells)
+	if math.Abs(points[0].weight-0.25) > 1e-9 || math.Abs(points[1].weight-0.25) > 1e-9 ||
+		math.Abs(points[2].weight-0.5) > 1e-9 {
+		t.Errorf("bad site weights: %+v", points)
+	}
+}
@@ -0,0 +1,268 @@
+// Package sqlitestore реализует хранилище записей о вышках lbs.Storage в базе SQLite. Небольшим
+// установкам оно позволяет обойтись без сервера MongoDB: данные загружаются в файл базы один раз,
+// а DB вычисляет координаты по нему так же, как по MongoDB.
+//
+// Пакет использует только database/sql, поэтому драйвер SQLite подключает приложение: например,
+// modernc.org/sqlite (драйвер "sqlite", без cgo) или github.com/mattn/go-sqlite3 (драйвер
+// "sqlite3").
+//
+//	import _ "modernc.org/sqlite"
+//
+//	store, err := sqlitestore.Open("sqlite", "cells.db")
+//	if err != nil {
+//		return err
+//	}
+//	if _, err := store.ImportCSV(ctx, file); err != nil {
+//		return err
+//	}
+//	db := lbs.OpenStorage(store, lbs.RadioFallback("lte", "umts", "gsm"))
+//	defer db.Close()
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/lbs/v2"
+)
+
+// schema создает таблицу с записями о вышках. Первичный ключ совпадает с ключом lbs.Key, поэтому
+// запись находится по индексу, а вышки одной зоны упорядочены по идентификатору (см.
+// lbs.FuzzyAreaLookup).
+const schema = `CREATE TABLE IF NOT EXISTS cells (
+	radio   TEXT    NOT NULL,
+	mcc     INTEGER NOT NULL,
+	mnc     INTEGER NOT NULL,
+	lac     INTEGER NOT NULL,
+	cell    INTEGER NOT NULL,
+	lon     REAL    NOT NULL,
+	lat     REAL    NOT NULL,
+	range   REAL    NOT NULL DEFAULT 0,
+	samples INTEGER NOT NULL DEFAULT 0,
+	blocked INTEGER NOT NULL DEFAULT 0,
+	created INTEGER,
+	updated INTEGER,
+	PRIMARY KEY (radio, mcc, mnc, lac, cell)
+) WITHOUT ROWID`
+
+// columns перечисляет колонки записи о вышке в порядке, в котором их читает scanCell.
+const columns = "radio, mcc, mnc, lac, cell, lon, lat, range, samples, created, updated"
+
+// ImportBatch задает количество записей, которые ImportCSV сохраняет в одной транзакции.
+var ImportBatch = 10000
+
+// Store описывает хранилище записей о вышках в базе SQLite.
+type Store struct {
+	db    *sql.DB
+	owned bool // база открыта в Open и закрывается вместе с хранилищем
+}
+
+var _ lbs.Storage = (*Store)(nil)
+
+// Open открывает базу SQLite с указанным драйвером и источником данных и создает в ней таблицу с
+// записями о вышках, если ее еще нет. База принадлежит хранилищу и закрывается вызовом Close.
+func Open(driver, dsn string) (*Store, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	store, err := New(context.Background(), db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	store.owned = true
+	return store, nil
+}
+
+// New возвращает хранилище в уже открытой базе SQLite и создает в ней таблицу с записями о
+// вышках, если ее еще нет. База остается во владении вызывающей стороны: Close ее не закрывает.
+func New(ctx context.Context, db *sql.DB) (*Store, error) {
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Import сохраняет записи о вышках в одной транзакции, заменяя записи о тех же вышках.
+func (s *Store) Import(ctx context.Context, cells []lbs.Cell) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	insert, err := tx.PrepareContext(ctx, "INSERT OR REPLACE INTO cells ("+columns+", blocked) "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, cell := range cells {
+		_, err := insert.ExecContext(ctx, cell.RadioType, cell.MobileCountryCode, cell.MobileNetworkCode,
+			cell.LocationAreaCode, int64(cell.CellId), cell.Location.Longitude(), cell.Location.Latitude(),
+			cell.Accuracy, cell.Samples, unixTime(cell.Created), unixTime(cell.Updated), cell.Blocked)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ImportCSV сохраняет записи о вышках из CSV в формате OpenCellID (см. lbs.CellHeader)
+// транзакциями по ImportBatch записей и возвращает количество сохраненных записей. Строка
+// заголовка необязательна, а записи с зарезервированными значениями идентификаторов (см.
+// lbs.Key.Valid) пропускаются. При ошибке уже сохраненные транзакции остаются в базе.
+func (s *Store) ImportCSV(ctx context.Context, r io.Reader) (count int, err error) {
+	in := csv.NewReader(r)
+	in.FieldsPerRecord = -1
+	batch := make([]lbs.Cell, 0, ImportBatch)
+	for line := 1; ; line++ {
+		record, err := in.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		if line == 1 && len(record) > 0 && record[0] == lbs.CellHeader[0] {
+			continue
+		}
+		var cell lbs.Cell
+		if err := cell.UnmarshalCSV(record); err != nil {
+			return count, err
+		}
+		if !cell.Key.Valid() {
+			continue
+		}
+		if batch = append(batch, cell); len(batch) >= ImportBatch {
+			if err := s.Import(ctx, batch); err != nil {
+				return count, err
+			}
+			count, batch = count+len(batch), batch[:0]
+		}
+	}
+	if err := s.Import(ctx, batch); err != nil {
+		return count, err
+	}
+	return count + len(batch), nil
+}
+
+// Cells возвращает незаблокированные записи о вышках с указанными ключами.
+func (s *Store) Cells(ctx context.Context, keys []lbs.Key) ([]lbs.Cell, error) {
+	find, err := s.db.PrepareContext(ctx, "SELECT "+columns+" FROM cells "+
+		"WHERE radio = ? AND mcc = ? AND mnc = ? AND lac = ? AND cell = ? AND blocked = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer find.Close()
+	cells := make([]lbs.Cell, 0, len(keys))
+	for _, key := range keys {
+		cell, err := scanCell(find.QueryRowContext(ctx, key.RadioType, key.MobileCountryCode,
+			key.MobileNetworkCode, key.LocationAreaCode, int64(key.CellId)))
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		cells = append(cells, cell)
+	}
+	return cells, nil
+}
+
+// Neighbors возвращает не более n незаблокированных вышек зоны ключа key с идентификаторами,
+// ближайшими к key.CellId. Вышки с большими и меньшими идентификаторами ищутся по первичному
+// ключу отдельными запросами.
+func (s *Store) Neighbors(ctx context.Context, key lbs.Key, n int) ([]lbs.Cell, error) {
+	area := "SELECT " + columns + " FROM cells " +
+		"WHERE radio = ? AND mcc = ? AND mnc = ? AND lac = ? AND blocked = 0 "
+	above, err := s.query(ctx, area+"AND cell > ? ORDER BY cell LIMIT ?", key, n)
+	if err != nil {
+		return nil, err
+	}
+	below, err := s.query(ctx, area+"AND cell < ? ORDER BY cell DESC LIMIT ?", key, n)
+	if err != nil {
+		return nil, err
+	}
+	// объединяем вышки по удалению идентификаторов от key.CellId
+	cells := make([]lbs.Cell, 0, n)
+	for len(cells) < n && (len(above) > 0 || len(below) > 0) {
+		if len(below) == 0 || len(above) > 0 && above[0].CellId-key.CellId <= key.CellId-below[0].CellId {
+			cells, above = append(cells, above[0]), above[1:]
+		} else {
+			cells, below = append(cells, below[0]), below[1:]
+		}
+	}
+	return cells, nil
+}
+
+// query выполняет запрос вышек зоны ключа key с ограничением количества n.
+func (s *Store) query(ctx context.Context, query string, key lbs.Key, n int) ([]lbs.Cell, error) {
+	rows, err := s.db.QueryContext(ctx, query, key.RadioType, key.MobileCountryCode,
+		key.MobileNetworkCode, key.LocationAreaCode, int64(key.CellId), n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cells []lbs.Cell
+	for rows.Next() {
+		cell, err := scanCell(rows)
+		if err != nil {
+			return nil, err
+		}
+		cells = append(cells, cell)
+	}
+	return cells, rows.Err()
+}
+
+// Count возвращает количество записей о вышках, включая заблокированные.
+func (s *Store) Count(ctx context.Context) (count int, err error) {
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM cells").Scan(&count)
+	return count, err
+}
+
+// Close закрывает базу, если она была открыта в Open.
+func (s *Store) Close() error {
+	if !s.owned {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// scanCell читает запись о вышке с колонками columns.
+func scanCell(row interface{ Scan(...interface{}) error }) (lbs.Cell, error) {
+	var (
+		cell             lbs.Cell
+		lon, lat         float64
+		id               int64
+		created, updated sql.NullInt64
+	)
+	err := row.Scan(&cell.RadioType, &cell.MobileCountryCode, &cell.MobileNetworkCode,
+		&cell.LocationAreaCode, &id, &lon, &lat, &cell.Accuracy, &cell.Samples, &created, &updated)
+	if err != nil {
+		return cell, err
+	}
+	cell.RadioType = strings.ToLower(cell.RadioType)
+	cell.CellId = uint64(id)
+	cell.Location = geo.NewPoint(lon, lat)
+	if created.Valid {
+		cell.Created = time.Unix(created.Int64, 0).UTC()
+	}
+	if updated.Valid {
+		cell.Updated = time.Unix(updated.Int64, 0).UTC()
+	}
+	return cell, nil
+}
+
+// unixTime возвращает время в секундах Unix или NULL для нулевого времени.
+func unixTime(t time.Time) sql.NullInt64 {
+	if t.IsZero() {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.Unix(), Valid: true}
+}
@@ -0,0 +1,130 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/lbs/v2"
+	"github.com/geotrace/locator"
+	_ "modernc.org/sqlite"
+)
+
+const cellsCSV = `radio,mcc,net,area,cell,unit,lon,lat,range,samples,changeable,created,updated,averageSignal
+GSM,250,2,7743,22517,,37.6,55.7,1000,10,1,1500000000,1600000000,0
+GSM,250,2,7743,22518,,37.7,55.8,1000,5,1,,,0
+GSM,250,2,7743,22530,,37.9,55.9,1000,5,1,,,0
+LTE,250,2,7743,26216458,,37.5,55.6,500,3,1,,,0
+GSM,250,2,7743,22518,,37.61,55.71,800,6,1,,,0
+GSM,250,2,0,1,,10,10,1000,1,1,,,0
+`
+
+// openMemory возвращает хранилище в базе SQLite в памяти. База в памяти существует в пределах
+// одного соединения, поэтому пул ограничен одним соединением.
+func openMemory(t *testing.T) *Store {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	store, err := New(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	store := openMemory(t)
+	count, err := store.ImportCSV(ctx, strings.NewReader(cellsCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Errorf("bad imported count: %d", count)
+	}
+	if count, err = store.Count(ctx); err != nil || count != 4 {
+		t.Errorf("bad records count: %d, %v", count, err)
+	}
+
+	key := func(radio string, cell uint64) lbs.Key {
+		return lbs.Key{RadioType: radio, MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: cell}
+	}
+	keys := []lbs.Key{key("gsm", 22518), key("lte", 26216458), key("gsm", 22519), key("umts", 22517), key("gsm", 22517)}
+	cells, err := store.Cells(ctx, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cells) != 3 || cells[0].Key != keys[0] || cells[1].Key != keys[1] || cells[2].Key != keys[4] {
+		t.Fatalf("bad cells: %+v", cells)
+	}
+	if cells[0].Accuracy != 800 || cells[0].Samples != 6 || !cells[0].Created.IsZero() {
+		t.Errorf("duplicate record not replaced: %+v", cells[0])
+	}
+	if d := lbs.Distance(cells[0].Location, geo.NewPoint(37.61, 55.71)); d > 1 {
+		t.Errorf("location stored with error %.2f m", d)
+	}
+	if !cells[2].Created.Equal(time.Unix(1500000000, 0)) || !cells[2].Updated.Equal(time.Unix(1600000000, 0)) {
+		t.Errorf("bad measurement time: %v, %v", cells[2].Created, cells[2].Updated)
+	}
+	if missing, err := store.Cells(ctx, keys[2:4]); err != nil || len(missing) != 0 {
+		t.Errorf("unknown cells found: %+v, %v", missing, err)
+	}
+
+	neighbors, err := store.Neighbors(ctx, key("gsm", 22519), 2)
+	if err != nil || len(neighbors) != 2 || neighbors[0].CellId != 22518 || neighbors[1].CellId != 22517 {
+		t.Errorf("bad neighbors: %+v, %v", neighbors, err)
+	}
+	if neighbors, _ = store.Neighbors(ctx, key("gsm", 22525), 10); len(neighbors) != 3 ||
+		neighbors[0].CellId != 22530 || neighbors[1].CellId != 22518 || neighbors[2].CellId != 22517 {
+		t.Errorf("bad neighbors order: %+v", neighbors)
+	}
+	if neighbors, _ = store.Neighbors(ctx, key("umts", 22517), 3); len(neighbors) != 0 {
+		t.Errorf("neighbors found for unknown radio type: %+v", neighbors)
+	}
+
+	// заблокированные вышки не находятся
+	blocked := cells[0]
+	blocked.Blocked = true
+	if err := store.Import(ctx, []lbs.Cell{blocked}); err != nil {
+		t.Fatal(err)
+	}
+	if cells, _ = store.Cells(ctx, keys[:1]); len(cells) != 0 {
+		t.Error("blocked cell found")
+	}
+	if neighbors, _ = store.Neighbors(ctx, key("gsm", 22519), 3); len(neighbors) != 2 || neighbors[0].CellId != 22517 {
+		t.Errorf("blocked cell in neighbors: %+v", neighbors)
+	}
+	if count, _ = store.Count(ctx); count != 4 {
+		t.Errorf("blocked cell not counted: %d", count)
+	}
+}
+
+func TestStorageGet(t *testing.T) {
+	ctx := context.Background()
+	store := openMemory(t)
+	if _, err := store.ImportCSV(ctx, strings.NewReader(cellsCSV)); err != nil {
+		t.Fatal(err)
+	}
+	db := lbs.OpenStorage(store)
+	defer db.Close()
+	tower := &locator.CellTower{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517}
+	resp, err := db.Get(ctx, locator.Request{RadioType: "gsm", CellTowers: []*locator.CellTower{tower}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := lbs.Distance(geo.NewPoint(resp.Location.Lng, resp.Location.Lat), geo.NewPoint(37.6, 55.7)); d > 1 {
+		t.Errorf("bad location: %+v", resp)
+	}
+	tower.CellId = 99999
+	if _, err := db.Get(ctx, locator.Request{RadioType: "gsm", CellTowers: []*locator.CellTower{tower}}); !errors.Is(err, lbs.ErrNotFound) {
+		t.Errorf("bad error for unknown cell: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package lbs
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// StaleCells включает учет возраста записей о вышках: вышки, которые не подтверждались дольше age
+// (по времени последнего измерения, а если оно не задано — первого), получают при вычислении
+// координат вес, умноженный на weight, а при нулевом weight не используются вовсе. Чаще всего
+// такие записи описывают переставленные или демонтированные вышки. Устаревшие вышки
+// перечисляются в Details.Stale; записи без времени измерений устаревшими не считаются. Значение
+// age 0 (по умолчанию) отключает учет возраста. Удалить устаревшие записи из хранилища можно с
+// помощью PruneStale или lbs-import -prune.
+func StaleCells(age time.Duration, weight float64) Option {
+	return func(db *DB) {
+		db.staleAge = age
+		db.staleWeight = weight
+	}
+}
+
+// splitStale разделяет найденные вышки на используемые при вычислении координат и отбрасывает
+// устаревшие, если их вес равен нулю (см. StaleCells). Возвращаются ключи всех устаревших вышек.
+// Порядок используемых вышек сохраняется.
+func (db *DB) splitStale(cells []Cell) (used []Cell, stale []Key) {
+	if db.staleAge <= 0 {
+		return cells, nil
+	}
+	before := db.clock.Now().Add(-db.staleAge)
+	used = make([]Cell, 0, len(cells))
+	for _, cell := range cells {
+		if seen := cell.LastSeen(); !seen.IsZero() && seen.Before(before) {
+			stale = append(stale, cell.Key)
+			if db.staleWeight <= 0 {
+				continue
+			}
+		}
+		used = append(used, cell)
+	}
+	return used, stale
+}
+
+// staleWeights умножает веса устаревших вышек stale на вес StaleCells, сохраняя сумму весов
+// равной единице.
+func (db *DB) staleWeights(points []weightedPoint, cells []Cell, stale []Key) {
+	if len(stale) == 0 || db.staleWeight <= 0 || db.staleWeight == 1 {
+		return
+	}
+	isStale := make(map[Key]bool, len(stale))
+	for _, key := range stale {
+		isStale[key] = true
+	}
+	var total float64
+	for i, cell := range cells {
+		if isStale[cell.Key] {
+			points[i].weight *= db.staleWeight
+		}
+		total += points[i].weight
+	}
+	for i := range points {
+		points[i].weight /= total
+	}
+}
+
+// PruneStale удаляет из хранилища записи о вышках, которые не подтверждались с момента before: с
+// временем последнего измерения раньше before или, если оно не задано, с временем первого
+// измерения раньше before. Записи без времени измерений не удаляются. Возвращает количество
+// удаленных записей. Удаленные записи остаются в кеше WithCache до его устаревания.
+func (db *DB) PruneStale(ctx context.Context, before time.Time) (removed int, err error) {
+	mdb, err := db.database()
+	if err != nil {
+		return 0, err
+	}
+	filter := bson.M{"$or": bson.A{
+		bson.M{"updated": bson.M{"$lt": before}},
+		bson.M{"updated": bson.M{"$exists": false}, "created": bson.M{"$lt": before}},
+	}}
+	for _, name := range db.collections() {
+		result, err := mdb.Collection(name).DeleteMany(ctx, filter)
+		if err != nil {
+			return removed, err
+		}
+		removed += int(result.DeletedCount)
+	}
+	return removed, nil
+}
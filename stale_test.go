@@ -0,0 +1,61 @@
+package lbs
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/geotrace/geo"
+)
+
+func TestStaleCells(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cell := func(id uint64, lon float64, updated time.Time) Cell {
+		return Cell{Key: Key{RadioType: "gsm", MobileCountryCode: 250, LocationAreaCode: 1, CellId: id},
+			Data: Data{Location: geo.NewPoint(lon, 55.75), Accuracy: 1000}, Updated: updated}
+	}
+	cells := []Cell{
+		cell(1, 37.60, now.AddDate(0, -1, 0)),
+		cell(2, 37.70, now.AddDate(-5, 0, 0)), // давно не подтверждалась
+		cell(3, 37.62, time.Time{}),           // время измерений неизвестно
+	}
+	keys := []Key{cells[0].Key, cells[1].Key, cells[2].Key}
+	clock := &testClock{now: now}
+
+	db := newDB("test", []Option{WithClock(clock), StaleCells(3*365*24*time.Hour, 0)})
+	details, err := db.locate(keys, nil, append([]Cell(nil), cells...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(details.Stale) != 1 || details.Stale[0] != cells[1].Key || len(details.Cells) != 2 {
+		t.Errorf("stale cell used: %+v", details)
+	}
+	if len(details.Missing) != 0 || details.CellCounts != (Counts{3, 3, 2}) {
+		t.Errorf("bad counts: %+v, missing %v", details.CellCounts, details.Missing)
+	}
+
+	db = newDB("test", []Option{WithClock(clock), StaleCells(3*365*24*time.Hour, 0.1)})
+	details, err = db.locate(keys, nil, append([]Cell(nil), cells...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(details.Stale) != 1 || len(details.Cells) != 3 {
+		t.Fatalf("bad cells: %+v", details)
+	}
+	if w := details.Cells[1].Weight; w >= details.Cells[0].Weight/5 {
+		t.Errorf("stale cell weight not lowered: %.3f", w)
+	}
+	fix := geo.NewPoint(details.Response.Location.Lng, details.Response.Location.Lat)
+	for _, match := range details.Cells {
+		if d := Distance(match.Location, fix); math.Abs(d-match.Distance) > 1 {
+			t.Errorf("bad distance of cell %d: %.0f, want %.0f", match.CellId, match.Distance, d)
+		}
+	}
+	if lon := details.Response.Location.Lng; lon > 37.63 {
+		t.Errorf("stale cell moved location: %.4f", lon)
+	}
+
+	if details, _ := newDB("test", nil).locate(keys, nil, append([]Cell(nil), cells...), nil, nil); len(details.Stale) != 0 {
+		t.Errorf("stale cells without StaleCells: %v", details.Stale)
+	}
+}
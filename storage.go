@@ -0,0 +1,87 @@
+package lbs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/geotrace/lbs/v2/offline"
+	"github.com/geotrace/lbs/v2/storage"
+)
+
+// ErrNoDatabase возвращается методами, которым нужна база MongoDB, для DB без нее (см.
+// OpenStorage).
+var ErrNoDatabase = errors.New("lbs: no MongoDB database")
+
+// Storage описывает хранилище записей о вышках, которое DB использует вместо MongoDB (см.
+// OpenStorage и storage.Storage).
+type Storage = storage.Storage
+
+// OpenStorage возвращает DB, который вычисляет координаты (Get, GetDetailed, GetCells, GetBatch)
+// по записям о вышках из storage вместо MongoDB. Хранилище принадлежит DB и закрывается вызовом
+// Close.
+//
+// Остальные данные хранятся только в MongoDB, поэтому методы, которым она нужна (Submit, Block,
+// Export и т.п.), возвращают ошибку ErrNoDatabase, точки доступа Wi-Fi из запросов считаются
+// ненайденными, а параметры подключения к MongoDB, HealthCheck и WithResultStore не используются.
+func OpenStorage(storage Storage, options ...Option) *DB {
+	db := newDB("", options)
+	db.storage = storage
+	return db
+}
+
+// OpenCSV загружает записи о вышках из файла CSV в формате OpenCellID (см. CellHeader) в память и
+// возвращает DB, который вычисляет координаты (Get, GetDetailed, GetCells и т.д.) без MongoDB:
+// например, на периферийных узлах с данными одного региона, подготовленными командой
+// lbs-export. Это сокращение для OpenStorage с хранилищем offline.OpenCSV, где описан формат
+// хранения записей.
+//
+// Данные доступны только для чтения, а остальные ограничения DB без MongoDB описаны в
+// OpenStorage.
+func OpenCSV(path string, options ...Option) (*DB, error) {
+	table, err := offline.OpenCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	return OpenStorage(table, options...), nil
+}
+
+// OpenSnapshot открывает снимок, записанный SnapshotWriter (например, командой lbs-import
+// -format=snapshot), и возвращает DB, который вычисляет координаты без MongoDB так же, как DB из
+// OpenCSV. Это сокращение для OpenStorage с хранилищем offline.OpenSnapshot.
+//
+// Файл освобождается вызовом Close. Ограничения те же, что и у OpenCSV (см. OpenStorage).
+func OpenSnapshot(path string, options ...Option) (*DB, error) {
+	table, err := offline.OpenSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	return OpenStorage(table, options...), nil
+}
+
+// SnapshotWriter собирает записи о вышках и записывает их в снимок, который открывает
+// OpenSnapshot (см. offline.SnapshotWriter).
+type SnapshotWriter = offline.SnapshotWriter
+
+// NewSnapshotWriter возвращает пустой SnapshotWriter.
+func NewSnapshotWriter() *SnapshotWriter {
+	return offline.NewSnapshotWriter()
+}
+
+// ErrBadSnapshot возвращается при открытии или чтении поврежденного файла снимка.
+var ErrBadSnapshot = offline.ErrBadSnapshot
+
+// storageCells возвращает записи о вышках с указанными ключами из хранилища Storage.
+func (db *DB) storageCells(ctx context.Context, keys []Key) ([]Cell, error) {
+	select {
+	case <-db.done:
+		return nil, ErrClosed
+	default:
+	}
+	start := time.Now()
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	cells, err := db.storage.Cells(ctx, keys)
+	db.metrics.Mongo.Since(start)
+	return cells, err
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/geotrace/geo"
+)
+
+// Data описывает данные для вышки сотовой станции.
+type Data struct {
+	Location geo.Point `bson:"location" json:"location"` // координаты
+	Accuracy float64   `bson:"range" json:"range"`       // расстояние
+}
+
+// Cell описывает запись о сотовой вышке в хранилище. Это единственное описание записи, которое
+// используют библиотека, хранилища, lbs-import, lbs-admin и lbs-serve: названия полей BSON и JSON
+// заданы тегами, а колонки CSV в формате OpenCellID — CellHeader, MarshalCSV и UnmarshalCSV.
+type Cell struct {
+	Key      `bson:",inline"`
+	Data     `bson:",inline"`
+	Samples  int       `bson:"samples,omitempty" json:"samples,omitempty"`   // количество подтверждений
+	Variance float64   `bson:"variance,omitempty" json:"variance,omitempty"` // дисперсия координат подтверждений, м² (см. lbs.DB.Submit)
+	Blocked  bool      `bson:"blocked,omitempty" json:"blocked,omitempty"`   // вышка заблокирована (см. lbs.DB.Block)
+	Site     uint64    `bson:"site,omitempty" json:"site,omitempty"`         // основная вышка площадки (см. lbs.GroupSites)
+	Created  time.Time `bson:"created,omitempty" json:"created,omitempty"`   // время первого измерения
+	Updated  time.Time `bson:"updated,omitempty" json:"updated,omitempty"`   // время последнего измерения
+}
+
+// CellHeader содержит заголовок файла с вышками в формате OpenCellID и Mozilla Location Service.
+var CellHeader = []string{"radio", "mcc", "net", "area", "cell", "unit", "lon", "lat", "range",
+	"samples", "changeable", "created", "updated", "averageSignal"}
+
+// Номера колонок CellHeader.
+const (
+	csvRadio = iota
+	csvMCC
+	csvMNC
+	csvArea
+	csvCell
+	csvUnit
+	csvLon
+	csvLat
+	csvRange
+	csvSamples
+	csvChangeable
+	csvCreated
+	csvUpdated
+	csvSignal
+)
+
+// MarshalCSV возвращает запись о вышке в виде строки CSV с колонками CellHeader. Время создания и
+// изменения записывается в секундах Unix, а незаполненные поля остаются пустыми.
+func (c Cell) MarshalCSV() []string {
+	record := make([]string, len(CellHeader))
+	record[csvRadio] = strings.ToUpper(c.RadioType)
+	record[csvMCC] = strconv.FormatUint(uint64(c.MobileCountryCode), 10)
+	record[csvMNC] = strconv.FormatUint(uint64(c.MobileNetworkCode), 10)
+	record[csvArea] = strconv.FormatUint(uint64(c.LocationAreaCode), 10)
+	record[csvCell] = strconv.FormatUint(uint64(c.CellId), 10)
+	record[csvLon] = strconv.FormatFloat(c.Location.Longitude(), 'f', -1, 64)
+	record[csvLat] = strconv.FormatFloat(c.Location.Latitude(), 'f', -1, 64)
+	record[csvRange] = strconv.FormatFloat(c.Accuracy, 'f', -1, 64)
+	record[csvSamples] = strconv.Itoa(c.Samples)
+	record[csvChangeable] = "1"
+	if !c.Created.IsZero() {
+		record[csvCreated] = strconv.FormatInt(c.Created.Unix(), 10)
+	}
+	if !c.Updated.IsZero() {
+		record[csvUpdated] = strconv.FormatInt(c.Updated.Unix(), 10)
+	}
+	return record
+}
+
+// UnmarshalCSV заполняет запись о вышке по строке CSV с колонками CellHeader. Тип радио
+// приводится к нижнему регистру. Колонки created и updated необязательны; остальные колонки
+// после samples не используются. Поля Blocked и Site не изменяются: в этом формате их нет.
+//
+// Проверка зарезервированных значений идентификаторов (см. Key.Valid) остается за вызывающей
+// стороной.
+func (c *Cell) UnmarshalCSV(record []string) error {
+	if len(record) <= csvSamples {
+		return fmt.Errorf("lbs: bad fields count: %d", len(record))
+	}
+	var n [4]uint64
+	for i, bits := range []int{16, 16, AreaCodeBits, CellIdBits} {
+		var err error
+		if n[i], err = strconv.ParseUint(record[csvMCC+i], 10, bits); err != nil {
+			return fmt.Errorf("lbs: bad %s: %s", CellHeader[csvMCC+i], record[csvMCC+i])
+		}
+	}
+	var f [3]float64
+	for i, column := range []int{csvLon, csvLat, csvRange} {
+		var err error
+		if f[i], err = strconv.ParseFloat(record[column], 64); err != nil {
+			return fmt.Errorf("lbs: bad %s: %s", CellHeader[column], record[column])
+		}
+	}
+	samples, err := strconv.ParseInt(record[csvSamples], 10, 32)
+	if err != nil {
+		return fmt.Errorf("lbs: bad samples: %s", record[csvSamples])
+	}
+	var times [2]time.Time
+	for i, column := range []int{csvCreated, csvUpdated} {
+		if column >= len(record) || record[column] == "" {
+			continue
+		}
+		sec, err := strconv.ParseInt(record[column], 10, 64)
+		if err != nil {
+			return fmt.Errorf("lbs: bad %s: %s", CellHeader[column], record[column])
+		}
+		times[i] = time.Unix(sec, 0).UTC()
+	}
+	c.Key = Key{
+		RadioType:         NormalizeRadio(record[csvRadio]),
+		MobileCountryCode: uint16(n[0]),
+		MobileNetworkCode: uint16(n[1]),
+		LocationAreaCode:  uint32(n[2]),
+		CellId:            n[3],
+	}
+	c.Data = Data{
+		Location: geo.NewPoint(f[0], f[1]),
+		Accuracy: f[2],
+	}
+	c.Samples = int(samples)
+	c.Created, c.Updated = times[0], times[1]
+	return nil
+}
+
+// SiteKey возвращает ключ площадки, к которой относится вышка: ключ основной записи площадки или
+// собственный ключ вышки, если она не объединена с другими (см. lbs.GroupSites).
+func (c Cell) SiteKey() Key {
+	key := c.Key
+	if c.Site != 0 {
+		key.CellId = c.Site
+	}
+	return key
+}
+
+// LastSeen возвращает время последнего измерения вышки или, если оно не задано, время первого.
+func (c Cell) LastSeen() time.Time {
+	if c.Updated.IsZero() {
+		return c.Created
+	}
+	return c.Updated
+}
@@ -0,0 +1,96 @@
+package storage
+
+// Key описывает ключ для поиска информации по LBS.
+//
+// Код зоны и идентификатор вышки хранятся в типах, вмещающих идентификаторы всех поколений сетей:
+// TAC в сетях 5G NR занимает 24 бита, а NCI — 36 бит (в LTE — 16 и 28 бит, см. AreaCodeBits и
+// CellIdBits). В MongoDB они хранятся как обычные целые числа, поэтому записи, сохраненные с
+// прежними 16- и 32-битными значениями, читаются без изменений (см. lbs.DB.MigrateSchema).
+type Key struct {
+	RadioType         string `bson:"radio" json:"radio"` // The mobile radio type. Supported values are lte, nr, gsm, umts, cdma, and wcdma.
+	MobileCountryCode uint16 `bson:"mcc" json:"mcc"`     // country code  (250 - Россия, 255 - Украина, Беларусь - 257)
+	MobileNetworkCode uint16 `bson:"mnc" json:"mnc"`     // operator code
+	LocationAreaCode  uint32 `bson:"lac" json:"lac"`     // the base station cell number
+	CellId            uint64 `bson:"cell" json:"cell"`   // base station number
+}
+
+// Разрядность кода зоны и идентификатора вышки: столько занимают TAC и NCI в сетях 5G NR.
+const (
+	AreaCodeBits = 24
+	CellIdBits   = 36
+)
+
+// Зарезервированные значения идентификаторов, которые используются устройствами и источниками
+// данных вместо неизвестного значения.
+const (
+	reservedAreaDeleted = 0xFFFE     // LAC удаленной зоны (3GPP TS 24.008)
+	reservedArea        = 0xFFFF     // LAC не определен
+	unavailableCellId   = 0x7FFFFFFF // Integer.MAX_VALUE в Android: идентификатор недоступен
+	reservedCellId      = 0xFFFFFFFF // идентификатор не определен
+)
+
+// Valid возвращает false, если код зоны или идентификатор вышки содержат нулевое или
+// зарезервированное значение. Такие значения подставляются вместо неизвестных и совпадают с
+// "мусорными" записями в базе, поэтому не должны использоваться ни при импорте, ни при поиске.
+// Для вышек CDMA (см. CDMAKey) нулевой NID допустим, а NID и BID должны помещаться в 16 бит.
+func (k Key) Valid() bool {
+	if k.RadioType == "cdma" {
+		return k.LocationAreaCode < MaxCDMANetworkId && k.CellId != 0 && k.CellId <= MaxCDMABaseStation
+	}
+	switch k.LocationAreaCode {
+	case 0, reservedAreaDeleted, reservedArea:
+		return false
+	}
+	switch k.CellId {
+	case 0, unavailableCellId, reservedCellId:
+		return false
+	}
+	return true
+}
+
+// Идентификаторы сетей CDMA (IS-95, CDMA2000): вышка определяется не кодами оператора, зоны и
+// вышки, а номером системы (SID), сети (NID) и базовой станции (BID).
+const (
+	MaxCDMASystemId    = 0x7FFF // SID занимает 15 бит
+	MaxCDMANetworkId   = 0xFFFF // NID 65535 означает любую сеть системы и вышку не определяет
+	MaxCDMABaseStation = 0xFFFF // BID занимает 16 бит
+)
+
+// CDMAKey возвращает ключ вышки CDMA. Идентификаторы CDMA хранятся в полях Key так же, как их
+// передают Google Geolocation API и Mozilla Location Service и как они записаны в выгрузках
+// OpenCellID и MLS: SID — в MobileNetworkCode, NID — в LocationAreaCode, а BID — в CellId. Поэтому
+// вышки CDMA из запросов и импортированных файлов находятся без преобразований, а CDMAKey нужен
+// для программ, получающих идентификаторы от модема в виде SID/NID/BID.
+func CDMAKey(mcc, sid, nid, bid uint16) Key {
+	return Key{
+		RadioType:         "cdma",
+		MobileCountryCode: mcc,
+		MobileNetworkCode: sid,
+		LocationAreaCode:  uint32(nid),
+		CellId:            uint64(bid),
+	}
+}
+
+// CDMA возвращает идентификаторы вышки CDMA: номера системы, сети и базовой станции. Для вышек
+// других типов радио возвращается false.
+func (k Key) CDMA() (sid, nid, bid uint16, ok bool) {
+	if k.RadioType != "cdma" {
+		return 0, 0, 0, false
+	}
+	return k.MobileNetworkCode, uint16(k.LocationAreaCode), uint16(k.CellId), true
+}
+
+// MaxNetworkCode возвращает максимальный код оператора для типа радио: для CDMA в этом поле
+// передается номер системы (SID).
+func MaxNetworkCode(radio string) uint16 {
+	if radio == "cdma" {
+		return MaxCDMASystemId
+	}
+	return 999
+}
+
+// Area возвращает ключ зоны вышки: ключ с нулевым идентификатором вышки.
+func (k Key) Area() Key {
+	k.CellId = 0
+	return k
+}
@@ -0,0 +1,20 @@
+package storage
+
+import "strings"
+
+// RadioAliases задает другие названия типов радио: ключ — название в нижнем регистре, значение —
+// тип радио, под которым вышки хранятся в базе. OpenCellID и Mozilla Location Service называют
+// сети третьего поколения UMTS, а клиенты часто передают wcdma, поэтому без замены такие вышки не
+// находились бы. Список можно дополнить до начала работы с lbs.DB; названия заменяются и при
+// импорте (см. Cell.UnmarshalCSV), и при поиске.
+var RadioAliases = map[string]string{"wcdma": "umts"}
+
+// NormalizeRadio приводит тип радио к виду, в котором он хранится в базе: к нижнему регистру и к
+// основному названию вместо другого (см. RadioAliases).
+func NormalizeRadio(radio string) string {
+	radio = strings.ToLower(strings.TrimSpace(radio))
+	if name, ok := RadioAliases[radio]; ok {
+		return name
+	}
+	return radio
+}
@@ -0,0 +1,47 @@
+// Package storage описывает записи о сотовых вышках (Key, Data, Cell) и интерфейс хранилища
+// Storage, по которому lbs.DB вычисляет координаты без MongoDB. Пакет не зависит ни от MongoDB, ни
+// от алгоритмов вычисления координат, поэтому его используют и сама библиотека, и хранилища
+// (пакеты offline и sqlitestore), и программы, которые только читают или записывают выгрузки.
+// Пакет lbs объявляет те же типы под прежними названиями (lbs.Key, lbs.Data, lbs.Cell,
+// lbs.Storage), поэтому код, написанный для предыдущих версий, работает без изменений.
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrClosed возвращается методами закрытого хранилища. Это та же ошибка, что и lbs.ErrClosed.
+var ErrClosed = errors.New("lbs: database closed")
+
+// Storage описывает хранилище записей о вышках, которое lbs.DB использует вместо MongoDB (см.
+// lbs.OpenStorage). В библиотеку входят хранилища в памяти процесса и двоичный снимок (пакет
+// github.com/geotrace/lbs/v2/offline) и SQLite (пакет github.com/geotrace/lbs/v2/sqlitestore).
+// Методы вызываются одновременно из нескольких горутин.
+type Storage interface {
+	// Cells возвращает незаблокированные записи о вышках с указанными ключами. Ненайденные
+	// вышки пропускаются.
+	Cells(ctx context.Context, keys []Key) ([]Cell, error)
+	// Neighbors возвращает не более n незаблокированных вышек зоны ключа key с идентификаторами,
+	// ближайшими к key.CellId, кроме самой вышки key (см. lbs.FuzzyAreaLookup и NearestCells).
+	Neighbors(ctx context.Context, key Key, n int) ([]Cell, error)
+	// Count возвращает количество записей о вышках.
+	Count(ctx context.Context) (int, error)
+	// Close освобождает ресурсы хранилища. Вызывается из lbs.DB.Close.
+	Close() error
+}
+
+// NearestCells объединяет вышки с идентификаторами больше id (по возрастанию) и меньше id (по
+// убыванию) и возвращает не более n вышек с идентификаторами, ближайшими к id. Хранилища
+// используют ее в Neighbors, выбирая вышки зоны по обе стороны от id.
+func NearestCells(id uint64, above, below []Cell, n int) []Cell {
+	cells := make([]Cell, 0, n)
+	for len(cells) < n && (len(above) > 0 || len(below) > 0) {
+		if len(below) == 0 || len(above) > 0 && above[0].CellId-id <= id-below[0].CellId {
+			cells, above = append(cells, above[0]), above[1:]
+		} else {
+			cells, below = append(cells, below[0]), below[1:]
+		}
+	}
+	return cells
+}
@@ -0,0 +1,41 @@
+package storage
+
+import "testing"
+
+func TestNearestCells(t *testing.T) {
+	cells := func(ids ...uint64) []Cell {
+		list := make([]Cell, len(ids))
+		for i, id := range ids {
+			list[i].CellId = id
+		}
+		return list
+	}
+	for _, test := range []struct {
+		above, below []Cell
+		n            int
+		want         []uint64
+	}{
+		{cells(22518, 22530), cells(22516, 22510), 3, []uint64{22518, 22516, 22510}},
+		{cells(22520), cells(22515), 2, []uint64{22515, 22520}},
+		{cells(22520), cells(22515), 1, []uint64{22515}},
+		{nil, cells(22516, 22510), 3, []uint64{22516, 22510}},
+		{cells(22518, 22519), nil, 3, []uint64{22518, 22519}},
+		{nil, nil, 3, nil},
+	} {
+		got := NearestCells(22517, test.above, test.below, test.n)
+		ids := make([]uint64, 0, len(got))
+		for _, cell := range got {
+			ids = append(ids, cell.CellId)
+		}
+		if len(ids) != len(test.want) {
+			t.Errorf("NearestCells(%d) = %v; want %v", test.n, ids, test.want)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != test.want[i] {
+				t.Errorf("NearestCells(%d) = %v; want %v", test.n, ids, test.want)
+				break
+			}
+		}
+	}
+}
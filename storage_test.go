@@ -0,0 +1,121 @@
+package lbs
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/locator"
+)
+
+const storageCSV = `radio,mcc,net,area,cell,unit,lon,lat,range,samples,changeable,created,updated,averageSignal
+GSM,250,2,7743,22517,,37.6,55.7,1000,10,1,1500000000,1600000000,0
+GSM,250,2,7743,22518,,37.7,55.8,1000,5,1,,,0
+GSM,250,2,7743,22530,,37.9,55.9,1000,5,1,,,0
+LTE,250,2,7743,26216458,,37.5,55.6,500,3,1,,,0
+GSM,250,2,7743,22518,,37.61,55.71,800,6,1,,,0
+GSM,250,2,0,1,,10,10,1000,1,1,,,0
+GSM,250,2,7743,22519,,37.6,155.7,1000,1,1,,,0
+`
+
+func TestOpenCSV(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cells.csv.gz")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(file)
+	gz.Write([]byte(storageCSV))
+	gz.Close()
+	file.Close()
+
+	db, err := OpenCSV(filename, FuzzyAreaLookup(DefaultFuzzyCells))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if records := db.Records(ctx); records != 4 {
+		t.Errorf("bad records count: %d", records)
+	}
+	details, err := db.GetDetailed(ctx, Request{Request: locator.Request{
+		RadioType:        "gsm",
+		CellTowers:       []*locator.CellTower{{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517, SignalStrength: -78}},
+		WifiAccessPoints: []*locator.WifiAccessPoint{{MacAddress: "a4:b1:c2:d3:e4:f5"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(details.Cells) != 1 || len(details.MissingWifi) != 1 {
+		t.Errorf("bad details: %+v", details)
+	}
+	details, err = db.GetDetailed(ctx, Request{Request: locator.Request{
+		RadioType:  "gsm",
+		CellTowers: []*locator.CellTower{{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22519, SignalStrength: -78}},
+	}})
+	if err != nil || !details.Fuzzy {
+		t.Errorf("fuzzy lookup failed: %+v, %v", details, err)
+	}
+	if _, err := db.Submit(ctx, nil); !errors.Is(err, ErrNoDatabase) {
+		t.Errorf("bad error without MongoDB: %v", err)
+	}
+	if _, err := OpenCSV(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("missing file opened")
+	}
+	db.Close()
+	if _, err := db.GetCells(ctx, locator.Request{
+		RadioType:  "gsm",
+		CellTowers: []*locator.CellTower{{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517, SignalStrength: -78}},
+	}); err != ErrClosed {
+		t.Errorf("closed DB returned bad error: %v", err)
+	}
+}
+
+func TestOpenSnapshot(t *testing.T) {
+	w := NewSnapshotWriter()
+	for _, id := range []uint64{22517, 22518, 22530} {
+		cell := Cell{
+			Key:  Key{RadioType: "gsm", MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: id},
+			Data: Data{Location: geo.NewPoint(37.6, 55.7), Accuracy: 1000},
+		}
+		if err := w.Add(cell); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filename := filepath.Join(t.TempDir(), "cells.snapshot")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteTo(file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	db, err := OpenSnapshot(filename, FuzzyAreaLookup(DefaultFuzzyCells))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	if records := db.Records(ctx); records != 3 {
+		t.Errorf("bad records count: %d", records)
+	}
+	details, err := db.GetDetailed(ctx, Request{Request: locator.Request{
+		RadioType:  "gsm",
+		CellTowers: []*locator.CellTower{{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22519, SignalStrength: -78}},
+	}})
+	if err != nil || !details.Fuzzy {
+		t.Errorf("fuzzy lookup failed: %+v, %v", details, err)
+	}
+	if err := os.WriteFile(filename, []byte("LBSSNAP1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenSnapshot(filename); !errors.Is(err, ErrBadSnapshot) {
+		t.Errorf("bad error for truncated snapshot: %v", err)
+	}
+}
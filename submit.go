@@ -0,0 +1,126 @@
+package lbs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/geotrace/geo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Submission описывает измерение, отправленное устройством: вышки и точки доступа Wi-Fi, видимые
+// в точке с известными (например, полученными от GPS) координатами. Так устроены данные запроса
+// geosubmit Mozilla Location Service.
+type Submission struct {
+	Request            // видимые вышки и точки доступа Wi-Fi
+	Location geo.Point // координаты устройства
+	Accuracy float64   // точность координат, м
+	Measured time.Time // время измерения (по умолчанию — время получения)
+}
+
+// ErrBadSubmission возвращается Submit, если ни одно из измерений не содержит допустимых координат.
+var ErrBadSubmission = errors.New("lbs: bad submission")
+
+// Submit уточняет хранилище по измерениям устройств. Для каждой видимой вышки и точки доступа
+// координаты записи сдвигаются к точке измерения как скользящее среднее, в котором старые
+// координаты имеют вес количества подтверждений (Samples), дисперсия координат (Variance)
+// пересчитывается, а радиус действия увеличивается до расстояния от новых координат до точки
+// измерения. Записи обновляются атомарно, поэтому одновременные измерения одной вышки не теряются.
+// Неизвестные вышки и точки доступа добавляются с координатами точки измерения и неизвестным
+// радиусом действия; заблокированные вышки не изменяются. Кроме того, измерения вышек сохраняются в
+// ObservationsCollectionName, по которым lbs-admin estimate может заново вычислить их координаты.
+//
+// Измерения без координат или с точностью хуже 1 км пропускаются. Submit возвращает количество
+// обновленных и добавленных записей о вышках и точках доступа.
+func (db *DB) Submit(ctx context.Context, submissions []Submission) (updated int, err error) {
+	mdb, err := db.database()
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	accepted := 0
+	for _, s := range submissions {
+		if !validPoint(s.Location) || s.Accuracy < 0 || s.Accuracy > estimateMaxAccuracy {
+			continue
+		}
+		accepted++
+		if s.Measured.IsZero() {
+			s.Measured = db.clock.Now()
+		}
+		observations := db.observationsAt(s.Request, s.Location, s.Accuracy, s.Measured)
+		if len(observations) > 0 {
+			docs := make([]interface{}, len(observations))
+			for i := range observations {
+				docs[i] = observations[i]
+			}
+			if _, err := mdb.Collection(ObservationsCollectionName).InsertMany(ctx, docs); err != nil {
+				return updated, err
+			}
+		}
+		for _, obs := range observations {
+			ok, err := db.submitCell(ctx, mdb.Collection(db.collection(obs.MobileCountryCode)), obs)
+			if err != nil {
+				return updated, err
+			}
+			if ok {
+				updated++
+			}
+		}
+		if db.wifiIgnored {
+			continue
+		}
+		for _, mac := range requestWifi(s.Request, db.maxTowers) {
+			if err := db.submitWifi(ctx, mdb.Collection(WifiCollectionName), mac, s.Location, s.Measured); err != nil {
+				return updated, err
+			}
+			updated++
+		}
+	}
+	if accepted == 0 && len(submissions) > 0 {
+		return 0, ErrBadSubmission
+	}
+	return updated, nil
+}
+
+// submitCell добавляет к записи о вышке подтверждение из измерения (см. mergePipeline) и
+// возвращает false, если вышка заблокирована. Неизвестная вышка добавляется в коллекцию.
+func (db *DB) submitCell(ctx context.Context, coll *mongo.Collection, obs Observation) (bool, error) {
+	if db.cache != nil {
+		defer db.cache.Delete(db.cacheKey(obs.Key))
+	}
+	filter := bson.M{
+		"radio":   obs.RadioType,
+		"mcc":     obs.MobileCountryCode,
+		"mnc":     obs.MobileNetworkCode,
+		"lac":     obs.LocationAreaCode,
+		"cell":    obs.CellId,
+		"blocked": notBlocked,
+	}
+	update := mergePipeline(obs.Location, obs.Measured)
+	_, err := coll.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if !mongo.IsDuplicateKeyError(err) {
+		return err == nil, err
+	}
+	// попытка создать запись нарушает уникальный индекс ключа вышки, если запись заблокирована
+	// (условие на флаг блокировки ее не находит) или если ее только что создало другое измерение
+	// той же вышки: повторяем обновление без создания записи, и заблокированная запись снова не
+	// находится
+	result, err := coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// submitWifi добавляет к записи о точке доступа Wi-Fi подтверждение из измерения так же, как
+// submitCell.
+func (db *DB) submitWifi(ctx context.Context, coll *mongo.Collection, mac string, location geo.Point,
+	measured time.Time) error {
+	_, err := coll.UpdateOne(ctx, bson.M{"_id": mac}, mergePipeline(location, measured),
+		options.Update().SetUpsert(true))
+	return err
+}
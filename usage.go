@@ -0,0 +1,90 @@
+package lbs
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UsageCollectionName описывает название коллекции со статистикой использования по ключам API.
+var UsageCollectionName = "lbs_usage"
+
+// UsageRetention задает время хранения статистики использования за каждый день. Устаревшие
+// записи удаляются MongoDB автоматически с помощью TTL-индекса.
+var UsageRetention = 90 * 24 * time.Hour
+
+// usageDayFormat задает формат дня в статистике использования.
+const usageDayFormat = "2006-01-02"
+
+// Usage описывает количество запросов с одним ключом API за один день (UTC).
+type Usage struct {
+	Key     string    `bson:"key" json:"key"`         // ключ API
+	Day     string    `bson:"day" json:"day"`         // день в формате 2006-01-02
+	Count   int       `bson:"count" json:"count"`     // количество запросов
+	Expires time.Time `bson:"expires" json:"expires"` // время удаления записи
+}
+
+// usageIndex описывает TTL-индекс коллекции со статистикой использования: записи удаляются
+// после наступления времени, указанного в поле expires.
+var usageIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: "expires", Value: 1}},
+	Options: options.Index().SetExpireAfterSeconds(0).SetBackground(true),
+}
+
+// CountUsage атомарно увеличивает счетчик запросов с указанным ключом API за текущий день и
+// возвращает его новое значение. Счетчики за каждый день хранятся отдельно, поэтому новый день
+// начинается с нуля, а старые записи удаляются через UsageRetention.
+func (db *DB) CountUsage(ctx context.Context, apiKey string) (count int, err error) {
+	now := db.clock.Now().UTC()
+	day := now.Format(usageDayFormat)
+	mdb, err := db.database()
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	coll := mdb.Collection(UsageCollectionName)
+	if atomic.LoadInt32(&db.usageIndexed) == 0 {
+		if _, err = coll.Indexes().CreateOne(ctx, usageIndex); err != nil {
+			return 0, err
+		}
+		atomic.StoreInt32(&db.usageIndexed, 1)
+	}
+	var usage Usage
+	err = coll.FindOneAndUpdate(ctx, bson.M{"_id": apiKey + "/" + day}, bson.M{
+		"$inc": bson.M{"count": 1},
+		"$setOnInsert": bson.M{
+			"key":     apiKey,
+			"day":     day,
+			"expires": now.Add(UsageRetention),
+		},
+	}, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&usage)
+	count = usage.Count
+	return count, err
+}
+
+// UsageStats возвращает статистику использования за последние days дней, включая текущий. Если
+// ключ API не указан, то возвращается статистика по всем ключам.
+func (db *DB) UsageStats(ctx context.Context, apiKey string, days int) (stats []Usage, err error) {
+	if days < 1 {
+		days = 1
+	}
+	since := db.clock.Now().UTC().AddDate(0, 0, 1-days).Format(usageDayFormat)
+	query := bson.M{"day": bson.M{"$gte": since}}
+	if apiKey != "" {
+		query["key"] = apiKey
+	}
+	mdb, err := db.database()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	err = findAll(ctx, mdb.Collection(UsageCollectionName), query, &stats, options.Find().
+		SetProjection(bson.M{"_id": 0}).SetSort(bson.D{{Key: "day", Value: 1}, {Key: "key", Value: 1}}))
+	return stats, err
+}
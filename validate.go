@@ -0,0 +1,127 @@
+package lbs
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/geotrace/lbs/v2/storage"
+)
+
+// RadioTypes содержит список поддерживаемых типов радио.
+var RadioTypes = []string{"gsm", "umts", "wcdma", "lte", "nr", "cdma"}
+
+// FieldError описывает ошибку в значении одного поля запроса.
+type FieldError struct {
+	Field   string `json:"field"`   // путь к полю в формате JSON, например cellTowers[1].cellId
+	Message string `json:"message"` // описание ошибки
+}
+
+// ValidationError описывает все ошибки, найденные при проверке запроса.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	messages := make([]string, len(e))
+	for i, field := range e {
+		messages[i] = field.Field + ": " + field.Message
+	}
+	return "lbs: invalid request: " + strings.Join(messages, "; ")
+}
+
+// validRadio возвращает true, если тип радио поддерживается, в том числе под другим названием (см.
+// RadioAliases).
+func validRadio(radio string) bool {
+	radio = NormalizeRadio(radio)
+	for _, name := range RadioTypes {
+		if radio == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate проверяет значения полей запроса: типы радио, диапазоны кодов страны и оператора,
+// идентификаторы вышек (см. Key.Valid), уровни сигнала и MAC-адреса точек доступа Wi-Fi. Если
+// найдены ошибки, то возвращается ValidationError со списком всех ошибочных полей.
+//
+// Get и GetDetailed не проверяют запрос и просто пропускают вышки с недопустимыми
+// идентификаторами, поэтому запросы, полученные от внешних клиентов, лучше проверять заранее,
+// чтобы сообщить клиенту о его ошибке.
+func (r Request) Validate() error {
+	var errs ValidationError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	if r.RadioType != "" && !validRadio(r.RadioType) {
+		add("radioType", "unsupported radio type %q", r.RadioType)
+	}
+	if r.HomeMobileCountryCode > 999 {
+		add("homeMobileCountryCode", "out of range: %d", r.HomeMobileCountryCode)
+	}
+	if r.HomeMobileNetworkCode > storage.MaxNetworkCode(NormalizeRadio(r.RadioType)) {
+		add("homeMobileNetworkCode", "out of range: %d", r.HomeMobileNetworkCode)
+	}
+	for i, cell := range r.CellTowers {
+		field := fmt.Sprintf("cellTowers[%d]", i)
+		if cell == nil {
+			add(field, "empty cell")
+			continue
+		}
+		if radio := r.radio(i); radio != "" && !validRadio(radio) {
+			add(field+".radioType", "unsupported radio type %q", radio)
+		}
+		lac, cellId := r.identity(i)
+		radio := r.radio(i)
+		if radio == "" {
+			radio = NormalizeRadio(r.RadioType)
+			if radio == "" {
+				radio = DefaultRadioType
+			}
+			radio = towerRadio(radio, cellId)
+		}
+		if cell.MobileCountryCode > 999 {
+			add(field+".mobileCountryCode", "out of range: %d", cell.MobileCountryCode)
+		}
+		if cell.MobileNetworkCode > storage.MaxNetworkCode(radio) {
+			add(field+".mobileNetworkCode", "out of range: %d", cell.MobileNetworkCode)
+		}
+		// у вышек CDMA код зоны и идентификатор — NID и BID (см. CDMAKey)
+		maxArea, maxCell := uint64(1<<AreaCodeBits-1), uint64(1<<CellIdBits-1)
+		if radio == "cdma" {
+			maxArea, maxCell = storage.MaxCDMANetworkId, storage.MaxCDMABaseStation
+		}
+		if uint64(lac) > maxArea {
+			add(field+".locationAreaCode", "out of range: %d", lac)
+		} else if key := (Key{RadioType: radio, LocationAreaCode: lac, CellId: 1}); !key.Valid() {
+			add(field+".locationAreaCode", "reserved value: %d", lac)
+		}
+		if cellId > maxCell {
+			add(field+".cellId", "out of range: %d", cellId)
+		} else if key := (Key{RadioType: radio, LocationAreaCode: 1, CellId: cellId}); !key.Valid() {
+			add(field+".cellId", "reserved value: %d", cellId)
+		}
+		if cell.SignalStrength != 0 {
+			// положительный уровень сигнала задается в ASU (см. SignalDBm)
+			if _, ok := SignalDBm(radio, int(cell.SignalStrength)); !ok || cell.SignalStrength < -150 {
+				add(field+".signalStrength", "out of range: %d", cell.SignalStrength)
+			}
+		}
+	}
+	for i, wifi := range r.WifiAccessPoints {
+		field := fmt.Sprintf("wifiAccessPoints[%d]", i)
+		if wifi == nil {
+			add(field, "empty access point")
+			continue
+		}
+		if mac, err := net.ParseMAC(wifi.MacAddress); err != nil || len(mac) != 6 {
+			add(field+".macAddress", "bad MAC address %q", wifi.MacAddress)
+		}
+		if wifi.SignalStrength > 0 || wifi.SignalStrength < -150 {
+			add(field+".signalStrength", "out of range: %d", wifi.SignalStrength)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package lbs
+
+import (
+	"testing"
+
+	"github.com/geotrace/locator"
+)
+
+func TestValidate(t *testing.T) {
+	request := Request{Request: locator.Request{
+		RadioType: "gsm",
+		CellTowers: []*locator.CellTower{
+			{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517, SignalStrength: -78},
+		},
+		WifiAccessPoints: []*locator.WifiAccessPoint{
+			{MacAddress: "01:23:45:67:89:ab", SignalStrength: -51},
+		},
+	}}
+	if err := request.Validate(); err != nil {
+		t.Fatalf("valid request rejected: %v", err)
+	}
+	request = Request{
+		Request: locator.Request{
+			RadioType: "5g",
+			CellTowers: []*locator.CellTower{
+				{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517, SignalStrength: -78},
+				{MobileCountryCode: 2500, MobileNetworkCode: 2, CellId: 0xFFFFFFFF, SignalStrength: 10},
+			},
+			WifiAccessPoints: []*locator.WifiAccessPoint{
+				{MacAddress: "bad"},
+			},
+		},
+		RadioTypes: []string{"", "tetra"},
+	}
+	err := request.Validate()
+	errs, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("bad error: %v", err)
+	}
+	fields := map[string]bool{}
+	for _, field := range errs {
+		fields[field.Field] = true
+	}
+	for _, field := range []string{
+		"radioType",
+		"cellTowers[1].radioType",
+		"cellTowers[1].mobileCountryCode",
+		"cellTowers[1].locationAreaCode",
+		"cellTowers[1].cellId",
+		"cellTowers[1].signalStrength",
+		"wifiAccessPoints[0].macAddress",
+	} {
+		if !fields[field] {
+			t.Errorf("field %s not reported", field)
+		}
+	}
+	if len(errs) != 7 {
+		t.Errorf("unexpected errors: %v", err)
+	}
+	request = Request{
+		Request: locator.Request{
+			RadioType:  "nr",
+			CellTowers: []*locator.CellTower{{MobileCountryCode: 250, MobileNetworkCode: 2, LocationAreaCode: 7743, CellId: 22517, SignalStrength: -95}},
+		},
+		Identities: []CellIdentity{{LocationAreaCode: 1 << AreaCodeBits, CellId: 1 << CellIdBits}},
+	}
+	err = request.Validate()
+	if errs, ok := err.(ValidationError); !ok || len(errs) != 2 ||
+		errs[0].Field != "cellTowers[0].locationAreaCode" || errs[1].Field != "cellTowers[0].cellId" {
+		t.Errorf("wide identities not reported: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package lbs
+
+import (
+	"math"
+
+	"github.com/geotrace/locator"
+)
+
+// SignalWeighted включает вычисление координат по вышкам с весами, зависящими от уровня сигнала
+// и времени задержки (timing advance) из запроса: чем ближе к вышке находится устройство, тем
+// больше ее вес. Расстояние до вышки оценивается по времени задержки, если оно известно, а иначе —
+// по модели затухания сигнала с расстоянием (log-distance path loss) с уровнем сигнала,
+// приведенным к RSSI в дБм (см. SignalRSSI). Вес вышки обратно
+// пропорционален квадрату оцененного расстояния. Для вышек без уровня сигнала и времени задержки
+// расстояние считается равным радиусу их действия.
+//
+// По умолчанию все найденные вышки имеют одинаковый вес.
+func SignalWeighted() Option {
+	return func(db *DB) {
+		db.signalWeighted = true
+	}
+}
+
+// Параметры модели затухания сигнала: уровень сигнала на расстоянии d составляет
+// pathLossRef - 10*pathLossExponent*log10(d/pathLossRefDistance). Значения совпадают с моделью
+// по умолчанию команды lbs-admin simulate.
+const (
+	pathLossRef         = -50.0 // уровень сигнала на опорном расстоянии, дБм
+	pathLossRefDistance = 100.0 // опорное расстояние, м
+	pathLossExponent    = 2.7   // показатель затухания
+)
+
+// Расстояние, соответствующее единице времени задержки сигнала, м.
+const (
+	gsmTimingAdvance = 553.5 // GSM: 1 бит ≈ 3,69 мкс
+	lteTimingAdvance = 78.12 // LTE: 16 Ts ≈ 0,52 мкс
+)
+
+// towerDistance возвращает оценку расстояния до вышки в метрах по измерению из запроса или 0,
+// если оценить расстояние нельзя.
+func towerDistance(radio string, tower *locator.CellTower) float64 {
+	if tower == nil {
+		return 0
+	}
+	if tower.TimingAdvance > 0 {
+		switch radio {
+		case "gsm":
+			return (float64(tower.TimingAdvance) + 0.5) * gsmTimingAdvance
+		case "lte":
+			return (float64(tower.TimingAdvance) + 0.5) * lteTimingAdvance
+		}
+	}
+	if rssi, ok := SignalRSSI(radio, int(tower.SignalStrength)); ok {
+		return pathLossRefDistance * math.Pow(10, (pathLossRef-rssi)/(10*pathLossExponent))
+	}
+	return 0
+}
+
+// signalWeights заменяет одинаковые веса вышек на веса, обратно пропорциональные квадрату
+// расстояния до вышки, оцененному по измерениям towers для ключей keys. Оценка расстояния
+// ограничивается радиусом действия вышки, а сумма весов остается равной единице.
+func signalWeights(points []weightedPoint, cells []Cell, keys []Key, towers []*locator.CellTower) {
+	measured := measuredTowers(keys, towers)
+	var total float64
+	for i, cell := range cells {
+		dist := towerDistance(cell.RadioType, measured[cell.Key])
+		if dist <= 0 || dist > cell.Accuracy {
+			dist = cell.Accuracy
+		}
+		dist = math.Max(dist, minAccuracy)
+		points[i].weight = 1 / (dist * dist)
+		total += points[i].weight
+	}
+	for i := range points {
+		points[i].weight /= total
+	}
+}
+
+// measuredTowers возвращает измерения из запроса towers по ключам вышек keys.
+func measuredTowers(keys []Key, towers []*locator.CellTower) map[Key]*locator.CellTower {
+	measured := make(map[Key]*locator.CellTower, len(keys))
+	for i, key := range keys {
+		if i < len(towers) {
+			measured[key] = towers[i]
+		}
+	}
+	return measured
+}
@@ -0,0 +1,55 @@
+package lbs
+
+import (
+	"math"
+	"testing"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/locator"
+)
+
+func TestTowerDistance(t *testing.T) {
+	if dist := towerDistance("gsm", &locator.CellTower{TimingAdvance: 2}); math.Abs(dist-1383.75) > 0.01 {
+		t.Errorf("bad GSM timing advance distance: %.2f", dist)
+	}
+	if dist := towerDistance("gsm", &locator.CellTower{SignalStrength: -50}); math.Abs(dist-100) > 0.01 {
+		t.Errorf("bad reference distance: %.2f", dist)
+	}
+	near := towerDistance("lte", &locator.CellTower{SignalStrength: -70})
+	far := towerDistance("lte", &locator.CellTower{SignalStrength: -90})
+	if near >= far {
+		t.Errorf("stronger signal is farther: %.0f >= %.0f", near, far)
+	}
+	if towerDistance("gsm", &locator.CellTower{}) != 0 || towerDistance("gsm", nil) != 0 {
+		t.Error("distance without measurements")
+	}
+}
+
+func TestSignalWeighted(t *testing.T) {
+	cell := func(id uint64, lon float64) Cell {
+		return Cell{Key: Key{RadioType: "gsm", MobileCountryCode: 250, LocationAreaCode: 1, CellId: id},
+			Data: Data{Location: geo.NewPoint(lon, 55.75), Accuracy: 3000}}
+	}
+	cells := []Cell{cell(1, 37.60), cell(2, 37.64)}
+	keys := []Key{cells[0].Key, cells[1].Key}
+	towers := []*locator.CellTower{{SignalStrength: -60}, {SignalStrength: -90}}
+
+	equal, err := newDB("test", nil).locate(keys, towers, append([]Cell(nil), cells...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(equal.Cells[0].Weight-0.5) > 1e-9 {
+		t.Errorf("unequal default weights: %+v", equal.Cells)
+	}
+	weighted, err := newDB("test", []Option{SignalWeighted()}).locate(keys, towers,
+		append([]Cell(nil), cells...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if weighted.Cells[0].Weight <= weighted.Cells[1].Weight {
+		t.Errorf("stronger tower has lower weight: %+v", weighted.Cells)
+	}
+	if lon := weighted.Response.Location.Lng; lon >= 37.62 {
+		t.Errorf("result not moved to stronger tower: %f", lon)
+	}
+}
@@ -0,0 +1,161 @@
+package lbs
+
+import (
+	"context"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/geotrace/locator"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// WifiCollectionName описывает название коллекции с данными о точках доступа Wi-Fi.
+var WifiCollectionName = "lbs_wifi"
+
+// MinWifiAccessPoints задает минимальное количество найденных точек доступа Wi-Fi, по которым
+// вычисляются координаты. Как и в Mozilla Location Service, одной точки недостаточно: так по
+// MAC-адресу нельзя узнать, где находится конкретный роутер, а переехавшая точка не может сама по
+// себе дать неверный результат.
+var MinWifiAccessPoints = 2
+
+// IgnoreWifi отключает использование точек доступа Wi-Fi: координаты вычисляются только по
+// вышкам, а точки доступа из запросов не ищутся в коллекции WifiCollectionName. Это позволяет не
+// тратить время на запрос к MongoDB, если данные Wi-Fi не загружены или им нельзя доверять.
+func IgnoreWifi() Option {
+	return func(db *DB) {
+		db.wifiIgnored = true
+	}
+}
+
+// requestMacs возвращает MAC-адреса точек доступа из запроса так же, как requestWifi, или пустой
+// список, если задан IgnoreWifi для DB или для запроса.
+func (db *DB) requestMacs(req Request) []string {
+	if db.wifiIgnored || req.Options != nil && req.Options.IgnoreWifi {
+		return nil
+	}
+	return requestWifi(req, db.maxTowers)
+}
+
+// AccessPoint описывает запись о точке доступа Wi-Fi в хранилище.
+type AccessPoint struct {
+	MAC      string `bson:"_id" json:"mac"` // MAC-адрес (BSSID) в формате 01:23:45:67:89:ab
+	Data     `bson:",inline"`
+	Samples  int       `bson:"samples,omitempty" json:"samples,omitempty"`   // количество подтверждений
+	Variance float64   `bson:"variance,omitempty" json:"variance,omitempty"` // дисперсия координат подтверждений, м² (см. DB.Submit)
+	Created  time.Time `bson:"created,omitempty" json:"created,omitempty"`   // время первого подтверждения
+	Updated  time.Time `bson:"updated,omitempty" json:"updated,omitempty"`   // время последнего подтверждения
+}
+
+// WifiMatch описывает найденную в хранилище точку доступа и ее вклад в вычисленные координаты.
+type WifiMatch struct {
+	AccessPoint
+	Weight   float64 `json:"weight"`   // доля точки доступа в вычисленных координатах
+	Distance float64 `json:"distance"` // расстояние от точки доступа до вычисленных координат, м
+}
+
+// NormalizeMAC приводит MAC-адрес точки доступа к виду, в котором он хранится в базе. Для
+// некорректных и групповых адресов, а также для локально администрируемых адресов, которые
+// используют мобильные точки доступа и устройства со случайным MAC-адресом, возвращается false:
+// такие точки доступа не имеют постоянного местоположения.
+func NormalizeMAC(mac string) (string, bool) {
+	addr, err := net.ParseMAC(mac)
+	if err != nil || len(addr) != 6 {
+		return "", false
+	}
+	if addr[0]&0x03 != 0 { // групповой или локально администрируемый адрес
+		return "", false
+	}
+	if addr[0]|addr[1]|addr[2]|addr[3]|addr[4]|addr[5] == 0 {
+		return "", false
+	}
+	return addr.String(), true
+}
+
+// requestWifi возвращает нормализованные MAC-адреса точек доступа из запроса без повторов.
+// Если limit больше нуля и точек больше, то используются limit точек с самым сильным сигналом.
+func requestWifi(req Request, limit int) []string {
+	macs := make([]string, 0, len(req.WifiAccessPoints))
+	best := make(map[string]int, len(req.WifiAccessPoints)) // MAC -> индекс в списке
+	points := make([]*locator.WifiAccessPoint, 0, len(req.WifiAccessPoints))
+	for _, wifi := range req.WifiAccessPoints {
+		if wifi == nil {
+			continue
+		}
+		mac, ok := NormalizeMAC(wifi.MacAddress)
+		if !ok {
+			continue
+		}
+		if i, ok := best[mac]; ok {
+			if strongerWifi(wifi, points[i]) {
+				points[i] = wifi
+			}
+			continue
+		}
+		best[mac] = len(macs)
+		macs = append(macs, mac)
+		points = append(points, wifi)
+	}
+	if limit <= 0 || len(macs) <= limit {
+		return macs
+	}
+	order := make([]int, len(macs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return strongerWifi(points[order[i]], points[order[j]])
+	})
+	order = order[:limit]
+	sort.Ints(order) // сохраняем исходный порядок точек в запросе
+	limited := make([]string, len(order))
+	for i, index := range order {
+		limited[i] = macs[index]
+	}
+	return limited
+}
+
+// strongerWifi возвращает true, если точка доступа a принята с более сильным сигналом, чем b.
+// Точки с неизвестным уровнем сигнала считаются самыми слабыми.
+func strongerWifi(a, b *locator.WifiAccessPoint) bool {
+	if (a.SignalStrength != 0) != (b.SignalStrength != 0) {
+		return a.SignalStrength != 0
+	}
+	return a.SignalStrength > b.SignalStrength
+}
+
+// queryWifi возвращает записи о найденных в хранилище точках доступа с указанными MAC-адресами.
+func (db *DB) queryWifi(ctx context.Context, macs []string) (points []AccessPoint, err error) {
+	if len(macs) == 0 || db.storage != nil {
+		return nil, nil // в хранилище Storage точек доступа нет
+	}
+	start := time.Now()
+	mdb, err := db.database()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+	err = findAll(ctx, mdb.Collection(WifiCollectionName), bson.M{"_id": bson.M{"$in": macs}}, &points)
+	db.metrics.Mongo.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// wifiNearCells оставляет только точки доступа, расположенные в зоне действия найденных вышек:
+// точки доступа, которые переехали вместе с владельцем, не должны уводить координаты далеко от
+// вышек. Если вышки не найдены, то точки доступа возвращаются без изменений.
+func wifiNearCells(points []AccessPoint, cells []Cell, lat, lon, accuracy float64) []AccessPoint {
+	if len(cells) == 0 {
+		return points
+	}
+	near := points[:0:0]
+	for _, point := range points {
+		if distance(lat, lon, point.Location.Latitude(), point.Location.Longitude()) <= accuracy+point.Accuracy {
+			near = append(near, point)
+		}
+	}
+	return near
+}
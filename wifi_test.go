@@ -0,0 +1,107 @@
+package lbs
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/geotrace/geo"
+	"github.com/geotrace/locator"
+)
+
+func TestNormalizeMAC(t *testing.T) {
+	for mac, want := range map[string]string{
+		"01:23:45:67:89:AB": "", // групповой адрес
+		"00:1A:2B:3C:4D:5E": "00:1a:2b:3c:4d:5e",
+		"00-1a-2b-3c-4d-5e": "00:1a:2b:3c:4d:5e",
+		"02:1a:2b:3c:4d:5e": "", // локально администрируемый адрес
+		"ff:ff:ff:ff:ff:ff": "", // широковещательный адрес
+		"00:00:00:00:00:00": "",
+		"00:1a:2b:3c:4d":    "",
+	} {
+		got, ok := NormalizeMAC(mac)
+		if got != want || ok != (want != "") {
+			t.Errorf("NormalizeMAC(%q) = %q, %v; want %q", mac, got, ok, want)
+		}
+	}
+}
+
+func TestRequestWifi(t *testing.T) {
+	macs := requestWifi(Request{Request: locator.Request{
+		WifiAccessPoints: []*locator.WifiAccessPoint{
+			{MacAddress: "00:1a:2b:3c:4d:01", SignalStrength: -80},
+			{MacAddress: "00:1A:2B:3C:4D:01", SignalStrength: -50},
+			{MacAddress: "bad"},
+			{MacAddress: "00:1a:2b:3c:4d:02"},
+			{MacAddress: "00:1a:2b:3c:4d:03", SignalStrength: -70},
+		},
+	}}, 2)
+	if len(macs) != 2 || macs[0] != "00:1a:2b:3c:4d:01" || macs[1] != "00:1a:2b:3c:4d:03" {
+		t.Errorf("bad access points: %v", macs)
+	}
+	req := Request{Request: locator.Request{
+		WifiAccessPoints: []*locator.WifiAccessPoint{{MacAddress: "00:1a:2b:3c:4d:01"}},
+	}}
+	if macs := newDB("test", []Option{IgnoreWifi()}).requestMacs(req); len(macs) != 0 {
+		t.Errorf("access points not ignored: %v", macs)
+	}
+	if macs := newDB("test", nil).requestMacs(req); len(macs) != 1 {
+		t.Errorf("bad access points: %v", macs)
+	}
+}
+
+func TestLocateWifi(t *testing.T) {
+	db := newDB("test", nil)
+	point := func(mac string, lon, lat, accuracy float64) AccessPoint {
+		return AccessPoint{MAC: mac, Data: Data{Location: geo.NewPoint(lon, lat), Accuracy: accuracy}}
+	}
+	cell := Cell{Key: Key{RadioType: "gsm", MobileCountryCode: 250, LocationAreaCode: 1, CellId: 1},
+		Data: Data{Location: geo.NewPoint(37.62, 55.75), Accuracy: 2000}}
+	macs := []string{"00:1a:2b:3c:4d:01", "00:1a:2b:3c:4d:02", "00:1a:2b:3c:4d:03", "00:1a:2b:3c:4d:04"}
+	points := []AccessPoint{
+		point(macs[0], 37.630, 55.755, 50),
+		point(macs[1], 37.631, 55.755, 50),
+		point(macs[2], 30.300, 59.950, 50), // точка доступа переехала в другой город
+	}
+
+	// только Wi-Fi: одной точки доступа недостаточно
+	if _, err := db.locate(nil, nil, nil, macs[:1], points[:1]); !errors.Is(err, ErrNotFound) {
+		t.Errorf("single access point located: %v", err)
+	}
+	details, err := db.locate(nil, nil, nil, macs[:2], points[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lon := details.Response.Location.Lng; math.Abs(lon-37.6305) > 1e-9 || details.Response.Accuracy > 100 {
+		t.Errorf("bad Wi-Fi only result: %+v", details.Response)
+	}
+
+	// вышка и Wi-Fi: точка доступа вне зоны действия вышки отбрасывается, а Wi-Fi определяет
+	// результат
+	details, err = db.locate([]Key{cell.Key}, nil, []Cell{cell}, macs, points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(details.Wifi) != 2 || len(details.MissingWifi) != 1 || details.MissingWifi[0] != macs[3] {
+		t.Errorf("bad access points: %+v, missing %v", details.Wifi, details.MissingWifi)
+	}
+	if dist := distance(details.Response.Location.Lat, details.Response.Location.Lng, 55.755, 37.6305); dist > 10 {
+		t.Errorf("result too far from access points: %.0f m", dist)
+	}
+	if details.Response.Accuracy > 100 {
+		t.Errorf("bad accuracy: %.0f", details.Response.Accuracy)
+	}
+	var total float64
+	for _, match := range details.Cells {
+		total += match.Weight
+	}
+	for _, match := range details.Wifi {
+		total += match.Weight
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("weights sum: %f", total)
+	}
+	if details.CellCounts != (Counts{1, 1, 1}) || details.WifiCounts != (Counts{4, 3, 2}) {
+		t.Errorf("bad counts: cells %+v, wifi %+v", details.CellCounts, details.WifiCounts)
+	}
+}